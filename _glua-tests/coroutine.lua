@@ -15,3 +15,44 @@ local ok, msg = pcall(function()
   co()
 end)
 assert(not ok and string.find(msg, "can not resume a dead thread"))
+
+local main, ismain = coroutine.running()
+assert(type(main) == "thread" and ismain)
+assert(not coroutine.isyieldable())
+local ok, msg = pcall(coroutine.close, main)
+assert(not ok and string.find(msg, "can not close a running coroutine"))
+
+local finished = false
+co = coroutine.create(function()
+  local th, ismain = coroutine.running()
+  assert(th ~= main and not ismain)
+  assert(coroutine.isyieldable())
+  pcall(function() assert(coroutine.isyieldable()) end)
+  local n = 0
+  coroutine.yield(function() n = n + 1; return n end)
+  finished = true
+end)
+local _, counter = coroutine.resume(co)
+assert(coroutine.close(co))
+assert(coroutine.status(co) == "dead")
+assert(counter() == 1 and counter() == 2)
+assert(not finished)
+local ok, msg = coroutine.resume(co)
+assert(not ok and string.find(msg, "can not resume a dead thread"))
+
+co = coroutine.create(function() error({code = 1}) end)
+coroutine.resume(co)
+local ok, err = coroutine.close(co)
+assert(not ok and err.code == 1)
+assert(coroutine.close(co))
+
+local outer
+outer = coroutine.create(function()
+  local inner = coroutine.create(function()
+    assert(coroutine.status(outer) == "normal")
+    local ok, msg = pcall(coroutine.close, outer)
+    assert(not ok and string.find(msg, "can not close a normal coroutine"))
+  end)
+  assert(coroutine.resume(inner))
+end)
+assert(coroutine.resume(outer))
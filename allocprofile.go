@@ -0,0 +1,264 @@
+package lua
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+/* AllocProfile {{{ */
+
+// AllocProfile attributes the tables, closures and strings allocated by Lua code to the
+// lines that allocate them. Set an AllocProfile to LStates by LState.SetAllocProfile; an
+// AllocProfile can be shared by many LStates running in different goroutines.
+//
+// The following allocations are recorded, with their sizes estimated from the Go
+// representations of the values:
+//
+//   - tables created by table constructors, LState.NewTable and LState.CreateTable
+//   - growth of the arrays and the hashes of these tables, by Lua code or Go code
+//   - closures created by function expressions
+//   - strings created by the concatenation operator, string.rep, string.format and
+//     string.gsub
+//
+// Allocations by Go code are attributed to the innermost Lua function on the call stack.
+type AllocProfile struct {
+	rate int64
+	// allocated is the total size of the allocations
+	allocated int64
+
+	mu      sync.Mutex
+	builder *profileBuilder
+	sites   map[allocSite]*allocCount
+}
+
+// allocSite is the instruction that allocates.
+type allocSite struct {
+	proto *FunctionProto
+	pc    int
+}
+
+type allocCount struct {
+	objects, bytes float64
+}
+
+// AllocLine is the allocations of a line.
+type AllocLine struct {
+	Source  string
+	Line    int
+	Objects int64
+	Bytes   int64
+}
+
+// NewAllocProfile returns a new AllocProfile that samples an allocation every rate bytes
+// on average. The sampled allocations are scaled up, so that the reported numbers estimate
+// all the allocations. A rate less than or equal to 1 records every allocation.
+func NewAllocProfile(rate int) *AllocProfile {
+	if rate < 1 {
+		rate = 1
+	}
+	return &AllocProfile{
+		rate: int64(rate),
+		builder: newProfileBuilder([2]string{"space", "bytes"}, int64(rate),
+			[2]string{"alloc_objects", "count"}, [2]string{"alloc_space", "bytes"}),
+		sites: map[allocSite]*allocCount{},
+	}
+}
+
+// alloc records an allocation of size bytes by L.
+func (p *AllocProfile) alloc(L *LState, size int) {
+	if L.currentFrame == nil || size <= 0 {
+		return
+	}
+	objects, bytes := 1.0, float64(size)
+	if p.rate > 1 {
+		// the samples are taken each time the total size crosses a multiple of the rate,
+		// and a sample stands for the rate bytes of the allocations of the same size
+		total := atomic.AddInt64(&p.allocated, int64(size))
+		n := total/p.rate - (total-int64(size))/p.rate
+		if n == 0 {
+			return
+		}
+		bytes = float64(n * p.rate)
+		objects = bytes / float64(size)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.builder.addSample(p.builder.stack(L), objects, bytes)
+	if site, ok := luaAllocSite(L); ok {
+		count, ok := p.sites[site]
+		if !ok {
+			count = &allocCount{}
+			p.sites[site] = count
+		}
+		count.objects += objects
+		count.bytes += bytes
+	}
+}
+
+// luaAllocSite returns the instruction of the innermost Lua function of L.
+func luaAllocSite(L *LState) (allocSite, bool) {
+	for ; L != nil; L = L.Parent {
+		for cf := L.currentFrame; cf != nil; cf = cf.Parent {
+			if !cf.Fn.IsG {
+				return allocSite{proto: cf.Fn.Proto, pc: cf.Pc - 1}, true
+			}
+		}
+	}
+	return allocSite{}, false
+}
+
+// instruction records the allocation by the instruction that has been executed.
+func (p *AllocProfile) instruction(L *LState, cf *callFrame, inst uint32) {
+	switch opGetOpCode(inst) {
+	case OP_NEWTABLE:
+		if tb, ok := L.reg.Get(cf.LocalBase + opGetArgA(inst)).(*LTable); ok {
+			L.recordTable(tb, opGetArgB(inst), opGetArgC(inst))
+		}
+	case OP_CLOSURE:
+		if fn, ok := L.reg.Get(cf.LocalBase + opGetArgA(inst)).(*LFunction); ok {
+			p.alloc(L, closureBytes(len(fn.Upvalues)))
+		}
+	case OP_CONCAT:
+		if s, ok := L.reg.Get(cf.LocalBase + opGetArgA(inst)).(LString); ok {
+			p.alloc(L, stringBytes(len(s)))
+		}
+	}
+}
+
+const (
+	valueBytes    = int(unsafe.Sizeof(LValue(nil)))
+	tableHeader   = int(unsafe.Sizeof(LTable{}))
+	closureHeader = int(unsafe.Sizeof(LFunction{}))
+	upvalueBytes  = int(unsafe.Sizeof(Upvalue{}))
+)
+
+// newTableBytes estimates the size of a new table.
+func newTableBytes(acap, hcap int) int {
+	return tableHeader + acap*valueBytes + hcap*2*valueBytes
+}
+
+// tableBytes estimates the size of a table.
+func tableBytes(tb *LTable) int {
+	return tableHeader + cap(tb.array)*valueBytes + (len(tb.dict)+len(tb.strdict))*2*valueBytes
+}
+
+// closureBytes estimates the size of a closure with nupvalues upvalues.
+func closureBytes(nupvalues int) int {
+	return closureHeader + nupvalues*upvalueBytes
+}
+
+// stringBytes estimates the size of a string of the length n.
+func stringBytes(n int) int {
+	return n + int(unsafe.Sizeof(""))
+}
+
+// recordAlloc records an allocation of size bytes to the AllocProfile of this LState, if
+// it has one.
+func (ls *LState) recordAlloc(size int) {
+	if ls.allocProfile != nil {
+		ls.allocProfile.alloc(ls, size)
+	}
+}
+
+// recordTable records the allocation of tb created with the capacities acap and hcap, if this
+// LState has an AllocProfile. The growth of tb is recorded afterwards too.
+func (ls *LState) recordTable(tb *LTable, acap, hcap int) {
+	if ls.allocProfile != nil {
+		ls.allocProfile.alloc(ls, newTableBytes(acap, hcap))
+		tb.allocG = ls.G
+	}
+}
+
+// grown records that tb has allocated size bytes to grow, to the LState running in the
+// Global that tb has been created in.
+func (tb *LTable) grown(size int) {
+	if tb.allocG != nil && tb.allocG.CurrentThread != nil {
+		tb.allocG.CurrentThread.recordAlloc(size)
+	}
+}
+
+// Lines returns the allocations by the lines, from the line that allocates the most bytes.
+func (p *AllocProfile) Lines() []AllocLine {
+	type lineKey struct {
+		source string
+		line   int
+	}
+	p.mu.Lock()
+	counts := map[lineKey]*allocCount{}
+	for site, count := range p.sites {
+		key := lineKey{source: site.proto.SourceName}
+		if site.pc >= 0 && site.pc < len(site.proto.DbgSourcePositions) {
+			key.line = site.proto.DbgSourcePositions[site.pc]
+		}
+		c, ok := counts[key]
+		if !ok {
+			c = &allocCount{}
+			counts[key] = c
+		}
+		c.objects += count.objects
+		c.bytes += count.bytes
+	}
+	p.mu.Unlock()
+
+	lines := make([]AllocLine, 0, len(counts))
+	for key, c := range counts {
+		lines = append(lines, AllocLine{
+			Source:  key.source,
+			Line:    key.line,
+			Objects: int64(math.Round(c.objects)),
+			Bytes:   int64(math.Round(c.bytes)),
+		})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Line < b.Line
+	})
+	return lines
+}
+
+// WriteReport writes the allocations by the lines as a text table.
+func (p *AllocProfile) WriteReport(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%12s %12s  %s\n", "bytes", "objects", "line"); err != nil {
+		return err
+	}
+	for _, line := range p.Lines() {
+		if _, err := fmt.Fprintf(w, "%12d %12d  %s:%d\n", line.Bytes, line.Objects, line.Source, line.Line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteProfile writes the allocations with their call stacks as a pprof heap profile, which
+// can be viewed by `go tool pprof`.
+func (p *AllocProfile) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.builder.write(w)
+}
+
+// SetAllocProfile makes this LState record its allocations to p. Threads created by this
+// LState afterwards record to p as well. A nil p stops recording.
+func (ls *LState) SetAllocProfile(p *AllocProfile) {
+	ls.allocProfile = p
+	ls.updateMainLoop()
+}
+
+// AllocProfile returns the AllocProfile set by SetAllocProfile, or nil.
+func (ls *LState) AllocProfile() *AllocProfile {
+	return ls.allocProfile
+}
+
+/* }}} */
@@ -0,0 +1,109 @@
+package lua
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func allocLines(p *AllocProfile) map[int]AllocLine {
+	lines := map[int]AllocLine{}
+	for _, line := range p.Lines() {
+		lines[line.Line] = line
+	}
+	return lines
+}
+
+func TestAllocProfileLines(t *testing.T) {
+	p := NewAllocProfile(1)
+	L := NewState()
+	defer L.Close()
+	L.SetAllocProfile(p)
+	errorIfFalse(t, L.AllocProfile() == p, "AllocProfile returns the profile set")
+	L.SetGlobal("newtable", L.NewFunction(func(L *LState) int {
+		L.Push(L.CreateTable(4, 0))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+local t = {}
+for i = 1, 10 do
+  t[i] = "x" .. i
+end
+local s = string.rep("ab", 50)
+local f = function() return t end
+local g = newtable()
+`)
+	lines := allocLines(p)
+	errorIfNotEqual(t, int64(1), lines[2].Objects)
+	errorIfNotEqual(t, int64(newTableBytes(0, 0)), lines[2].Bytes)
+	errorIfFalse(t, lines[4].Objects > 10, "concatenations and growth of the table should be recorded: %v", lines[4])
+	errorIfNotEqual(t, int64(stringBytes(100)), lines[6].Bytes)
+	errorIfNotEqual(t, int64(closureBytes(1)), lines[7].Bytes)
+	errorIfNotEqual(t, int64(newTableBytes(4, 0)), lines[8].Bytes)
+	errorIfNotEqual(t, "<string>", lines[8].Source)
+
+	L.SetAllocProfile(nil)
+	errorIfScriptFail(t, L, `local t = {}`)
+	errorIfNotEqual(t, int64(1), allocLines(p)[2].Objects)
+
+	var buf bytes.Buffer
+	errorIfNotNil(t, p.WriteReport(&buf))
+	errorIfFalse(t, strings.Contains(buf.String(), "<string>:4\n"), "report should contain the lines: %v", buf.String())
+
+	buf.Reset()
+	errorIfNotNil(t, p.WriteProfile(&buf))
+	zr, err := gzip.NewReader(&buf)
+	errorIfNotNil(t, err)
+	data, err := ioutil.ReadAll(zr)
+	errorIfNotNil(t, err)
+	for _, s := range []string{"alloc_objects", "alloc_space", "rep", "main chunk"} {
+		errorIfFalse(t, bytes.Contains(data, []byte(s)), "profile should contain %q", s)
+	}
+}
+
+func TestAllocProfileSampling(t *testing.T) {
+	p := NewAllocProfile(1024)
+	L := NewState()
+	defer L.Close()
+	L.SetAllocProfile(p)
+	errorIfScriptFail(t, L, `
+local function f()
+  for i = 1, 1000 do
+    local s = string.rep("x", 100)
+  end
+end
+coroutine.wrap(f)()
+`)
+	line := allocLines(p)[4]
+	total := int64(1000 * stringBytes(100))
+	errorIfFalse(t, total-1024 <= line.Bytes && line.Bytes <= total, "sampled bytes should estimate %v bytes: %v", total, line.Bytes)
+	errorIfFalse(t, 990 <= line.Objects && line.Objects <= 1010, "sampled objects should estimate 1000 objects: %v", line.Objects)
+}
+
+func TestAllocProfileTableGrowth(t *testing.T) {
+	p := NewAllocProfile(1)
+	L := NewState()
+	defer L.Close()
+	L.SetAllocProfile(p)
+	L.SetGlobal("fill", L.NewFunction(func(L *LState) int {
+		tb := L.CheckTable(1)
+		for i := 1; i <= 100; i++ {
+			tb.RawSetInt(tb.Len()+1, LNumber(i))
+		}
+		L.SetField(tb, "field", LTrue)
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+local a, h, g, m = {}, {}, {}, setmetatable({}, {__newindex = rawset})
+for i = 1, 100 do table.insert(a, i) end
+for i = 1, 100 do rawset(h, i + 0.5, i) end
+fill(g)
+for i = 1, 100 do m[i] = i end
+`)
+	lines := allocLines(p)
+	for line := 3; line <= 6; line++ {
+		errorIfFalse(t, lines[line].Bytes >= int64(100*valueBytes), "growth of the table should be recorded at line %d: %v", line, lines[line])
+	}
+}
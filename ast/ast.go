@@ -5,11 +5,22 @@ type PositionHolder interface {
 	SetLine(int)
 	LastLine() int
 	SetLastLine(int)
+	Pos() Position
+	SetPos(Position)
+	End() Position
+	SetEnd(Position)
+}
+
+// Element is a statement or an expression, that is a node of the AST.
+type Element interface {
+	PositionHolder
 }
 
 type Node struct {
 	line     int
 	lastline int
+	pos      Position
+	end      Position
 }
 
 func (self *Node) Line() int {
@@ -27,3 +38,21 @@ func (self *Node) LastLine() int {
 func (self *Node) SetLastLine(line int) {
 	self.lastline = line
 }
+
+// Pos returns the position of the first character of the node.
+func (self *Node) Pos() Position {
+	return self.pos
+}
+
+func (self *Node) SetPos(pos Position) {
+	self.pos = pos
+}
+
+// End returns the position just after the last character of the node.
+func (self *Node) End() Position {
+	return self.end
+}
+
+func (self *Node) SetEnd(pos Position) {
+	self.end = pos
+}
@@ -0,0 +1,269 @@
+package ast
+
+import "reflect"
+
+// Rewrite returns node whose descendants are replaced by f. f is called for each node
+// after its children are rewritten, and returns the node to use instead of the node;
+// f returns the node as it is to keep it. f must return a Stmt for a Stmt and an Expr
+// for an Expr, except that f may return nil for a statement in a list of statements to
+// remove the statement, and a *FunctionExpr for the function of a FuncDefStmt.
+//
+// Rewrite does not modify the AST: the nodes that have rewritten children are copied,
+// and the nodes that do not are shared between the original AST and the result.
+func Rewrite(node Element, f func(Element) Element) Element {
+	return rewriter(f).node(node)
+}
+
+// RewriteStmts calls Rewrite for each statement of stmts, like a chunk returned by
+// parse.Parse, and returns the rewritten statements. stmts is returned as it is if no
+// statements are rewritten.
+func RewriteStmts(stmts []Stmt, f func(Element) Element) []Stmt {
+	result, _ := rewriter(f).stmts(stmts)
+	return result
+}
+
+type rewriter func(Element) Element
+
+func (f rewriter) node(node Element) Element {
+	switch n := node.(type) {
+	case *AssignStmt:
+		lhs, c1 := f.exprs(n.Lhs)
+		rhs, c2 := f.exprs(n.Rhs)
+		if c1 || c2 {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			node = &cp
+		}
+	case *LocalAssignStmt:
+		if exprs, changed := f.exprs(n.Exprs); changed {
+			cp := *n
+			cp.Exprs = exprs
+			node = &cp
+		}
+	case *FuncCallStmt:
+		if expr := f.expr(n.Expr); expr != n.Expr {
+			cp := *n
+			cp.Expr = expr
+			node = &cp
+		}
+	case *DoBlockStmt:
+		if stmts, changed := f.stmts(n.Stmts); changed {
+			cp := *n
+			cp.Stmts = stmts
+			node = &cp
+		}
+	case *WhileStmt:
+		cond := f.expr(n.Condition)
+		stmts, changed := f.stmts(n.Stmts)
+		if changed || cond != n.Condition {
+			cp := *n
+			cp.Condition, cp.Stmts = cond, stmts
+			node = &cp
+		}
+	case *RepeatStmt:
+		stmts, changed := f.stmts(n.Stmts)
+		cond := f.expr(n.Condition)
+		if changed || cond != n.Condition {
+			cp := *n
+			cp.Condition, cp.Stmts = cond, stmts
+			node = &cp
+		}
+	case *IfStmt:
+		cond := f.expr(n.Condition)
+		then, c1 := f.stmts(n.Then)
+		els, c2 := f.stmts(n.Else)
+		if c1 || c2 || cond != n.Condition {
+			cp := *n
+			cp.Condition, cp.Then, cp.Else = cond, then, els
+			node = &cp
+		}
+	case *NumberForStmt:
+		init, limit, step := f.expr(n.Init), f.expr(n.Limit), f.expr(n.Step)
+		stmts, changed := f.stmts(n.Stmts)
+		if changed || init != n.Init || limit != n.Limit || step != n.Step {
+			cp := *n
+			cp.Init, cp.Limit, cp.Step, cp.Stmts = init, limit, step, stmts
+			node = &cp
+		}
+	case *GenericForStmt:
+		exprs, c1 := f.exprs(n.Exprs)
+		stmts, c2 := f.stmts(n.Stmts)
+		if c1 || c2 {
+			cp := *n
+			cp.Exprs, cp.Stmts = exprs, stmts
+			node = &cp
+		}
+	case *FuncDefStmt:
+		name := *n.Name
+		name.Func, name.Receiver = f.expr(n.Name.Func), f.expr(n.Name.Receiver)
+		fn := f.expr(n.Func)
+		if name != *n.Name || fn != Expr(n.Func) {
+			cp := *n
+			cp.Name = &name
+			fexpr, ok := fn.(*FunctionExpr)
+			if !ok {
+				panic("ast.Rewrite: the function of a function definition is replaced with " + typeName(fn))
+			}
+			cp.Func = fexpr
+			node = &cp
+		}
+	case *ReturnStmt:
+		if exprs, changed := f.exprs(n.Exprs); changed {
+			cp := *n
+			cp.Exprs = exprs
+			node = &cp
+		}
+	case *BreakStmt, *LabelStmt, *GotoStmt:
+		// nothing to do
+
+	case *TrueExpr, *FalseExpr, *NilExpr, *NumberExpr, *StringExpr, *Comma3Expr, *IdentExpr:
+		// nothing to do
+	case *AttrGetExpr:
+		obj, key := f.expr(n.Object), f.expr(n.Key)
+		if obj != n.Object || key != n.Key {
+			cp := *n
+			cp.Object, cp.Key = obj, key
+			node = &cp
+		}
+	case *TableExpr:
+		var fields []*Field
+		for i, field := range n.Fields {
+			key, value := f.expr(field.Key), f.expr(field.Value)
+			if key == field.Key && value == field.Value {
+				continue
+			}
+			if fields == nil {
+				fields = append([]*Field{}, n.Fields...)
+			}
+			fields[i] = &Field{Key: key, Value: value}
+		}
+		if fields != nil {
+			cp := *n
+			cp.Fields = fields
+			node = &cp
+		}
+	case *FuncCallExpr:
+		fn, recv := f.expr(n.Func), f.expr(n.Receiver)
+		args, changed := f.exprs(n.Args)
+		if changed || fn != n.Func || recv != n.Receiver {
+			cp := *n
+			cp.Func, cp.Receiver, cp.Args = fn, recv, args
+			node = &cp
+		}
+	case *LogicalOpExpr:
+		lhs, rhs := f.expr(n.Lhs), f.expr(n.Rhs)
+		if lhs != n.Lhs || rhs != n.Rhs {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			node = &cp
+		}
+	case *RelationalOpExpr:
+		lhs, rhs := f.expr(n.Lhs), f.expr(n.Rhs)
+		if lhs != n.Lhs || rhs != n.Rhs {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			node = &cp
+		}
+	case *StringConcatOpExpr:
+		lhs, rhs := f.expr(n.Lhs), f.expr(n.Rhs)
+		if lhs != n.Lhs || rhs != n.Rhs {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			node = &cp
+		}
+	case *ArithmeticOpExpr:
+		lhs, rhs := f.expr(n.Lhs), f.expr(n.Rhs)
+		if lhs != n.Lhs || rhs != n.Rhs {
+			cp := *n
+			cp.Lhs, cp.Rhs = lhs, rhs
+			node = &cp
+		}
+	case *UnaryMinusOpExpr:
+		if expr := f.expr(n.Expr); expr != n.Expr {
+			cp := *n
+			cp.Expr = expr
+			node = &cp
+		}
+	case *UnaryNotOpExpr:
+		if expr := f.expr(n.Expr); expr != n.Expr {
+			cp := *n
+			cp.Expr = expr
+			node = &cp
+		}
+	case *UnaryLenOpExpr:
+		if expr := f.expr(n.Expr); expr != n.Expr {
+			cp := *n
+			cp.Expr = expr
+			node = &cp
+		}
+	case *FunctionExpr:
+		if stmts, changed := f.stmts(n.Stmts); changed {
+			cp := *n
+			cp.Stmts = stmts
+			node = &cp
+		}
+
+	default:
+		panic("ast.Rewrite: unexpected node type " + typeName(node))
+	}
+
+	return f(node)
+}
+
+// expr rewrites expr, that may be nil for optional expressions.
+func (f rewriter) expr(expr Expr) Expr {
+	if expr == nil {
+		return nil
+	}
+	result, ok := f.node(expr).(Expr)
+	if !ok {
+		panic("ast.Rewrite: an expression is replaced with a non-expression")
+	}
+	return result
+}
+
+// exprs rewrites exprs and reports whether any expressions are rewritten. exprs is
+// returned as it is if no expressions are rewritten.
+func (f rewriter) exprs(exprs []Expr) ([]Expr, bool) {
+	var result []Expr
+	for i, expr := range exprs {
+		if e := f.expr(expr); e != expr {
+			if result == nil {
+				result = append([]Expr{}, exprs...)
+			}
+			result[i] = e
+		}
+	}
+	if result == nil {
+		return exprs, false
+	}
+	return result, true
+}
+
+// stmts rewrites stmts and reports whether any statements are rewritten or removed. stmts
+// is returned as it is if no statements are rewritten.
+func (f rewriter) stmts(stmts []Stmt) ([]Stmt, bool) {
+	changed := false
+	result := make([]Stmt, 0, len(stmts))
+	for _, stmt := range stmts {
+		n := f.node(stmt)
+		if n == nil {
+			changed = true
+			continue
+		}
+		s, ok := n.(Stmt)
+		if !ok {
+			panic("ast.Rewrite: a statement is replaced with a non-statement")
+		}
+		changed = changed || s != stmt
+		result = append(result, s)
+	}
+	if !changed {
+		return stmts, false
+	}
+	return result, true
+}
+
+func typeName(node Element) string {
+	return reflect.TypeOf(node).String()
+}
@@ -57,6 +57,8 @@ type IfStmt struct {
 	Condition Expr
 	Then      []Stmt
 	Else      []Stmt
+	// ElseLine is the line of the else keyword, or 0 if the statement has no else.
+	ElseLine int
 }
 
 type NumberForStmt struct {
@@ -2,12 +2,15 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 )
 
 type Position struct {
 	Source string
 	Line   int
 	Column int
+	// Offset is the byte offset from the beginning of the source, starting at 0.
+	Offset int
 }
 
 type Token struct {
@@ -15,6 +18,22 @@ type Token struct {
 	Name string
 	Str  string
 	Pos  Position
+	// End is the position just after the last character of the token.
+	End Position
+	// Comments are the comments between the previous token and this token.
+	Comments []*Comment
+}
+
+// Comment is a comment in a source.
+type Comment struct {
+	// Text is the comment including the leading `--`.
+	Text string
+	Pos  Position
+}
+
+// EndLine returns the line where the comment ends.
+func (self *Comment) EndLine() int {
+	return self.Pos.Line + strings.Count(self.Text, "\n")
 }
 
 func (self *Token) String() string {
@@ -0,0 +1,140 @@
+package ast
+
+// A Visitor's Visit method is called for each node by Walk. If the result visitor w is
+// not nil, Walk visits each of the children of node with w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Element) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling v.Visit(node); node
+// must not be nil. The children are visited in the order they appear in the source.
+// The keys and the values of table fields and the expressions in function names are
+// visited as children of TableExpr and FuncDefStmt.
+func Walk(v Visitor, node Element) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *AssignStmt:
+		walkExprs(v, n.Lhs)
+		walkExprs(v, n.Rhs)
+	case *LocalAssignStmt:
+		walkExprs(v, n.Exprs)
+	case *FuncCallStmt:
+		Walk(v, n.Expr)
+	case *DoBlockStmt:
+		WalkStmts(v, n.Stmts)
+	case *WhileStmt:
+		Walk(v, n.Condition)
+		WalkStmts(v, n.Stmts)
+	case *RepeatStmt:
+		WalkStmts(v, n.Stmts)
+		Walk(v, n.Condition)
+	case *IfStmt:
+		Walk(v, n.Condition)
+		WalkStmts(v, n.Then)
+		WalkStmts(v, n.Else)
+	case *NumberForStmt:
+		Walk(v, n.Init)
+		Walk(v, n.Limit)
+		if n.Step != nil {
+			Walk(v, n.Step)
+		}
+		WalkStmts(v, n.Stmts)
+	case *GenericForStmt:
+		walkExprs(v, n.Exprs)
+		WalkStmts(v, n.Stmts)
+	case *FuncDefStmt:
+		if n.Name.Func != nil {
+			Walk(v, n.Name.Func)
+		} else {
+			Walk(v, n.Name.Receiver)
+		}
+		Walk(v, n.Func)
+	case *ReturnStmt:
+		walkExprs(v, n.Exprs)
+	case *BreakStmt, *LabelStmt, *GotoStmt:
+		// nothing to do
+
+	case *TrueExpr, *FalseExpr, *NilExpr, *NumberExpr, *StringExpr, *Comma3Expr, *IdentExpr:
+		// nothing to do
+	case *AttrGetExpr:
+		Walk(v, n.Object)
+		Walk(v, n.Key)
+	case *TableExpr:
+		for _, field := range n.Fields {
+			if field.Key != nil {
+				Walk(v, field.Key)
+			}
+			Walk(v, field.Value)
+		}
+	case *FuncCallExpr:
+		if n.Func != nil {
+			Walk(v, n.Func)
+		} else {
+			Walk(v, n.Receiver)
+		}
+		walkExprs(v, n.Args)
+	case *LogicalOpExpr:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+	case *RelationalOpExpr:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+	case *StringConcatOpExpr:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+	case *ArithmeticOpExpr:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+	case *UnaryMinusOpExpr:
+		Walk(v, n.Expr)
+	case *UnaryNotOpExpr:
+		Walk(v, n.Expr)
+	case *UnaryLenOpExpr:
+		Walk(v, n.Expr)
+	case *FunctionExpr:
+		WalkStmts(v, n.Stmts)
+
+	default:
+		panic("ast.Walk: unexpected node type " + typeName(node))
+	}
+
+	v.Visit(nil)
+}
+
+// WalkStmts calls Walk for each statement of stmts, like a chunk returned by parse.Parse.
+func WalkStmts(v Visitor, stmts []Stmt) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExprs(v Visitor, exprs []Expr) {
+	for _, expr := range exprs {
+		Walk(v, expr)
+	}
+}
+
+type inspector func(Element) bool
+
+func (f inspector) Visit(node Element) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling f(node); node must
+// not be nil. If f returns true, Inspect invokes f recursively for each of the children
+// of node, followed by a call of f(nil).
+func Inspect(node Element, f func(Element) bool) {
+	Walk(inspector(f), node)
+}
+
+// InspectStmts calls Inspect for each statement of stmts.
+func InspectStmts(stmts []Stmt, f func(Element) bool) {
+	WalkStmts(inspector(f), stmts)
+}
@@ -0,0 +1,161 @@
+package ast_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func parseChunk(t *testing.T, src string) []ast.Stmt {
+	t.Helper()
+	chunk, err := parse.Parse(strings.NewReader(src), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunk
+}
+
+// nodeName returns the type name of node without the package, like `LocalAssignStmt`.
+func nodeName(node ast.Element) string {
+	name := fmt.Sprintf("%T", node)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+type recorder struct {
+	events *[]string
+	depth  int
+}
+
+func (r recorder) Visit(node ast.Element) ast.Visitor {
+	if node == nil {
+		*r.events = append(*r.events, strings.Repeat(" ", r.depth-1)+"end")
+		return nil
+	}
+	*r.events = append(*r.events, strings.Repeat(" ", r.depth)+nodeName(node))
+	return recorder{events: r.events, depth: r.depth + 1}
+}
+
+func TestWalk(t *testing.T) {
+	chunk := parseChunk(t, "local x = f(1)\nif x then return -x end")
+	events := []string{}
+	ast.WalkStmts(recorder{events: &events}, chunk)
+	expected := []string{
+		"LocalAssignStmt",
+		" FuncCallExpr",
+		"  IdentExpr",
+		"  end",
+		"  NumberExpr",
+		"  end",
+		" end",
+		"end",
+		"IfStmt",
+		" IdentExpr",
+		" end",
+		" ReturnStmt",
+		"  UnaryMinusOpExpr",
+		"   IdentExpr",
+		"   end",
+		"  end",
+		" end",
+		"end",
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Errorf("unexpected order:\n%s", strings.Join(events, "\n"))
+	}
+}
+
+func TestInspect(t *testing.T) {
+	chunk := parseChunk(t, "local function f() return g(1) end\nprint(h(2))")
+	names := []string{}
+	ast.InspectStmts(chunk, func(node ast.Element) bool {
+		if node == nil {
+			return false
+		}
+		names = append(names, nodeName(node))
+		// the bodies of functions are pruned
+		_, isFunc := node.(*ast.FunctionExpr)
+		return !isFunc
+	})
+	expected := []string{"LocalAssignStmt", "FunctionExpr", "FuncCallStmt", "FuncCallExpr", "IdentExpr", "FuncCallExpr", "IdentExpr", "NumberExpr"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("%v expected, but got %v", expected, names)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	chunk := parseChunk(t, "local a = x + 1\nprint(a)\ngoto done\n::done::")
+	original := parse.Dump(chunk)
+	rewritten := ast.RewriteStmts(chunk, func(node ast.Element) ast.Element {
+		switch n := node.(type) {
+		case *ast.IdentExpr:
+			if n.Value == "x" {
+				cp := *n
+				cp.Value = "y"
+				return &cp
+			}
+		case *ast.GotoStmt:
+			return nil
+		}
+		return node
+	})
+
+	// the original AST is kept as it is
+	if dump := parse.Dump(chunk); dump != original {
+		t.Errorf("the original AST is modified:\n%s", dump)
+	}
+	if len(rewritten) != 3 {
+		t.Fatalf("3 statements expected, but got %d", len(rewritten))
+	}
+	if rewritten[0] == chunk[0] {
+		t.Error("the statement whose child is replaced must be copied")
+	}
+	if rewritten[1] != chunk[1] || rewritten[2] != chunk[3] {
+		t.Error("the statements that are not rewritten must be shared")
+	}
+	lhs := rewritten[0].(*ast.LocalAssignStmt).Exprs[0].(*ast.ArithmeticOpExpr).Lhs.(*ast.IdentExpr)
+	if lhs.Value != "y" {
+		t.Errorf("y expected, but got %s", lhs.Value)
+	}
+
+	// nothing is copied if nothing is rewritten
+	same := ast.RewriteStmts(chunk, func(node ast.Element) ast.Element { return node })
+	if &same[0] != &chunk[0] {
+		t.Error("the statements must be returned as they are")
+	}
+}
+
+func TestRewritePanic(t *testing.T) {
+	chunk := parseChunk(t, "print(x)")
+	defer func() {
+		rcv := recover()
+		if rcv == nil || !strings.Contains(fmt.Sprint(rcv), "an expression is replaced with a non-expression") {
+			t.Errorf("panic expected, but got %v", rcv)
+		}
+	}()
+	ast.RewriteStmts(chunk, func(node ast.Element) ast.Element {
+		if _, ok := node.(*ast.IdentExpr); ok {
+			return &ast.BreakStmt{}
+		}
+		return node
+	})
+}
+
+func TestRewriteFuncDefPanic(t *testing.T) {
+	chunk := parseChunk(t, "function f() end")
+	defer func() {
+		rcv := recover()
+		if rcv == nil || !strings.Contains(fmt.Sprint(rcv), "the function of a function definition is replaced with *ast.NilExpr") {
+			t.Errorf("panic expected, but got %v", rcv)
+		}
+	}()
+	ast.RewriteStmts(chunk, func(node ast.Element) ast.Element {
+		if _, ok := node.(*ast.FunctionExpr); ok {
+			return &ast.NilExpr{}
+		}
+		return node
+	})
+}
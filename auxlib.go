@@ -437,6 +437,17 @@ func (ls *LState) PreloadModule(name string, loader LGFunction) {
 	ls.SetField(preload, name, ls.NewFunction(loader))
 }
 
+// preloadBoundModule is PreloadModule for the loaders bound to this LState, like the
+// methods of the objects that hold it. They are not copied to the LStates of tasks.
+func (ls *LState) preloadBoundModule(name string, loader LGFunction) {
+	ls.PreloadModule(name, loader)
+	fn := ls.GetField(ls.GetField(ls.GetField(ls.Get(EnvironIndex), "package"), "preload"), name).(*LFunction)
+	if ls.G.boundLoaders == nil {
+		ls.G.boundLoaders = make(map[*LFunction]bool)
+	}
+	ls.G.boundLoaders[fn] = true
+}
+
 // Checks whether the given index is an LChannel and returns this channel.
 func (ls *LState) CheckChannel(n int) chan LValue {
 	v := ls.Get(n)
@@ -266,7 +266,15 @@ func basePCall(L *LState) int {
 		return 2
 	}
 	nargs := L.GetTop() - 1
-	if err := L.PCall(nargs, MultRet, nil); err != nil {
+	err := L.PCallK(nargs, MultRet, nil, 0, finishPCall)
+	return finishPCall(L, err, 0)
+}
+
+// finishPCall is a continuation of pcall and xpcall. ctx is the number of values on the
+// stack before the called function.
+func finishPCall(L *LState, err error, ctx interface{}) int {
+	top := ctx.(int)
+	if err != nil {
 		L.Push(LFalse)
 		if aerr, ok := err.(*ApiError); ok {
 			L.Push(aerr.Object)
@@ -274,10 +282,9 @@ func basePCall(L *LState) int {
 			L.Push(LString(err.Error()))
 		}
 		return 2
-	} else {
-		L.Insert(LTrue, 1)
-		return L.GetTop()
 	}
+	L.Insert(LTrue, top+1)
+	return L.GetTop() - top
 }
 
 func basePrint(L *LState) int {
@@ -463,18 +470,8 @@ func baseXPCall(L *LState) int {
 
 	top := L.GetTop()
 	L.Push(fn)
-	if err := L.PCall(0, MultRet, errfunc); err != nil {
-		L.Push(LFalse)
-		if aerr, ok := err.(*ApiError); ok {
-			L.Push(aerr.Object)
-		} else {
-			L.Push(LString(err.Error()))
-		}
-		return 2
-	} else {
-		L.Insert(LTrue, top+1)
-		return L.GetTop() - top
-	}
+	err := L.PCallK(0, MultRet, errfunc, top, finishPCall)
+	return finishPCall(L, err, top)
 }
 
 /* }}} */
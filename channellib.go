@@ -2,6 +2,7 @@ package lua
 
 import (
 	"reflect"
+	"time"
 )
 
 func checkChannel(L *LState, idx int) reflect.Value {
@@ -9,10 +10,10 @@ func checkChannel(L *LState, idx int) reflect.Value {
 	return reflect.ValueOf(ch)
 }
 
-func checkGoroutineSafe(L *LState, idx int) LValue {
-	v := L.CheckAny(2)
-	if !isGoroutineSafe(v) {
-		L.ArgError(2, "can not send a function, userdata, thread or table that has a metatable")
+func checkChannelValue(L *LState, idx int) LValue {
+	v, err := marshalChannelValue(L, L.CheckAny(idx))
+	if err != nil {
+		L.ArgError(idx, "can not send a value: "+err.Error())
 	}
 	return v
 }
@@ -44,7 +45,9 @@ func channelMake(L *LState) int {
 func channelSelect(L *LState) int {
 	//TODO check case table size
 	cases := make([]reflect.SelectCase, L.GetTop())
+	timeouts := make([]bool, L.GetTop())
 	top := L.GetTop()
+	hasDefault := false
 	for i := 0; i < top; i++ {
 		cas := reflect.SelectCase{
 			Dir:  reflect.SelectSend,
@@ -63,9 +66,9 @@ func channelSelect(L *LState) int {
 				L.ArgError(i+1, "invalid select case")
 			}
 			cas.Chan = reflect.ValueOf((chan LValue)(ch))
-			v := tbl.RawGetInt(3)
-			if !isGoroutineSafe(v) {
-				L.ArgError(i+1, "can not send a function, userdata, thread or table that has a metatable")
+			v, err := marshalChannelValue(L, tbl.RawGetInt(3))
+			if err != nil {
+				L.ArgError(i+1, "can not send a value: "+err.Error())
 			}
 			cas.Send = reflect.ValueOf(v)
 		case "|<-":
@@ -76,7 +79,21 @@ func channelSelect(L *LState) int {
 			cas.Chan = reflect.ValueOf((chan LValue)(ch))
 			cas.Dir = reflect.SelectRecv
 		case "default":
+			if hasDefault {
+				L.ArgError(i+1, "multiple default cases")
+			}
+			hasDefault = true
 			cas.Dir = reflect.SelectDefault
+		case "timeout":
+			secs, ok := tbl.RawGetInt(2).(LNumber)
+			if !ok {
+				L.ArgError(i+1, "invalid select case")
+			}
+			timer := time.NewTimer(time.Duration(float64(secs) * float64(time.Second)))
+			defer timer.Stop()
+			cas.Chan = reflect.ValueOf(timer.C)
+			cas.Dir = reflect.SelectRecv
+			timeouts[i] = true
 		default:
 			L.ArgError(i+1, "invalid channel direction:"+string(dir))
 		}
@@ -93,23 +110,28 @@ func channelSelect(L *LState) int {
 
 	pos, recv, rok := reflect.Select(cases)
 
-	if L.ctx != nil && pos == L.GetTop() {
-		return 0
+	if L.ctx != nil && pos == top {
+		raiseContextError(L)
 	}
 
 	lv := LNil
-	if recv.Kind() != 0 {
+	if timeouts[pos] {
+		rok = false
+	} else if recv.Kind() != 0 {
 		lv, _ = recv.Interface().(LValue)
 		if lv == nil {
 			lv = LNil
 		}
+		lv = unmarshalChannelValue(L, lv)
 	}
 	tbl := L.Get(pos + 1).(*LTable)
 	last := tbl.RawGetInt(tbl.Len())
 	if last.Type() == LTFunction {
 		L.Push(last)
-		switch cases[pos].Dir {
-		case reflect.SelectRecv:
+		switch {
+		case timeouts[pos]:
+			L.Call(0, 0)
+		case cases[pos].Dir == reflect.SelectRecv:
 			if rok {
 				L.Push(LTrue)
 			} else {
@@ -117,10 +139,10 @@ func channelSelect(L *LState) int {
 			}
 			L.Push(lv)
 			L.Call(2, 0)
-		case reflect.SelectSend:
+		case cases[pos].Dir == reflect.SelectSend:
 			L.Push(tbl.RawGetInt(3))
 			L.Call(1, 0)
-		case reflect.SelectDefault:
+		case cases[pos].Dir == reflect.SelectDefault:
 			L.Call(0, 0)
 		}
 	}
@@ -134,6 +156,12 @@ func channelSelect(L *LState) int {
 	return 3
 }
 
+// raiseContextError raises an error that tells the context of L has been done while
+// a channel operation is blocked.
+func raiseContextError(L *LState) {
+	L.RaiseError("%w", L.ctx.Err())
+}
+
 var channelMethods = map[string]LGFunction{
 	"receive": channelReceive,
 	"send":    channelSend,
@@ -154,13 +182,17 @@ func channelReceive(L *LState) int {
 			Chan: rch,
 			Send: reflect.ValueOf(nil),
 		}}
-		_, v, ok = reflect.Select(cases)
+		var pos int
+		pos, v, ok = reflect.Select(cases)
+		if pos == 0 {
+			raiseContextError(L)
+		}
 	} else {
 		v, ok = rch.Recv()
 	}
 	if ok {
 		L.Push(LTrue)
-		L.Push(v.Interface().(LValue))
+		L.Push(unmarshalChannelValue(L, v.Interface().(LValue)))
 	} else {
 		L.Push(LFalse)
 		L.Push(LNil)
@@ -170,7 +202,22 @@ func channelReceive(L *LState) int {
 
 func channelSend(L *LState) int {
 	rch := checkChannel(L, 1)
-	v := checkGoroutineSafe(L, 2)
+	v := checkChannelValue(L, 2)
+	if L.ctx != nil {
+		cases := []reflect.SelectCase{{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(L.ctx.Done()),
+			Send: reflect.ValueOf(nil),
+		}, {
+			Dir:  reflect.SelectSend,
+			Chan: rch,
+			Send: reflect.ValueOf(v),
+		}}
+		if pos, _, _ := reflect.Select(cases); pos == 0 {
+			raiseContextError(L)
+		}
+		return 0
+	}
 	rch.Send(reflect.ValueOf(v))
 	return 0
 }
@@ -2,6 +2,7 @@ package lua
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sync"
 	"testing"
@@ -35,7 +36,7 @@ func TestChannelSelectError(t *testing.T) {
 	errorIfScriptFail(t, L, `ch = channel.make()`)
 	errorIfScriptNotFail(t, L, `channel.select({1,2,3})`, "invalid select case")
 	errorIfScriptNotFail(t, L, `channel.select({"<-|", 1, 3})`, "invalid select case")
-	errorIfScriptNotFail(t, L, `channel.select({"<-|", ch, function() end})`, "can not send a function")
+	errorIfScriptNotFail(t, L, `channel.select({"<-|", ch, coroutine.create(function() end)})`, "can not transfer a thread")
 	errorIfScriptNotFail(t, L, `channel.select({"|<-", 1, 3})`, "invalid select case")
 	errorIfScriptNotFail(t, L, `channel.select({"<-->", 1, 3})`, "invalid channel direction")
 	errorIfScriptFail(t, L, `ch:close()`)
@@ -251,7 +252,7 @@ func TestChannelSendReceive1(t *testing.T) {
 		defer L.Close()
 		L.SetGlobal("ch", LChannel(ch))
 		errorIfScriptFail(t, L, `ch:send("1")`)
-		errorIfScriptNotFail(t, L, `ch:send(function() end)`, "can not send a function")
+		errorIfScriptNotFail(t, L, `ch:send(coroutine.create(function() end))`, "can not transfer a thread")
 		errorIfScriptFail(t, L, `ch:close()`)
 	}
 	ch := make(chan LValue)
@@ -292,3 +293,36 @@ func TestCancelChannelReceive2(t *testing.T) {
 	cancel()
 	<-done
 }
+
+func TestChannelSelectTimeout(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("ch", LChannel(make(chan LValue)))
+	errorIfScriptFail(t, L, `
+    local called = false
+    local idx, rcv, ok = channel.select(
+        {"|<-", ch},
+        {"timeout", 0.01, function() called = true end}
+    )
+    assert(idx == 2 and rcv == nil and not ok)
+    assert(called)
+    idx = channel.select({"timeout", 10}, {"timeout", 0})
+    assert(idx == 2)
+    `)
+	errorIfScriptNotFail(t, L, `channel.select({"timeout", "x"})`, "invalid select case")
+	errorIfScriptNotFail(t, L, `channel.select({"default"}, {"default"})`, "multiple default cases")
+}
+
+func TestCancelChannelSend(t *testing.T) {
+	for _, script := range []string{`ch:send(1)`, `channel.select({"<-|", ch, 1})`} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		L := NewState()
+		L.SetContext(ctx)
+		L.SetGlobal("ch", LChannel(make(chan LValue)))
+		err := L.DoString(script)
+		errorIfNil(t, err)
+		errorIfFalse(t, errors.Is(err, context.DeadlineExceeded), "error must wrap context.DeadlineExceeded: %v", err)
+		L.Close()
+		cancel()
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// compileMain implements `glua compile`, that writes precompiled chunks.
+func compileMain(args []string) int {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	var opt_o string
+	var opt_s bool
+	fs.StringVar(&opt_o, "o", "", "")
+	fs.BoolVar(&opt_s, "s", false, "")
+	fs.Usage = func() {
+		fmt.Println(`Usage: glua compile [options] script.
+Available options are:
+  -o file  write the precompiled chunk to the file(default: script with the .luac extension)
+  -s       strip debug information`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	script := fs.Arg(0)
+	if len(opt_o) == 0 {
+		opt_o = strings.TrimSuffix(script, filepath.Ext(script)) + ".luac"
+	}
+	if err := compileFile(script, opt_o, opt_s); err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func compileFile(script, out string, strip bool) error {
+	file, err := os.Open(script)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	// skip the first line like LoadFile, but keep the newline for line numbers
+	if c, err := reader.ReadByte(); err == nil {
+		if c != '#' {
+			reader.UnreadByte()
+		} else if _, err := reader.ReadString('\n'); err == nil {
+			reader.UnreadByte()
+		}
+	}
+	chunk, err := parse.Parse(reader, script)
+	if err != nil {
+		return err
+	}
+	proto, err := lua.Compile(chunk, script)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := lua.DumpFunctionProto(f, proto, strip); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
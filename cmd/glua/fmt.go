@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yuin/gopher-lua/format"
+)
+
+// fmtMain implements `glua fmt`, that formats Lua sources.
+func fmtMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	var opt_w, opt_l, opt_q bool
+	var opt_i int
+	fs.BoolVar(&opt_w, "w", false, "")
+	fs.BoolVar(&opt_l, "l", false, "")
+	fs.BoolVar(&opt_q, "q", false, "")
+	fs.IntVar(&opt_i, "indent", 2, "")
+	fs.Usage = func() {
+		fmt.Println(`Usage: glua fmt [options] [script...].
+Formats the scripts, or the standard input if no scripts are given.
+Available options are:
+  -w          write the result to the scripts instead of the standard output
+  -l          list the scripts whose formatting differs
+  -q          use single quotes for strings
+  -indent n   number of spaces for indentation, 0 means a tab(default: 2)`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg := &format.Config{Indent: "\t", SingleQuote: opt_q}
+	if opt_i > 0 {
+		cfg.Indent = strings.Repeat(" ", opt_i)
+	}
+	if fs.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			src, err = format.Source(src, "<stdin>", cfg)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		os.Stdout.Write(src)
+		return 0
+	}
+	status := 0
+	for _, path := range fs.Args() {
+		if err := fmtFile(path, cfg, opt_w, opt_l); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			status = 1
+		}
+	}
+	return status
+}
+
+func fmtFile(path string, cfg *format.Config, write, list bool) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := format.Source(src, path, cfg)
+	if err != nil {
+		return err
+	}
+	changed := !bytes.Equal(src, out)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write {
+		if changed {
+			return os.WriteFile(path, out, 0644)
+		}
+		return nil
+	}
+	if !list {
+		os.Stdout.Write(out)
+	}
+	return nil
+}
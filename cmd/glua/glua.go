@@ -3,24 +3,42 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/chzyer/readline"
 	"github.com/yuin/gopher-lua"
 	"github.com/yuin/gopher-lua/parse"
 	"os"
+	"path/filepath"
 	"runtime/pprof"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compile":
+			os.Exit(compileMain(os.Args[2:]))
+		case "fmt":
+			os.Exit(fmtMain(os.Args[2:]))
+		case "lint":
+			os.Exit(lintMain(os.Args[2:]))
+		case "lsp":
+			os.Exit(lspMain(os.Args[2:]))
+		case "run":
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
 	os.Exit(mainAux())
 }
 
 func mainAux() int {
-	var opt_e, opt_l, opt_p string
+	var opt_e, opt_l, opt_p, opt_lp, opt_ap, opt_cover, opt_trace string
 	var opt_i, opt_v, opt_dt, opt_dc bool
 	var opt_m int
 	flag.StringVar(&opt_e, "e", "", "")
 	flag.StringVar(&opt_l, "l", "", "")
 	flag.StringVar(&opt_p, "p", "", "")
+	flag.StringVar(&opt_lp, "lp", "", "")
+	flag.StringVar(&opt_ap, "ap", "", "")
+	flag.StringVar(&opt_cover, "cover", "", "")
+	flag.StringVar(&opt_trace, "trace", "", "")
 	flag.IntVar(&opt_m, "mx", 0, "")
 	flag.BoolVar(&opt_i, "i", false, "")
 	flag.BoolVar(&opt_v, "v", false, "")
@@ -28,6 +46,12 @@ func mainAux() int {
 	flag.BoolVar(&opt_dc, "dc", false, "")
 	flag.Usage = func() {
 		fmt.Println(`Usage: glua [options] [script [args]].
+       glua run [options] [script [args]].
+       glua compile [-s] [-o file] script.
+       glua lint [options] script....
+       glua fmt [options] [script...].
+       glua lsp [options].
+script can be a Lua source file or a chunk precompiled by glua compile.
 Available options are:
   -e stat  execute string 'stat'
   -l name  require library 'name'
@@ -36,6 +60,15 @@ Available options are:
   -dc      dump VM codes
   -i       enter interactive mode after executing 'script'
   -p file  write cpu profiles to the file
+  -lp file write profiles of Lua functions to the file
+  -ap file write the allocations of Lua code to the file as a pprof
+           profile, or as a report by lines if the file ends with .txt
+  -cover file
+           write the line coverage to the file as lcov, or as
+           Cobertura XML or HTML if the file ends with .xml or .html
+  -trace file
+           write the calls of functions to the file as Chrome trace
+           events
   -v       show version information`)
 	}
 	flag.Parse()
@@ -54,10 +87,63 @@ Available options are:
 
 	status := 0
 
-	L := lua.NewState()
-	defer L.Close()
-	if opt_m > 0 {
-		L.SetMx(opt_m)
+	var coverage *lua.Coverage
+	if len(opt_cover) != 0 {
+		coverage = lua.NewCoverage()
+	}
+	var allocProfile *lua.AllocProfile
+	if len(opt_ap) != 0 {
+		allocProfile = lua.NewAllocProfile(1)
+	}
+	var tracer *lua.ChromeTracer
+	if len(opt_trace) != 0 {
+		f, err := os.Create(opt_trace)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		defer f.Close()
+		tracer = lua.NewChromeTracer(f)
+	}
+
+	newState := func() *lua.LState {
+		L := lua.NewState(lua.Options{LoadBinaryChunks: true})
+		if opt_m > 0 {
+			L.SetMx(opt_m)
+		}
+		if coverage != nil {
+			L.SetCoverage(coverage)
+		}
+		if allocProfile != nil {
+			L.SetAllocProfile(allocProfile)
+		}
+		if tracer != nil {
+			L.SetTracer(tracer)
+		}
+		return L
+	}
+	L := newState()
+	defer func() { L.Close() }()
+
+	if len(opt_lp) != 0 {
+		f, err := os.Create(opt_lp)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		defer f.Close()
+		if err := L.StartProfile(f); err != nil {
+			fmt.Println(err.Error())
+			return 1
+		}
+		// L is replaced by :reset in the interactive mode. the profile is of the first state,
+		// and it is written even if the state has been closed
+		profiled := L
+		defer func() {
+			if err := profiled.StopProfile(); err != nil {
+				fmt.Println(err.Error())
+			}
+		}()
 	}
 
 	if opt_v || opt_i {
@@ -114,65 +200,61 @@ Available options are:
 	}
 
 	if opt_i {
-		doREPL(L)
+		L = doREPL(L, newState)
 	}
-	return status
-}
 
-// do read/eval/print/loop
-func doREPL(L *lua.LState) {
-	rl, err := readline.New("> ")
-	if err != nil {
-		panic(err)
+	if coverage != nil {
+		if err := writeCoverage(coverage, opt_cover); err != nil {
+			fmt.Println(err.Error())
+			status = 1
+		}
 	}
-	defer rl.Close()
-	for {
-		if str, err := loadline(rl, L); err == nil {
-			if err := L.DoString(str); err != nil {
-				fmt.Println(err)
-			}
-		} else { // error on loadline
-			fmt.Println(err)
-			return
+	if allocProfile != nil {
+		if err := writeAllocProfile(allocProfile, opt_ap); err != nil {
+			fmt.Println(err.Error())
+			status = 1
 		}
 	}
-}
-
-func incomplete(err error) bool {
-	if lerr, ok := err.(*lua.ApiError); ok {
-		if perr, ok := lerr.Cause.(*parse.Error); ok {
-			return perr.Pos.Line == parse.EOF
+	if tracer != nil {
+		if err := tracer.Close(); err != nil {
+			fmt.Println(err.Error())
+			status = 1
 		}
 	}
-	return false
+	return status
 }
 
-func loadline(rl *readline.Instance, L *lua.LState) (string, error) {
-	rl.SetPrompt("> ")
-	if line, err := rl.Readline(); err == nil {
-		if _, err := L.LoadString("return " + line); err == nil { // try add return <...> then compile
-			return line, nil
-		} else {
-			return multiline(line, rl, L)
-		}
-	} else {
-		return "", err
+func writeCoverage(coverage *lua.Coverage, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
 	}
+	switch filepath.Ext(path) {
+	case ".xml":
+		err = coverage.WriteCobertura(f)
+	case ".html":
+		err = coverage.WriteHTML(f)
+	default:
+		err = coverage.WriteLcov(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
-func multiline(ml string, rl *readline.Instance, L *lua.LState) (string, error) {
-	for {
-		if _, err := L.LoadString(ml); err == nil { // try compile
-			return ml, nil
-		} else if !incomplete(err) { // syntax error , but not EOF
-			return ml, nil
-		} else {
-			rl.SetPrompt(">> ")
-			if line, err := rl.Readline(); err == nil {
-				ml = ml + "\n" + line
-			} else {
-				return "", err
-			}
-		}
+func writeAllocProfile(profile *lua.AllocProfile, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".txt" {
+		err = profile.WriteReport(f)
+	} else {
+		err = profile.WriteProfile(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
+	return err
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yuin/gopher-lua/lint"
+)
+
+// lintMain implements `glua lint`, that checks Lua sources statically.
+func lintMain(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	var opt_g, opt_s, opt_d string
+	var opt_j bool
+	fs.StringVar(&opt_g, "globals", "", "")
+	fs.StringVar(&opt_s, "signatures", "", "")
+	fs.StringVar(&opt_d, "disable", "", "")
+	fs.BoolVar(&opt_j, "json", false, "")
+	fs.Usage = func() {
+		fmt.Println(`Usage: glua lint [options] script....
+Available options are:
+  -globals names     comma separated names of globals provided by the host
+  -signatures file   check arguments of calls against the signatures in the file
+  -disable codes     comma separated codes of diagnostics not to report
+  -json              print diagnostics in JSON`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cfg := &lint.Config{
+		Globals:  splitList(opt_g),
+		Disabled: splitList(opt_d),
+	}
+	if len(opt_s) != 0 {
+		sigs, err := lint.LoadSignatures(opt_s)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		cfg.Signatures = sigs
+	}
+
+	diags := []*lint.Diagnostic{}
+	for _, path := range fs.Args() {
+		ds, err := lint.CheckFile(path, cfg)
+		if err != nil {
+			fmt.Println(err.Error())
+			return 2
+		}
+		diags = append(diags, ds...)
+	}
+	if opt_j {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(diags)
+	} else {
+		for _, d := range diags {
+			fmt.Println(d.String())
+		}
+	}
+	if len(diags) != 0 {
+		return 1
+	}
+	return 0
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/yuin/gopher-lua/lsp"
+)
+
+// lspMain implements `glua lsp`, that runs a language server over the standard input and
+// output.
+func lspMain(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	var opt_g, opt_s, opt_p string
+	fs.StringVar(&opt_g, "globals", "", "")
+	fs.StringVar(&opt_s, "stubs", "", "")
+	fs.StringVar(&opt_p, "path", "", "")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `Usage: glua lsp [options].
+Runs a language server that speaks the Language Server Protocol over the standard input and output.
+Available options are:
+  -globals names   comma separated names of globals provided by the host
+  -stubs files     comma separated Lua files that describe the modules provided by the host
+  -path path       path to search for the modules loaded by require(default: ?.lua;?/init.lua)`)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	server, err := lsp.NewServer(&lsp.Config{
+		Globals: splitList(opt_g),
+		Stubs:   splitList(opt_s),
+		Path:    opt_p,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	if err := server.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+const replHelp = `:load file   execute the file
+:reset       restart with a new state
+:time code   execute the code and show the elapsed time
+:help        show this message`
+
+type repl struct {
+	L        *lua.LState
+	newState func() *lua.LState
+}
+
+// do read/eval/print/loop. It returns the state that is used at the end of the loop.
+func doREPL(L *lua.LState, newState func() *lua.LState) *lua.LState {
+	r := &repl{L: L, newState: newState}
+	cfg := &readline.Config{
+		Prompt:       "> ",
+		HistoryFile:  historyFile(),
+		AutoComplete: &completer{r},
+	}
+	rl, err := readline.NewEx(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer rl.Close()
+	for {
+		str, err := loadline(rl, r.L)
+		if err == readline.ErrInterrupt {
+			continue
+		} else if err != nil { // error on loadline
+			if err != io.EOF {
+				fmt.Println(err)
+			}
+			return r.L
+		}
+		if strings.HasPrefix(str, ":") {
+			r.command(str)
+		} else if err := r.eval(str); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// historyFile returns the path of the history file. It can be changed by the
+// GLUA_HISTORY environment variable.
+func historyFile() string {
+	if path := os.Getenv("GLUA_HISTORY"); len(path) != 0 {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".glua_history")
+}
+
+func (r *repl) command(line string) {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch name {
+	case ":load":
+		if len(arg) == 0 {
+			fmt.Println("usage: :load file")
+		} else if err := r.L.DoFile(arg); err != nil {
+			fmt.Println(err)
+		}
+	case ":reset":
+		r.L.Close()
+		r.L = r.newState()
+	case ":time":
+		start := time.Now()
+		if err := r.eval(arg); err != nil {
+			fmt.Println(err)
+		}
+		fmt.Printf("elapsed: %v\n", time.Since(start))
+	case ":help":
+		fmt.Println(replHelp)
+	default:
+		fmt.Printf("unknown command %s, see :help\n", name)
+	}
+}
+
+// eval executes src. If src is an expression, the values of it are printed.
+func (r *repl) eval(src string) error {
+	L := r.L
+	fn, err := L.LoadString("return " + src)
+	isexpr := err == nil
+	if !isexpr {
+		if fn, err = L.LoadString(src); err != nil {
+			return err
+		}
+	}
+	top := L.GetTop()
+	L.Push(fn)
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		return err
+	}
+	if isexpr && L.GetTop() > top {
+		values := make([]string, 0, L.GetTop()-top)
+		for i := top + 1; i <= L.GetTop(); i++ {
+			values = append(values, prettyFormat(L, L.Get(i)))
+		}
+		fmt.Println(strings.Join(values, "\t"))
+	}
+	L.SetTop(top)
+	return nil
+}
+
+func incomplete(err error) bool {
+	if lerr, ok := err.(*lua.ApiError); ok {
+		if perr, ok := lerr.Cause.(*parse.Error); ok {
+			return perr.Pos.Line == parse.EOF
+		}
+	}
+	return false
+}
+
+func loadline(rl *readline.Instance, L *lua.LState) (string, error) {
+	rl.SetPrompt("> ")
+	if line, err := rl.Readline(); err == nil {
+		if strings.HasPrefix(line, ":") {
+			return line, nil
+		}
+		if strings.HasPrefix(line, "=") { // "=expr" prints the values of expr like lua
+			line = line[1:]
+		}
+		if _, err := L.LoadString("return " + line); err == nil { // try add return <...> then compile
+			return line, nil
+		} else {
+			return multiline(line, rl, L)
+		}
+	} else {
+		return "", err
+	}
+}
+
+func multiline(ml string, rl *readline.Instance, L *lua.LState) (string, error) {
+	for {
+		if _, err := L.LoadString(ml); err == nil { // try compile
+			return ml, nil
+		} else if !incomplete(err) { // syntax error , but not EOF
+			return ml, nil
+		} else {
+			rl.SetPrompt(">> ")
+			if line, err := rl.Readline(); err == nil {
+				ml = ml + "\n" + line
+			} else {
+				return "", err
+			}
+		}
+	}
+}
+
+/* pretty printing {{{ */
+
+const prettyLineWidth = 72
+
+// prettyFormat formats lv like a Lua literal. Tables are expanded, and tables that
+// are being formatted are shown as <cycle>.
+func prettyFormat(L *lua.LState, lv lua.LValue) string {
+	p := &prettyPrinter{L: L, visiting: make(map[*lua.LTable]bool)}
+	return p.format(lv, "")
+}
+
+type prettyPrinter struct {
+	L        *lua.LState
+	visiting map[*lua.LTable]bool
+}
+
+func (p *prettyPrinter) format(lv lua.LValue, indent string) string {
+	switch v := lv.(type) {
+	case lua.LString:
+		return strconv.Quote(string(v))
+	case *lua.LTable:
+		if p.L.GetMetaField(v, "__tostring") != lua.LNil {
+			return p.toString(v)
+		}
+		return p.formatTable(v, indent)
+	case *lua.LUserData:
+		return p.toString(v)
+	default:
+		return lv.String()
+	}
+}
+
+// toString converts lv by its __tostring metamethod, or returns lv.String() if the
+// metamethod raises an error.
+func (p *prettyPrinter) toString(lv lua.LValue) string {
+	L := p.L
+	fn := L.NewFunction(func(L *lua.LState) int {
+		L.Push(L.ToStringMeta(L.Get(1)))
+		return 1
+	})
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, lv); err != nil {
+		return lv.String()
+	}
+	defer L.Pop(1)
+	return L.Get(-1).String()
+}
+
+func (p *prettyPrinter) formatTable(tb *lua.LTable, indent string) string {
+	if p.visiting[tb] {
+		return fmt.Sprintf("<cycle %v>", tb)
+	}
+	p.visiting[tb] = true
+	defer delete(p.visiting, tb)
+
+	inner := indent + "  "
+	entries := []string{}
+	n := tb.Len()
+	for i := 1; i <= n; i++ {
+		entries = append(entries, p.format(tb.RawGetInt(i), inner))
+	}
+	type entry struct {
+		key  string
+		text string
+	}
+	fields := []entry{}
+	tb.ForEach(func(key, value lua.LValue) {
+		if num, ok := key.(lua.LNumber); ok {
+			if i := int(num); lua.LNumber(i) == num && i >= 1 && i <= n {
+				return
+			}
+		}
+		k := p.formatKey(key, inner)
+		fields = append(fields, entry{k, k + " = " + p.format(value, inner)})
+	})
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	for _, f := range fields {
+		entries = append(entries, f.text)
+	}
+
+	if len(entries) == 0 {
+		return "{}"
+	}
+	line := "{ " + strings.Join(entries, ", ") + " }"
+	if len(indent)+len(line) <= prettyLineWidth && !strings.Contains(line, "\n") {
+		return line
+	}
+	return "{\n" + inner + strings.Join(entries, ",\n"+inner) + "\n" + indent + "}"
+}
+
+func (p *prettyPrinter) formatKey(key lua.LValue, indent string) string {
+	if s, ok := key.(lua.LString); ok && isIdentifier(string(s)) {
+		return string(s)
+	}
+	return "[" + p.format(key, indent) + "]"
+}
+
+func isIdentifier(s string) bool {
+	if len(s) == 0 || isKeyword(s) {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "and", "break", "do", "else", "elseif", "end", "false", "for", "function", "if", "in",
+		"local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while":
+		return true
+	}
+	return false
+}
+
+/* }}} */
+
+/* completion {{{ */
+
+// completer completes global variables and fields of tables(`table.field` and
+// `value:method`).
+type completer struct {
+	r *repl
+}
+
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && isPathRune(line[start-1]) {
+		start--
+	}
+	word := string(line[start:pos])
+	var target lua.LValue = c.r.L.G.Global
+	prefix := word
+	if i := strings.LastIndexAny(word, ".:"); i >= 0 {
+		target = c.resolve(word[:i])
+		prefix = word[i+1:]
+	}
+	names := c.fieldNames(target, prefix)
+	candidates := make([][]rune, 0, len(names))
+	for _, name := range names {
+		candidates = append(candidates, []rune(name[len(prefix):]))
+	}
+	return candidates, len([]rune(prefix))
+}
+
+func isPathRune(c rune) bool {
+	return c == '_' || c == '.' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// resolve returns the value of the path like `a.b.c`, or nil if the path is not found.
+func (c *completer) resolve(path string) lua.LValue {
+	L := c.r.L
+	var lv lua.LValue = L.G.Global
+	for _, name := range strings.FieldsFunc(path, func(c rune) bool { return c == '.' || c == ':' }) {
+		lv = c.field(lv, name)
+		if lv == lua.LNil {
+			return lua.LNil
+		}
+	}
+	return lv
+}
+
+// field returns the field of lv without calling metamethods other than table __index.
+func (c *completer) field(lv lua.LValue, name string) lua.LValue {
+	for depth := 0; depth < 8 && lv != lua.LNil; depth++ {
+		if tb, ok := lv.(*lua.LTable); ok {
+			if v := tb.RawGetString(name); v != lua.LNil {
+				return v
+			}
+		}
+		lv = c.r.L.GetMetaField(lv, "__index")
+	}
+	return lua.LNil
+}
+
+// fieldNames returns the sorted string keys that start with prefix in lv and the
+// tables that are __index metamethods of lv.
+func (c *completer) fieldNames(lv lua.LValue, prefix string) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for depth := 0; depth < 8 && lv != lua.LNil; depth++ {
+		if tb, ok := lv.(*lua.LTable); ok {
+			tb.ForEach(func(key, _ lua.LValue) {
+				if s, ok := key.(lua.LString); ok && strings.HasPrefix(string(s), prefix) && !seen[string(s)] {
+					seen[string(s)] = true
+					names = append(names, string(s))
+				}
+			})
+		}
+		lv = c.r.L.GetMetaField(lv, "__index")
+	}
+	sort.Strings(names)
+	return names
+}
+
+/* }}} */
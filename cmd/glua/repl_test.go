@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestPrettyFormat(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`
+cycle = {1}
+cycle.self = cycle
+named = setmetatable({}, {__tostring = function() return "named" end})
+broken = setmetatable({}, {__tostring = function() error("broken") end})
+long = {}
+for i = 1, 30 do long[i] = i end
+`); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		src      string
+		expected string
+	}{
+		{`"a\nb"`, `"a\nb"`},
+		{`{}`, `{}`},
+		{`{1, 2, x = true, ["a b"] = 3, ["end"] = 4}`, `{ 1, 2, ["a b"] = 3, ["end"] = 4, x = true }`},
+		{`{a = {b = {}}}`, `{ a = { b = {} } }`},
+		{`cycle`, `{ 1, self = <cycle table: `},
+		{`named`, `named`},
+		{`{named}`, `{ named }`},
+		{`broken`, `table: `},
+		{`long`, "{\n  1,\n  2,"},
+	}
+	for _, c := range cases {
+		if err := L.DoString("return " + c.src); err != nil {
+			t.Fatal(err)
+		}
+		actual := prettyFormat(L, L.Get(-1))
+		L.SetTop(0)
+		if !strings.HasPrefix(actual, c.expected) {
+			t.Errorf("%v: %q expected, but got %q", c.src, c.expected, actual)
+		}
+	}
+}
+
+func TestCompleter(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoString(`
+obj = setmetatable({field = 1}, {__index = {method = function() end, mine = 2}})
+`); err != nil {
+		t.Fatal(err)
+	}
+	c := &completer{&repl{L: L}}
+	cases := []struct {
+		line       string
+		candidates []string
+		length     int
+	}{
+		{"str", []string{"ing"}, 3},
+		{"x = string.up", []string{"per"}, 2},
+		{"string.", nil, 0},
+		{"obj:m", []string{"ethod", "ine"}, 1},
+		{"obj.f", []string{"ield"}, 1},
+		{"missing.x", []string{}, 1},
+	}
+	for _, cs := range cases {
+		candidates, length := c.Do([]rune(cs.line), len(cs.line))
+		if length != cs.length {
+			t.Errorf("%q: length %v expected, but got %v", cs.line, cs.length, length)
+		}
+		if cs.candidates == nil {
+			if len(candidates) == 0 {
+				t.Errorf("%q: candidates expected", cs.line)
+			}
+			continue
+		}
+		actual := []string{}
+		for _, candidate := range candidates {
+			actual = append(actual, string(candidate))
+		}
+		if strings.Join(actual, " ") != strings.Join(cs.candidates, " ") {
+			t.Errorf("%q: %v expected, but got %v", cs.line, cs.candidates, actual)
+		}
+	}
+}
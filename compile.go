@@ -174,20 +174,37 @@ func (e *CompileError) Error() string {
 type codeStore struct { // {{{
 	codes []uint32
 	lines []int
+	cols  []int
 	pc    int
+	// pos is the position of the node being compiled
+	pos ast.Position
 }
 
 func (cd *codeStore) Add(inst uint32, line int) {
+	col := 0
+	if line == cd.pos.Line {
+		col = cd.pos.Column
+	}
 	if l := len(cd.codes); l <= 0 || cd.pc == l {
 		cd.codes = append(cd.codes, inst)
 		cd.lines = append(cd.lines, line)
+		cd.cols = append(cd.cols, col)
 	} else {
 		cd.codes[cd.pc] = inst
 		cd.lines[cd.pc] = line
+		cd.cols[cd.pc] = col
 	}
 	cd.pc++
 }
 
+// SetPos sets the position of the node being compiled and returns the previous one.
+// Instructions added on the same line as the node are given the column of the node.
+func (cd *codeStore) SetPos(pos ast.Position) ast.Position {
+	old := cd.pos
+	cd.pos = pos
+	return old
+}
+
 func (cd *codeStore) AddABC(op int, a int, b int, c int, line int) {
 	cd.Add(opCreateABC(op, a, b, c), line)
 }
@@ -280,6 +297,10 @@ func (cd *codeStore) PosList() []int {
 	return cd.lines[:cd.pc]
 }
 
+func (cd *codeStore) ColumnList() []int {
+	return cd.cols[:cd.pc]
+}
+
 func (cd *codeStore) LastPC() int {
 	return cd.pc - 1
 }
@@ -363,10 +384,12 @@ type codeBlock struct {
 	LastLine       int
 	labels         map[string]*gotoLabelDesc
 	firstGotoIndex int
+	// dbgLocals is the indices of the DbgLocals of the local variables of the block
+	dbgLocals []int
 }
 
 func newCodeBlock(localvars *varNamePool, blabel int, parent *codeBlock, pos ast.PositionHolder, firstGotoIndex int) *codeBlock {
-	bl := &codeBlock{localvars, blabel, parent, false, 0, 0, map[string]*gotoLabelDesc{}, firstGotoIndex}
+	bl := &codeBlock{localvars, blabel, parent, false, 0, 0, map[string]*gotoLabelDesc{}, firstGotoIndex, nil}
 	if pos != nil {
 		bl.LineStart = pos.Line()
 		bl.LastLine = pos.LastLine()
@@ -414,7 +437,7 @@ type funcContext struct {
 func newFuncContext(sourcename string, parent *funcContext) *funcContext {
 	fc := &funcContext{
 		Proto:           newFunctionProto(sourcename),
-		Code:            &codeStore{make([]uint32, 0, 1024), make([]int, 0, 1024), 0},
+		Code:            &codeStore{make([]uint32, 0, 1024), make([]int, 0, 1024), make([]int, 0, 1024), 0, ast.Position{}},
 		Parent:          parent,
 		Upvalues:        newVarNamePool(0),
 		Block:           newCodeBlock(newVarNamePool(0), labelNoJump, nil, nil, 0),
@@ -542,6 +565,7 @@ func (fc *funcContext) BlockLocalVarsCount() int {
 
 func (fc *funcContext) RegisterLocalVar(name string) int {
 	ret := fc.Block.LocalVars.Register(name)
+	fc.Block.dbgLocals = append(fc.Block.dbgLocals, len(fc.Proto.DbgLocals))
 	fc.Proto.DbgLocals = append(fc.Proto.DbgLocals, &DbgLocalInfo{Name: name, StartPc: fc.Code.LastPC() + 1})
 	fc.SetRegTop(fc.RegTop() + 1)
 	return ret
@@ -596,8 +620,8 @@ func (fc *funcContext) LeaveBlock() int {
 }
 
 func (fc *funcContext) EndScope() {
-	for _, vr := range fc.Block.LocalVars.List() {
-		fc.Proto.DbgLocals[vr.Index].EndPc = fc.Code.LastPC()
+	for _, index := range fc.Block.dbgLocals {
+		fc.Proto.DbgLocals[index].EndPc = fc.Code.LastPC()
 	}
 }
 
@@ -651,6 +675,7 @@ func compileBlock(context *funcContext, chunk []ast.Stmt) { // {{{
 } // }}}
 
 func compileStmt(context *funcContext, stmt ast.Stmt, isLastStmt bool) { // {{{
+	defer context.Code.SetPos(context.Code.SetPos(stmt.Pos()))
 	switch st := stmt.(type) {
 	case *ast.AssignStmt:
 		compileAssignStmt(context, st)
@@ -1140,6 +1165,7 @@ func compileGotoStmt(context *funcContext, stmt *ast.GotoStmt) { // {{{
 
 func compileExpr(context *funcContext, reg int, expr ast.Expr, ec *expcontext) int { // {{{
 	code := context.Code
+	defer code.SetPos(code.SetPos(expr.Pos()))
 	sreg := savereg(ec, reg)
 	sused := 1
 	if sreg < reg {
@@ -1334,6 +1360,7 @@ func compileFunctionExpr(context *funcContext, funcexpr *ast.FunctionExpr, ec *e
 	context.CheckUnresolvedGoto()
 	context.Proto.Code = context.Code.List()
 	context.Proto.DbgSourcePositions = context.Code.PosList()
+	context.Proto.DbgSourceColumns = context.Code.ColumnList()
 	context.Proto.DbgUpvalues = context.Upvalues.Names()
 	context.Proto.NumUpvalues = uint8(len(context.Proto.DbgUpvalues))
 	for _, clv := range context.Proto.Constants {
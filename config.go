@@ -11,6 +11,7 @@ var RegistryGrowStep = 32
 var CallStackSize = 256
 var MaxTableGetLoop = 100
 var MaxArrayIndex = 67108864
+var MaxTaskWorkers = 64
 
 type LNumber float64
 
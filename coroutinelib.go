@@ -8,12 +8,14 @@ func OpenCoroutine(L *LState) int {
 }
 
 var coFuncs = map[string]LGFunction{
-	"create":  coCreate,
-	"yield":   coYield,
-	"resume":  coResume,
-	"running": coRunning,
-	"status":  coStatus,
-	"wrap":    coWrap,
+	"create":      coCreate,
+	"yield":       coYield,
+	"resume":      coResume,
+	"running":     coRunning,
+	"status":      coStatus,
+	"wrap":        coWrap,
+	"close":       coClose,
+	"isyieldable": coIsYieldable,
 }
 
 func coCreate(L *LState) int {
@@ -70,6 +72,9 @@ func coResume(L *LState) int {
 		nargs := L.GetTop() - 1
 		L.XMoveTo(th, nargs)
 		cf.NArgs = nargs
+		if th.tracing != nil {
+			th.tracing.call(th, cf)
+		}
 		th.initCallFrame(cf)
 		th.Panic = panicWithoutTraceback
 	} else {
@@ -83,11 +88,13 @@ func coResume(L *LState) int {
 
 func coRunning(L *LState) int {
 	if L.G.MainThread == L {
-		L.Push(LNil)
-		return 1
+		L.Push(L)
+		L.Push(LTrue)
+		return 2
 	}
 	L.Push(L.G.CurrentThread)
-	return 1
+	L.Push(LFalse)
+	return 2
 }
 
 func coStatus(L *LState) int {
@@ -95,6 +102,33 @@ func coStatus(L *LState) int {
 	return 1
 }
 
+func coClose(L *LState) int {
+	th := L.CheckThread(1)
+	if status := L.Status(th); status == "running" || status == "normal" {
+		L.ArgError(1, "can not close a "+status+" coroutine")
+	}
+	if err := L.CloseThread(th); err != nil {
+		L.Push(LFalse)
+		if aerr, ok := err.(*ApiError); ok {
+			L.Push(aerr.Object)
+		} else {
+			L.Push(LString(err.Error()))
+		}
+		return 2
+	}
+	L.Push(LTrue)
+	return 1
+}
+
+func coIsYieldable(L *LState) int {
+	if L.GetTop() > 0 {
+		L.Push(LBool(L.CheckThread(1).IsYieldable()))
+		return 1
+	}
+	L.Push(LBool(L.IsYieldable()))
+	return 1
+}
+
 func wrapaux(L *LState) int {
 	L.Insert(L.ToThread(UpvalueIndex(1)), 1)
 	return coResume(L)
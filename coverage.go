@@ -0,0 +1,465 @@
+package lua
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+/* Coverage {{{ */
+
+// Coverage collects the lines of Lua sources executed by LStates. Set a Coverage to
+// LStates by LState.SetCoverage; a Coverage can be shared by many LStates running in
+// different goroutines, and the hit counts of the same source are merged even if the
+// LStates compiled the source separately.
+//
+// Lines are known from the debug information of the compiled functions, so the functions
+// whose debug information is stripped are not recorded.
+type Coverage struct {
+	// funcs maps coverageKey to *funcCoverage
+	funcs sync.Map
+
+	mu sync.Mutex
+	// lines are the hit counts merged by Merge or added by AddFile, keyed by sources and
+	// lines
+	lines map[string]map[int]uint64
+}
+
+// coverageKey identifies a function by its location in its source, so that the functions
+// compiled from the same source share their hit counts and the compiled functions are not
+// kept by the Coverage.
+type coverageKey struct {
+	source   string
+	line     int
+	lastLine int
+	// column is the column of the first instruction, which tells the functions defined in
+	// the same lines apart
+	column int
+	ncode  int
+}
+
+func newCoverageKey(proto *FunctionProto) coverageKey {
+	key := coverageKey{
+		source:   proto.SourceName,
+		line:     proto.LineDefined,
+		lastLine: proto.LastLineDefined,
+		ncode:    len(proto.Code),
+	}
+	if len(proto.DbgSourceColumns) != 0 {
+		key.column = proto.DbgSourceColumns[0]
+	}
+	return key
+}
+
+// funcCoverage holds the hit counts of the instructions of a function.
+type funcCoverage struct {
+	source string
+	// lines are the lines of the instructions returned by codeLines
+	lines  []int
+	counts []uint64
+}
+
+// FileCoverage is the coverage of a source.
+type FileCoverage struct {
+	// Source is the name of the chunk, that is the path of the file for DoFile.
+	Source string
+	// Lines are the executable lines sorted by the line numbers.
+	Lines []LineCoverage
+}
+
+// LineCoverage is the number of the times a line is executed.
+type LineCoverage struct {
+	Line int
+	Hits uint64
+}
+
+// NewCoverage returns a new empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{lines: map[string]map[int]uint64{}}
+}
+
+// hit records the execution of the instruction at pc of proto by L.
+func (c *Coverage) hit(L *LState, proto *FunctionProto, pc int) {
+	if L.coverProto != proto {
+		L.coverProto, L.coverCounts = proto, c.counts(proto)
+	}
+	if L.coverCounts != nil {
+		atomic.AddUint64(&L.coverCounts[pc], 1)
+	}
+}
+
+// counts returns the hit counts of the instructions of proto, or nil if proto has no line
+// information. The nested functions of proto are registered with proto, so that their lines
+// are reported even if they are never called.
+func (c *Coverage) counts(proto *FunctionProto) []uint64 {
+	key := newCoverageKey(proto)
+	if fc, ok := c.funcs.Load(key); ok {
+		return fc.(*funcCoverage).counts
+	}
+	c.register(proto)
+	fc, _ := c.funcs.Load(key)
+	return fc.(*funcCoverage).counts
+}
+
+func (c *Coverage) register(proto *FunctionProto) {
+	fc := &funcCoverage{source: proto.SourceName}
+	if len(proto.DbgSourcePositions) == len(proto.Code) && len(proto.Code) != 0 {
+		fc.lines = append([]int(nil), codeLines(proto)...)
+		fc.counts = make([]uint64, len(proto.Code))
+	}
+	if _, loaded := c.funcs.LoadOrStore(newCoverageKey(proto), fc); loaded {
+		return
+	}
+	for _, child := range proto.FunctionPrototypes {
+		c.register(child)
+	}
+}
+
+// AddFile registers the executable lines of the Lua source file at path, so that the file is
+// reported even if it is never loaded.
+func (c *Coverage) AddFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	chunk, err := parse.Parse(bufio.NewReader(file), path)
+	if err != nil {
+		return err
+	}
+	proto, err := Compile(chunk, path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addProtoLines(proto)
+	return nil
+}
+
+func (c *Coverage) addProtoLines(proto *FunctionProto) {
+	for _, line := range codeLines(proto) {
+		c.addLine(proto.SourceName, line, 0)
+	}
+	for _, child := range proto.FunctionPrototypes {
+		c.addProtoLines(child)
+	}
+}
+
+// codeLines returns the lines of the instructions of proto except the last one, that is the
+// return instruction the compiler appends to every function at the line of `end`.
+func codeLines(proto *FunctionProto) []int {
+	if len(proto.DbgSourcePositions) == 0 {
+		return nil
+	}
+	return proto.DbgSourcePositions[:len(proto.DbgSourcePositions)-1]
+}
+
+func (c *Coverage) addLine(source string, line int, hits uint64) {
+	if line <= 0 {
+		return
+	}
+	lines, ok := c.lines[source]
+	if !ok {
+		lines = map[int]uint64{}
+		c.lines[source] = lines
+	}
+	lines[line] += hits
+}
+
+// Merge adds the hit counts recorded in other to c.
+func (c *Coverage) Merge(other *Coverage) {
+	files := other.Files()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, file := range files {
+		for _, line := range file.Lines {
+			c.addLine(file.Source, line.Line, line.Hits)
+		}
+	}
+}
+
+// Files returns the coverage of the sources sorted by their names. The hit count of a line
+// is the largest hit count of the instructions of a function in the line, summed over the
+// functions defined in the line.
+func (c *Coverage) Files() []*FileCoverage {
+	lines := map[string]map[int]uint64{}
+	add := func(source string, line int, hits uint64) {
+		if line <= 0 {
+			return
+		}
+		if _, ok := lines[source]; !ok {
+			lines[source] = map[int]uint64{}
+		}
+		lines[source][line] += hits
+	}
+	c.funcs.Range(func(_, v interface{}) bool {
+		fc := v.(*funcCoverage)
+		if fc.counts == nil {
+			return true
+		}
+		max := map[int]uint64{}
+		for i, line := range fc.lines {
+			hits := atomic.LoadUint64(&fc.counts[i])
+			if old, ok := max[line]; !ok || hits > old {
+				max[line] = hits
+			}
+		}
+		for line, hits := range max {
+			add(fc.source, line, hits)
+		}
+		return true
+	})
+	c.mu.Lock()
+	for source, ls := range c.lines {
+		for line, hits := range ls {
+			add(source, line, hits)
+		}
+	}
+	c.mu.Unlock()
+
+	files := make([]*FileCoverage, 0, len(lines))
+	for source, ls := range lines {
+		file := &FileCoverage{Source: source, Lines: make([]LineCoverage, 0, len(ls))}
+		for line, hits := range ls {
+			file.Lines = append(file.Lines, LineCoverage{Line: line, Hits: hits})
+		}
+		sort.Slice(file.Lines, func(i, j int) bool { return file.Lines[i].Line < file.Lines[j].Line })
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Source < files[j].Source })
+	return files
+}
+
+// Covered returns the number of the lines executed at least once.
+func (fc *FileCoverage) Covered() int {
+	n := 0
+	for _, line := range fc.Lines {
+		if line.Hits > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Rate returns the ratio of the executed lines to the executable lines.
+func (fc *FileCoverage) Rate() float64 {
+	return rate(fc.Covered(), len(fc.Lines))
+}
+
+func rate(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total)
+}
+
+/* }}} */
+
+/* reports {{{ */
+
+// WriteLcov writes the coverage in the lcov tracefile format.
+func (c *Coverage) WriteLcov(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, file := range c.Files() {
+		fmt.Fprintf(bw, "SF:%s\n", file.Source)
+		for _, line := range file.Lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.Line, line.Hits)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(file.Lines), file.Covered())
+	}
+	return bw.Flush()
+}
+
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      int                `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity int              `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity int             `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int    `xml:"number,attr"`
+	Hits   uint64 `xml:"hits,attr"`
+}
+
+// WriteCobertura writes the coverage in the Cobertura XML format. All the sources are
+// reported in a package, each as a class.
+func (c *Coverage) WriteCobertura(w io.Writer) error {
+	report := &coberturaReport{
+		BranchRate: "0",
+		Version:    PackageName + " " + PackageVersion,
+		Timestamp:  time.Now().UnixNano() / int64(time.Millisecond),
+		Sources:    []string{"."},
+	}
+	pkg := coberturaPackage{Name: ".", BranchRate: "0"}
+	for _, file := range c.Files() {
+		class := coberturaClass{
+			Name:       strings.TrimSuffix(file.Source, ".lua"),
+			Filename:   file.Source,
+			LineRate:   formatRate(file.Rate()),
+			BranchRate: "0",
+			Lines:      make([]coberturaLine, 0, len(file.Lines)),
+		}
+		for _, line := range file.Lines {
+			class.Lines = append(class.Lines, coberturaLine{Number: line.Line, Hits: line.Hits})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		report.LinesCovered += file.Covered()
+		report.LinesValid += len(file.Lines)
+	}
+	report.LineRate = formatRate(rate(report.LinesCovered, report.LinesValid))
+	pkg.LineRate = report.LineRate
+	report.Packages = []coberturaPackage{pkg}
+
+	if _, err := io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func formatRate(r float64) string {
+	return fmt.Sprintf("%.4f", r)
+}
+
+var coverageHTML = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage</title>
+<style>
+body { font-family: sans-serif; }
+table.summary td, table.summary th { padding: 2px 12px; text-align: left; }
+table.source { border-collapse: collapse; font-family: monospace; white-space: pre; }
+table.source td { padding: 0 8px; }
+td.line, td.hits { color: #888; text-align: right; }
+tr.hit { background: #dfd; }
+tr.miss { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>Coverage: {{.Percent}}</h1>
+<table class="summary">
+<tr><th>Source</th><th>Lines</th><th>Covered</th><th>Rate</th></tr>
+{{range $i, $f := .Files}}<tr><td><a href="#file{{$i}}">{{$f.Source}}</a></td><td>{{$f.Total}}</td><td>{{$f.Covered}}</td><td>{{$f.Percent}}</td></tr>
+{{end}}</table>
+{{range $i, $f := .Files}}
+<h2 id="file{{$i}}">{{$f.Source}} ({{$f.Percent}})</h2>
+<table class="source">
+{{range $f.Lines}}<tr class="{{.Class}}"><td class="line">{{.Line}}</td><td class="hits">{{if .Class}}{{.Hits}}{{end}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type htmlFile struct {
+	Source  string
+	Total   int
+	Covered int
+	Percent string
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Line  int
+	Hits  uint64
+	Class string
+	Text  string
+}
+
+// WriteHTML writes a report in HTML that shows the sources with the executed lines and the
+// lines not executed highlighted. The sources are read from the files named by the chunk
+// names; only the hit counts are shown for the sources that can not be read.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	data := struct {
+		Percent string
+		Files   []*htmlFile
+	}{}
+	covered, total := 0, 0
+	for _, file := range c.Files() {
+		hf := &htmlFile{Source: file.Source, Total: len(file.Lines), Covered: file.Covered()}
+		hf.Percent = formatPercent(file.Rate())
+		covered += hf.Covered
+		total += hf.Total
+		hits := map[int]uint64{}
+		last := 0
+		for _, line := range file.Lines {
+			hits[line.Line] = line.Hits
+			last = line.Line
+		}
+		var text []string
+		if src, err := os.ReadFile(file.Source); err == nil {
+			text = strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n")
+			if len(text) > last {
+				last = len(text)
+			}
+		}
+		for i := 1; i <= last; i++ {
+			hl := htmlLine{Line: i}
+			if i <= len(text) {
+				hl.Text = text[i-1]
+			}
+			if h, ok := hits[i]; ok {
+				hl.Hits = h
+				hl.Class = "miss"
+				if h > 0 {
+					hl.Class = "hit"
+				}
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		data.Files = append(data.Files, hf)
+	}
+	data.Percent = formatPercent(rate(covered, total))
+	return coverageHTML.Execute(w, data)
+}
+
+func formatPercent(r float64) string {
+	return fmt.Sprintf("%.1f%%", r*100)
+}
+
+/* }}} */
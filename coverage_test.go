@@ -0,0 +1,210 @@
+package lua
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const coverageScript = `local function f(x)
+  if x > 1 then
+    return x
+  end
+  return 0
+end
+
+local function never()
+  print("no")
+end
+
+for i = 1, 3 do
+  f(i)
+end
+`
+
+func coverageHits(c *Coverage, source string) map[int]uint64 {
+	hits := map[int]uint64{}
+	for _, file := range c.Files() {
+		if file.Source == source {
+			for _, line := range file.Lines {
+				hits[line.Line] = line.Hits
+			}
+		}
+	}
+	return hits
+}
+
+func TestCoverageLines(t *testing.T) {
+	c := NewCoverage()
+	L := NewState()
+	defer L.Close()
+	L.SetCoverage(c)
+	errorIfFalse(t, L.Coverage() == c, "Coverage returns the coverage set")
+	errorIfScriptFail(t, L, coverageScript)
+
+	hits := coverageHits(c, "<string>")
+	expected := map[int]uint64{1: 1, 2: 3, 3: 2, 5: 1, 8: 1, 9: 0, 12: 4, 13: 3}
+	errorIfNotEqual(t, len(expected), len(hits))
+	for line, n := range expected {
+		errorIfFalse(t, hits[line] == n, "line %v: expected %v hits, but got %v", line, n, hits[line])
+	}
+
+	files := c.Files()
+	errorIfNotEqual(t, 1, len(files))
+	errorIfNotEqual(t, 7, files[0].Covered())
+	errorIfNotEqual(t, 0.875, files[0].Rate())
+
+	L.SetCoverage(nil)
+	errorIfScriptFail(t, L, coverageScript)
+	errorIfNotEqual(t, uint64(4), coverageHits(c, "<string>")[12])
+}
+
+func TestCoverageRecompiled(t *testing.T) {
+	c := NewCoverage()
+	L := NewState()
+	defer L.Close()
+	L.SetCoverage(c)
+	for i := 0; i < 10; i++ {
+		errorIfScriptFail(t, L, coverageScript)
+	}
+	// the functions compiled from the same source share their hit counts
+	errorIfNotEqual(t, 3, coverageFuncs(c))
+	hits := coverageHits(c, "<string>")
+	errorIfNotEqual(t, uint64(30), hits[2])
+	errorIfNotEqual(t, uint64(0), hits[9])
+
+	// the functions defined in the same line are told apart
+	c = NewCoverage()
+	L.SetCoverage(c)
+	errorIfScriptFail(t, L, `local f, g = function() return 1 end, function() return 2 end
+f()
+f()
+g()`)
+	errorIfNotEqual(t, 3, coverageFuncs(c))
+	errorIfNotEqual(t, uint64(4), coverageHits(c, "<string>")[1])
+}
+
+func coverageFuncs(c *Coverage) int {
+	n := 0
+	c.funcs.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func TestCoverageCoroutine(t *testing.T) {
+	c := NewCoverage()
+	L := NewState()
+	defer L.Close()
+	L.SetCoverage(c)
+	errorIfScriptFail(t, L, `
+local co = coroutine.wrap(function()
+  coroutine.yield(1)
+  return 2
+end)
+co()
+co()
+`)
+	hits := coverageHits(c, "<string>")
+	errorIfNotEqual(t, uint64(1), hits[3])
+	errorIfNotEqual(t, uint64(1), hits[4])
+}
+
+func TestCoverageGoroutines(t *testing.T) {
+	c := NewCoverage()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			L := NewState()
+			defer L.Close()
+			L.SetCoverage(c)
+			if err := L.DoString(coverageScript); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	hits := coverageHits(c, "<string>")
+	errorIfNotEqual(t, uint64(12), hits[2])
+	errorIfNotEqual(t, uint64(0), hits[9])
+}
+
+func TestCoverageMerge(t *testing.T) {
+	c1, c2 := NewCoverage(), NewCoverage()
+	for _, c := range []*Coverage{c1, c2} {
+		L := NewState()
+		L.SetCoverage(c)
+		errorIfScriptFail(t, L, coverageScript)
+		L.Close()
+	}
+	c1.Merge(c2)
+	hits := coverageHits(c1, "<string>")
+	errorIfNotEqual(t, uint64(6), hits[2])
+	errorIfNotEqual(t, uint64(8), hits[12])
+	errorIfNotEqual(t, uint64(3), coverageHits(c2, "<string>")[2])
+}
+
+func TestCoverageAddFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coverage")
+	errorIfNotNil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "unused.lua")
+	errorIfNotNil(t, ioutil.WriteFile(path, []byte(coverageScript), 0644))
+
+	c := NewCoverage()
+	errorIfNotNil(t, c.AddFile(path))
+	files := c.Files()
+	errorIfNotEqual(t, 1, len(files))
+	errorIfNotEqual(t, path, files[0].Source)
+	errorIfNotEqual(t, 8, len(files[0].Lines))
+	errorIfNotEqual(t, 0, files[0].Covered())
+
+	L := NewState()
+	defer L.Close()
+	L.SetCoverage(c)
+	errorIfNil(t, c.AddFile(filepath.Join(dir, "missing.lua")))
+	errorIfNotNil(t, L.DoFile(path))
+	errorIfNotEqual(t, 7, c.Files()[0].Covered())
+}
+
+func TestCoverageReports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coverage")
+	errorIfNotNil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.lua")
+	errorIfNotNil(t, ioutil.WriteFile(path, []byte(coverageScript), 0644))
+
+	c := NewCoverage()
+	L := NewState()
+	defer L.Close()
+	L.SetCoverage(c)
+	errorIfNotNil(t, L.DoFile(path))
+
+	var buf bytes.Buffer
+	errorIfNotNil(t, c.WriteLcov(&buf))
+	lcov := buf.String()
+	for _, s := range []string{"SF:" + path + "\n", "DA:2,3\n", "DA:9,0\n", "LF:8\n", "LH:7\n", "end_of_record\n"} {
+		errorIfFalse(t, strings.Contains(lcov, s), "lcov should contain %q: %v", s, lcov)
+	}
+
+	buf.Reset()
+	errorIfNotNil(t, c.WriteCobertura(&buf))
+	xml := buf.String()
+	for _, s := range []string{`lines-covered="7"`, `lines-valid="8"`, `line-rate="0.8750"`, `filename="` + path + `"`, `<line number="9" hits="0"></line>`} {
+		errorIfFalse(t, strings.Contains(xml, s), "cobertura should contain %q: %v", s, xml)
+	}
+
+	buf.Reset()
+	errorIfNotNil(t, c.WriteHTML(&buf))
+	html := buf.String()
+	for _, s := range []string{"87.5%", `<tr class="miss"><td class="line">9</td>`, "if x &gt; 1 then"} {
+		errorIfFalse(t, strings.Contains(html, s), "html should contain %q: %v", s, html)
+	}
+}
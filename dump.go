@@ -0,0 +1,316 @@
+package lua
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// FunctionProtoSignature is the header of the FunctionProto serialized by DumpFunctionProto.
+// LState.Load executes the serialized FunctionProto instead of parsing a source code if the
+// reader starts with this signature and Options.LoadBinaryChunks is set.
+const FunctionProtoSignature = "\x1bGLua"
+
+// functionProtoFormat is the version of the format written by DumpFunctionProto.
+// Format 1 does not have the columns of the instructions.
+const functionProtoFormat byte = 2
+
+const (
+	dumpConstNil byte = iota
+	dumpConstFalse
+	dumpConstTrue
+	dumpConstNumber
+	dumpConstString
+)
+
+// DumpFunctionProto writes proto to w in a binary format that LoadFunctionProto and
+// LState.Load read. If strip is true, the source name, the line numbers, the columns and the names
+// of local variables and upvalues are not written.
+func DumpFunctionProto(w io.Writer, proto *FunctionProto, strip bool) error {
+	d := &protoDumper{w: bufio.NewWriter(w), strip: strip}
+	d.bytes([]byte(FunctionProtoSignature))
+	d.bytes([]byte{functionProtoFormat})
+	d.proto(proto)
+	if d.err != nil {
+		return d.err
+	}
+	return d.w.Flush()
+}
+
+type protoDumper struct {
+	w     *bufio.Writer
+	strip bool
+	buf   [binary.MaxVarintLen64]byte
+	err   error
+}
+
+func (d *protoDumper) bytes(b []byte) {
+	if d.err == nil {
+		_, d.err = d.w.Write(b)
+	}
+}
+
+func (d *protoDumper) int(v int) {
+	n := binary.PutVarint(d.buf[:], int64(v))
+	d.bytes(d.buf[:n])
+}
+
+func (d *protoDumper) string(s string) {
+	d.int(len(s))
+	d.bytes([]byte(s))
+}
+
+func (d *protoDumper) proto(proto *FunctionProto) {
+	if d.strip {
+		d.string("?")
+	} else {
+		d.string(proto.SourceName)
+	}
+	d.int(proto.LineDefined)
+	d.int(proto.LastLineDefined)
+	d.bytes([]byte{proto.NumUpvalues, proto.NumParameters, proto.IsVarArg, proto.NumUsedRegisters})
+
+	d.int(len(proto.Code))
+	for _, inst := range proto.Code {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], inst)
+		d.bytes(b[:])
+	}
+
+	d.int(len(proto.Constants))
+	for _, lv := range proto.Constants {
+		switch v := lv.(type) {
+		case LNumber:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(float64(v)))
+			d.bytes([]byte{dumpConstNumber})
+			d.bytes(b[:])
+		case LString:
+			d.bytes([]byte{dumpConstString})
+			d.string(string(v))
+		case LBool:
+			if v {
+				d.bytes([]byte{dumpConstTrue})
+			} else {
+				d.bytes([]byte{dumpConstFalse})
+			}
+		case *LNilType:
+			d.bytes([]byte{dumpConstNil})
+		default:
+			if d.err == nil {
+				d.err = fmt.Errorf("can not dump a constant of type %v", lv.Type())
+			}
+		}
+	}
+
+	d.int(len(proto.FunctionPrototypes))
+	for _, p := range proto.FunctionPrototypes {
+		d.proto(p)
+	}
+
+	if d.strip {
+		// the line numbers are replaced with 0, so the stripped code can report errors
+		d.int(len(proto.DbgSourcePositions))
+		for range proto.DbgSourcePositions {
+			d.int(0)
+		}
+		d.int(len(proto.DbgSourceColumns))
+		for range proto.DbgSourceColumns {
+			d.int(0)
+		}
+		d.int(0)
+		d.int(0)
+		d.int(len(proto.DbgUpvalues))
+		for range proto.DbgUpvalues {
+			d.string("?")
+		}
+		return
+	}
+	d.int(len(proto.DbgSourcePositions))
+	for _, line := range proto.DbgSourcePositions {
+		d.int(line)
+	}
+	d.int(len(proto.DbgSourceColumns))
+	for _, col := range proto.DbgSourceColumns {
+		d.int(col)
+	}
+	d.int(len(proto.DbgLocals))
+	for _, local := range proto.DbgLocals {
+		d.string(local.Name)
+		d.int(local.StartPc)
+		d.int(local.EndPc)
+	}
+	d.int(len(proto.DbgCalls))
+	for _, call := range proto.DbgCalls {
+		d.string(call.Name)
+		d.int(call.Pc)
+	}
+	d.int(len(proto.DbgUpvalues))
+	for _, name := range proto.DbgUpvalues {
+		d.string(name)
+	}
+}
+
+// LoadFunctionProto reads a FunctionProto written by DumpFunctionProto. r is read to the
+// end. The FunctionProto is not verified, so only FunctionProtos from trusted sources should
+// be loaded.
+func LoadFunctionProto(r io.Reader) (*FunctionProto, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	u := &protoUndumper{r: bytes.NewReader(data)}
+	sig := make([]byte, len(FunctionProtoSignature)+1)
+	if _, err := io.ReadFull(u.r, sig); err != nil || string(sig[:len(sig)-1]) != FunctionProtoSignature {
+		return nil, errors.New("not a precompiled chunk")
+	}
+	u.format = sig[len(sig)-1]
+	if u.format < 1 || u.format > functionProtoFormat {
+		return nil, fmt.Errorf("unsupported precompiled chunk format %v", u.format)
+	}
+	proto := u.proto()
+	if u.err != nil {
+		return nil, fmt.Errorf("malformed precompiled chunk: %v", u.err)
+	}
+	return proto, nil
+}
+
+type protoUndumper struct {
+	r      *bytes.Reader
+	format byte
+	err    error
+}
+
+func (u *protoUndumper) fail(err error) {
+	if u.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		u.err = err
+	}
+}
+
+func (u *protoUndumper) bytes(n int) []byte {
+	if u.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(u.r, b); err != nil {
+		u.fail(err)
+		return nil
+	}
+	return b
+}
+
+func (u *protoUndumper) int() int {
+	if u.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(u.r)
+	if err != nil {
+		u.fail(err)
+	}
+	return int(v)
+}
+
+// length reads the length of a string or a list whose elements take at least size bytes.
+// The length is bounded by the rest of the input, so that malformed inputs do not make
+// LoadFunctionProto allocate much more memory than the inputs.
+func (u *protoUndumper) length(size int) int {
+	n := u.int()
+	if u.err != nil {
+		return 0
+	}
+	if n < 0 || n > u.r.Len()/size {
+		u.fail(fmt.Errorf("invalid length %v", n))
+		return 0
+	}
+	return n
+}
+
+func (u *protoUndumper) string() string {
+	return string(u.bytes(u.length(1)))
+}
+
+func (u *protoUndumper) proto() *FunctionProto {
+	proto := newFunctionProto(u.string())
+	proto.LineDefined = u.int()
+	proto.LastLineDefined = u.int()
+	if b := u.bytes(4); b != nil {
+		proto.NumUpvalues, proto.NumParameters, proto.IsVarArg, proto.NumUsedRegisters = b[0], b[1], b[2], b[3]
+	}
+
+	proto.Code = make([]uint32, u.length(4))
+	for i := 0; i < len(proto.Code) && u.err == nil; i++ {
+		if b := u.bytes(4); b != nil {
+			proto.Code[i] = binary.LittleEndian.Uint32(b)
+		}
+	}
+
+	proto.Constants = make([]LValue, u.length(1))
+	for i := 0; i < len(proto.Constants) && u.err == nil; i++ {
+		var lv LValue = LNil
+		sv := ""
+		switch b := u.bytes(1); {
+		case b == nil:
+		case b[0] == dumpConstNumber:
+			if v := u.bytes(8); v != nil {
+				lv = LNumber(math.Float64frombits(binary.LittleEndian.Uint64(v)))
+			}
+		case b[0] == dumpConstString:
+			sv = u.string()
+			lv = LString(sv)
+		case b[0] == dumpConstTrue:
+			lv = LTrue
+		case b[0] == dumpConstFalse:
+			lv = LFalse
+		case b[0] == dumpConstNil:
+		default:
+			u.fail(fmt.Errorf("invalid constant type %v", b[0]))
+		}
+		proto.Constants[i] = lv
+		proto.stringConstants = append(proto.stringConstants, sv)
+	}
+
+	proto.FunctionPrototypes = make([]*FunctionProto, u.length(1))
+	for i := 0; i < len(proto.FunctionPrototypes) && u.err == nil; i++ {
+		proto.FunctionPrototypes[i] = u.proto()
+	}
+
+	proto.DbgSourcePositions = make([]int, u.length(1))
+	for i := 0; i < len(proto.DbgSourcePositions) && u.err == nil; i++ {
+		proto.DbgSourcePositions[i] = u.int()
+	}
+	if u.format >= 2 {
+		proto.DbgSourceColumns = make([]int, u.length(1))
+		for i := 0; i < len(proto.DbgSourceColumns) && u.err == nil; i++ {
+			proto.DbgSourceColumns[i] = u.int()
+		}
+	} else {
+		proto.DbgSourceColumns = make([]int, len(proto.DbgSourcePositions))
+	}
+	// a local variable takes at least 3 bytes: the empty name, StartPc and EndPc
+	proto.DbgLocals = make([]*DbgLocalInfo, u.length(3))
+	for i := 0; i < len(proto.DbgLocals) && u.err == nil; i++ {
+		proto.DbgLocals[i] = &DbgLocalInfo{Name: u.string(), StartPc: u.int(), EndPc: u.int()}
+	}
+	proto.DbgCalls = make([]DbgCall, u.length(2))
+	for i := 0; i < len(proto.DbgCalls) && u.err == nil; i++ {
+		proto.DbgCalls[i] = DbgCall{Name: u.string(), Pc: u.int()}
+	}
+	proto.DbgUpvalues = make([]string, u.length(1))
+	for i := 0; i < len(proto.DbgUpvalues) && u.err == nil; i++ {
+		proto.DbgUpvalues[i] = u.string()
+	}
+	if u.err == nil && len(proto.DbgSourcePositions) != len(proto.Code) {
+		u.fail(errors.New("the number of line numbers does not match the code"))
+	}
+	if u.err == nil && len(proto.DbgSourceColumns) != len(proto.Code) {
+		u.fail(errors.New("the number of columns does not match the code"))
+	}
+	return proto
+}
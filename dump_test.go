@@ -0,0 +1,108 @@
+package lua
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func dumpString(t *testing.T, src string, strip bool) []byte {
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(src)
+	errorIfNotNil(t, err)
+	var buf bytes.Buffer
+	errorIfNotNil(t, DumpFunctionProto(&buf, fn.Proto, strip))
+	return buf.Bytes()
+}
+
+func TestDumpFunctionProto(t *testing.T) {
+	src := `
+    local t = {}
+    local function add(a, b) return a + b end
+    for i = 1, 3 do t[#t+1] = add(i, 0.5) end
+    assert(#t == 3 and t[3] == 3.5)
+    assert(("x"):rep(2) == "xx" and true ~= false)
+    if not ok then error("failed") end
+    `
+	for _, strip := range []bool{false, true} {
+		b := dumpString(t, src, strip)
+		errorIfFalse(t, bytes.HasPrefix(b, []byte(FunctionProtoSignature)), "no signature")
+
+		L := NewState(Options{LoadBinaryChunks: true})
+		fn, err := L.Load(bytes.NewReader(b), "dumped")
+		errorIfNotNil(t, err)
+		L.Push(fn)
+		err = L.PCall(0, 0, nil)
+		errorIfNil(t, err)
+		if strip {
+			errorIfFalse(t, strings.Contains(err.Error(), "?:0: failed"), err.Error())
+		} else {
+			errorIfFalse(t, strings.Contains(err.Error(), "<string>:7: failed"), err.Error())
+		}
+		L.Close()
+	}
+}
+
+func TestLoadFunctionProtoError(t *testing.T) {
+	_, err := LoadFunctionProto(strings.NewReader("return 1"))
+	errorIfNil(t, err)
+
+	b := dumpString(t, `return 1`, false)
+	_, err = LoadFunctionProto(bytes.NewReader(b[:len(b)-3]))
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "malformed"), err.Error())
+
+	L := NewState(Options{LoadBinaryChunks: true})
+	defer L.Close()
+	_, err = L.Load(bytes.NewReader(b[:len(b)-3]), "broken")
+	errorIfNil(t, err)
+	errorIfNotEqual(t, ApiErrorSyntax, err.(*ApiError).Type)
+
+	// the lengths are bounded by the rest of the input
+	huge := append([]byte(FunctionProtoSignature), 2, 0, 0, 0, 0, 0, 0, 0xfe, 0xff, 0xff, 0xff, 0x0f)
+	_, err = LoadFunctionProto(bytes.NewReader(huge))
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "invalid length"), err.Error())
+}
+
+func TestLoadBinaryChunks(t *testing.T) {
+	b := dumpString(t, `return 1`, false)
+	L := NewState()
+	defer L.Close()
+	_, err := L.Load(bytes.NewReader(b), "chunk")
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "chunk: attempt to load a precompiled chunk"), err.Error())
+	L.Push(L.GetGlobal("loadstring"))
+	L.Push(LString(b))
+	L.Call(1, 2)
+	errorIfNotEqual(t, LNil, L.Get(-2))
+
+	L = NewState(Options{LoadBinaryChunks: true})
+	defer L.Close()
+	L.Push(L.GetGlobal("loadstring"))
+	L.Push(LString(b))
+	L.Call(1, 1)
+	L.Call(0, 1)
+	errorIfNotEqual(t, LNumber(1), L.Get(-1))
+}
+
+func TestDumpFunctionProtoColumns(t *testing.T) {
+	src := "local a = 1\nlocal b = a +   f(a)\n"
+	L := NewState()
+	defer L.Close()
+	fn, err := L.LoadString(src)
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, len(fn.Proto.Code), len(fn.Proto.DbgSourceColumns))
+
+	proto, err := LoadFunctionProto(bytes.NewReader(dumpString(t, src, false)))
+	errorIfNotNil(t, err)
+	errorIfFalse(t, reflect.DeepEqual(fn.Proto.DbgSourceColumns, proto.DbgSourceColumns), "%v != %v", fn.Proto.DbgSourceColumns, proto.DbgSourceColumns)
+
+	proto, err = LoadFunctionProto(bytes.NewReader(dumpString(t, src, true)))
+	errorIfNotNil(t, err)
+	for _, col := range proto.DbgSourceColumns {
+		errorIfNotEqual(t, 0, col)
+	}
+}
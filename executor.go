@@ -0,0 +1,180 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// ErrExecutorClosed is returned by Executor methods called after the Executor is closed.
+var ErrExecutorClosed = errors.New("lua: executor is closed")
+
+// ExecutorQueueSize is the default number of functions that can wait for an Executor.
+var ExecutorQueueSize = 64
+
+// Executor owns an LState and runs functions on it one at a time on a dedicated
+// goroutine, so the LState can be used safely from many goroutines.
+//
+// LValues returned by the Executor still belong to the LState. Tables, functions and
+// userdata must not be modified or called outside of functions run by the Executor.
+type Executor struct {
+	l       *LState
+	queue   chan *execRequest
+	mu      sync.RWMutex
+	closed  bool
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type execRequest struct {
+	ctx  context.Context
+	fn   func(*LState) error
+	errc chan error
+}
+
+// NewExecutor returns a new Executor that owns L. L must not be used directly after
+// this call. queueSize is the number of functions that can wait to be run; if it is less
+// than 1, lua.ExecutorQueueSize is used. Do and Call block while the queue is full.
+func NewExecutor(L *LState, queueSize int) *Executor {
+	if queueSize < 1 {
+		queueSize = ExecutorQueueSize
+	}
+	e := &Executor{
+		l:       L,
+		queue:   make(chan *execRequest, queueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// Do runs fn on the LState and returns its error. While fn is running, ctx is set as
+// the context of the LState, so Lua code called by fn stops when ctx is done. If ctx is
+// done before fn finishes, Do returns ctx.Err(). The data stack of the LState is cleared
+// after fn returns. If fn panics, the LState is reset to the state before fn and the
+// panic is returned as an *ApiError.
+func (e *Executor) Do(ctx context.Context, fn func(*LState) error) error {
+	req := &execRequest{ctx: ctx, fn: fn, errc: make(chan error, 1)}
+	e.mu.RLock()
+	if e.closed {
+		e.mu.RUnlock()
+		return ErrExecutorClosed
+	}
+	select {
+	case e.queue <- req:
+	case <-ctx.Done():
+		e.mu.RUnlock()
+		return ctx.Err()
+	}
+	e.mu.RUnlock()
+	select {
+	case err := <-req.errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call calls the global function named fn with args and returns the results.
+func (e *Executor) Call(ctx context.Context, fn string, args ...LValue) ([]LValue, error) {
+	// the results are sent over a channel because fn may still be running when Do
+	// returns for ctx
+	resc := make(chan []LValue, 1)
+	err := e.Do(ctx, func(L *LState) error {
+		lv := L.GetGlobal(fn)
+		if lv.Type() != LTFunction {
+			return fmt.Errorf("lua: %s is not a function but %s", fn, lv.Type().String())
+		}
+		if err := L.CallByParam(P{Fn: lv, NRet: MultRet, Protect: true}, args...); err != nil {
+			return err
+		}
+		results := make([]LValue, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			results = append(results, L.Get(i))
+		}
+		resc <- results
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-resc, nil
+}
+
+// Close stops the Executor and closes the LState. Functions that are waiting to be run
+// are not run and return ErrExecutorClosed. Close waits for the running function.
+func (e *Executor) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.closing)
+	}
+	e.mu.Unlock()
+	<-e.done
+}
+
+func (e *Executor) loop() {
+	defer close(e.done)
+	for {
+		select {
+		case req := <-e.queue:
+			req.errc <- e.run(req)
+		case <-e.closing:
+			for {
+				select {
+				case req := <-e.queue:
+					req.errc <- ErrExecutorClosed
+				default:
+					e.l.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Executor) run(req *execRequest) (err error) {
+	if err := req.ctx.Err(); err != nil {
+		return err
+	}
+	L := e.l
+	oldctx := L.RemoveContext()
+	L.SetContext(req.ctx)
+	sp := L.stack.Sp()
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			if aerr, ok := rcv.(*ApiError); ok {
+				err = aerr
+			} else {
+				aerr := newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
+				if cause, ok := rcv.(error); ok {
+					aerr.Cause = cause
+				}
+				buf := make([]byte, 4096)
+				runtime.Stack(buf, false)
+				aerr.StackTrace = strings.Trim(string(buf), "\000")
+				err = aerr
+			}
+			// the panic has skipped the cleanups of the LState
+			L.stack.SetSp(sp)
+			L.currentFrame = L.stack.Last()
+			if sp == 0 {
+				L.currentFrame = nil
+			}
+			L.nny = 0
+			L.hasErrorFunc = false
+			L.Panic = panicWithTraceback
+			L.G.CurrentThread = L
+		}
+		L.SetTop(0)
+		L.RemoveContext()
+		if oldctx != nil {
+			L.SetContext(oldctx)
+		}
+	}()
+	return req.fn(L)
+}
@@ -0,0 +1,127 @@
+package lua
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecutorCall(t *testing.T) {
+	L := NewState()
+	e := NewExecutor(L, 4)
+	defer e.Close()
+	err := e.Do(context.Background(), func(L *LState) error {
+		return L.DoString(`
+        count = 0
+        function add(a, b)
+          count = count + 1
+          return a + b, count
+        end
+        `)
+	})
+	errorIfNotNil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results, err := e.Call(context.Background(), "add", LNumber(i), LNumber(1))
+			errorIfNotNil(t, err)
+			errorIfNotEqual(t, 2, len(results))
+			errorIfNotEqual(t, LNumber(i+1), results[0])
+		}(i)
+	}
+	wg.Wait()
+	results, err := e.Call(context.Background(), "add", LNumber(0), LNumber(0))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LNumber(21), results[1])
+
+	_, err = e.Call(context.Background(), "missing")
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "missing is not a function"), err.Error())
+	_, err = e.Call(context.Background(), "error", LString("--failed--"))
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "--failed--"), err.Error())
+}
+
+func TestExecutorContext(t *testing.T) {
+	e := NewExecutor(NewState(), 1)
+	defer e.Close()
+	errorIfNotNil(t, e.Do(context.Background(), func(L *LState) error {
+		return L.DoString(`function loop() while true do end end`)
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := e.Call(ctx, "loop")
+	errorIfNil(t, err)
+	// the state is usable after the deadline
+	err = e.Do(context.Background(), func(L *LState) error {
+		errorIfNotNil(t, L.Context().Err())
+		return L.DoString(`x = 1`)
+	})
+	errorIfNotNil(t, err)
+}
+
+func TestExecutorCallCanceled(t *testing.T) {
+	e := NewExecutor(NewState(), 1)
+	defer e.Close()
+	started := make(chan struct{})
+	errorIfNotNil(t, e.Do(context.Background(), func(L *LState) error {
+		L.SetGlobal("slow", L.NewFunction(func(L *LState) int {
+			close(started)
+			// Go functions do not stop for the context
+			time.Sleep(50 * time.Millisecond)
+			L.Push(LString("late"))
+			L.Push(LNumber(1))
+			return 2
+		}))
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	results, err := e.Call(ctx, "slow")
+	errorIfNotEqual(t, context.Canceled, err)
+	errorIfNotEqual(t, 0, len(results))
+	// waits for slow to finish writing its results
+	results, err = e.Call(context.Background(), "tostring", LNumber(1))
+	errorIfNotNil(t, err)
+	errorIfNotEqual(t, LString("1"), results[0])
+}
+
+func TestExecutorPanic(t *testing.T) {
+	e := NewExecutor(NewState(), 1)
+	defer e.Close()
+	err := e.Do(context.Background(), func(L *LState) error {
+		L.Push(L.NewFunction(func(L *LState) int {
+			panic("--panic--")
+		}))
+		L.Call(0, 0)
+		return nil
+	})
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "--panic--"), err.Error())
+	err = e.Do(context.Background(), func(L *LState) error {
+		L.Call(0, 0) // raises an error: attempt to call a nil value
+		return nil
+	})
+	errorIfNil(t, err)
+	err = e.Do(context.Background(), func(L *LState) error {
+		errorIfNotEqual(t, 0, L.GetTop())
+		errorIfNotEqual(t, 0, L.stack.Sp())
+		return L.DoString(`assert(1 + 1 == 2)`)
+	})
+	errorIfNotNil(t, err)
+}
+
+func TestExecutorClose(t *testing.T) {
+	e := NewExecutor(NewState(), 1)
+	e.Close()
+	e.Close()
+	errorIfNotEqual(t, ErrExecutorClosed, e.Do(context.Background(), func(L *LState) error { return nil }))
+}
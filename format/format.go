@@ -0,0 +1,80 @@
+// Package format provides a formatter that regenerates Lua sources from the AST.
+package format
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Config configures the formatter.
+type Config struct {
+	// Indent is the string used for one level of indentation. The default is two spaces.
+	Indent string
+	// SingleQuote makes the formatter use ' instead of " for strings.
+	SingleQuote bool
+}
+
+func (cfg *Config) indent() string {
+	if cfg == nil || len(cfg.Indent) == 0 {
+		return "  "
+	}
+	return cfg.Indent
+}
+
+// Format returns the Lua source of chunk. comments are the comments returned by
+// parse.ParseWithComments; they are placed near the statements and the table fields
+// that they originally preceded or followed.
+func Format(chunk []ast.Stmt, comments []*ast.Comment, cfg *Config) []byte {
+	return format(chunk, comments, cfg, nil)
+}
+
+func format(chunk []ast.Stmt, comments []*ast.Comment, cfg *Config, blank []bool) []byte {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	p := newPrinter(comments, cfg)
+	p.blank = blank
+	p.stmts(chunk)
+	p.leading(int(^uint(0) >> 1))
+	return p.bytes()
+}
+
+// Source formats the Lua source src. The first line of src is kept as it is if it starts
+// with `#`, like LState.LoadFile skips it.
+func Source(src []byte, name string, cfg *Config) ([]byte, error) {
+	var shebang []byte
+	if bytes.HasPrefix(src, []byte("#")) {
+		end := bytes.IndexByte(src, '\n')
+		if end < 0 {
+			end = len(src)
+		}
+		shebang = src[:end]
+		// keep the newline so that the line numbers do not change
+		src = src[end:]
+	}
+	chunk, comments, err := parse.ParseWithComments(bytes.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	out := format(chunk, comments, cfg, blankLines(src))
+	if _, err := parse.Parse(bytes.NewReader(out), name); err != nil {
+		return nil, fmt.Errorf("formatted source is invalid: %v", err)
+	}
+	if shebang != nil {
+		out = append(append(append([]byte{}, shebang...), '\n'), out...)
+	}
+	return out, nil
+}
+
+// blankLines returns a slice whose i-th element is true if the line i of src is blank.
+func blankLines(src []byte) []bool {
+	lines := bytes.Split(src, []byte("\n"))
+	blank := make([]bool, len(lines)+1)
+	for i, line := range lines {
+		blank[i+1] = len(bytes.TrimSpace(line)) == 0
+	}
+	return blank
+}
@@ -0,0 +1,73 @@
+package format
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestSource(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.lua"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test data")
+	}
+	for _, input := range inputs {
+		src, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Source(src, input, nil)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		golden := strings.TrimSuffix(input, ".lua") + ".golden"
+		if *update {
+			if err := os.WriteFile(golden, out, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, out) {
+			t.Errorf("%s: unexpected output:\n%s\nexpected:\n%s", input, out, expected)
+		}
+
+		// formatting the formatted source does not change it
+		again, err := Source(out, golden, nil)
+		if err != nil {
+			t.Errorf("%s: %v", golden, err)
+			continue
+		}
+		if !bytes.Equal(out, again) {
+			t.Errorf("%s: formatting is not idempotent:\n%s\nsecond output:\n%s", input, out, again)
+		}
+	}
+}
+
+func TestSourceConfig(t *testing.T) {
+	out, err := Source([]byte("if x then\nprint(\"a\")\nend"), "config.lua", &Config{Indent: "\t", SingleQuote: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "if x then\n\tprint('a')\nend\n"
+	if string(out) != expected {
+		t.Errorf("%q expected, but got %q", expected, out)
+	}
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	if _, err := Source([]byte("local = 1"), "error.lua", nil); err == nil {
+		t.Error("error expected")
+	}
+}
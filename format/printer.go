@@ -0,0 +1,785 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+type printer struct {
+	cfg      *Config
+	buf      bytes.Buffer
+	level    int
+	bol      bool // at the beginning of a line
+	comments []*ast.Comment
+	printed  []bool
+	next     int
+	// line is the last source line that has been printed, used to keep blank lines
+	line int
+	// blockStart is true until the first statement or comment of a block is printed
+	blockStart bool
+	// blank[i] is true if the line i is blank in the source. It is nil if the source is
+	// not available.
+	blank []bool
+}
+
+func newPrinter(comments []*ast.Comment, cfg *Config) *printer {
+	return &printer{
+		cfg:        cfg,
+		bol:        true,
+		comments:   comments,
+		printed:    make([]bool, len(comments)),
+		blockStart: true,
+	}
+}
+
+func (p *printer) bytes() []byte {
+	out := bytes.TrimRight(p.buf.Bytes(), "\n")
+	if len(out) == 0 {
+		return []byte{}
+	}
+	return append(out, '\n')
+}
+
+func (p *printer) write(s string) {
+	if p.bol {
+		p.buf.WriteString(strings.Repeat(p.cfg.indent(), p.level))
+		p.bol = false
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.bol = true
+}
+
+// gap prints a blank line if there are blank lines before line in the source.
+func (p *printer) gap(line int) {
+	if !p.blockStart && p.line > 0 && line > p.line+1 {
+		// the AST does not know the last lines of some expressions like tables
+		if p.blank == nil || line-1 < len(p.blank) && p.blank[line-1] {
+			p.newline()
+		}
+	}
+	p.blockStart = false
+}
+
+/* comments {{{ */
+
+// leading prints the comments that start before line on their own lines.
+func (p *printer) leading(line int) {
+	for ; p.next < len(p.comments); p.next++ {
+		c := p.comments[p.next]
+		if p.printed[p.next] {
+			continue
+		}
+		if c.Pos.Line >= line {
+			return
+		}
+		p.gap(c.Pos.Line)
+		p.write(c.Text)
+		p.newline()
+		p.printed[p.next] = true
+		p.line = c.EndLine()
+	}
+}
+
+// trailing prints the comments that start on line at the end of the current line.
+func (p *printer) trailing(line int) {
+	for i := p.next; i < len(p.comments) && p.comments[i].Pos.Line <= line; i++ {
+		if c := p.comments[i]; !p.printed[i] && c.Pos.Line == line {
+			p.write(" " + c.Text)
+			p.printed[i] = true
+			if end := c.EndLine(); end > p.line {
+				p.line = end
+			}
+		}
+	}
+}
+
+// hasComments reports whether there are comments that are not printed before line.
+func (p *printer) hasComments(line int) bool {
+	for i := p.next; i < len(p.comments) && p.comments[i].Pos.Line < line; i++ {
+		if !p.printed[i] {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (p *printer) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		p.stmt(stmt)
+	}
+}
+
+// block prints stmts indented. Comments before end, that is the line of the keyword
+// closing the block, are printed in the block.
+func (p *printer) block(stmts []ast.Stmt, end int) {
+	p.level++
+	p.blockStart = true
+	p.stmts(stmts)
+	if end > 0 {
+		p.leading(end)
+	}
+	p.level--
+	p.blockStart = false
+}
+
+// closeBlock prints the keyword closing a block.
+func (p *printer) closeBlock(keyword string, line int) {
+	p.write(keyword)
+	p.trailing(line)
+	p.newline()
+	if line > p.line {
+		p.line = line
+	}
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	p.leading(stmt.Line())
+	p.gap(stmt.Line())
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		if len(st.Lhs) > 0 && startsWithParen(st.Lhs[0]) {
+			p.write(";")
+		}
+		p.exprs(st.Lhs)
+		p.write(" = ")
+		p.exprs(st.Rhs)
+	case *ast.LocalAssignStmt:
+		if len(st.Names) == 1 && len(st.Exprs) == 1 {
+			if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok {
+				p.write("local function " + st.Names[0])
+				p.funcBody(fn, false)
+				p.line = fn.LastLine()
+				return
+			}
+		}
+		p.write("local " + strings.Join(st.Names, ", "))
+		if len(st.Exprs) > 0 {
+			p.write(" = ")
+			p.exprs(st.Exprs)
+		}
+	case *ast.FuncCallStmt:
+		if startsWithParen(st.Expr) {
+			p.write(";")
+		}
+		p.expr(st.Expr, 0)
+	case *ast.DoBlockStmt:
+		p.write("do")
+		p.header(st.Line())
+		p.block(st.Stmts, st.LastLine())
+		p.closeBlock("end", st.LastLine())
+		return
+	case *ast.WhileStmt:
+		p.write("while ")
+		p.expr(st.Condition, 0)
+		p.write(" do")
+		p.header(st.Line())
+		p.block(st.Stmts, st.LastLine())
+		p.closeBlock("end", st.LastLine())
+		return
+	case *ast.RepeatStmt:
+		p.write("repeat")
+		p.header(st.Line())
+		p.block(st.Stmts, st.Condition.Line())
+		p.write("until ")
+		p.expr(st.Condition, 0)
+	case *ast.IfStmt:
+		p.ifStmt(st)
+		return
+	case *ast.NumberForStmt:
+		p.write("for " + st.Name + " = ")
+		p.expr(st.Init, 0)
+		p.write(", ")
+		p.expr(st.Limit, 0)
+		if st.Step != nil {
+			p.write(", ")
+			p.expr(st.Step, 0)
+		}
+		p.write(" do")
+		p.header(st.Line())
+		p.block(st.Stmts, st.LastLine())
+		p.closeBlock("end", st.LastLine())
+		return
+	case *ast.GenericForStmt:
+		p.write("for " + strings.Join(st.Names, ", ") + " in ")
+		p.exprs(st.Exprs)
+		p.write(" do")
+		p.header(st.Line())
+		p.block(st.Stmts, st.LastLine())
+		p.closeBlock("end", st.LastLine())
+		return
+	case *ast.FuncDefStmt:
+		p.write("function ")
+		if st.Name.Func != nil {
+			p.expr(st.Name.Func, 0)
+		} else {
+			p.expr(st.Name.Receiver, 0)
+			p.write(":" + st.Name.Method)
+		}
+		p.funcBody(st.Func, false)
+		p.line = st.Func.LastLine()
+		return
+	case *ast.ReturnStmt:
+		p.write("return")
+		if len(st.Exprs) > 0 {
+			p.write(" ")
+			p.exprs(st.Exprs)
+		}
+	case *ast.BreakStmt:
+		p.write("break")
+	case *ast.LabelStmt:
+		p.write("::" + st.Name + "::")
+	case *ast.GotoStmt:
+		p.write("goto " + st.Label)
+	}
+	end := stmtEnd(stmt)
+	p.trailing(end)
+	p.newline()
+	if end > p.line {
+		p.line = end
+	}
+}
+
+// header ends the first line of a compound statement.
+func (p *printer) header(line int) {
+	p.trailing(line)
+	p.newline()
+	p.line = line
+}
+
+func (p *printer) ifStmt(st *ast.IfStmt) {
+	end := st.LastLine()
+	p.write("if ")
+	for {
+		p.expr(st.Condition, 0)
+		p.write(" then")
+		p.header(st.Line())
+		thenEnd := end
+		if st.ElseLine > 0 {
+			thenEnd = st.ElseLine
+		} else if len(st.Else) > 0 {
+			thenEnd = st.Else[0].Line()
+		}
+		p.block(st.Then, thenEnd)
+		// an empty else is dropped unless it has comments
+		if len(st.Else) == 0 && (st.ElseLine == 0 || !p.hasComments(end)) {
+			break
+		}
+		// the parser creates an IfStmt without the last line for elseif
+		if len(st.Else) == 1 {
+			if elseif, ok := st.Else[0].(*ast.IfStmt); ok && elseif.LastLine() == 0 {
+				p.write("elseif ")
+				st = elseif
+				continue
+			}
+		}
+		p.write("else")
+		if st.ElseLine > 0 {
+			p.header(st.ElseLine)
+		} else {
+			p.newline()
+		}
+		p.block(st.Else, end)
+		break
+	}
+	p.closeBlock("end", end)
+}
+
+// funcBody prints the parameters and the body of fn. If inline is true and fn has no
+// statements, it is printed on one line.
+func (p *printer) funcBody(fn *ast.FunctionExpr, inline bool) {
+	params := append([]string{}, fn.ParList.Names...)
+	if fn.ParList.HasVargs {
+		params = append(params, "...")
+	}
+	p.write("(" + strings.Join(params, ", ") + ")")
+	if len(fn.Stmts) == 0 && !p.hasComments(fn.LastLine()) && (inline || fn.Line() == fn.LastLine()) {
+		p.write(" end")
+		if !inline {
+			p.trailing(fn.LastLine())
+			p.newline()
+		}
+		return
+	}
+	p.header(fn.Line())
+	p.block(fn.Stmts, fn.LastLine())
+	if inline {
+		p.write("end")
+		if fn.LastLine() > p.line {
+			p.line = fn.LastLine()
+		}
+		return
+	}
+	p.closeBlock("end", fn.LastLine())
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precAtom
+)
+
+var binaryPrecs = map[string]int{
+	"or": precOr, "and": precAnd,
+	"<": precCompare, ">": precCompare, "<=": precCompare, ">=": precCompare, "==": precCompare, "~=": precCompare,
+	"..": precConcat,
+	"+":  precAdd, "-": precAdd,
+	"*": precMul, "/": precMul, "%": precMul,
+	"^": precPow,
+}
+
+func precOf(expr ast.Expr) int {
+	switch ex := expr.(type) {
+	case *ast.LogicalOpExpr:
+		return binaryPrecs[ex.Operator]
+	case *ast.RelationalOpExpr:
+		return precCompare
+	case *ast.StringConcatOpExpr:
+		return precConcat
+	case *ast.ArithmeticOpExpr:
+		return binaryPrecs[ex.Operator]
+	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr:
+		return precUnary
+	}
+	return precAtom
+}
+
+func (p *printer) exprs(exprs []ast.Expr) {
+	for i, expr := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(expr, 0)
+	}
+}
+
+// expr prints expr. It is parenthesized if its precedence is lower than prec.
+func (p *printer) expr(expr ast.Expr, prec int) {
+	if precOf(expr) < prec {
+		p.write("(")
+		p.expr(expr, 0)
+		p.write(")")
+		return
+	}
+	switch ex := expr.(type) {
+	case *ast.NilExpr:
+		p.write("nil")
+	case *ast.TrueExpr:
+		p.write("true")
+	case *ast.FalseExpr:
+		p.write("false")
+	case *ast.NumberExpr:
+		p.write(ex.Value)
+	case *ast.StringExpr:
+		p.write(quote(ex.Value, p.cfg.SingleQuote))
+	case *ast.Comma3Expr:
+		if ex.AdjustRet {
+			p.write("(...)")
+		} else {
+			p.write("...")
+		}
+	case *ast.IdentExpr:
+		p.write(ex.Value)
+	case *ast.AttrGetExpr:
+		p.prefix(ex.Object)
+		if key, ok := ex.Key.(*ast.StringExpr); ok && isName(key.Value) {
+			p.write("." + key.Value)
+		} else {
+			p.write("[")
+			p.expr(ex.Key, 0)
+			p.write("]")
+		}
+	case *ast.TableExpr:
+		p.table(ex)
+	case *ast.FuncCallExpr:
+		if ex.AdjustRet {
+			p.write("(")
+		}
+		if ex.Func != nil {
+			p.prefix(ex.Func)
+		} else {
+			p.prefix(ex.Receiver)
+			p.write(":" + ex.Method)
+		}
+		p.write("(")
+		p.exprs(ex.Args)
+		p.write(")")
+		if ex.AdjustRet {
+			p.write(")")
+		}
+	case *ast.LogicalOpExpr:
+		p.binary(ex.Operator, ex.Lhs, ex.Rhs)
+	case *ast.RelationalOpExpr:
+		p.binary(ex.Operator, ex.Lhs, ex.Rhs)
+	case *ast.StringConcatOpExpr:
+		p.binary("..", ex.Lhs, ex.Rhs)
+	case *ast.ArithmeticOpExpr:
+		p.binary(ex.Operator, ex.Lhs, ex.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		p.unary("-", ex.Expr)
+	case *ast.UnaryNotOpExpr:
+		p.unary("not ", ex.Expr)
+	case *ast.UnaryLenOpExpr:
+		p.unary("#", ex.Expr)
+	case *ast.FunctionExpr:
+		p.write("function")
+		p.funcBody(ex, true)
+	}
+}
+
+func (p *printer) binary(op string, lhs, rhs ast.Expr) {
+	prec := binaryPrecs[op]
+	// `..` and `^` are right associative
+	if op == ".." || op == "^" {
+		p.expr(lhs, prec+1)
+		p.write(" " + op + " ")
+		p.expr(rhs, prec)
+		return
+	}
+	p.expr(lhs, prec)
+	p.write(" " + op + " ")
+	p.expr(rhs, prec+1)
+}
+
+func (p *printer) unary(op string, operand ast.Expr) {
+	p.write(op)
+	// `- -x` must not be printed as a comment
+	if op == "-" && startsWithMinus(operand) {
+		p.write(" ")
+	}
+	p.expr(operand, precUnary)
+}
+
+// prefix prints expr as the prefix of a field access or a function call.
+func (p *printer) prefix(expr ast.Expr) {
+	switch expr.(type) {
+	case *ast.IdentExpr, *ast.AttrGetExpr, *ast.FuncCallExpr:
+		p.expr(expr, 0)
+	default:
+		if ex, ok := expr.(*ast.Comma3Expr); ok && ex.AdjustRet {
+			p.expr(expr, 0)
+			return
+		}
+		p.write("(")
+		p.expr(expr, 0)
+		p.write(")")
+	}
+}
+
+func (p *printer) table(tb *ast.TableExpr) {
+	if len(tb.Fields) == 0 {
+		p.write("{}")
+		return
+	}
+	if !isMultilineTable(tb) {
+		p.write("{ ")
+		for i, field := range tb.Fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.field(field)
+		}
+		p.write(" }")
+		return
+	}
+	p.write("{")
+	p.trailing(tb.Line())
+	p.newline()
+	p.level++
+	p.blockStart = true
+	for _, field := range tb.Fields {
+		p.leading(fieldLine(field))
+		p.gap(fieldLine(field))
+		p.field(field)
+		p.write(",")
+		end := exprEnd(field.Value)
+		p.trailing(end)
+		p.newline()
+		if end > p.line {
+			p.line = end
+		}
+	}
+	// comments after the last field are printed in the table
+	p.leading(exprEnd(tb))
+	p.level--
+	p.blockStart = false
+	p.write("}")
+}
+
+// isMultilineTable reports whether tb is printed with one field per line. It is if the
+// fields are on multiple lines in the source or some of them are printed on multiple lines.
+func isMultilineTable(tb *ast.TableExpr) bool {
+	for _, field := range tb.Fields {
+		if fieldLine(field) > tb.Line() || exprEnd(field.Value) > tb.Line() || isMultiline(field.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMultiline reports whether expr is printed on multiple lines.
+func isMultiline(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.FunctionExpr:
+		return len(ex.Stmts) > 0 || ex.LastLine() > ex.Line()
+	case *ast.TableExpr:
+		return len(ex.Fields) > 0 && isMultilineTable(ex)
+	case *ast.StringExpr:
+		return strings.Contains(ex.Value, "\n") && isLongStringSafe(ex.Value)
+	}
+	for _, child := range children(expr) {
+		if isMultiline(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) field(field *ast.Field) {
+	if field.Key != nil {
+		if key, ok := field.Key.(*ast.StringExpr); ok && isName(key.Value) {
+			p.write(key.Value)
+		} else {
+			p.write("[")
+			p.expr(field.Key, 0)
+			p.write("]")
+		}
+		p.write(" = ")
+	}
+	p.expr(field.Value, 0)
+}
+
+func fieldLine(field *ast.Field) int {
+	if field.Key != nil && field.Key.Line() > 0 {
+		return field.Key.Line()
+	}
+	return field.Value.Line()
+}
+
+/* }}} */
+
+/* utilities {{{ */
+
+// startsWithParen reports whether the printed expr starts with `(`.
+func startsWithParen(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.FuncCallExpr:
+		if ex.AdjustRet {
+			return true
+		}
+		if ex.Func != nil {
+			return startsWithParen(ex.Func)
+		}
+		return startsWithParen(ex.Receiver)
+	case *ast.AttrGetExpr:
+		return startsWithParen(ex.Object)
+	case *ast.IdentExpr:
+		return false
+	}
+	// other expressions are parenthesized when they are used as prefixes
+	return true
+}
+
+func startsWithMinus(expr ast.Expr) bool {
+	switch ex := expr.(type) {
+	case *ast.UnaryMinusOpExpr:
+		return true
+	case *ast.NumberExpr:
+		return strings.HasPrefix(ex.Value, "-")
+	}
+	return false
+}
+
+// stmtEnd returns the last line of stmt in the source.
+func stmtEnd(stmt ast.Stmt) int {
+	if end := stmt.End().Line; end > 0 {
+		return end
+	}
+	end := stmt.Line()
+	if stmt.LastLine() > end {
+		end = stmt.LastLine()
+	}
+	var exprs []ast.Expr
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		exprs = append(append(exprs, st.Lhs...), st.Rhs...)
+	case *ast.LocalAssignStmt:
+		exprs = st.Exprs
+	case *ast.FuncCallStmt:
+		exprs = []ast.Expr{st.Expr}
+	case *ast.ReturnStmt:
+		exprs = st.Exprs
+	case *ast.RepeatStmt:
+		exprs = []ast.Expr{st.Condition}
+	}
+	for _, expr := range exprs {
+		if e := exprEnd(expr); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// exprEnd returns the last line of expr in the source that the AST knows.
+func exprEnd(expr ast.Expr) int {
+	if end := expr.End().Line; end > 0 {
+		return end
+	}
+	end := expr.Line()
+	if expr.LastLine() > end {
+		end = expr.LastLine()
+	}
+	if ex, ok := expr.(*ast.StringExpr); ok {
+		// assumes that the string is written like `quote` writes it
+		if n := strings.Count(ex.Value, "\n"); n > 0 && isLongStringSafe(ex.Value) {
+			end += n + 1
+		}
+	}
+	for _, child := range children(expr) {
+		if e := exprEnd(child); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// children returns the sub expressions of expr except the ones in function bodies.
+func children(expr ast.Expr) []ast.Expr {
+	var children []ast.Expr
+	switch ex := expr.(type) {
+	case *ast.AttrGetExpr:
+		children = []ast.Expr{ex.Object, ex.Key}
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				children = append(children, field.Key)
+			}
+			children = append(children, field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			children = append(children, ex.Func)
+		} else {
+			children = append(children, ex.Receiver)
+		}
+		children = append(children, ex.Args...)
+	case *ast.LogicalOpExpr:
+		children = []ast.Expr{ex.Lhs, ex.Rhs}
+	case *ast.RelationalOpExpr:
+		children = []ast.Expr{ex.Lhs, ex.Rhs}
+	case *ast.StringConcatOpExpr:
+		children = []ast.Expr{ex.Lhs, ex.Rhs}
+	case *ast.ArithmeticOpExpr:
+		children = []ast.Expr{ex.Lhs, ex.Rhs}
+	case *ast.UnaryMinusOpExpr:
+		children = []ast.Expr{ex.Expr}
+	case *ast.UnaryNotOpExpr:
+		children = []ast.Expr{ex.Expr}
+	case *ast.UnaryLenOpExpr:
+		children = []ast.Expr{ex.Expr}
+	}
+	return children
+}
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "goto": true, "if": true, "in": true,
+	"local": true, "nil": true, "not": true, "or": true, "repeat": true, "return": true,
+	"then": true, "true": true, "until": true, "while": true,
+}
+
+func isName(s string) bool {
+	if len(s) == 0 || keywords[s] {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// quote returns the Lua literal of s. Strings that have newlines are written as long
+// strings.
+func quote(s string, single bool) string {
+	if strings.Contains(s, "\n") && isLongStringSafe(s) {
+		eq := ""
+		for strings.Contains(s+"]", "]"+eq+"]") {
+			eq += "="
+		}
+		// the first newline of a long string is skipped
+		return "[" + eq + "[\n" + s + "]" + eq + "]"
+	}
+	q, other := byte('"'), byte('\'')
+	if single {
+		q, other = other, q
+	}
+	if strings.IndexByte(s, q) >= 0 && strings.IndexByte(s, other) < 0 {
+		q = other
+	}
+	var buf strings.Builder
+	buf.WriteByte(q)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case q, '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\v':
+			buf.WriteString(`\v`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&buf, "\\%03d", c)
+			} else {
+				buf.WriteByte(c)
+			}
+		}
+	}
+	buf.WriteByte(q)
+	return buf.String()
+}
+
+// isLongStringSafe reports whether s is read back as it is from a long string.
+func isLongStringSafe(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 && c != '\n' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+/* }}} */
@@ -0,0 +1,36 @@
+#!/usr/bin/env glua
+-- leading comment of the chunk
+
+--[[ block
+     comment ]]
+local x=1 -- trailing comment
+local   t = {
+  -- comment in a table
+  a=1, -- after a field
+  b = {1,2,3},
+  --[==[ long ]==]
+}
+
+-- comment before a function
+local function f(a,b)
+  -- first statement
+  if a then return b end -- after if
+  -- before the end
+end
+
+if x then -- a
+  -- b
+else
+  --[==[ c ]==]
+end
+if y then
+  print(y)
+else -- after else
+end
+if z then
+  print(z)
+else
+end
+
+for i=1,#t do print(i) end
+-- trailing comment of the chunk
@@ -0,0 +1,12 @@
+local a,b=1,"two"
+local s='single' .. "double" .. [[long]]
+local n = -a^2 + (b or 3) * 4 / (5 - 6)
+if a==1 then print(a) elseif a>1 then print(b) else print(n) end
+while a<10 do a=a+1 end
+repeat a=a-1 until a<=0
+for k,v in pairs({x=1,["y z"]=2}) do print(k,v) end
+local t={f=function(...) return ... end,[1]=not nil,}
+t.f(1):g{1}:h"s"
+do local x = #t; goto done end
+::done::
+return t
@@ -35,9 +35,11 @@ type FunctionProto struct {
 	FunctionPrototypes []*FunctionProto
 
 	DbgSourcePositions []int
-	DbgLocals          []*DbgLocalInfo
-	DbgCalls           []DbgCall
-	DbgUpvalues        []string
+	// DbgSourceColumns holds the column of each instruction, or 0 if it is unknown.
+	DbgSourceColumns []int
+	DbgLocals        []*DbgLocalInfo
+	DbgCalls         []DbgCall
+	DbgUpvalues      []string
 
 	stringConstants []string
 }
@@ -101,6 +103,7 @@ func newFunctionProto(name string) *FunctionProto {
 		FunctionPrototypes: make([]*FunctionProto, 0, 16),
 
 		DbgSourcePositions: make([]int, 0, 128),
+		DbgSourceColumns:   make([]int, 0, 128),
 		DbgLocals:          make([]*DbgLocalInfo, 0, 16),
 		DbgCalls:           make([]DbgCall, 0, 128),
 		DbgUpvalues:        make([]string, 0, 16),
@@ -148,6 +151,141 @@ func (fp *FunctionProto) str(level int, count int) string {
 	return strings.Join(buf, "")
 }
 
+// sourceColumn returns the column of the instruction at pc, or 0 if it is unknown.
+func (fp *FunctionProto) sourceColumn(pc int) int {
+	if pc < 0 || pc >= len(fp.DbgSourceColumns) {
+		return 0
+	}
+	return fp.DbgSourceColumns[pc]
+}
+
+func (fp *FunctionProto) localName(regno, pc int) (string, bool) {
+	for i := 0; i < len(fp.DbgLocals) && fp.DbgLocals[i].StartPc < pc; i++ {
+		if pc < fp.DbgLocals[i].EndPc {
+			regno--
+			if regno == 0 {
+				return fp.DbgLocals[i].Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// activeLocalName returns the name of the regno-th local variable active at the instruction
+// at pc. Unlike localName, which is given the pc saved in a call frame, that is the pc of the
+// next instruction, the variables are active from their StartPc to their EndPc, inclusive.
+func (fp *FunctionProto) activeLocalName(regno, pc int) (string, bool) {
+	for i := 0; i < len(fp.DbgLocals) && fp.DbgLocals[i].StartPc <= pc; i++ {
+		if pc <= fp.DbgLocals[i].EndPc {
+			regno--
+			if regno == 0 {
+				return fp.DbgLocals[i].Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+/* }}} */
+
+/* symbolic execution {{{ */
+
+// findSetReg returns the pc of the last instruction before lastpc that
+// changes the register reg, or -1 if it can not be determined statically.
+// This is equivalent to findsetreg in ldebug.c.
+func (fp *FunctionProto) findSetReg(lastpc, reg int) int {
+	setreg := -1
+	jmptarget := 0
+	for pc := 0; pc < lastpc; pc++ {
+		inst := fp.Code[pc]
+		op := opGetOpCode(inst)
+		a := opGetArgA(inst)
+		change := false
+		skip := 0
+		switch op {
+		case OP_LOADNIL:
+			change = a <= reg && reg <= opGetArgB(inst)
+		case OP_SELF:
+			change = reg == a || reg == a+1
+		case OP_CALL, OP_TAILCALL, OP_VARARG:
+			change = reg >= a
+		case OP_TFORLOOP:
+			change = reg >= a+2
+		case OP_FORLOOP:
+			change = reg == a || reg == a+3
+		case OP_JMP:
+			// forward jumps make the code in between conditional
+			dest := pc + 1 + opGetArgSbx(inst)
+			if pc < dest && dest <= lastpc && dest > jmptarget {
+				jmptarget = dest
+			}
+		case OP_CLOSURE:
+			change = reg == a
+			// skip pseudo instructions that describe upvalues
+			skip = int(fp.FunctionPrototypes[opGetArgBx(inst)].NumUpvalues)
+		case OP_SETLIST:
+			if opGetArgC(inst) == 0 {
+				skip = 1
+			}
+		case OP_TEST, OP_NOP:
+			/* nothing to do */
+		default:
+			change = opProps[op].SetRegA && reg == a
+		}
+		if change {
+			if pc < jmptarget {
+				setreg = -1
+			} else {
+				setreg = pc
+			}
+		}
+		pc += skip
+	}
+	return setreg
+}
+
+func (fp *FunctionProto) constName(rk int) string {
+	if opIsK(rk) {
+		if str, ok := fp.Constants[opIndexK(rk)].(LString); ok {
+			return string(str)
+		}
+	}
+	return "?"
+}
+
+// objName returns a kind of the variable("local", "global", "field",
+// "upvalue" or "method") and its name that the value held by the register reg
+// at pc comes from. This is equivalent to getobjname in ldebug.c.
+func (fp *FunctionProto) objName(pc, reg int) (string, string) {
+	if name, ok := fp.activeLocalName(reg+1, pc); ok {
+		return "local", name
+	}
+	setpc := fp.findSetReg(pc, reg)
+	if setpc < 0 {
+		return "", ""
+	}
+	inst := fp.Code[setpc]
+	switch opGetOpCode(inst) {
+	case OP_MOVE, OP_MOVEN:
+		if b := opGetArgB(inst); b < opGetArgA(inst) {
+			return fp.objName(setpc, b)
+		}
+	case OP_GETGLOBAL:
+		if str, ok := fp.Constants[opGetArgBx(inst)].(LString); ok {
+			return "global", string(str)
+		}
+	case OP_GETTABLE, OP_GETTABLEKS:
+		return "field", fp.constName(opGetArgC(inst))
+	case OP_GETUPVAL:
+		if b := opGetArgB(inst); b < len(fp.DbgUpvalues) {
+			return "upvalue", fp.DbgUpvalues[b]
+		}
+	case OP_SELF:
+		return "method", fp.constName(opGetArgC(inst))
+	}
+	return "", ""
+}
+
 /* }}} */
 
 /* LFunction {{{ */
@@ -178,16 +316,7 @@ func (fn *LFunction) LocalName(regno, pc int) (string, bool) {
 	if fn.IsG {
 		return "", false
 	}
-	p := fn.Proto
-	for i := 0; i < len(p.DbgLocals) && p.DbgLocals[i].StartPc < pc; i++ {
-		if pc < p.DbgLocals[i].EndPc {
-			regno--
-			if regno == 0 {
-				return p.DbgLocals[i].Name, true
-			}
-		}
-	}
-	return "", false
+	return fn.Proto.localName(regno, pc)
 }
 
 /* }}} */
@@ -0,0 +1,106 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+const lGoErrorClass = "error*"
+
+var goErrorMethods = map[string]LGFunction{
+	"__tostring": goErrorToString,
+	"error":      goErrorToString,
+	"is":         goErrorIs,
+	"unwrap":     goErrorUnwrap,
+}
+
+// goErrorKinds are the error kinds that can be tested by err:is(kind).
+var goErrorKinds = map[string][]error{
+	"not_exist":  {os.ErrNotExist},
+	"exist":      {os.ErrExist},
+	"permission": {os.ErrPermission},
+	"closed":     {os.ErrClosed},
+	"eof":        {io.EOF, io.ErrUnexpectedEOF},
+	"timeout":    {context.DeadlineExceeded, os.ErrDeadlineExceeded},
+	"canceled":   {context.Canceled},
+}
+
+func (ls *LState) newGoError(err error) *LUserData {
+	mt := ls.GetTypeMetatable(lGoErrorClass)
+	if mt == LNil {
+		tb := ls.NewTypeMetatable(lGoErrorClass)
+		tb.RawSetString("__index", tb)
+		ls.SetFuncs(tb, goErrorMethods)
+		mt = tb
+	}
+	ud := ls.NewUserData()
+	ud.Value = err
+	ls.SetMetatable(ud, mt)
+	return ud
+}
+
+// goErrorValue returns the Go error held by the given Lua value, if any.
+func goErrorValue(lv LValue) (error, bool) {
+	if ud, ok := lv.(*LUserData); ok {
+		if err, ok := ud.Value.(error); ok {
+			return err, true
+		}
+	}
+	return nil, false
+}
+
+func checkGoError(L *LState, n int) error {
+	if err, ok := goErrorValue(L.Get(n)); ok {
+		return err
+	}
+	L.ArgError(n, "error expected")
+	return nil
+}
+
+func goErrorToString(L *LState) int {
+	L.Push(LString(checkGoError(L, 1).Error()))
+	return 1
+}
+
+func goErrorIs(L *LState) int {
+	err := checkGoError(L, 1)
+	if target, ok := goErrorValue(L.Get(2)); ok {
+		L.Push(LBool(errors.Is(err, target)))
+		return 1
+	}
+	kind := L.CheckString(2)
+	targets, ok := goErrorKinds[kind]
+	if !ok {
+		L.ArgError(2, "unknown error kind '"+kind+"'")
+	}
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			L.Push(LTrue)
+			return 1
+		}
+	}
+	if kind == "timeout" {
+		var terr interface{ Timeout() bool }
+		if errors.As(err, &terr) && terr.Timeout() {
+			L.Push(LTrue)
+			return 1
+		}
+	}
+	L.Push(LFalse)
+	return 1
+}
+
+func goErrorUnwrap(L *LState) int {
+	if err := errors.Unwrap(checkGoError(L, 1)); err != nil {
+		// the metatable already exists since the receiver is an error value.
+		ud := L.NewUserData()
+		ud.Value = err
+		L.SetMetatable(ud, L.GetTypeMetatable(lGoErrorClass))
+		L.Push(ud)
+	} else {
+		L.Push(LNil)
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRaiseGoError(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	_, perr := os.Open("/nonexistent/file")
+	L.SetGlobal("openfile", L.NewFunction(func(L *LState) int {
+		L.RaiseGoError(fmt.Errorf("openfile: %w", perr))
+		return 0
+	}))
+	L.SetGlobal("timeout", L.NewFunction(func(L *LState) int {
+		L.RaiseGoError(context.DeadlineExceeded)
+		return 0
+	}))
+	errorIfScriptFail(t, L, `
+    local ok, err = pcall(openfile)
+    assert(not ok)
+    assert(type(err) == "userdata")
+    assert(tostring(err) == err:error())
+    assert(string.find(tostring(err), "openfile: open /nonexistent/file", 1, true))
+    assert(err:is("not_exist"))
+    assert(not err:is("permission"))
+    assert(err:unwrap():is("not_exist"))
+    local ok, err2 = pcall(function() error(err) end)
+    assert(rawequal(err, err2))
+    local ok, terr = pcall(timeout)
+    assert(terr:is("timeout") and not terr:is("canceled"))
+    assert(not terr:is(err) and err:is(err))
+    `)
+	errorIfScriptNotFail(t, L, `local ok, err = pcall(openfile); err:is("unknown")`, "unknown error kind 'unknown'")
+
+	err := L.DoString(`local ok, err = pcall(openfile); error(err)`)
+	aerr, ok := err.(*ApiError)
+	errorIfFalse(t, ok, "expected *ApiError")
+	errorIfFalse(t, errors.Is(aerr.Unwrap(), os.ErrNotExist), "Unwrap should return the original error")
+	var pathErr *os.PathError
+	errorIfFalse(t, errors.As(err, &pathErr), "errors.As should find *os.PathError")
+	errorIfNotEqual(t, perr, pathErr)
+	errorIfFalse(t, strings.HasPrefix(aerr.Error(), "openfile: open"), "unexpected message: %s", aerr.Error())
+
+	L.Push(L.GetGlobal("timeout"))
+	err = L.PCall(0, 0, nil)
+	errorIfFalse(t, errors.Is(err, context.DeadlineExceeded), "errors.Is should find context.DeadlineExceeded")
+}
@@ -0,0 +1,460 @@
+package lua
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+/* HeapGraph {{{ */
+
+// HeapGraph is the graph of the Lua objects reachable from an LState: tables, functions,
+// userdata, upvalues and threads. Strings and numbers are not objects of the graph; the
+// sizes of strings are added to the sizes of the objects that refer to them.
+type HeapGraph struct {
+	// Root is the synthetic object whose edges are the roots: the threads, the registry,
+	// the global table and the metatables of the builtin types.
+	Root *HeapObject
+	// Objects are the objects in the breadth-first order from Root, starting with Root.
+	Objects []*HeapObject
+
+	index map[interface{}]*HeapObject
+}
+
+// HeapObject is an object of a HeapGraph.
+type HeapObject struct {
+	ID int
+	// Type is one of "root", "table", "function", "userdata", "upvalue" and "thread".
+	Type string
+	// Name describes the object, like `function <script.lua:10>`.
+	Name string
+	// Value is the LValue of the object, or the *Upvalue for an upvalue.
+	Value interface{}
+	// Size is the estimated size of the object in bytes.
+	Size int
+	// RetainedSize is the size of the memory that would be freed with the object, that is
+	// the sum of the sizes of the objects that are reachable only through it.
+	RetainedSize int
+	Edges        []HeapEdge
+
+	// parent and parentEdge are the edge that found the object first, which is on one of
+	// the shortest paths from Root
+	parent     *HeapObject
+	parentEdge int
+	retainers  []*HeapObject
+	// idom is the immediate dominator of the object
+	idom *HeapObject
+	// postorder is the number of the object in the depth-first postorder from Root, or 0 if
+	// it is not visited yet
+	postorder int
+}
+
+// HeapEdge is a reference from an object to another.
+type HeapEdge struct {
+	// Name is the key of a table field like `name` or `[1]`, or describes the reference
+	// like `(metatable)` or `(upvalue count)`.
+	Name string
+	To   *HeapObject
+	kind heapEdgeKind
+	// index is the integer key of an element edge
+	index int
+}
+
+type heapEdgeKind int
+
+// the edge kinds are in the order of the edge types of the Chrome heap snapshots
+const (
+	heapEdgeContext heapEdgeKind = iota
+	heapEdgeElement
+	heapEdgeProperty
+	heapEdgeInternal
+)
+
+// HeapPath is a path of references from the roots of a HeapGraph to an object.
+type HeapPath []HeapEdge
+
+// String returns the names of the edges like `globals -> cache -> [1]`.
+func (p HeapPath) String() string {
+	names := make([]string, len(p))
+	for i, edge := range p {
+		names[i] = edge.Name
+	}
+	return strings.Join(names, " -> ")
+}
+
+// HeapGraph returns the graph of the objects reachable from this LState. It must be called
+// by the goroutine running the LState, because it reads the stacks of the threads.
+func (ls *LState) HeapGraph() *HeapGraph {
+	g := &HeapGraph{index: map[interface{}]*HeapObject{}}
+	g.Root = g.newObject(nil, "root", "(roots)", 0)
+	w := &heapWalker{g: g}
+	w.edge(g.Root, heapEdgeInternal, "main thread", 0, ls.G.MainThread)
+	if ls != ls.G.MainThread {
+		w.edge(g.Root, heapEdgeInternal, "thread", 0, ls)
+	}
+	w.edge(g.Root, heapEdgeInternal, "registry", 0, ls.G.Registry)
+	w.edge(g.Root, heapEdgeInternal, "globals", 0, ls.G.Global)
+	for typ, mt := range ls.G.builtinMts {
+		w.edge(g.Root, heapEdgeInternal, "(metatable of "+LValueType(typ).String()+")", 0, mt)
+	}
+	for i := 1; i < len(g.Objects); i++ {
+		w.walk(g.Objects[i])
+	}
+	g.dominators()
+	return g
+}
+
+func (g *HeapGraph) newObject(value interface{}, typ, name string, size int) *HeapObject {
+	obj := &HeapObject{ID: len(g.Objects)*2 + 1, Type: typ, Name: name, Value: value, Size: size}
+	g.Objects = append(g.Objects, obj)
+	if value != nil {
+		g.index[value] = obj
+	}
+	return obj
+}
+
+// Object returns the object of the value, or nil if the value is not reachable.
+func (g *HeapGraph) Object(value LValue) *HeapObject {
+	return g.index[value]
+}
+
+// RetentionPaths returns the paths from the roots to the object of the value through each
+// of the objects that refer to it, up to max paths. Each path is one of the shortest paths
+// to the referring object. It returns nil if the value is not reachable.
+func (g *HeapGraph) RetentionPaths(value LValue, max int) []HeapPath {
+	obj := g.Object(value)
+	if obj == nil {
+		return nil
+	}
+	paths := []HeapPath{}
+	for _, retainer := range obj.retainers {
+		if len(paths) >= max {
+			break
+		}
+		for _, edge := range retainer.Edges {
+			if edge.To == obj {
+				paths = append(paths, append(g.path(retainer), edge))
+				break
+			}
+		}
+	}
+	return paths
+}
+
+// path returns the shortest path from the roots to obj.
+func (g *HeapGraph) path(obj *HeapObject) HeapPath {
+	path := HeapPath{}
+	for ; obj.parent != nil; obj = obj.parent {
+		path = append(HeapPath{obj.parent.Edges[obj.parentEdge]}, path...)
+	}
+	return path
+}
+
+// heapWalker finds the objects reachable from the roots in the breadth-first order.
+type heapWalker struct {
+	g *HeapGraph
+}
+
+// edge adds an edge from obj to the value, if the value is an object.
+func (w *heapWalker) edge(obj *HeapObject, kind heapEdgeKind, name string, index int, value interface{}) {
+	to := w.object(value)
+	if to == nil {
+		return
+	}
+	obj.Edges = append(obj.Edges, HeapEdge{Name: name, To: to, kind: kind, index: index})
+	if to.parent == nil && to != w.g.Root {
+		to.parent, to.parentEdge = obj, len(obj.Edges)-1
+	}
+}
+
+// object returns the object of the value, creating one if it is not found yet. It returns
+// nil if the value is not an object.
+func (w *heapWalker) object(value interface{}) *HeapObject {
+	switch v := value.(type) {
+	case *LTable:
+		if v == nil {
+			return nil
+		}
+	case *LFunction, *LUserData, *LState, *Upvalue:
+	default:
+		return nil
+	}
+	if obj, ok := w.g.index[value]; ok {
+		return obj
+	}
+	switch v := value.(type) {
+	case *LTable:
+		return w.g.newObject(v, "table", "table", tableBytes(v))
+	case *LFunction:
+		return w.g.newObject(v, "function", heapFunctionName(v), closureBytes(len(v.Upvalues)))
+	case *LUserData:
+		return w.g.newObject(v, "userdata", "userdata", int(unsafe.Sizeof(LUserData{})))
+	case *LState:
+		name := "thread"
+		if v == v.G.MainThread {
+			name = "main thread"
+		}
+		return w.g.newObject(v, "thread", name, int(unsafe.Sizeof(LState{}))+cap(v.reg.array)*valueBytes)
+	case *Upvalue:
+		return w.g.newObject(v, "upvalue", "upvalue", upvalueBytes)
+	}
+	return nil
+}
+
+func heapFunctionName(fn *LFunction) string {
+	if fn.IsG {
+		if f := runtime.FuncForPC(reflect.ValueOf(fn.GFunction).Pointer()); f != nil {
+			return "function <" + f.Name() + ">"
+		}
+		return "function <G>"
+	}
+	return fmt.Sprintf("function <%v:%v>", fn.Proto.SourceName, fn.Proto.LineDefined)
+}
+
+// walk adds the edges of obj, and the sizes of the strings it refers to.
+func (w *heapWalker) walk(obj *HeapObject) {
+	switch v := obj.Value.(type) {
+	case *LTable:
+		v.ForEach(func(key, value LValue) {
+			obj.Size += heapStringBytes(key) + heapStringBytes(value)
+			switch k := key.(type) {
+			case LString:
+				w.edge(obj, heapEdgeProperty, string(k), 0, value)
+			case LNumber:
+				if i := int(k); LNumber(i) == k && i > 0 {
+					w.edge(obj, heapEdgeElement, fmt.Sprintf("[%d]", i), i, value)
+				} else {
+					w.edge(obj, heapEdgeInternal, "["+k.String()+"]", 0, value)
+				}
+			default:
+				w.edge(obj, heapEdgeInternal, "(key)", 0, key)
+				w.edge(obj, heapEdgeInternal, "["+key.String()+"]", 0, value)
+			}
+		})
+		w.edge(obj, heapEdgeInternal, "(metatable)", 0, v.Metatable)
+	case *LFunction:
+		w.edge(obj, heapEdgeInternal, "(env)", 0, v.Env)
+		for i, uv := range v.Upvalues {
+			name := fmt.Sprintf("(upvalue %d)", i+1)
+			if !v.IsG && i < len(v.Proto.DbgUpvalues) {
+				name = "(upvalue " + v.Proto.DbgUpvalues[i] + ")"
+			}
+			w.edge(obj, heapEdgeContext, name, 0, uv)
+		}
+	case *Upvalue:
+		value := v.Value()
+		obj.Size += heapStringBytes(value)
+		w.edge(obj, heapEdgeInternal, "(value)", 0, value)
+	case *LUserData:
+		w.edge(obj, heapEdgeInternal, "(env)", 0, v.Env)
+		w.edge(obj, heapEdgeInternal, "(metatable)", 0, v.Metatable)
+	case *LState:
+		w.edge(obj, heapEdgeInternal, "(env)", 0, v.Env)
+		for i := 0; i < v.stack.Sp(); i++ {
+			w.edge(obj, heapEdgeInternal, fmt.Sprintf("(frame %d)", i), 0, v.stack.At(i).Fn)
+		}
+		for i := 0; i < v.reg.Top(); i++ {
+			value := v.reg.Get(i)
+			obj.Size += heapStringBytes(value)
+			w.edge(obj, heapEdgeInternal, fmt.Sprintf("(register %d)", i), 0, value)
+		}
+	}
+}
+
+func heapStringBytes(value LValue) int {
+	if s, ok := value.(LString); ok {
+		return stringBytes(len(s))
+	}
+	return 0
+}
+
+// dominators computes the retainers, the dominators and the retained sizes of the objects
+// by the algorithm of Cooper, Harvey and Kennedy.
+func (g *HeapGraph) dominators() {
+	// number the objects in the postorder
+	postorder := make([]*HeapObject, 0, len(g.Objects))
+	type entry struct {
+		obj  *HeapObject
+		edge int
+	}
+	stack := []entry{{obj: g.Root}}
+	for len(stack) != 0 {
+		top := &stack[len(stack)-1]
+		if top.edge < len(top.obj.Edges) {
+			to := top.obj.Edges[top.edge].To
+			top.edge++
+			if to.postorder == 0 && to != g.Root {
+				to.postorder = -1
+				stack = append(stack, entry{obj: to})
+			}
+			continue
+		}
+		postorder = append(postorder, top.obj)
+		top.obj.postorder = len(postorder)
+		stack = stack[:len(stack)-1]
+	}
+	for _, obj := range g.Objects {
+		for _, edge := range obj.Edges {
+			if n := len(edge.To.retainers); n == 0 || edge.To.retainers[n-1] != obj {
+				edge.To.retainers = append(edge.To.retainers, obj)
+			}
+		}
+	}
+
+	intersect := func(a, b *HeapObject) *HeapObject {
+		for a != b {
+			for a.postorder < b.postorder {
+				a = a.idom
+			}
+			for b.postorder < a.postorder {
+				b = b.idom
+			}
+		}
+		return a
+	}
+	g.Root.idom = g.Root
+	for changed := true; changed; {
+		changed = false
+		for i := len(postorder) - 2; i >= 0; i-- {
+			obj := postorder[i]
+			var idom *HeapObject
+			for _, retainer := range obj.retainers {
+				if retainer.idom == nil {
+					continue
+				}
+				if idom == nil {
+					idom = retainer
+				} else {
+					idom = intersect(retainer, idom)
+				}
+			}
+			if idom != obj.idom {
+				obj.idom = idom
+				changed = true
+			}
+		}
+	}
+
+	for _, obj := range postorder {
+		obj.RetainedSize += obj.Size
+		if obj != g.Root {
+			obj.idom.RetainedSize += obj.RetainedSize
+		}
+	}
+}
+
+/* }}} */
+
+/* Chrome heap snapshots {{{ */
+
+type heapSnapshot struct {
+	Snapshot           heapSnapshotInfo `json:"snapshot"`
+	Nodes              []int            `json:"nodes"`
+	Edges              []int            `json:"edges"`
+	TraceFunctionInfos []int            `json:"trace_function_infos"`
+	TraceTree          []int            `json:"trace_tree"`
+	Samples            []int            `json:"samples"`
+	Locations          []int            `json:"locations"`
+	Strings            []string         `json:"strings"`
+}
+
+type heapSnapshotInfo struct {
+	Meta               heapSnapshotMeta `json:"meta"`
+	NodeCount          int              `json:"node_count"`
+	EdgeCount          int              `json:"edge_count"`
+	TraceFunctionCount int              `json:"trace_function_count"`
+}
+
+type heapSnapshotMeta struct {
+	NodeFields              []string      `json:"node_fields"`
+	NodeTypes               []interface{} `json:"node_types"`
+	EdgeFields              []string      `json:"edge_fields"`
+	EdgeTypes               []interface{} `json:"edge_types"`
+	TraceFunctionInfoFields []string      `json:"trace_function_info_fields"`
+	TraceNodeFields         []string      `json:"trace_node_fields"`
+	SampleFields            []string      `json:"sample_fields"`
+	LocationFields          []string      `json:"location_fields"`
+}
+
+var heapNodeTypes = []string{"hidden", "array", "string", "object", "code", "closure", "regexp", "number", "native", "synthetic", "concatenated string", "sliced string", "symbol", "bigint"}
+
+var heapNodeTypeIndex = map[string]int{
+	"upvalue":  0,
+	"table":    3,
+	"thread":   3,
+	"function": 5,
+	"userdata": 8,
+	"root":     9,
+}
+
+// WriteSnapshot writes the graph in the format of the heap snapshots of Chrome, which can be
+// loaded by the Memory panel of Chrome DevTools. The nodes have the retained sizes in the
+// field retained_size besides the standard ones.
+func (g *HeapGraph) WriteSnapshot(w io.Writer) error {
+	const nodeFieldCount = 7
+	strs := []string{}
+	strIndex := map[string]int{}
+	str := func(s string) int {
+		if i, ok := strIndex[s]; ok {
+			return i
+		}
+		strIndex[s] = len(strs)
+		strs = append(strs, s)
+		return len(strs) - 1
+	}
+	nodeIndex := map[*HeapObject]int{}
+	for i, obj := range g.Objects {
+		nodeIndex[obj] = i
+	}
+	nodes := make([]int, 0, len(g.Objects)*nodeFieldCount)
+	edges := []int{}
+	for _, obj := range g.Objects {
+		nodes = append(nodes, heapNodeTypeIndex[obj.Type], str(obj.Name), obj.ID, obj.Size, len(obj.Edges), 0, obj.RetainedSize)
+		for _, edge := range obj.Edges {
+			nameOrIndex := edge.index
+			if edge.kind != heapEdgeElement {
+				nameOrIndex = str(edge.Name)
+			}
+			edges = append(edges, int(edge.kind), nameOrIndex, nodeIndex[edge.To]*nodeFieldCount)
+		}
+	}
+	snapshot := heapSnapshot{
+		Snapshot: heapSnapshotInfo{
+			Meta: heapSnapshotMeta{
+				NodeFields: []string{"type", "name", "id", "self_size", "edge_count", "trace_node_id", "retained_size"},
+				NodeTypes:  []interface{}{heapNodeTypes, "string", "number", "number", "number", "number", "number"},
+				EdgeFields: []string{"type", "name_or_index", "to_node"},
+				EdgeTypes: []interface{}{
+					[]string{"context", "element", "property", "internal", "hidden", "shortcut", "weak"},
+					"string_or_number", "node",
+				},
+				TraceFunctionInfoFields: []string{"function_id", "name", "script_name", "script_id", "line", "column"},
+				TraceNodeFields:         []string{"id", "function_info_index", "count", "size", "children"},
+				SampleFields:            []string{"timestamp_us", "last_assigned_id"},
+				LocationFields:          []string{"object_index", "script_id", "line", "column"},
+			},
+			NodeCount: len(g.Objects),
+			EdgeCount: len(edges) / 3,
+		},
+		Nodes:              nodes,
+		Edges:              edges,
+		TraceFunctionInfos: []int{},
+		TraceTree:          []int{},
+		Samples:            []int{},
+		Locations:          []int{},
+		Strings:            strs,
+	}
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// HeapSnapshot writes the graph of the objects reachable from this LState in the format of
+// the heap snapshots of Chrome. See HeapGraph and HeapGraph.WriteSnapshot.
+func (ls *LState) HeapSnapshot(w io.Writer) error {
+	return ls.HeapGraph().WriteSnapshot(w)
+}
+
+/* }}} */
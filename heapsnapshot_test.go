@@ -0,0 +1,104 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestHeapGraph(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+cache = {items = {}}
+local big = string.rep("x", 1000)
+cache.items[1] = {data = big}
+local leaked = cache.items[1]
+function handler()
+  return leaked
+end
+`)
+	item := L.GetTable(L.GetField(L.GetGlobal("cache"), "items"), LNumber(1))
+	g := L.HeapGraph()
+	obj := g.Object(item)
+	errorIfNil(t, obj)
+	errorIfNotEqual(t, "table", obj.Type)
+	errorIfFalse(t, obj.Size >= 1000, "the size of the table should include the string: %v", obj.Size)
+	errorIfNotEqual(t, obj.Size, obj.RetainedSize)
+
+	paths := g.RetentionPaths(item, 10)
+	strs := map[string]bool{}
+	for _, path := range paths {
+		strs[path.String()] = true
+	}
+	errorIfNotEqual(t, 2, len(paths))
+	errorIfFalse(t, strs["globals -> cache -> items -> [1]"], "path through the table: %v", strs)
+	errorIfFalse(t, strs["globals -> handler -> (upvalue leaked) -> (value)"], "path through the upvalue: %v", strs)
+	errorIfNotEqual(t, 1, len(g.RetentionPaths(item, 1)))
+
+	// the item is retained by two objects, so neither retains its size
+	items := g.Object(L.GetField(L.GetGlobal("cache"), "items"))
+	errorIfNotEqual(t, items.Size, items.RetainedSize)
+	cache := g.Object(L.GetGlobal("cache"))
+	errorIfNotEqual(t, cache.Size+items.Size, cache.RetainedSize)
+	errorIfFalse(t, g.Root.RetainedSize >= obj.Size+cache.RetainedSize, "the root retains everything")
+	errorIfFalse(t, g.Object(LString("cache")) == nil, "strings are not objects")
+}
+
+func TestHeapGraphThreads(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+co = coroutine.create(function()
+  local held = {}
+  coroutine.yield(held)
+end)
+local _, held = coroutine.resume(co)
+heldref = held
+`)
+	g := L.HeapGraph()
+	held := L.GetGlobal("heldref")
+	paths := g.RetentionPaths(held, 10)
+	errorIfNotEqual(t, 2, len(paths))
+	found := false
+	for _, path := range paths {
+		if path[len(path)-2].To.Type == "thread" {
+			found = true
+		}
+	}
+	errorIfFalse(t, found, "the table should be retained by the register of the thread: %v", paths)
+	errorIfNil(t, g.RetentionPaths(L.NewTable(), 10))
+}
+
+func TestHeapSnapshot(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `t = {1, 2, {x = "y"}}`)
+	var buf bytes.Buffer
+	errorIfNotNil(t, L.HeapSnapshot(&buf))
+	var snapshot struct {
+		Snapshot struct {
+			Meta struct {
+				NodeFields []string `json:"node_fields"`
+			} `json:"meta"`
+			NodeCount int `json:"node_count"`
+			EdgeCount int `json:"edge_count"`
+		} `json:"snapshot"`
+		Nodes   []int    `json:"nodes"`
+		Edges   []int    `json:"edges"`
+		Strings []string `json:"strings"`
+	}
+	errorIfNotNil(t, json.Unmarshal(buf.Bytes(), &snapshot))
+	fields := len(snapshot.Snapshot.Meta.NodeFields)
+	errorIfNotEqual(t, snapshot.Snapshot.NodeCount*fields, len(snapshot.Nodes))
+	errorIfNotEqual(t, snapshot.Snapshot.EdgeCount*3, len(snapshot.Edges))
+	edges := 0
+	for i := 0; i < len(snapshot.Nodes); i += fields {
+		edges += snapshot.Nodes[i+4]
+	}
+	errorIfNotEqual(t, snapshot.Snapshot.EdgeCount, edges)
+	for i := 2; i < len(snapshot.Edges); i += 3 {
+		errorIfFalse(t, snapshot.Edges[i]%fields == 0 && snapshot.Edges[i] < len(snapshot.Nodes), "invalid to_node: %v", snapshot.Edges[i])
+	}
+	errorIfNotEqual(t, "(roots)", snapshot.Strings[snapshot.Nodes[1]])
+}
@@ -21,6 +21,13 @@ const (
 	ChannelLibName = "channel"
 	// CoroutineLibName is the name of the coroutine Library.
 	CoroutineLibName = "coroutine"
+	// ReLibName is the name of the re Library. It is not opened by OpenLibs.
+	ReLibName = "re"
+	// SchedLibName is the name of the sched Library. It is not opened by OpenLibs;
+	// NewScheduler makes it available via require.
+	SchedLibName = "sched"
+	// TaskLibName is the name of the task Library. It is not opened by OpenLibs.
+	TaskLibName = "task"
 )
 
 type luaLib struct {
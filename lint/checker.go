@@ -0,0 +1,478 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+type varKind int
+
+const (
+	varLocal varKind = iota
+	varParam
+	varLoop
+	varImplicit // self of methods
+)
+
+type variable struct {
+	name string
+	pos  ast.Position
+	kind varKind
+	used bool
+}
+
+type label struct {
+	name  string
+	pos   ast.Position
+	index int
+	used  bool
+}
+
+type pendingGoto struct {
+	stmt *ast.GotoStmt
+	// index is the index of the statement that contains the goto in the block
+	index int
+}
+
+type scope struct {
+	parent *scope
+	fn     int
+	vars   []*variable
+	labels map[string]*label
+	order  []*label
+	gotos  []*pendingGoto
+	index  int
+}
+
+type globalAccess struct {
+	name string
+	pos  ast.Position
+}
+
+type checker struct {
+	source   string
+	globals  map[string]bool
+	disabled map[string]bool
+	scope    *scope
+	fn       int
+	numFn    int
+	reads    []globalAccess
+	assigned map[string]bool
+	diags    []*Diagnostic
+	sigs     map[string]*Signature
+}
+
+func newChecker(source string, cfg *Config) *checker {
+	c := &checker{
+		source:   source,
+		globals:  map[string]bool{},
+		disabled: map[string]bool{},
+		assigned: map[string]bool{},
+		sigs:     cfg.Signatures,
+	}
+	for name := range standardGlobals() {
+		c.globals[name] = true
+	}
+	for _, name := range cfg.Globals {
+		c.globals[name] = true
+	}
+	for name := range cfg.Signatures {
+		// the functions with signatures are provided by the host
+		c.globals[strings.FieldsFunc(name, isPathSeparator)[0]] = true
+	}
+	for _, code := range cfg.Disabled {
+		c.disabled[code] = true
+	}
+	return c
+}
+
+func (c *checker) report(pos ast.Position, code string, format string, args ...interface{}) {
+	if c.disabled[code] {
+		return
+	}
+	c.diags = append(c.diags, &Diagnostic{
+		Source:  c.source,
+		Line:    pos.Line,
+		Column:  pos.Column,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) chunk(stmts []ast.Stmt) {
+	c.function(nil, ast.Position{}, false, stmts)
+	for _, read := range c.reads {
+		if !c.assigned[read.name] {
+			c.report(read.pos, UndefinedGlobal, "accessing undefined variable '%s'", read.name)
+		}
+	}
+}
+
+/* scopes {{{ */
+
+func (c *checker) openScope() {
+	c.scope = &scope{parent: c.scope, fn: c.fn, labels: map[string]*label{}}
+}
+
+func (c *checker) closeScope() {
+	s := c.scope
+	for _, v := range s.vars {
+		if v.used || v.kind == varImplicit || strings.HasPrefix(v.name, "_") {
+			continue
+		}
+		switch v.kind {
+		case varParam:
+			c.report(v.pos, UnusedParam, "unused parameter '%s'", v.name)
+		case varLoop:
+			c.report(v.pos, UnusedLocal, "unused loop variable '%s'", v.name)
+		default:
+			c.report(v.pos, UnusedLocal, "unused local variable '%s'", v.name)
+		}
+	}
+	c.scope = s.parent
+}
+
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if s.vars[i].name == name {
+				return s.vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) declare(name string, pos ast.Position, kind varKind) {
+	if kind != varImplicit && !strings.HasPrefix(name, "_") {
+		if old := c.lookup(name); old != nil && old.kind != varImplicit {
+			if c.isDeclaredInScope(old) {
+				c.report(pos, Shadowing, "variable '%s' was previously defined on line %d", name, old.pos.Line)
+			} else {
+				c.report(pos, Shadowing, "shadowing upvalue or local '%s' on line %d", name, old.pos.Line)
+			}
+		}
+	}
+	c.scope.vars = append(c.scope.vars, &variable{name: name, pos: pos, kind: kind})
+}
+
+func (c *checker) isDeclaredInScope(v *variable) bool {
+	for _, sv := range c.scope.vars {
+		if sv == v {
+			return true
+		}
+	}
+	return false
+}
+
+func isPathSeparator(c rune) bool {
+	return c == '.' || c == ':'
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (c *checker) function(parlist *ast.ParList, pos ast.Position, method bool, stmts []ast.Stmt) {
+	parent := c.fn
+	c.numFn++
+	c.fn = c.numFn
+	c.openScope()
+	if method {
+		c.declare("self", ast.Position{}, varImplicit)
+	}
+	if parlist != nil {
+		for _, name := range parlist.Names {
+			c.declare(name, pos, varParam)
+		}
+	}
+	c.block(stmts)
+	c.closeScope()
+	c.fn = parent
+}
+
+// scopedBlock checks stmts in a new scope.
+func (c *checker) scopedBlock(stmts []ast.Stmt) {
+	c.openScope()
+	c.block(stmts)
+	c.closeScope()
+}
+
+func (c *checker) block(stmts []ast.Stmt) {
+	s := c.scope
+	for i, stmt := range stmts {
+		if lbl, ok := stmt.(*ast.LabelStmt); ok {
+			if old, ok := s.labels[lbl.Name]; ok {
+				c.report(lbl.Pos(), DuplicateLabel, "label '%s' already defined on line %d", lbl.Name, old.pos.Line)
+				continue
+			}
+			s.labels[lbl.Name] = &label{name: lbl.Name, pos: lbl.Pos(), index: i}
+			s.order = append(s.order, s.labels[lbl.Name])
+		}
+	}
+
+	unreachable := false
+	for i, stmt := range stmts {
+		s.index = i
+		if _, ok := stmt.(*ast.LabelStmt); ok {
+			unreachable = false
+		} else if i > 0 && terminates(stmts[i-1]) && !unreachable {
+			unreachable = true
+			c.report(stmt.Pos(), Unreachable, "unreachable code")
+		}
+		c.stmt(stmt)
+	}
+
+	for _, g := range s.gotos {
+		if lbl, ok := s.labels[g.stmt.Label]; ok {
+			lbl.used = true
+			c.checkGotoScope(stmts, g, lbl)
+		} else if s.parent != nil && s.parent.fn == s.fn {
+			s.parent.gotos = append(s.parent.gotos, &pendingGoto{stmt: g.stmt, index: s.parent.index})
+		} else {
+			c.report(g.stmt.Pos(), UndefinedLabel, "no visible label '%s' for goto", g.stmt.Label)
+		}
+	}
+	for _, lbl := range s.order {
+		if !lbl.used {
+			c.report(lbl.pos, UnusedLabel, "unused label '%s'", lbl.name)
+		}
+	}
+}
+
+// checkGotoScope reports a goto that jumps forward into the scope of a local variable.
+// A label at the end of a block is considered outside the scope of the locals of the block.
+func (c *checker) checkGotoScope(stmts []ast.Stmt, g *pendingGoto, lbl *label) {
+	if lbl.index < g.index || onlyLabels(stmts[lbl.index+1:]) {
+		return
+	}
+	for _, stmt := range stmts[g.index+1 : lbl.index] {
+		if local, ok := stmt.(*ast.LocalAssignStmt); ok {
+			c.report(g.stmt.Pos(), GotoScope, "goto '%s' jumps into the scope of local '%s'", lbl.name, local.Names[0])
+			return
+		}
+	}
+}
+
+func onlyLabels(stmts []ast.Stmt) bool {
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.LabelStmt); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// terminates reports whether the statements after stmt in the same block are never executed.
+func terminates(stmt ast.Stmt) bool {
+	switch st := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.GotoStmt:
+		return true
+	case *ast.DoBlockStmt:
+		return len(st.Stmts) > 0 && terminates(st.Stmts[len(st.Stmts)-1])
+	case *ast.IfStmt:
+		if len(st.Then) == 0 || !terminates(st.Then[len(st.Then)-1]) || len(st.Else) == 0 {
+			return false
+		}
+		return terminates(st.Else[len(st.Else)-1])
+	}
+	return false
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		c.exprs(st.Rhs)
+		for _, lhs := range st.Lhs {
+			c.assign(lhs)
+		}
+	case *ast.LocalAssignStmt:
+		if len(st.Names) == 1 && len(st.Exprs) == 1 {
+			if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok { // local function f() ... end
+				c.declare(st.Names[0], st.Pos(), varLocal)
+				c.function(fn.ParList, fn.Pos(), false, fn.Stmts)
+				return
+			}
+		}
+		c.exprs(st.Exprs)
+		for _, name := range st.Names {
+			c.declare(name, st.Pos(), varLocal)
+		}
+	case *ast.FuncCallStmt:
+		c.expr(st.Expr)
+	case *ast.DoBlockStmt:
+		c.scopedBlock(st.Stmts)
+	case *ast.WhileStmt:
+		c.expr(st.Condition)
+		c.scopedBlock(st.Stmts)
+	case *ast.RepeatStmt:
+		// the condition can refer to the locals in the block
+		c.openScope()
+		c.block(st.Stmts)
+		c.expr(st.Condition)
+		c.closeScope()
+	case *ast.IfStmt:
+		c.expr(st.Condition)
+		c.scopedBlock(st.Then)
+		c.scopedBlock(st.Else)
+	case *ast.NumberForStmt:
+		c.expr(st.Init)
+		c.expr(st.Limit)
+		if st.Step != nil {
+			c.expr(st.Step)
+		}
+		c.openScope()
+		c.declare(st.Name, st.Pos(), varLoop)
+		c.block(st.Stmts)
+		c.closeScope()
+	case *ast.GenericForStmt:
+		c.exprs(st.Exprs)
+		c.openScope()
+		for _, name := range st.Names {
+			c.declare(name, st.Pos(), varLoop)
+		}
+		c.block(st.Stmts)
+		c.closeScope()
+	case *ast.FuncDefStmt:
+		if st.Name.Func != nil {
+			c.assign(st.Name.Func)
+			c.function(st.Func.ParList, st.Func.Pos(), false, st.Func.Stmts)
+		} else {
+			c.expr(st.Name.Receiver)
+			c.function(st.Func.ParList, st.Func.Pos(), true, st.Func.Stmts)
+		}
+	case *ast.ReturnStmt:
+		c.exprs(st.Exprs)
+	case *ast.GotoStmt:
+		c.scope.gotos = append(c.scope.gotos, &pendingGoto{stmt: st, index: c.scope.index})
+	case *ast.BreakStmt, *ast.LabelStmt:
+	}
+}
+
+func (c *checker) assign(lhs ast.Expr) {
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		if c.lookup(ex.Value) != nil {
+			return
+		}
+		c.assigned[ex.Value] = true
+		if !c.globals[ex.Value] {
+			c.report(ex.Pos(), GlobalAssign, "setting non-standard global variable '%s'", ex.Value)
+		}
+	default:
+		c.expr(lhs)
+	}
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+func (c *checker) exprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		c.expr(expr)
+	}
+}
+
+func (c *checker) expr(expr ast.Expr) {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if v := c.lookup(ex.Value); v != nil {
+			v.used = true
+		} else if !c.globals[ex.Value] {
+			c.reads = append(c.reads, globalAccess{ex.Value, ex.Pos()})
+		}
+	case *ast.AttrGetExpr:
+		c.expr(ex.Object)
+		c.expr(ex.Key)
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				c.expr(field.Key)
+			}
+			c.expr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			c.expr(ex.Func)
+		} else {
+			c.expr(ex.Receiver)
+		}
+		c.exprs(ex.Args)
+		c.checkArity(ex)
+	case *ast.LogicalOpExpr:
+		c.expr(ex.Lhs)
+		c.expr(ex.Rhs)
+	case *ast.RelationalOpExpr:
+		c.expr(ex.Lhs)
+		c.expr(ex.Rhs)
+	case *ast.StringConcatOpExpr:
+		c.expr(ex.Lhs)
+		c.expr(ex.Rhs)
+	case *ast.ArithmeticOpExpr:
+		c.expr(ex.Lhs)
+		c.expr(ex.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		c.expr(ex.Expr)
+	case *ast.UnaryNotOpExpr:
+		c.expr(ex.Expr)
+	case *ast.UnaryLenOpExpr:
+		c.expr(ex.Expr)
+	case *ast.FunctionExpr:
+		c.function(ex.ParList, ex.Pos(), false, ex.Stmts)
+	}
+}
+
+// globalPath returns the name like `a.b.c` of expr if expr is a field of a global variable.
+func (c *checker) globalPath(expr ast.Expr) (string, bool) {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		return ex.Value, c.lookup(ex.Value) == nil
+	case *ast.AttrGetExpr:
+		key, ok := ex.Key.(*ast.StringExpr)
+		if !ok {
+			return "", false
+		}
+		path, ok := c.globalPath(ex.Object)
+		return path + "." + key.Value, ok
+	}
+	return "", false
+}
+
+func (c *checker) checkArity(call *ast.FuncCallExpr) {
+	if len(c.sigs) == 0 {
+		return
+	}
+	var name string
+	var ok bool
+	if call.Func != nil {
+		name, ok = c.globalPath(call.Func)
+	} else {
+		name, ok = c.globalPath(call.Receiver)
+		name += ":" + call.Method
+	}
+	if !ok {
+		return
+	}
+	sig, ok := c.sigs[name]
+	if !ok {
+		return
+	}
+	nargs := len(call.Args)
+	multret := false
+	if nargs > 0 {
+		switch call.Args[nargs-1].(type) {
+		case *ast.FuncCallExpr, *ast.Comma3Expr:
+			// the last argument can be expanded to any number of values
+			multret = true
+			nargs--
+		}
+	}
+	if (!multret && nargs < sig.MinArgs) || (sig.MaxArgs >= 0 && nargs > sig.MaxArgs) {
+		c.report(call.Pos(), Arity, "function '%s' expects %s but got %d", name, sig.expects(), len(call.Args))
+	}
+}
+
+/* }}} */
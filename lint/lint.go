@@ -0,0 +1,136 @@
+// Package lint provides a static checker for Lua sources.
+package lint
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Codes of the diagnostics.
+const (
+	SyntaxError     = "syntax-error"
+	UndefinedGlobal = "undefined-global"
+	GlobalAssign    = "global-assign"
+	UnusedLocal     = "unused-local"
+	UnusedParam     = "unused-param"
+	Shadowing       = "shadowing"
+	Unreachable     = "unreachable"
+	UndefinedLabel  = "undefined-label"
+	DuplicateLabel  = "duplicate-label"
+	UnusedLabel     = "unused-label"
+	GotoScope       = "goto-scope"
+	Arity           = "arity"
+)
+
+// Diagnostic is a problem found in a Lua source.
+type Diagnostic struct {
+	Source  string `json:"source"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s (%s)", d.Source, d.Line, d.Column, d.Message, d.Code)
+	}
+	return fmt.Sprintf("%s:%d: %s (%s)", d.Source, d.Line, d.Message, d.Code)
+}
+
+// Config configures the checks.
+type Config struct {
+	// Globals are the names of the global variables provided by the host in addition to
+	// the ones defined by the standard libraries.
+	Globals []string
+	// Signatures are the signatures of the functions provided by the host, keyed by the
+	// names like `print`, `http.get` or `obj:method`. Calls of these functions are checked
+	// against them.
+	Signatures map[string]*Signature
+	// Disabled are the codes of the diagnostics that are not reported.
+	Disabled []string
+}
+
+// Check checks chunk parsed from source and returns the diagnostics sorted by line.
+// If cfg is nil, the default configuration is used.
+func Check(chunk []ast.Stmt, source string, cfg *Config) []*Diagnostic {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	c := newChecker(source, cfg)
+	c.chunk(chunk)
+	sort.SliceStable(c.diags, func(i, j int) bool { return c.diags[i].Line < c.diags[j].Line })
+	return c.diags
+}
+
+// CheckReader parses the Lua source read from reader and checks it. Syntax errors are
+// reported as diagnostics with the SyntaxError code, and the other checks are skipped if
+// the source has syntax errors.
+func CheckReader(reader io.Reader, source string, cfg *Config) ([]*Diagnostic, error) {
+	src, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	chunk, _, errs := parse.ParseAll(bytes.NewReader(src), source)
+	if len(errs) == 0 {
+		return Check(chunk, source, cfg), nil
+	}
+	diags := make([]*Diagnostic, 0, len(errs))
+	for _, perr := range errs {
+		line := perr.Pos.Line
+		if line == parse.EOF {
+			// errors at the end of the source are reported at the last line like Lua
+			line = lastLine(src)
+		}
+		diags = append(diags, &Diagnostic{
+			Source:  source,
+			Line:    line,
+			Column:  perr.Pos.Column,
+			Code:    SyntaxError,
+			Message: perr.Message,
+		})
+	}
+	return diags, nil
+}
+
+// lastLine returns the number of the last line that is not empty in src.
+func lastLine(src []byte) int {
+	src = bytes.TrimRight(src, " \t\r\n")
+	return bytes.Count(src, []byte("\n")) + 1
+}
+
+// CheckFile checks the Lua source file at path.
+func CheckFile(path string, cfg *Config) ([]*Diagnostic, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return CheckReader(file, path, cfg)
+}
+
+var stdGlobals map[string]bool
+var stdGlobalsOnce sync.Once
+
+// standardGlobals returns the names of the global variables that are defined in a new LState.
+func standardGlobals() map[string]bool {
+	stdGlobalsOnce.Do(func() {
+		L := lua.NewState()
+		defer L.Close()
+		stdGlobals = map[string]bool{}
+		L.G.Global.ForEach(func(key, _ lua.LValue) {
+			if s, ok := key.(lua.LString); ok {
+				stdGlobals[string(s)] = true
+			}
+		})
+	})
+	return stdGlobals
+}
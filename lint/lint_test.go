@@ -0,0 +1,80 @@
+package lint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	sigs := map[string]*Signature{}
+	for _, src := range []string{"http.get(url, options?)", "log(...)", "obj:send(msg)"} {
+		sig, err := ParseSignature(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs[sig.Name] = sig
+	}
+	cases := []struct {
+		code    string
+		src     string
+		lines   []int
+		message string
+	}{
+		{SyntaxError, "local x = = 1", []int{1}, "expected expression"},
+		{SyntaxError, "local x = 1\nif x then\n  print(x)\n\n", []int{3}, "expected 'end' to close 'if' at line 2"},
+		{UndefinedGlobal, "print(undefined)\nlocal defined\nprint(defined, host)", []int{1}, "accessing undefined variable 'undefined'"},
+		{GlobalAssign, "counter = 1\nprint = nil\nhost = 1", []int{1}, "setting non-standard global variable 'counter'"},
+		{UnusedLocal, "local used, unused = 1, 2\nprint(used)\nfor i = 1, 2 do end\nlocal _ = 1", []int{1, 3}, "unused local variable 'unused'"},
+		{UnusedParam, "local function f(a, b, _) return a end\nf()", []int{1}, "unused parameter 'b'"},
+		{Shadowing, "local x = 1\ndo\n  local x = 2\n  print(x)\nend\nprint(x)", []int{3}, "shadowing upvalue or local 'x' on line 1"},
+		{Shadowing, "local x = 1\nlocal x = 2\nprint(x)", []int{2}, "variable 'x' was previously defined on line 1"},
+		{Unreachable, "local function f(x)\n  if x then return 1 else return 2 end\n  print(1)\n  print(2)\nend\nwhile true do\n  do break end\n  print(3)\nend\ngoto done\n::done::\nf()", []int{3, 8}, "unreachable code"},
+		{UndefinedLabel, "goto missing", []int{1}, "no visible label 'missing' for goto"},
+		{DuplicateLabel, "::a::\n::a::\ngoto a", []int{2}, "label 'a' already defined on line 1"},
+		{UnusedLabel, "::unused::", []int{1}, "unused label 'unused'"},
+		{GotoScope, "goto skip\nlocal x = 1\n::skip::\nprint(x)", []int{1}, "goto 'skip' jumps into the scope of local 'x'"},
+		{Arity, "http.get()\nhttp.get('a', {}, 1)\nhttp.get('a')\nhttp.get(...)\nlog()\nobj:send()\nobj:send(1)", []int{1, 2, 6}, "function 'http.get' expects 1 to 2 arguments but got 0"},
+	}
+	for _, c := range cases {
+		diags, err := CheckReader(strings.NewReader(c.src), "test.lua", &Config{
+			Globals:    []string{"host", "obj"},
+			Signatures: sigs,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := []int{}
+		var first *Diagnostic
+		for _, diag := range diags {
+			if diag.Code != c.code {
+				continue
+			}
+			if first == nil {
+				first = diag
+			}
+			lines = append(lines, diag.Line)
+		}
+		if !reflect.DeepEqual(c.lines, lines) {
+			t.Errorf("%s: %q: lines %v expected, but got %v: %v", c.code, c.src, c.lines, lines, diags)
+			continue
+		}
+		if first.Message != c.message {
+			t.Errorf("%s: %q: message %q expected, but got %q", c.code, c.src, c.message, first.Message)
+		}
+	}
+}
+
+func TestCheckDisabled(t *testing.T) {
+	src := "local unused\nprint(undefined)"
+	diags, err := CheckReader(strings.NewReader(src), "test.lua", &Config{Disabled: []string{UnusedLocal}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diags) != 1 || diags[0].Code != UndefinedGlobal {
+		t.Errorf("only %s expected, but got %v", UndefinedGlobal, diags)
+	}
+	if s := diags[0].String(); !strings.HasPrefix(s, "test.lua:2:") || !strings.HasSuffix(s, "(undefined-global)") {
+		t.Errorf("unexpected string %q", s)
+	}
+}
@@ -0,0 +1,117 @@
+package lint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Signature is the signature of a function provided by the host.
+type Signature struct {
+	Name   string
+	Params []string
+	// MinArgs is the number of the required arguments.
+	MinArgs int
+	// MaxArgs is the maximum number of the arguments, or -1 if the function takes
+	// variable arguments.
+	MaxArgs int
+}
+
+func (s *Signature) String() string {
+	return s.Name + "(" + strings.Join(s.Params, ", ") + ")"
+}
+
+// expects describes the number of the arguments that the function expects.
+func (s *Signature) expects() string {
+	switch {
+	case s.MaxArgs < 0:
+		return fmt.Sprintf("at least %s", plural(s.MinArgs, "argument"))
+	case s.MinArgs == s.MaxArgs:
+		return plural(s.MinArgs, "argument")
+	default:
+		return fmt.Sprintf("%d to %s", s.MinArgs, plural(s.MaxArgs, "argument"))
+	}
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+var signaturePattern = regexp.MustCompile(`^([A-Za-z_][\w]*(?:\.[A-Za-z_]\w*)*(?::[A-Za-z_]\w*)?)\s*\((.*)\)$`)
+
+// ParseSignature parses a signature like `http.get(url, options?)`. Parameters that end
+// with `?` are optional and `...` means variable arguments. `obj:method(a)` is the
+// signature of a method; self is not counted as an argument.
+func ParseSignature(src string) (*Signature, error) {
+	m := signaturePattern.FindStringSubmatch(strings.TrimSpace(src))
+	if m == nil {
+		return nil, fmt.Errorf("invalid signature: %s", src)
+	}
+	sig := &Signature{Name: m[1], Params: []string{}}
+	optional := false
+	for _, param := range strings.Split(m[2], ",") {
+		param = strings.TrimSpace(param)
+		switch {
+		case len(param) == 0 && len(strings.TrimSpace(m[2])) == 0:
+			continue
+		case sig.MaxArgs < 0:
+			return nil, fmt.Errorf("invalid signature: %s: '...' must be the last parameter", src)
+		case param == "...":
+			sig.MaxArgs = -1
+		case strings.HasSuffix(param, "?"):
+			optional = true
+			sig.MaxArgs++
+		case optional:
+			return nil, fmt.Errorf("invalid signature: %s: required parameter after optional ones", src)
+		case len(param) == 0:
+			return nil, fmt.Errorf("invalid signature: %s", src)
+		default:
+			sig.MinArgs++
+			sig.MaxArgs++
+		}
+		sig.Params = append(sig.Params, param)
+	}
+	return sig, nil
+}
+
+// ParseSignatures reads signatures, one per line. Empty lines and lines starting with `#`
+// are ignored.
+func ParseSignatures(reader io.Reader) (map[string]*Signature, error) {
+	sigs := map[string]*Signature{}
+	scanner := bufio.NewScanner(reader)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sig, err := ParseSignature(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		sigs[sig.Name] = sig
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
+
+// LoadSignatures reads the signature file at path.
+func LoadSignatures(path string) (map[string]*Signature, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sigs, err := ParseSignatures(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sigs, nil
+}
@@ -0,0 +1,596 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+type declKind int
+
+const (
+	declLocal declKind = iota
+	declParam
+	declLoop
+	declSelf // self of methods
+)
+
+// decl is a declaration of a local variable.
+type decl struct {
+	name string
+	kind declKind
+	// start and end are the offsets of the name
+	start, end int
+	// scopeStart and scopeEnd are the offsets of the range where the variable is visible
+	scopeStart, scopeEnd int
+	sym                  *symbol
+}
+
+type refKind int
+
+const (
+	refVariable refKind = iota // a local or global variable
+	refField                   // a field like b of a.b
+	refMethod                  // a method like m of a:m()
+	refRequire                 // the module name of require "m"
+)
+
+// ref is a name in the source that refers to a symbol.
+type ref struct {
+	kind       refKind
+	start, end int
+	name       string
+	// decl is the declaration of the local variable for refVariable, or nil if the variable
+	// is global
+	decl *decl
+	// object is the table of refField and refMethod
+	object ast.Expr
+}
+
+// analysis holds the declarations and the references in a document.
+type analysis struct {
+	doc    *document
+	env    *environment
+	decls  []*decl
+	refs   []*ref
+	locals map[*ast.IdentExpr]*decl
+	// globals are the global variables assigned in the document
+	globals map[string]*symbol
+	// exports is the value returned by the chunk, or nil
+	exports *symbol
+	// preloads are the modules set to package.preload or package.loaded in the document
+	preloads map[string]*symbol
+}
+
+type scope struct {
+	parent *scope
+	decls  []*decl
+	// end is the offset where the scope ends
+	end int
+}
+
+// resolver walks the AST to analyze a document.
+type resolver struct {
+	*analysis
+	scope *scope
+	docs  *docComments
+	// fn is the function that the resolver is in, or nil for the main chunk
+	fn      *ast.FunctionExpr
+	returns map[*ast.FunctionExpr]*symbol
+}
+
+func analyze(doc *document, env *environment) *analysis {
+	a := &analysis{
+		doc:      doc,
+		env:      env,
+		locals:   map[*ast.IdentExpr]*decl{},
+		globals:  map[string]*symbol{},
+		preloads: map[string]*symbol{},
+	}
+	r := &resolver{analysis: a, docs: newDocComments(doc.text, doc.comments), returns: map[*ast.FunctionExpr]*symbol{}}
+	r.openScope(len(doc.text) + 1)
+	r.block(doc.chunk)
+	r.closeScope()
+	a.exports = r.returns[nil]
+	return a
+}
+
+/* scopes {{{ */
+
+func (r *resolver) openScope(end int) {
+	r.scope = &scope{parent: r.scope, end: end}
+}
+
+func (r *resolver) closeScope() {
+	r.scope = r.scope.parent
+}
+
+func (r *resolver) lookup(name string) *decl {
+	for s := r.scope; s != nil; s = s.parent {
+		for i := len(s.decls) - 1; i >= 0; i-- {
+			if s.decls[i].name == name {
+				return s.decls[i]
+			}
+		}
+	}
+	return nil
+}
+
+// declare declares a local variable whose name is at the offset start and that is visible
+// after the offset scopeStart.
+func (r *resolver) declare(name string, kind declKind, start, scopeStart int, sym *symbol) *decl {
+	d := &decl{name: name, kind: kind, start: start, end: start + len(name), scopeStart: scopeStart, scopeEnd: r.scope.end, sym: sym}
+	if kind == declSelf {
+		d.end = start
+	}
+	if sym.loc == nil {
+		sym.loc = r.doc.location(d.start, d.end)
+	}
+	r.scope.decls = append(r.scope.decls, d)
+	r.decls = append(r.decls, d)
+	return d
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || '0' <= c && c <= '9'
+}
+
+/* }}} */
+
+/* statements {{{ */
+
+func (r *resolver) block(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		r.stmt(stmt)
+	}
+}
+
+// scopedBlock analyzes stmts in a new scope that ends at the offset end.
+func (r *resolver) scopedBlock(stmts []ast.Stmt, end int) {
+	r.openScope(end)
+	r.block(stmts)
+	r.closeScope()
+}
+
+func (r *resolver) stmt(stmt ast.Stmt) {
+	_, end := r.doc.span(stmt)
+	switch st := stmt.(type) {
+	case *ast.AssignStmt:
+		r.exprs(st.Rhs)
+		for i, lhs := range st.Lhs {
+			r.assign(lhs, valueAt(st.Rhs, i), stmt)
+		}
+	case *ast.LocalAssignStmt:
+		start := r.doc.clamp(st.Pos().Offset)
+		if len(st.Names) == 1 && len(st.Exprs) == 1 {
+			name := st.Names[0]
+			nameStart := r.doc.findName(start, name)
+			if fn, ok := st.Exprs[0].(*ast.FunctionExpr); ok && strings.Contains(string(r.doc.text[start:nameStart]), "function") {
+				// local function f() ... end; f is visible in the function
+				r.declare(name, declLocal, nameStart, start, r.valueSymbol(name, name, true, fn, st.Pos().Line))
+				r.function(fn, false)
+				return
+			}
+		}
+		r.exprs(st.Exprs)
+		for i, name := range st.Names {
+			start = r.doc.findName(start, name)
+			r.declare(name, declLocal, start, end, r.valueSymbol(name, name, true, valueAt(st.Exprs, i), st.Pos().Line))
+			start += len(name)
+		}
+	case *ast.FuncCallStmt:
+		r.expr(st.Expr)
+	case *ast.DoBlockStmt:
+		r.scopedBlock(st.Stmts, end)
+	case *ast.WhileStmt:
+		r.expr(st.Condition)
+		r.scopedBlock(st.Stmts, end)
+	case *ast.RepeatStmt:
+		// the condition can refer to the locals in the block
+		r.openScope(end)
+		r.block(st.Stmts)
+		r.expr(st.Condition)
+		r.closeScope()
+	case *ast.IfStmt:
+		r.expr(st.Condition)
+		thenEnd := end
+		if len(st.Else) != 0 {
+			thenEnd, _ = r.doc.span(st.Else[0])
+		}
+		r.scopedBlock(st.Then, thenEnd)
+		r.scopedBlock(st.Else, end)
+	case *ast.NumberForStmt:
+		r.expr(st.Init)
+		r.expr(st.Limit)
+		header := st.Limit
+		if st.Step != nil {
+			r.expr(st.Step)
+			header = st.Step
+		}
+		r.openScope(end)
+		_, bodyStart := r.doc.span(header)
+		start, _ := r.doc.span(stmt)
+		r.declare(st.Name, declLoop, r.doc.findName(start, st.Name), bodyStart, &symbol{name: st.Name, detail: "(loop variable) " + st.Name})
+		r.block(st.Stmts)
+		r.closeScope()
+	case *ast.GenericForStmt:
+		r.exprs(st.Exprs)
+		r.openScope(end)
+		_, bodyStart := r.doc.span(st.Exprs[len(st.Exprs)-1])
+		start, _ := r.doc.span(stmt)
+		for _, name := range st.Names {
+			start = r.doc.findName(start, name)
+			r.declare(name, declLoop, start, bodyStart, &symbol{name: name, detail: "(loop variable) " + name})
+			start += len(name)
+		}
+		r.block(st.Stmts)
+		r.closeScope()
+	case *ast.FuncDefStmt:
+		if st.Name.Func != nil {
+			r.assign(st.Name.Func, st.Func, stmt)
+			r.function(st.Func, false)
+		} else {
+			r.expr(st.Name.Receiver)
+			_, recvEnd := r.doc.span(st.Name.Receiver)
+			start := r.doc.findName(recvEnd, st.Name.Method)
+			r.refs = append(r.refs, &ref{kind: refMethod, start: start, end: start + len(st.Name.Method), name: st.Name.Method, object: st.Name.Receiver})
+			if obj := r.resolve(st.Name.Receiver); obj != nil {
+				path := exprPath(st.Name.Receiver) + ":" + st.Name.Method
+				sym := r.valueSymbol(st.Name.Method, path, false, st.Func, st.Pos().Line)
+				sym.loc = r.doc.location(start, start+len(st.Name.Method))
+				obj.setField(sym)
+			}
+			r.function(st.Func, true)
+		}
+	case *ast.ReturnStmt:
+		r.exprs(st.Exprs)
+		if _, ok := r.returns[r.fn]; !ok && len(st.Exprs) != 0 {
+			r.returns[r.fn] = r.resolve(st.Exprs[0])
+		}
+	case *ast.BreakStmt, *ast.LabelStmt, *ast.GotoStmt:
+	}
+}
+
+// valueAt returns the i-th value of exprs, or nil if the value is not given by an expression.
+func valueAt(exprs []ast.Expr, i int) ast.Expr {
+	if i < len(exprs) {
+		return exprs[i]
+	}
+	return nil
+}
+
+// function analyzes the body of fn. method is true if fn is defined with `function a:m()`.
+func (r *resolver) function(fn *ast.FunctionExpr, method bool) {
+	parent := r.fn
+	r.fn = fn
+	start, end := r.doc.span(fn)
+	r.openScope(end)
+	if method {
+		r.declare("self", declSelf, start, start, &symbol{name: "self", detail: "(parameter) self"})
+	}
+	params := start
+	if i := strings.IndexByte(string(r.doc.text[start:end]), '('); i >= 0 {
+		params += i
+	}
+	for _, name := range fn.ParList.Names {
+		params = r.doc.findName(params, name)
+		r.declare(name, declParam, params, start, &symbol{name: name, detail: "(parameter) " + name})
+		params += len(name)
+	}
+	r.block(fn.Stmts)
+	r.closeScope()
+	r.fn = parent
+}
+
+// assign analyzes the assignment of value to lhs in stmt. value is nil if it is not given
+// by an expression.
+func (r *resolver) assign(lhs ast.Expr, value ast.Expr, stmt ast.Stmt) {
+	line := stmt.Pos().Line
+	switch ex := lhs.(type) {
+	case *ast.IdentExpr:
+		r.expr(ex)
+		if r.locals[ex] != nil {
+			return
+		}
+		if _, ok := r.globals[ex.Value]; !ok {
+			sym := r.valueSymbol(ex.Value, ex.Value, false, value, line)
+			sym.loc = r.doc.location(r.doc.span(ex))
+			r.globals[ex.Value] = sym
+		}
+	case *ast.AttrGetExpr:
+		r.expr(ex)
+		key, ok := ex.Key.(*ast.StringExpr)
+		if !ok {
+			return
+		}
+		if name, ok := preloadName(ex.Object); ok {
+			r.preload(key.Value, name, value, stmt)
+			return
+		}
+		obj := r.resolve(ex.Object)
+		if obj == nil {
+			return
+		}
+		sym := r.valueSymbol(key.Value, exprPath(ex), false, value, line)
+		sym.loc = r.doc.location(r.doc.span(key))
+		obj.setField(sym)
+	default:
+		r.expr(lhs)
+	}
+}
+
+// preloadName returns `preload` or `loaded` if expr is package.preload or package.loaded.
+func preloadName(expr ast.Expr) (string, bool) {
+	attr, ok := expr.(*ast.AttrGetExpr)
+	if !ok {
+		return "", false
+	}
+	pkg, ok := attr.Object.(*ast.IdentExpr)
+	key, ok2 := attr.Key.(*ast.StringExpr)
+	if !ok || !ok2 || pkg.Value != "package" || (key.Value != "preload" && key.Value != "loaded") {
+		return "", false
+	}
+	return key.Value, true
+}
+
+// preload records the module set to package.preload or package.loaded, like the modules
+// registered by LState.PreloadModule.
+func (r *resolver) preload(module, table string, value ast.Expr, stmt ast.Stmt) {
+	var exports *symbol
+	if fn, ok := value.(*ast.FunctionExpr); ok && table == "preload" {
+		exports = r.returns[fn]
+	} else if table == "loaded" && value != nil {
+		exports = r.resolve(value)
+	}
+	sym := &symbol{name: module, kind: symTable, detail: fmt.Sprintf("module %q", module), doc: r.docs.doc(stmt.Pos().Line)}
+	sym.loc = r.doc.location(r.doc.span(stmt))
+	if exports != nil {
+		sym.fields, sym.module = exports.fields, exports.module
+		if sym.fields == nil {
+			// shares the fields added later
+			exports.fields = map[string]*symbol{}
+			sym.fields = exports.fields
+		}
+	}
+	r.preloads[module] = sym
+}
+
+/* }}} */
+
+/* expressions {{{ */
+
+func (r *resolver) exprs(exprs []ast.Expr) {
+	for _, expr := range exprs {
+		r.expr(expr)
+	}
+}
+
+func (r *resolver) expr(expr ast.Expr) {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		d := r.lookup(ex.Value)
+		if d != nil {
+			r.locals[ex] = d
+		}
+		start, end := r.doc.span(ex)
+		r.refs = append(r.refs, &ref{kind: refVariable, start: start, end: end, name: ex.Value, decl: d})
+	case *ast.AttrGetExpr:
+		r.expr(ex.Object)
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			start, end := r.doc.span(key)
+			r.refs = append(r.refs, &ref{kind: refField, start: start, end: end, name: key.Value, object: ex.Object})
+		} else {
+			r.expr(ex.Key)
+		}
+	case *ast.TableExpr:
+		for _, field := range ex.Fields {
+			if field.Key != nil {
+				r.expr(field.Key)
+			}
+			r.expr(field.Value)
+		}
+	case *ast.FuncCallExpr:
+		if ex.Func != nil {
+			r.expr(ex.Func)
+			if module, ok := requireName(ex); ok {
+				start, end := r.doc.span(ex.Args[0])
+				r.refs = append(r.refs, &ref{kind: refRequire, start: start, end: end, name: module})
+			}
+		} else {
+			r.expr(ex.Receiver)
+			_, recvEnd := r.doc.span(ex.Receiver)
+			start := r.doc.findName(recvEnd, ex.Method)
+			r.refs = append(r.refs, &ref{kind: refMethod, start: start, end: start + len(ex.Method), name: ex.Method, object: ex.Receiver})
+		}
+		r.exprs(ex.Args)
+	case *ast.LogicalOpExpr:
+		r.expr(ex.Lhs)
+		r.expr(ex.Rhs)
+	case *ast.RelationalOpExpr:
+		r.expr(ex.Lhs)
+		r.expr(ex.Rhs)
+	case *ast.StringConcatOpExpr:
+		r.expr(ex.Lhs)
+		r.expr(ex.Rhs)
+	case *ast.ArithmeticOpExpr:
+		r.expr(ex.Lhs)
+		r.expr(ex.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		r.expr(ex.Expr)
+	case *ast.UnaryNotOpExpr:
+		r.expr(ex.Expr)
+	case *ast.UnaryLenOpExpr:
+		r.expr(ex.Expr)
+	case *ast.FunctionExpr:
+		r.function(ex, false)
+	}
+}
+
+// requireName returns the name of the module if call is like `require "m"`.
+func requireName(call *ast.FuncCallExpr) (string, bool) {
+	fn, ok := call.Func.(*ast.IdentExpr)
+	if !ok || fn.Value != "require" || len(call.Args) == 0 {
+		return "", false
+	}
+	module, ok := call.Args[0].(*ast.StringExpr)
+	if !ok {
+		return "", false
+	}
+	return module.Value, true
+}
+
+// exprPath returns the name like `a.b.c` of expr for details of symbols.
+func exprPath(expr ast.Expr) string {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		return ex.Value
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return exprPath(ex.Object) + "." + key.Value
+		}
+		return exprPath(ex.Object) + "[]"
+	}
+	return "?"
+}
+
+/* }}} */
+
+/* symbols {{{ */
+
+// valueSymbol returns the symbol of a variable or a field that is assigned value. path is
+// the name like `a.b` of the variable or the field, and local is true for local variables.
+func (r *resolver) valueSymbol(name, path string, local bool, value ast.Expr, line int) *symbol {
+	sym := &symbol{name: name, doc: r.docs.doc(line)}
+	switch {
+	case local:
+		sym.detail = "local " + name
+	case strings.ContainsAny(path, ".:"):
+		sym.detail = "(field) " + path
+	default:
+		sym.detail = "(global) " + path
+	}
+	switch ex := value.(type) {
+	case *ast.FunctionExpr:
+		params := append([]string{}, ex.ParList.Names...)
+		if ex.ParList.HasVargs {
+			params = append(params, "...")
+		}
+		sym.kind = symFunction
+		sym.detail = "function " + path + "(" + strings.Join(params, ", ") + ")"
+		if local {
+			sym.detail = "local " + sym.detail
+		}
+	case *ast.TableExpr:
+		sym.kind = symTable
+		for _, field := range ex.Fields {
+			if key, ok := field.Key.(*ast.StringExpr); ok {
+				fieldSym := r.valueSymbol(key.Value, path+"."+key.Value, false, field.Value, key.Pos().Line)
+				fieldSym.loc = r.doc.location(r.doc.span(key))
+				sym.setField(fieldSym)
+			}
+		}
+	case *ast.FuncCallExpr:
+		if module, ok := requireName(ex); ok {
+			sym.kind = symTable
+			sym.module = module
+		}
+	case nil:
+	default:
+		if v := r.resolve(value); v != nil {
+			// aliases like `local insert = table.insert`
+			sym.kind, sym.fields, sym.module = v.kind, v.fields, v.module
+		}
+	}
+	return sym
+}
+
+// resolve returns the symbol of the value of expr, or nil if it is unknown.
+func (a *analysis) resolve(expr ast.Expr) *symbol {
+	switch ex := expr.(type) {
+	case *ast.IdentExpr:
+		if d, ok := a.locals[ex]; ok {
+			return d.sym
+		}
+		return a.global(ex.Value)
+	case *ast.AttrGetExpr:
+		if key, ok := ex.Key.(*ast.StringExpr); ok {
+			return a.env.field(a.resolve(ex.Object), key.Value)
+		}
+	case *ast.FuncCallExpr:
+		if module, ok := requireName(ex); ok {
+			return &symbol{name: module, kind: symTable, module: module}
+		}
+	}
+	return nil
+}
+
+// global returns the symbol of the global variable, or nil if it is unknown.
+func (a *analysis) global(name string) *symbol {
+	if sym, ok := a.globals[name]; ok {
+		return sym
+	}
+	return a.env.globals[name]
+}
+
+// symbolAt returns the symbol of the name at the offset and the offsets of the name.
+func (a *analysis) symbolAt(offset int) (*symbol, int, int) {
+	for _, d := range a.decls {
+		if d.start <= offset && offset <= d.end && d.start != d.end {
+			return d.sym, d.start, d.end
+		}
+	}
+	for _, rf := range a.refs {
+		if rf.start <= offset && offset <= rf.end {
+			return a.refSymbol(rf), rf.start, rf.end
+		}
+	}
+	return nil, 0, 0
+}
+
+func (a *analysis) refSymbol(rf *ref) *symbol {
+	switch rf.kind {
+	case refVariable:
+		if rf.decl != nil {
+			return rf.decl.sym
+		}
+		return a.global(rf.name)
+	case refField, refMethod:
+		return a.env.field(a.resolve(rf.object), rf.name)
+	case refRequire:
+		if a.env.module != nil {
+			return a.env.module(rf.name)
+		}
+	}
+	return nil
+}
+
+// visible returns the local variables visible at the offset, the innermost first.
+func (a *analysis) visible(offset int) []*decl {
+	decls := []*decl{}
+	seen := map[string]bool{}
+	for i := len(a.decls) - 1; i >= 0; i-- {
+		d := a.decls[i]
+		if d.scopeStart <= offset && offset < d.scopeEnd && !seen[d.name] {
+			seen[d.name] = true
+			decls = append(decls, d)
+		}
+	}
+	return decls
+}
+
+// lookupAt returns the symbol of the variable name visible at the offset.
+func (a *analysis) lookupAt(name string, offset int) *symbol {
+	for _, d := range a.visible(offset) {
+		if d.name == name {
+			return d.sym
+		}
+	}
+	return a.global(name)
+}
+
+/* }}} */
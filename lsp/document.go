@@ -0,0 +1,209 @@
+package lsp
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// document is a Lua source opened by the client or read from a file.
+type document struct {
+	uri  string
+	text []byte
+	// lines are the offsets where the lines start
+	lines    []int
+	chunk    []ast.Stmt
+	comments []*ast.Comment
+	errs     []*parse.Error
+	analysis *analysis
+}
+
+// newDocument parses text. The first line of text is skipped if it starts with `#`, like
+// LState.LoadFile skips it.
+func newDocument(uri string, text []byte) *document {
+	doc := &document{uri: uri, text: text, lines: lineStarts(text)}
+	src := text
+	if bytes.HasPrefix(text, []byte("#")) {
+		// blanks the line out so that the offsets do not change
+		src = append([]byte{}, text...)
+		for i := 0; i < len(src) && src[i] != '\n' && src[i] != '\r'; i++ {
+			src[i] = ' '
+		}
+	}
+	doc.chunk, doc.comments, doc.errs = parse.ParseAll(bytes.NewReader(src), uriToPath(uri))
+	return doc
+}
+
+// lineStarts returns the offsets where the lines of text start.
+func lineStarts(text []byte) []int {
+	lines := []int{0}
+	for i, c := range text {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// position converts the byte offset to the position in the protocol.
+func (doc *document) position(offset int) Position {
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(doc.text) {
+		offset = len(doc.text)
+	}
+	line := sort.Search(len(doc.lines), func(i int) bool { return doc.lines[i] > offset }) - 1
+	character := 0
+	for _, r := range string(doc.text[doc.lines[line]:offset]) {
+		character += utf16Len(r)
+	}
+	return Position{Line: line, Character: character}
+}
+
+// offset converts the position in the protocol to the byte offset.
+func (doc *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(doc.lines) {
+		return len(doc.text)
+	}
+	offset := doc.lines[pos.Line]
+	for character := 0; character < pos.Character && offset < len(doc.text); {
+		r, size := utf8.DecodeRune(doc.text[offset:])
+		if r == '\n' {
+			break
+		}
+		character += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+// utf16Len returns the number of the UTF-16 code units that encode r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+func (doc *document) rangeOf(start, end int) Range {
+	return Range{Start: doc.position(start), End: doc.position(end)}
+}
+
+func (doc *document) location(start, end int) *Location {
+	return &Location{URI: doc.uri, Range: doc.rangeOf(start, end)}
+}
+
+// span returns the offsets where node starts and ends.
+func (doc *document) span(node ast.Element) (int, int) {
+	return doc.clamp(node.Pos().Offset), doc.clamp(node.End().Offset)
+}
+
+func (doc *document) clamp(offset int) int {
+	if offset < 0 {
+		return 0
+	} else if offset > len(doc.text) {
+		return len(doc.text)
+	}
+	return offset
+}
+
+// findName returns the offset of the identifier name after the offset from, or from if
+// it is not found.
+func (doc *document) findName(from int, name string) int {
+	text := doc.text
+	for i := from; i < len(text); {
+		if !isIdentStart(text[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(text) && isIdentChar(text[j]) {
+			j++
+		}
+		if string(text[i:j]) == name {
+			return i
+		}
+		i = j
+	}
+	return from
+}
+
+// inComment reports whether the offset is in a comment.
+func (doc *document) inComment(offset int) bool {
+	for _, c := range doc.comments {
+		if c.Pos.Offset < offset && offset <= c.Pos.Offset+len(c.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnostics returns the syntax errors.
+func (doc *document) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+	for _, err := range doc.errs {
+		start := len(doc.text)
+		if err.Pos.Line != parse.EOF {
+			start = doc.clamp(err.Pos.Offset)
+		}
+		end := doc.clamp(start + len(err.Token))
+		if i := bytes.IndexAny(doc.text[start:end], "\r\n"); i >= 0 {
+			end = start + i
+		}
+		diags = append(diags, Diagnostic{
+			Range:    doc.rangeOf(start, end),
+			Severity: SeverityError,
+			Source:   "glua",
+			Message:  err.Message,
+		})
+	}
+	return diags
+}
+
+// applyChange returns the text of the document changed by change.
+func (doc *document) applyChange(change TextDocumentContentChangeEvent) []byte {
+	if change.Range == nil {
+		return []byte(change.Text)
+	}
+	start, end := doc.offset(change.Range.Start), doc.offset(change.Range.End)
+	if end < start {
+		start, end = end, start
+	}
+	text := make([]byte, 0, len(doc.text)-(end-start)+len(change.Text))
+	text = append(text, doc.text[:start]...)
+	text = append(text, change.Text...)
+	return append(text, doc.text[end:]...)
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
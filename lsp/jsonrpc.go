@@ -0,0 +1,117 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Error codes of JSON-RPC.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// message is a request or a notification sent by the client. ID is nil for notifications.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *responseError   `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// maxMessageLength is the maximum length of the messages, that protects the server from
+// allocating too much memory for a broken Content-Length header.
+const maxMessageLength = 64 << 20
+
+// readMessage reads a message framed by the Content-Length header.
+func readMessage(reader *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(line) != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header: %s", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:i]), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(line[i+1:])); err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid header: %s", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	if length > maxMessageLength {
+		// skips the message to read the next one
+		if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+			return nil, err
+		}
+		return nil, &responseError{Code: codeInvalidRequest, Message: fmt.Sprintf("message too large: %d bytes", length)}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &responseError{Code: codeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+// writeMessage writes v framed by the Content-Length header.
+func writeMessage(writer io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(writer, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = writer.Write(body)
+	return err
+}
@@ -0,0 +1,140 @@
+package lsp
+
+import (
+	"github.com/yuin/gopher-lua/ast"
+)
+
+// documentSymbols returns the outline of the document: the functions, and the variables and
+// the fields assigned in the main chunk.
+func (a *analysis) documentSymbols() []DocumentSymbol {
+	return a.outline(a.doc.chunk, true)
+}
+
+// outline returns the symbols defined in stmts. top is true for the statements of the main
+// chunk.
+func (a *analysis) outline(stmts []ast.Stmt, top bool) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, stmt := range stmts {
+		switch st := stmt.(type) {
+		case *ast.FuncDefStmt:
+			kind, name := SymbolKindFunction, exprPath(st.Name.Func)
+			var start, end int
+			if st.Name.Func != nil {
+				start, end = a.doc.span(st.Name.Func)
+			} else {
+				kind, name = SymbolKindMethod, exprPath(st.Name.Receiver)+":"+st.Name.Method
+				start, end = a.doc.span(st.Name.Receiver)
+				end = a.doc.findName(end, st.Name.Method) + len(st.Name.Method)
+			}
+			syms = append(syms, a.symbol(name, kind, stmt, start, end, a.outline(st.Func.Stmts, false)))
+		case *ast.LocalAssignStmt:
+			for i, name := range st.Names {
+				d := a.declAt(name, stmt)
+				if d == nil {
+					continue
+				}
+				switch value := valueAt(st.Exprs, i).(type) {
+				case *ast.FunctionExpr:
+					syms = append(syms, a.symbol(name, SymbolKindFunction, stmt, d.start, d.end, a.outline(value.Stmts, false)))
+				case *ast.TableExpr:
+					if top {
+						syms = append(syms, a.symbol(name, SymbolKindVariable, stmt, d.start, d.end, a.fieldOutline(value)))
+					}
+				default:
+					if top {
+						syms = append(syms, a.symbol(name, SymbolKindVariable, stmt, d.start, d.end, nil))
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range st.Lhs {
+				value := valueAt(st.Rhs, i)
+				_, isFunc := value.(*ast.FunctionExpr)
+				if ident, ok := lhs.(*ast.IdentExpr); ok && a.locals[ident] != nil {
+					continue
+				}
+				if _, ok := lhs.(*ast.IdentExpr); !ok && !isFunc {
+					continue
+				}
+				if !top && !isFunc {
+					continue
+				}
+				start, end := a.doc.span(lhs)
+				kind := SymbolKindVariable
+				var children []DocumentSymbol
+				switch v := value.(type) {
+				case *ast.FunctionExpr:
+					kind = SymbolKindFunction
+					children = a.outline(v.Stmts, false)
+				case *ast.TableExpr:
+					children = a.fieldOutline(v)
+				}
+				syms = append(syms, a.symbol(exprPath(lhs), kind, stmt, start, end, children))
+			}
+		case *ast.DoBlockStmt:
+			syms = append(syms, a.outline(st.Stmts, false)...)
+		case *ast.WhileStmt:
+			syms = append(syms, a.outline(st.Stmts, false)...)
+		case *ast.RepeatStmt:
+			syms = append(syms, a.outline(st.Stmts, false)...)
+		case *ast.IfStmt:
+			syms = append(syms, a.outline(st.Then, false)...)
+			syms = append(syms, a.outline(st.Else, false)...)
+		case *ast.NumberForStmt:
+			syms = append(syms, a.outline(st.Stmts, false)...)
+		case *ast.GenericForStmt:
+			syms = append(syms, a.outline(st.Stmts, false)...)
+		}
+	}
+	return syms
+}
+
+// fieldOutline returns the symbols of the fields with names in the table constructor.
+func (a *analysis) fieldOutline(tb *ast.TableExpr) []DocumentSymbol {
+	syms := []DocumentSymbol{}
+	for _, field := range tb.Fields {
+		key, ok := field.Key.(*ast.StringExpr)
+		if !ok {
+			continue
+		}
+		start, end := a.doc.span(key)
+		switch value := field.Value.(type) {
+		case *ast.FunctionExpr:
+			syms = append(syms, a.symbol(key.Value, SymbolKindMethod, value, start, end, a.outline(value.Stmts, false)))
+		case *ast.TableExpr:
+			syms = append(syms, a.symbol(key.Value, SymbolKindField, value, start, end, a.fieldOutline(value)))
+		default:
+			syms = append(syms, a.symbol(key.Value, SymbolKindField, field.Value, start, end, nil))
+		}
+	}
+	return syms
+}
+
+// symbol returns the document symbol whose name is at the offsets start and end in node.
+func (a *analysis) symbol(name string, kind int, node ast.Element, start, end int, children []DocumentSymbol) DocumentSymbol {
+	nodeStart, nodeEnd := a.doc.span(node)
+	if start < nodeStart {
+		nodeStart = start
+	}
+	if end > nodeEnd {
+		nodeEnd = end
+	}
+	return DocumentSymbol{
+		Name:           name,
+		Kind:           kind,
+		Range:          a.doc.rangeOf(nodeStart, nodeEnd),
+		SelectionRange: a.doc.rangeOf(start, end),
+		Children:       children,
+	}
+}
+
+// declAt returns the declaration of the local variable declared by stmt.
+func (a *analysis) declAt(name string, stmt ast.Stmt) *decl {
+	start, end := a.doc.span(stmt)
+	for _, d := range a.decls {
+		if d.name == name && d.kind == declLocal && start <= d.start && d.end <= end {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,479 @@
+// Package lsp provides a language server for Lua scripts that speaks the Language Server
+// Protocol.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Config configures the server.
+type Config struct {
+	// Globals are the names of the global variables provided by the host in addition to
+	// the ones defined by the standard libraries and the stubs.
+	Globals []string
+	// Stubs are the paths of the Lua files that describe the modules provided by the host.
+	// The global variables assigned in the stubs are the modules registered by
+	// LState.RegisterModule or LState.SetGlobal, and the functions set to package.preload
+	// are the modules registered by LState.PreloadModule:
+	//
+	//   http = {}
+	//   -- Sends a GET request and returns the response body.
+	//   function http.get(url, options) end
+	//
+	//   package.preload["json"] = function()
+	//     local json = {}
+	//     function json.encode(value) end
+	//     return json
+	//   end
+	Stubs []string
+	// Path is the path to search for the modules loaded by require, like package.path.
+	// Relative paths are relative to the root of the workspace. The default is
+	// `?.lua;?/init.lua`.
+	Path string
+}
+
+// Server is a language server. The server provides the diagnostics of syntax errors,
+// the document symbols, go-to-definition, hover and completion.
+type Server struct {
+	cfg     *Config
+	env     *environment
+	modules map[string]*symbol
+	docs    map[string]*document
+	// files are the modules read from the files, keyed by the paths
+	files   map[string]*fileModule
+	loading map[string]bool
+	root    string
+	writer  io.Writer
+	// shutdown is true after the client requested to shut down
+	shutdown bool
+}
+
+type fileModule struct {
+	modTime  int64
+	analysis *analysis
+}
+
+var errExit = errors.New("exit")
+
+// NewServer returns a server. NewServer reads the stubs and returns an error if they can
+// not be read or parsed. If cfg is nil, the default configuration is used.
+func NewServer(cfg *Config) (*Server, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	s := &Server{
+		cfg:     cfg,
+		modules: map[string]*symbol{},
+		docs:    map[string]*document{},
+		files:   map[string]*fileModule{},
+		loading: map[string]bool{},
+	}
+	if wd, err := os.Getwd(); err == nil {
+		s.root = wd
+	}
+	s.env = &environment{globals: map[string]*symbol{}, module: s.module}
+	for name, sym := range standardSymbols() {
+		s.env.globals[name] = sym
+	}
+	for _, name := range cfg.Globals {
+		s.env.globals[name] = &symbol{name: name, detail: "(global) " + name}
+	}
+	stubEnv := &environment{globals: standardSymbols()}
+	for _, path := range cfg.Stubs {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		doc := newDocument(pathToURI(path), text)
+		if len(doc.errs) != 0 {
+			return nil, errors.New(strings.TrimSpace(doc.errs[0].Error()))
+		}
+		a := analyze(doc, stubEnv)
+		for name, sym := range a.globals {
+			s.env.globals[name] = sym
+		}
+		for name, sym := range a.preloads {
+			s.modules[name] = sym
+		}
+	}
+	return s, nil
+}
+
+// Serve reads the requests from reader and writes the responses to writer until the client
+// sends the exit notification. Serve returns an error if the client exits without
+// requesting to shut down, or reader is closed.
+func (s *Server) Serve(reader io.Reader, writer io.Writer) error {
+	r := bufio.NewReader(reader)
+	s.writer = writer
+	for {
+		msg, err := readMessage(r)
+		if err != nil {
+			if rerr, ok := err.(*responseError); ok {
+				s.reply(nil, nil, rerr)
+				continue
+			}
+			return err
+		}
+		if err := s.handle(msg); err != nil {
+			if err == errExit {
+				if !s.shutdown {
+					return errors.New("exited without shutdown")
+				}
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// handle handles a message and returns an error only if the server must stop.
+func (s *Server) handle(msg *message) (err error) {
+	var result interface{}
+	var rerr *responseError
+	defer func() {
+		if rcv := recover(); rcv != nil {
+			result, rerr = nil, &responseError{Code: codeInternalError, Message: fmt.Sprint(rcv)}
+		}
+		if msg.ID != nil {
+			err = s.reply(msg.ID, result, rerr)
+		}
+	}()
+	if msg.ID == nil {
+		return s.notify(msg)
+	}
+	if s.shutdown && msg.Method != "shutdown" {
+		rerr = &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+		return nil
+	}
+	switch msg.Method {
+	case "initialize":
+		var params InitializeParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = s.initialize(&params)
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = s.documentSymbol(&params)
+		}
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = s.definition(&params)
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = s.hover(&params)
+		}
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if rerr = unmarshalParams(msg, &params); rerr == nil {
+			result = s.completion(&params)
+		}
+	default:
+		rerr = &responseError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	return nil
+}
+
+func (s *Server) notify(msg *message) error {
+	switch msg.Method {
+	case "exit":
+		return errExit
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if unmarshalParams(msg, &params) == nil {
+			return s.update(params.TextDocument.URI, []byte(params.TextDocument.Text))
+		}
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if unmarshalParams(msg, &params) != nil {
+			return nil
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			text := doc.text
+			for _, change := range params.ContentChanges {
+				text = (&document{text: text, lines: lineStarts(text)}).applyChange(change)
+			}
+			return s.update(doc.uri, text)
+		}
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if unmarshalParams(msg, &params) == nil {
+			delete(s.docs, params.TextDocument.URI)
+			return s.publish(params.TextDocument.URI, []Diagnostic{})
+		}
+	}
+	return nil
+}
+
+func unmarshalParams(msg *message, params interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// reply writes the response to the request whose ID is id. id is nil if the request can not
+// be parsed.
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	if rerr != nil {
+		return writeMessage(s.writer, &errorResponse{JSONRPC: "2.0", ID: id, Error: rerr})
+	}
+	return writeMessage(s.writer, &response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) publish(uri string, diags []Diagnostic) error {
+	return writeMessage(s.writer, &notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  &PublishDiagnosticsParams{URI: uri, Diagnostics: diags},
+	})
+}
+
+func (s *Server) initialize(params *InitializeParams) interface{} {
+	if len(params.RootURI) != 0 {
+		s.root = uriToPath(params.RootURI)
+	} else if len(params.RootPath) != 0 {
+		s.root = params.RootPath
+	}
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":       1, // the full text of the documents is sent
+			"documentSymbolProvider": true,
+			"definitionProvider":     true,
+			"hoverProvider":          true,
+			"completionProvider": map[string]interface{}{
+				"triggerCharacters": []string{".", ":"},
+			},
+		},
+		"serverInfo": map[string]string{"name": "glua", "version": lua.PackageVersion},
+	}
+}
+
+/* documents {{{ */
+
+// update parses the text of the document and publishes the diagnostics.
+func (s *Server) update(uri string, text []byte) error {
+	doc := newDocument(uri, text)
+	if old, ok := s.docs[uri]; ok && doc.chunk == nil {
+		// keeps the last analysis to complete the names while the source can not be parsed
+		doc.analysis = old.analysis
+	} else {
+		doc.analysis = analyze(doc, s.env)
+	}
+	s.docs[uri] = doc
+	if path := uriToPath(uri); s.files[path] != nil {
+		delete(s.files, path)
+	}
+	return s.publish(uri, doc.diagnostics())
+}
+
+// document returns the opened document, or nil if the document is not opened.
+func (s *Server) document(uri string) *document {
+	return s.docs[uri]
+}
+
+// module returns the module loaded by `require(name)`, or nil if it is not found.
+func (s *Server) module(name string) *symbol {
+	if sym, ok := s.modules[name]; ok {
+		return sym
+	}
+	if sym, ok := s.env.globals[name]; ok && sym.kind == symTable {
+		// the modules registered by LState.RegisterModule and the standard libraries
+		return sym
+	}
+	path := s.findModule(name)
+	if len(path) == 0 || s.loading[path] {
+		return nil
+	}
+	s.loading[path] = true
+	defer delete(s.loading, path)
+	var a *analysis
+	if doc := s.document(pathToURI(path)); doc != nil {
+		a = doc.analysis
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil
+		}
+		if f, ok := s.files[path]; ok && f.modTime == info.ModTime().UnixNano() {
+			a = f.analysis
+		} else {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			a = analyze(newDocument(pathToURI(path), text), s.env)
+			s.files[path] = &fileModule{modTime: info.ModTime().UnixNano(), analysis: a}
+		}
+	}
+	sym := &symbol{name: name, kind: symTable, detail: fmt.Sprintf("module %q", name), loc: a.doc.location(0, 0)}
+	if a.exports != nil {
+		sym.fields, sym.module = a.exports.fields, a.exports.module
+		sym.doc = a.exports.doc
+	}
+	return sym
+}
+
+// findModule returns the path of the file of the module, or an empty string if it is not
+// found.
+func (s *Server) findModule(name string) string {
+	path := s.cfg.Path
+	if len(path) == 0 {
+		path = "?.lua;?/init.lua"
+	}
+	name = strings.Replace(name, ".", string(filepath.Separator), -1)
+	for _, pattern := range strings.Split(path, ";") {
+		if len(pattern) == 0 {
+			continue
+		}
+		file := filepath.FromSlash(strings.Replace(pattern, "?", name, -1))
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(s.root, file)
+		}
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return file
+		}
+	}
+	return ""
+}
+
+/* }}} */
+
+/* requests {{{ */
+
+func (s *Server) documentSymbol(params *DocumentSymbolParams) interface{} {
+	doc := s.document(params.TextDocument.URI)
+	if doc == nil || doc.chunk == nil {
+		return []DocumentSymbol{}
+	}
+	return doc.analysis.documentSymbols()
+}
+
+func (s *Server) definition(params *TextDocumentPositionParams) interface{} {
+	doc := s.document(params.TextDocument.URI)
+	if doc == nil {
+		return nil
+	}
+	sym, _, _ := doc.analysis.symbolAt(doc.offset(params.Position))
+	if sym == nil || sym.loc == nil {
+		return nil
+	}
+	return sym.loc
+}
+
+func (s *Server) hover(params *TextDocumentPositionParams) interface{} {
+	doc := s.document(params.TextDocument.URI)
+	if doc == nil {
+		return nil
+	}
+	sym, start, end := doc.analysis.symbolAt(doc.offset(params.Position))
+	if sym == nil {
+		return nil
+	}
+	rng := doc.rangeOf(start, end)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: sym.markdown()}, Range: &rng}
+}
+
+var keywords = []string{
+	"and", "break", "do", "else", "elseif", "end", "false", "for", "function", "goto", "if",
+	"in", "local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while",
+}
+
+func (s *Server) completion(params *TextDocumentPositionParams) interface{} {
+	list := &CompletionList{Items: []CompletionItem{}}
+	doc := s.document(params.TextDocument.URI)
+	if doc == nil {
+		return list
+	}
+	offset := doc.offset(params.Position)
+	if doc.inComment(offset) {
+		return list
+	}
+	a := doc.analysis
+	text := doc.text
+	start := offset
+	for start > 0 && isIdentChar(text[start-1]) {
+		start--
+	}
+	prefix := string(text[start:offset])
+	if path, sep, ok := completionPath(text, start); ok {
+		sym := a.lookupAt(path[0], offset)
+		for _, name := range path[1:] {
+			sym = s.env.field(sym, name)
+		}
+		for _, field := range s.env.fields(sym) {
+			if strings.HasPrefix(field.name, prefix) && (sep != ':' || field.kind == symFunction) {
+				list.Items = append(list.Items, field.completionItem(sep == ':'))
+			}
+		}
+		return list
+	}
+	if start > 0 && (text[start-1] == '.' || text[start-1] == ':') {
+		// a field of an unknown value
+		return list
+	}
+
+	seen := map[string]bool{}
+	add := func(sym *symbol) {
+		if !seen[sym.name] && strings.HasPrefix(sym.name, prefix) {
+			seen[sym.name] = true
+			list.Items = append(list.Items, sym.completionItem(false))
+		}
+	}
+	for _, d := range a.visible(offset) {
+		add(d.sym)
+	}
+	for _, sym := range sortSymbols(a.globals) {
+		add(sym)
+	}
+	for _, sym := range sortSymbols(s.env.globals) {
+		add(sym)
+	}
+	for _, kw := range keywords {
+		if !seen[kw] && strings.HasPrefix(kw, prefix) {
+			list.Items = append(list.Items, CompletionItem{Label: kw, Kind: CompletionKindKeyword})
+		}
+	}
+	return list
+}
+
+// completionPath returns the names like `a`, `b` of `a.b.` before the offset start and the
+// separator before start.
+func completionPath(text []byte, start int) ([]string, byte, bool) {
+	if start == 0 || (text[start-1] != '.' && text[start-1] != ':') || (start > 1 && text[start-2] == '.') {
+		return nil, 0, false
+	}
+	sep := text[start-1]
+	path := []string{}
+	for end := start - 1; ; {
+		i := end
+		for i > 0 && isIdentChar(text[i-1]) {
+			i--
+		}
+		if i == end || !isIdentStart(text[i]) {
+			return nil, 0, false
+		}
+		path = append([]string{string(text[i:end])}, path...)
+		if i < 2 || text[i-1] != '.' || text[i-2] == '.' {
+			break
+		}
+		end = i - 1
+	}
+	return path, sep, true
+}
+
+/* }}} */
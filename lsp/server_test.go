@@ -0,0 +1,206 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testModule = `local M = {}
+
+-- Adds two numbers.
+function M.add(a, b)
+  return a + b
+end
+
+return M
+`
+
+const testStub = `http = {}
+-- Sends a GET request and returns the response body.
+function http.get(url) end
+`
+
+const testMain = `local mod = require("mod")
+local function greet(name)
+  return "hello " .. name
+end
+print(mod.add(1, 2), greet("lua"), http.get("url"))
+`
+
+// session writes the requests and the notifications framed like a client.
+type session struct {
+	bytes.Buffer
+	id int
+}
+
+func (s *session) request(method string, params interface{}) {
+	s.id++
+	writeMessage(s, map[string]interface{}{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+}
+
+func (s *session) notify(method string, params interface{}) {
+	writeMessage(s, map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+type output struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+}
+
+// readOutputs reads the messages written by the server.
+func readOutputs(t *testing.T, r io.Reader) []*output {
+	t.Helper()
+	reader := bufio.NewReader(r)
+	outputs := []*output{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return outputs
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("invalid header: %q", line)
+		}
+		reader.ReadString('\n')
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			t.Fatal(err)
+		}
+		out := &output{}
+		if err := json.Unmarshal(body, out); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out)
+	}
+}
+
+func position(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+func TestServerSession(t *testing.T) {
+	dir := t.TempDir()
+	stub := filepath.Join(dir, "stub.lua")
+	for name, text := range map[string]string{"mod.lua": testModule, "stub.lua": testStub} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := NewServer(&Config{Stubs: []string{stub}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainURI := pathToURI(filepath.Join(dir, "main.lua"))
+	modURI := pathToURI(filepath.Join(dir, "mod.lua"))
+
+	s := &session{}
+	s.request("initialize", map[string]interface{}{"rootUri": pathToURI(dir)})
+	s.notify("initialized", map[string]interface{}{})
+	s.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": mainURI, "languageId": "lua", "version": 1, "text": testMain},
+	})
+	s.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": map[string]string{"uri": mainURI}})
+	s.request("textDocument/definition", position(mainURI, 4, 11)) // add of mod.add
+	s.request("textDocument/definition", position(mainURI, 4, 22)) // greet
+	s.request("textDocument/hover", position(mainURI, 4, 41))      // get of http.get
+	s.request("textDocument/completion", position(mainURI, 4, 36)) // ht of http
+	// the names are completed while the source has an error
+	s.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": mainURI, "version": 2},
+		"contentChanges": []map[string]string{{"text": testMain + "mod."}},
+	})
+	s.request("textDocument/completion", position(mainURI, 5, 4)) // mod.
+	s.request("unknown/method", map[string]interface{}{})
+	s.request("shutdown", nil)
+	s.notify("exit", nil)
+
+	var out bytes.Buffer
+	if err := server.Serve(&s.Buffer, &out); err != nil {
+		t.Fatal(err)
+	}
+	outputs := readOutputs(t, &out)
+	results := map[int]string{}
+	diagnostics := []string{}
+	for _, o := range outputs {
+		if o.Method == "textDocument/publishDiagnostics" {
+			diagnostics = append(diagnostics, string(o.Params))
+		} else if o.Error != nil {
+			results[*o.ID] = "error: " + o.Error.Message
+		} else {
+			results[*o.ID] = string(o.Result)
+		}
+	}
+
+	contains := func(id int, what string, substrs ...string) {
+		t.Helper()
+		for _, substr := range substrs {
+			if !strings.Contains(results[id], substr) {
+				t.Errorf("%s: %q expected in %s", what, substr, results[id])
+			}
+		}
+	}
+	contains(1, "initialize", `"definitionProvider":true`, `"name":"glua"`)
+	contains(2, "documentSymbol", `"name":"mod"`, `"name":"greet"`)
+	contains(3, "definition across require", fmt.Sprintf(`"uri":%q`, modURI), `"start":{"line":3,`)
+	contains(4, "definition", fmt.Sprintf(`"uri":%q`, mainURI), `"start":{"line":1,`)
+	contains(5, "hover of a stub", "Sends a GET request and returns the response body.")
+	contains(6, "completion of a stub global", `"label":"http"`)
+	contains(7, "completion of a module", `"label":"add"`)
+	contains(8, "unknown method", "error: method not found: unknown/method")
+	if results[9] != "null" {
+		t.Errorf("shutdown: null expected, but got %s", results[9])
+	}
+
+	if len(diagnostics) != 2 {
+		t.Fatalf("2 diagnostics expected, but got %v", diagnostics)
+	}
+	if strings.Contains(diagnostics[0], `"message"`) {
+		t.Errorf("no errors expected, but got %s", diagnostics[0])
+	}
+	if !strings.Contains(diagnostics[1], `"message"`) || !strings.Contains(diagnostics[1], `"severity":1`) {
+		t.Errorf("a syntax error expected, but got %s", diagnostics[1])
+	}
+}
+
+func TestServerExitWithoutShutdown(t *testing.T) {
+	server, err := NewServer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &session{}
+	s.notify("exit", nil)
+	if err := server.Serve(&s.Buffer, io.Discard); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestReadMessageTooLarge(t *testing.T) {
+	input := fmt.Sprintf("Content-Length: %d\r\n\r\n", maxMessageLength+1)
+	input += strings.Repeat(" ", maxMessageLength+1)
+	input += "Content-Length: 2\r\n\r\n{}"
+	reader := bufio.NewReader(strings.NewReader(input))
+	_, err := readMessage(reader)
+	if rerr, ok := err.(*responseError); !ok || rerr.Code != codeInvalidRequest {
+		t.Fatalf("invalid request expected, but got %v", err)
+	}
+	// the next message is read
+	if _, err := readMessage(reader); err != nil {
+		t.Error(err)
+	}
+}
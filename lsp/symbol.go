@@ -0,0 +1,211 @@
+package lsp
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+)
+
+type symbolKind int
+
+const (
+	symVariable symbolKind = iota
+	symFunction
+	symTable
+)
+
+// symbol is a variable, a field or a module that hover, completion and go-to-definition
+// report.
+type symbol struct {
+	name string
+	kind symbolKind
+	// detail is the declaration of the symbol like `function http.get(url, options)`
+	detail string
+	doc    string
+	// loc is the location where the symbol is defined, or nil for the standard libraries
+	loc    *Location
+	fields map[string]*symbol
+	// module is the name of the module that the symbol holds, like `json` of
+	// `local json = require "json"`
+	module string
+}
+
+func (sym *symbol) setField(field *symbol) {
+	if sym.fields == nil {
+		sym.fields = map[string]*symbol{}
+	}
+	if _, ok := sym.fields[field.name]; !ok {
+		sym.fields[field.name] = field
+	}
+}
+
+// markdown returns the contents of the hover for the symbol.
+func (sym *symbol) markdown() string {
+	s := "```lua\n" + sym.detail + "\n```"
+	if len(sym.doc) != 0 {
+		s += "\n\n" + sym.doc
+	}
+	return s
+}
+
+func (sym *symbol) completionKind(method bool) int {
+	switch {
+	case sym.kind == symFunction && method:
+		return CompletionKindMethod
+	case sym.kind == symFunction:
+		return CompletionKindFunction
+	case sym.kind == symTable:
+		return CompletionKindModule
+	}
+	return CompletionKindVariable
+}
+
+func (sym *symbol) completionItem(method bool) CompletionItem {
+	item := CompletionItem{Label: sym.name, Kind: sym.completionKind(method), Detail: sym.detail}
+	if len(sym.doc) != 0 {
+		item.Documentation = &MarkupContent{Kind: "markdown", Value: sym.doc}
+	}
+	return item
+}
+
+// environment is what the sources can refer to besides their own declarations.
+type environment struct {
+	// globals are the global variables defined by the standard libraries and the host
+	globals map[string]*symbol
+	// module returns the module loaded by `require(name)`, or nil if it is not found.
+	module func(name string) *symbol
+}
+
+// field returns the field of sym, looking into the module that sym holds.
+func (env *environment) field(sym *symbol, name string) *symbol {
+	if sym == nil {
+		return nil
+	}
+	if field, ok := sym.fields[name]; ok {
+		return field
+	}
+	if m := env.moduleOf(sym); m != nil {
+		return m.fields[name]
+	}
+	return nil
+}
+
+// fields returns the fields of sym sorted by name.
+func (env *environment) fields(sym *symbol) []*symbol {
+	if sym == nil {
+		return nil
+	}
+	fields := map[string]*symbol{}
+	if m := env.moduleOf(sym); m != nil {
+		for name, field := range m.fields {
+			fields[name] = field
+		}
+	}
+	for name, field := range sym.fields {
+		fields[name] = field
+	}
+	return sortSymbols(fields)
+}
+
+func (env *environment) moduleOf(sym *symbol) *symbol {
+	if len(sym.module) == 0 || env.module == nil {
+		return nil
+	}
+	return env.module(sym.module)
+}
+
+func sortSymbols(syms map[string]*symbol) []*symbol {
+	list := make([]*symbol, 0, len(syms))
+	for _, sym := range syms {
+		list = append(list, sym)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+var stdSymbols map[string]*symbol
+var stdSymbolsOnce sync.Once
+
+// standardSymbols returns the symbols of the global variables that are defined in a new
+// LState.
+func standardSymbols() map[string]*symbol {
+	stdSymbolsOnce.Do(func() {
+		L := lua.NewState()
+		defer L.Close()
+		stdSymbols = map[string]*symbol{}
+		L.G.Global.ForEach(func(key, value lua.LValue) {
+			if name, ok := key.(lua.LString); ok {
+				sym := valueSymbol(string(name), string(name), value)
+				if tb, ok := value.(*lua.LTable); ok && name != "_G" {
+					tb.ForEach(func(k, v lua.LValue) {
+						if field, ok := k.(lua.LString); ok && !strings.HasPrefix(string(field), "__") {
+							sym.setField(valueSymbol(string(field), string(name)+"."+string(field), v))
+						}
+					})
+				}
+				stdSymbols[string(name)] = sym
+			}
+		})
+	})
+	return stdSymbols
+}
+
+// valueSymbol returns the symbol of a value defined by the standard libraries.
+func valueSymbol(name, path string, value lua.LValue) *symbol {
+	switch value.(type) {
+	case *lua.LFunction:
+		return &symbol{name: name, kind: symFunction, detail: "function " + path + "(...)"}
+	case *lua.LTable:
+		return &symbol{name: name, kind: symTable, detail: "(library) " + path}
+	}
+	return &symbol{name: name, kind: symVariable, detail: "(global) " + path}
+}
+
+// docComments finds the comments that document the statements.
+type docComments struct {
+	text []byte
+	// byEndLine are the comments keyed by the lines where they end
+	byEndLine map[int]*ast.Comment
+}
+
+func newDocComments(text []byte, comments []*ast.Comment) *docComments {
+	dc := &docComments{text: text, byEndLine: map[int]*ast.Comment{}}
+	for _, c := range comments {
+		dc.byEndLine[c.EndLine()] = c
+	}
+	return dc
+}
+
+// doc returns the text of the comments just above the line, without the comment markers.
+func (dc *docComments) doc(line int) string {
+	lines := []string{}
+	for c := dc.byEndLine[line-1]; c != nil && dc.ownLine(c); c = dc.byEndLine[c.Pos.Line-1] {
+		lines = append([]string{commentText(c.Text)}, lines...)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// ownLine reports whether no code precedes the comment in its line.
+func (dc *docComments) ownLine(c *ast.Comment) bool {
+	for i := c.Pos.Offset - 1; i >= 0 && i < len(dc.text) && dc.text[i] != '\n'; i-- {
+		if dc.text[i] != ' ' && dc.text[i] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// commentText strips the comment markers like `--` and `--[[ ]]` from the comment.
+func commentText(text string) string {
+	text = strings.TrimPrefix(text, "--")
+	if strings.HasPrefix(text, "[") {
+		if level := strings.IndexByte(text[1:], '['); level >= 0 && strings.Trim(text[1:level+1], "=") == "" {
+			closing := "]" + strings.Repeat("=", level) + "]"
+			return strings.TrimSpace(strings.TrimSuffix(text[level+2:], closing))
+		}
+	}
+	return strings.TrimSpace(strings.TrimLeft(text, "-"))
+}
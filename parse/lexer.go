@@ -46,6 +46,12 @@ func isDigit(ch int) bool {
 type Scanner struct {
 	Pos    ast.Position
 	reader *bufio.Reader
+	// comments are the comments that are not attached to a token yet
+	comments []*ast.Comment
+	// raw holds the characters read by Next while it is not nil
+	raw *bytes.Buffer
+	// offset is the number of the bytes read from the reader
+	offset int
 }
 
 func NewScanner(reader io.Reader, source string) *Scanner {
@@ -67,7 +73,11 @@ func (sc *Scanner) readNext() int {
 	ch, err := sc.reader.ReadByte()
 	if err == io.EOF {
 		return EOF
+	} else if err != nil {
+		// Parse and ParseAll return the errors of the reader
+		panic(err)
 	}
+	sc.offset++
 	return int(ch)
 }
 
@@ -79,7 +89,7 @@ func (sc *Scanner) Newline(ch int) {
 	sc.Pos.Column = 0
 	next := sc.Peek()
 	if ch == '\n' && next == '\r' || ch == '\r' && next == '\n' {
-		sc.reader.ReadByte()
+		sc.readNext()
 	}
 }
 
@@ -95,6 +105,10 @@ func (sc *Scanner) Next() int {
 	default:
 		sc.Pos.Column++
 	}
+	sc.Pos.Offset = sc.offset - 1
+	if sc.raw != nil && ch >= 0 {
+		writeChar(sc.raw, ch)
+	}
 	return ch
 }
 
@@ -102,6 +116,7 @@ func (sc *Scanner) Peek() int {
 	ch := sc.readNext()
 	if ch != EOF {
 		sc.reader.UnreadByte()
+		sc.offset--
 	}
 	return ch
 }
@@ -332,10 +347,16 @@ redo:
 			tok.Type = EOF
 		case '-':
 			if sc.Peek() == '-' {
+				comment := &ast.Comment{Pos: sc.Pos}
+				sc.raw = bytes.NewBufferString("-")
 				err = sc.skipComments(sc.Next())
+				comment.Text = strings.TrimRight(sc.raw.String(), "\n")
+				sc.raw = nil
 				if err != nil {
 					goto finally
 				}
+				sc.comments = append(sc.comments, comment)
+				lexer.Comments = append(lexer.Comments, comment)
 				goto redo
 			} else {
 				tok.Type = ch
@@ -430,6 +451,9 @@ redo:
 
 finally:
 	tok.Name = TokenName(int(tok.Type))
+	tok.End = ast.Position{Source: sc.Pos.Source, Line: sc.Pos.Line, Column: sc.Pos.Column + 1, Offset: sc.Pos.Offset + 1}
+	tok.Comments = sc.comments
+	sc.comments = nil
 	return tok, err
 }
 
@@ -441,32 +465,105 @@ type Lexer struct {
 	PNewLine      bool
 	Token         ast.Token
 	PrevTokenType int
+	// Comments are all the comments read by the lexer.
+	Comments []*ast.Comment
+
+	// blocks are the blocks and the brackets that are not closed before Token
+	blocks []openBlock
+	// recovering is true if the errors are recorded instead of panicking
+	recovering bool
+	errors     []*Error
+	// closers are the tokens that the lexer inserts at EOF to close the open blocks
+	closers  []ast.Token
+	inserted bool
+	// parser is the parser reading the tokens when recovering from errors
+	parser *yyParserImpl
+	// sinceError is the number of the tokens read after the last error
+	sinceError int
+}
+
+func newLexer(reader io.Reader, name string) *Lexer {
+	return &Lexer{scanner: NewScanner(reader, name), Token: ast.Token{Str: ""}, PrevTokenType: TNil}
 }
 
 func (lx *Lexer) Lex(lval *yySymType) int {
 	lx.PrevTokenType = lx.Token.Type
-	tok, err := lx.scanner.Scan(lx)
-	if err != nil {
-		panic(err)
-	}
+	lx.trackBlock(lx.Token)
+	tok := lx.scan()
+	lx.Token = tok
+	lx.sinceError++
 	if tok.Type < 0 {
 		return 0
 	}
 	lval.token = tok
-	lx.Token = tok
 	return int(tok.Type)
 }
 
+func (lx *Lexer) scan() ast.Token {
+	if len(lx.closers) > 0 {
+		tok := lx.closers[0]
+		lx.closers = lx.closers[1:]
+		return tok
+	}
+	for {
+		tok, err := lx.scanner.Scan(lx)
+		if err != nil {
+			serr, ok := err.(*Error)
+			if !ok || !lx.recovering {
+				panic(err)
+			}
+			lx.errors = append(lx.errors, serr)
+			lx.sinceError = 0
+			continue
+		}
+		if lx.recovering && lx.unmatched(tok) {
+			// the parser can not recover from the tokens that close the whole chunk. they
+			// are skipped, and they are always reported since they are not caused by the
+			// errors before them
+			lx.Token = tok
+			lx.errors = append(lx.errors, lx.scanner.TokenError(tok, lx.unmatchedMessage(tok)))
+			lx.sinceError = 0
+			continue
+		}
+		if tok.Type == EOF && lx.recovering && !lx.inserted && len(lx.blocks) > 0 {
+			// the parser recovers from the error of an unclosed block better if it reads the
+			// tokens closing the blocks than EOF. the parser reports the error of a block
+			// whose header is not complete, as the message depends on what it expects
+			if b := &lx.blocks[len(lx.blocks)-1]; b.await == 0 || lx.headerFailed() {
+				lx.Token = tok
+				if !lx.headerFailed() {
+					lx.fail(lx.scanner.TokenError(tok, unclosedMessage(b)))
+				}
+				lx.inserted = true
+				lx.closers = lx.closeBlocks()
+				return lx.scan()
+			}
+		}
+		return tok
+	}
+}
+
 func (lx *Lexer) Error(message string) {
-	panic(lx.scanner.Error(lx.Token.Str, message))
+	if strings.HasPrefix(message, "syntax error") {
+		message = lx.syntaxErrorMessage(message)
+	}
+	lx.fail(lx.scanner.TokenError(lx.Token, message))
+	lx.recoverAtEOF()
 }
 
 func (lx *Lexer) TokenError(tok ast.Token, message string) {
-	panic(lx.scanner.TokenError(tok, message))
+	lx.fail(lx.scanner.TokenError(tok, message))
 }
 
 func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
-	lexer := &Lexer{NewScanner(reader, name), nil, false, ast.Token{Str: ""}, TNil}
+	chunk, _, err = ParseWithComments(reader, name)
+	return
+}
+
+// ParseWithComments is like Parse, but also returns the comments in the source in the order
+// they appear.
+func ParseWithComments(reader io.Reader, name string) (chunk []ast.Stmt, comments []*ast.Comment, err error) {
+	lexer := newLexer(reader, name)
 	chunk = nil
 	defer func() {
 		if e := recover(); e != nil {
@@ -475,6 +572,7 @@ func Parse(reader io.Reader, name string) (chunk []ast.Stmt, err error) {
 	}()
 	yyParse(lexer)
 	chunk = lexer.Stmts
+	comments = lexer.Comments
 	return
 }
 
@@ -11,7 +11,7 @@ import (
 	"github.com/yuin/gopher-lua/ast"
 )
 
-//line parser.go.y:34
+//line parser.go.y:35
 type yySymType struct {
 	yys   int
 	token ast.Token
@@ -105,10 +105,15 @@ var yyToknames = [...]string{
 	"TString",
 	"'{'",
 	"'('",
+	"'}'",
+	"')'",
+	"'['",
+	"']'",
+	"'-'",
+	"'#'",
 	"'>'",
 	"'<'",
 	"'+'",
-	"'-'",
 	"'*'",
 	"'/'",
 	"'%'",
@@ -119,11 +124,6 @@ var yyToknames = [...]string{
 	"','",
 	"':'",
 	"'.'",
-	"'['",
-	"']'",
-	"'#'",
-	"')'",
-	"'}'",
 }
 
 var yyStatenames = [...]string{}
@@ -132,7 +132,13 @@ const yyEofCode = 1
 const yyErrCode = 2
 const yyInitialStackSize = 16
 
-//line parser.go.y:525
+//line parser.go.y:638
+
+// setSpan sets the positions where node starts and ends.
+func setSpan(node ast.PositionHolder, pos, end ast.Position) {
+	node.SetPos(pos)
+	node.SetEnd(end)
+}
 
 func TokenName(c int) string {
 	if c >= TAnd && c-TAnd < len(yyToknames) {
@@ -148,207 +154,239 @@ var yyExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 19,
-	48, 33,
-	49, 33,
-	-2, 70,
-	-1, 97,
-	48, 34,
-	49, 34,
-	-2, 70,
+	-1, 3,
+	1, 3,
+	7, 3,
+	8, 3,
+	9, 3,
+	23, 3,
+	-2, 0,
+	-1, 22,
+	53, 41,
+	54, 41,
+	-2, 78,
+	-1, 103,
+	53, 42,
+	54, 42,
+	-2, 78,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 616
+const yyLast = 782
 
 var yyAct = [...]uint8{
-	26, 92, 52, 25, 47, 88, 158, 58, 142, 118,
-	141, 167, 54, 69, 56, 55, 35, 139, 41, 42,
-	49, 160, 34, 67, 63, 137, 50, 64, 112, 113,
-	69, 109, 51, 48, 46, 45, 147, 85, 86, 87,
-	115, 110, 24, 95, 43, 44, 99, 96, 78, 136,
-	50, 171, 143, 103, 69, 108, 51, 84, 79, 80,
-	81, 82, 83, 89, 84, 111, 110, 41, 42, 49,
-	119, 120, 121, 122, 123, 124, 125, 126, 127, 128,
-	129, 130, 131, 132, 133, 134, 81, 82, 83, 170,
-	84, 153, 155, 33, 154, 144, 9, 138, 40, 23,
-	153, 19, 62, 22, 114, 101, 146, 149, 148, 151,
-	150, 71, 100, 152, 66, 50, 65, 61, 50, 157,
-	156, 51, 57, 64, 51, 70, 116, 106, 192, 21,
-	173, 174, 172, 76, 77, 75, 74, 78, 98, 159,
-	189, 95, 161, 97, 162, 72, 73, 79, 80, 81,
-	82, 83, 68, 84, 184, 183, 177, 169, 164, 104,
-	140, 168, 117, 53, 1, 91, 135, 175, 32, 20,
-	176, 8, 178, 60, 59, 180, 179, 3, 165, 28,
-	4, 39, 2, 187, 186, 27, 37, 0, 188, 0,
-	0, 29, 0, 191, 71, 0, 0, 0, 0, 0,
-	31, 0, 93, 30, 41, 42, 22, 0, 70, 0,
-	36, 0, 0, 0, 0, 0, 76, 77, 75, 74,
-	78, 94, 0, 38, 71, 90, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 0, 84, 0, 70, 0,
-	0, 0, 0, 163, 0, 0, 76, 77, 75, 74,
-	78, 0, 0, 0, 0, 0, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 28, 84, 39, 0, 0,
-	0, 27, 37, 145, 0, 0, 0, 29, 0, 0,
-	0, 0, 0, 0, 0, 0, 31, 0, 23, 30,
-	41, 42, 22, 28, 0, 39, 36, 0, 0, 27,
-	37, 0, 0, 0, 0, 29, 0, 0, 0, 38,
-	102, 0, 0, 0, 31, 0, 93, 30, 41, 42,
-	22, 28, 0, 39, 36, 0, 0, 27, 37, 0,
-	0, 0, 0, 29, 0, 94, 71, 38, 181, 0,
-	0, 0, 31, 0, 23, 30, 41, 42, 22, 0,
-	70, 0, 36, 0, 0, 0, 0, 0, 76, 77,
-	75, 74, 78, 71, 0, 38, 0, 0, 0, 0,
-	72, 73, 79, 80, 81, 82, 83, 70, 84, 0,
-	0, 182, 0, 0, 0, 76, 77, 75, 74, 78,
-	71, 0, 190, 0, 0, 0, 0, 72, 73, 79,
-	80, 81, 82, 83, 70, 84, 0, 0, 166, 0,
-	0, 0, 76, 77, 75, 74, 78, 71, 0, 0,
-	0, 0, 0, 0, 72, 73, 79, 80, 81, 82,
-	83, 70, 84, 0, 185, 0, 0, 0, 0, 76,
-	77, 75, 74, 78, 71, 0, 0, 0, 0, 0,
-	0, 72, 73, 79, 80, 81, 82, 83, 70, 84,
-	0, 107, 0, 0, 0, 0, 76, 77, 75, 74,
-	78, 71, 0, 105, 0, 0, 0, 0, 72, 73,
-	79, 80, 81, 82, 83, 70, 84, 0, 0, 0,
-	0, 0, 0, 76, 77, 75, 74, 78, 71, 0,
-	0, 0, 0, 0, 0, 72, 73, 79, 80, 81,
-	82, 83, 70, 84, 0, 0, 0, 0, 0, 0,
-	76, 77, 75, 74, 78, 0, 0, 0, 0, 0,
-	0, 0, 72, 73, 79, 80, 81, 82, 83, 0,
-	84, 7, 10, 0, 0, 0, 0, 14, 15, 13,
-	0, 16, 71, 0, 0, 6, 12, 0, 0, 0,
-	11, 18, 0, 0, 0, 0, 0, 0, 17, 23,
-	0, 0, 0, 22, 76, 77, 75, 74, 78, 0,
-	0, 0, 0, 5, 0, 0, 72, 73, 79, 80,
-	81, 82, 83, 0, 84, 76, 77, 75, 74, 78,
-	0, 0, 0, 0, 0, 0, 0, 72, 73, 79,
-	80, 81, 82, 83, 0, 84,
+	29, 180, 98, 55, 28, 50, 94, 63, 121, 122,
+	183, 124, 118, 75, 149, 57, 38, 60, 157, 59,
+	174, 117, 189, 37, 46, 47, 73, 69, 152, 53,
+	151, 153, 27, 75, 116, 90, 54, 173, 171, 127,
+	91, 92, 93, 44, 45, 52, 101, 95, 48, 105,
+	84, 102, 36, 53, 168, 26, 12, 109, 75, 25,
+	54, 118, 86, 51, 49, 167, 85, 87, 88, 89,
+	165, 90, 120, 123, 107, 146, 128, 129, 130, 131,
+	132, 133, 134, 135, 136, 137, 138, 139, 140, 141,
+	142, 143, 87, 88, 89, 188, 90, 165, 43, 68,
+	104, 154, 22, 148, 147, 64, 70, 44, 45, 52,
+	106, 72, 145, 156, 160, 158, 159, 163, 161, 162,
+	70, 53, 164, 166, 53, 71, 67, 125, 54, 170,
+	169, 54, 113, 209, 115, 218, 62, 194, 192, 193,
+	82, 83, 81, 80, 84, 24, 103, 215, 210, 172,
+	207, 101, 206, 175, 176, 198, 86, 197, 78, 79,
+	85, 87, 88, 89, 181, 90, 191, 192, 190, 186,
+	184, 74, 179, 178, 110, 185, 119, 187, 112, 4,
+	56, 2, 150, 195, 97, 144, 35, 196, 23, 11,
+	199, 66, 65, 201, 5, 200, 6, 3, 203, 1,
+	77, 0, 204, 0, 0, 0, 212, 0, 211, 0,
+	0, 0, 213, 214, 76, 0, 0, 0, 0, 0,
+	217, 0, 82, 83, 81, 80, 84, 0, 0, 0,
+	0, 0, 77, 0, 0, 0, 0, 0, 86, 0,
+	78, 79, 85, 87, 88, 89, 76, 90, 0, 0,
+	205, 0, 0, 0, 82, 83, 81, 80, 84, 0,
+	0, 0, 0, 0, 77, 0, 216, 0, 0, 0,
+	86, 0, 78, 79, 85, 87, 88, 89, 76, 90,
+	0, 0, 182, 0, 0, 0, 82, 83, 81, 80,
+	84, 0, 0, 0, 0, 0, 77, 0, 0, 0,
+	0, 0, 86, 0, 78, 79, 85, 87, 88, 89,
+	76, 90, 0, 208, 0, 0, 0, 0, 82, 83,
+	81, 80, 84, 0, 0, 0, 0, 0, 77, 0,
+	0, 0, 0, 0, 86, 0, 78, 79, 85, 87,
+	88, 89, 76, 90, 0, 0, 0, 0, 0, 0,
+	82, 83, 81, 80, 84, 0, 0, 0, 0, 0,
+	77, 0, 0, 0, 0, 177, 86, 0, 78, 79,
+	85, 87, 88, 89, 76, 90, 0, 0, 0, 0,
+	0, 0, 82, 83, 81, 80, 84, 0, 0, 0,
+	0, 0, 77, 0, 0, 0, 0, 155, 86, 0,
+	78, 79, 85, 87, 88, 89, 76, 90, 0, 0,
+	0, 0, 0, 0, 82, 83, 81, 80, 84, 0,
+	0, 0, 0, 0, 77, 0, 0, 126, 0, 0,
+	86, 0, 78, 79, 85, 87, 88, 89, 76, 90,
+	0, 114, 0, 0, 0, 0, 82, 83, 81, 80,
+	84, 0, 0, 0, 0, 0, 77, 0, 111, 0,
+	0, 0, 86, 0, 78, 79, 85, 87, 88, 89,
+	76, 90, 0, 0, 0, 0, 0, 0, 82, 83,
+	81, 80, 84, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 86, 0, 78, 79, 85, 87,
+	88, 89, 8, 90, 0, 10, 13, 0, 0, 0,
+	0, 17, 18, 16, 0, 19, 77, 0, 0, 9,
+	15, 0, 0, 0, 14, 21, 0, 0, 0, 0,
+	76, 0, 20, 26, 0, 0, 0, 25, 82, 83,
+	81, 80, 84, 77, 0, 0, 0, 0, 0, 0,
+	0, 0, 7, 0, 86, 0, 78, 79, 85, 87,
+	88, 89, 0, 90, 0, 82, 83, 81, 80, 84,
+	31, 0, 42, 0, 0, 0, 30, 40, 0, 0,
+	0, 86, 32, 78, 79, 85, 87, 88, 89, 0,
+	90, 34, 0, 99, 33, 44, 45, 25, 96, 202,
+	100, 0, 39, 41, 0, 0, 0, 31, 0, 42,
+	0, 0, 0, 30, 40, 0, 0, 0, 0, 32,
+	0, 0, 0, 0, 0, 0, 0, 0, 34, 0,
+	26, 33, 44, 45, 25, 31, 0, 42, 0, 39,
+	41, 30, 40, 0, 0, 0, 0, 32, 0, 0,
+	0, 0, 0, 0, 0, 0, 34, 0, 99, 33,
+	44, 45, 25, 0, 31, 100, 42, 39, 41, 0,
+	30, 40, 0, 0, 0, 0, 32, 0, 0, 0,
+	0, 0, 0, 0, 61, 34, 0, 26, 33, 44,
+	45, 25, 31, 108, 42, 0, 39, 41, 30, 40,
+	0, 0, 0, 0, 32, 0, 0, 0, 0, 0,
+	0, 0, 58, 34, 0, 26, 33, 44, 45, 25,
+	31, 0, 42, 0, 39, 41, 30, 40, 0, 0,
+	0, 0, 32, 0, 0, 0, 0, 0, 0, 0,
+	0, 34, 0, 26, 33, 44, 45, 25, 31, 0,
+	42, 0, 39, 41, 30, 40, 0, 0, 0, 0,
+	32, 0, 0, 0, 0, 0, 0, 0, 0, 34,
+	0, 26, 33, 44, 45, 25, 0, 0, 0, 0,
+	39, 41,
 }
 
 var yyPact = [...]int16{
-	-1000, -1000, 536, -5, -1000, -1000, 311, -1000, -4, -17,
-	-1000, 311, -1000, 311, 89, 84, 90, 83, 81, -1000,
-	-1000, -1000, 311, -1000, -1000, -36, 494, -1000, -1000, -1000,
-	-1000, -1000, -1000, -17, -1000, -1000, 311, 311, 311, 26,
-	-1000, -1000, 169, 311, 66, 311, 79, -1000, 72, 255,
-	-1000, -1000, 150, -1000, 467, 104, 440, 7, 17, 26,
-	-22, -1000, 71, -8, -1000, 94, -1000, 107, -46, 311,
-	311, 311, 311, 311, 311, 311, 311, 311, 311, 311,
-	311, 311, 311, 311, 311, 11, 11, 11, -1000, -6,
-	-1000, -39, -1000, 4, 311, 494, -36, -1000, -17, 220,
-	-1000, 32, -1000, -19, -1000, -1000, 311, -1000, 311, 311,
-	67, -1000, 61, 59, 26, 311, -1000, -1000, -1000, 494,
-	548, 569, 18, 18, 18, 18, 18, 18, 18, 44,
-	44, 11, 11, 11, 11, -49, -1000, -1000, -28, -1000,
-	283, -1000, -1000, 311, 190, -1000, -1000, -1000, 149, 494,
-	-1000, 359, 5, -1000, -1000, -1000, -1000, -36, -1000, 148,
-	58, -1000, 494, 3, -1000, 123, 311, -1000, 147, -1000,
-	-1000, 311, -1000, -1000, 311, 332, 146, -1000, 494, 145,
-	413, -1000, 311, -1000, -1000, -1000, 131, 386, -1000, -1000,
-	-1000, 119, -1000,
+	-32768, 177, -32768, 500, -32768, -20, -32768, -32768, -32768, 738,
+	-32768, -29, 8, -32768, 710, -32768, 682, 103, 93, 87,
+	92, 78, -32768, -32768, -32768, 738, -32768, -32768, -41, 512,
+	-32768, -32768, -32768, -32768, -32768, -32768, 8, -32768, -32768, 738,
+	738, 738, 10, -32768, -32768, 560, 738, 22, 738, 77,
+	-32768, 41, 654, -32768, -32768, 165, -32768, 452, 172, 109,
+	420, 113, -19, 7, 170, 10, -47, -32768, 40, -42,
+	-32768, 95, -32768, 388, 0, 738, 738, 738, 738, 738,
+	738, 738, 738, 738, 738, 738, 738, 738, 738, 738,
+	738, -16, -16, -16, -32768, 73, -32768, -24, -32768, -22,
+	738, 512, -41, -32768, 8, 356, -32768, 72, -32768, -21,
+	-32768, -32768, -32768, 738, -32768, -32768, 738, 738, 37, -32768,
+	-32768, 32, 21, 10, 738, -32768, -32768, -32768, 512, 539,
+	114, 20, 20, 20, 20, 20, 20, 20, 45, 45,
+	-16, -16, -16, -16, -1, -32768, -2, -32768, -34, -32768,
+	625, -32768, -32768, 738, 324, -32768, -32768, -32768, 164, 163,
+	512, -32768, -32768, 228, 4, -32768, 161, -32768, -32768, -32768,
+	-41, -32768, 160, -32768, 64, -32768, 512, -31, -32768, -32768,
+	159, 130, 738, -32768, -32768, 148, -32768, 146, -32768, 738,
+	-32768, -32768, 597, -32768, -32768, 196, 143, -32768, -32768, 512,
+	141, 292, 112, 139, -32768, 738, -32768, -32768, -32768, -32768,
+	-32768, 138, 260, -32768, -32768, -32768, -32768, 126, -32768,
 }
 
 var yyPgo = [...]uint8{
-	0, 163, 182, 2, 180, 178, 177, 174, 173, 171,
-	98, 7, 3, 0, 22, 93, 129, 169, 4, 168,
-	5, 166, 16, 165, 1, 160,
+	0, 199, 180, 197, 3, 196, 1, 194, 192, 191,
+	189, 98, 7, 4, 0, 23, 52, 145, 188, 5,
+	186, 6, 185, 16, 184, 2, 182,
 }
 
 var yyR1 = [...]int8{
-	0, 1, 1, 1, 2, 2, 2, 3, 4, 4,
-	4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
-	4, 4, 4, 4, 5, 5, 6, 6, 6, 7,
-	7, 8, 8, 9, 9, 10, 10, 10, 11, 11,
-	12, 12, 13, 13, 13, 13, 13, 13, 13, 13,
-	13, 13, 13, 13, 13, 13, 13, 13, 13, 13,
-	13, 13, 13, 13, 13, 13, 13, 13, 13, 14,
-	15, 15, 15, 15, 17, 16, 16, 18, 18, 18,
-	18, 19, 20, 20, 21, 21, 21, 22, 22, 23,
-	23, 23, 24, 24, 24, 25, 25,
+	0, 1, 1, 2, 2, 2, 3, 3, 3, 3,
+	4, 5, 5, 5, 5, 5, 5, 5, 5, 5,
+	5, 5, 5, 5, 5, 5, 5, 5, 5, 5,
+	5, 6, 6, 6, 7, 7, 7, 8, 8, 9,
+	9, 10, 10, 11, 11, 11, 12, 12, 13, 13,
+	14, 14, 14, 14, 14, 14, 14, 14, 14, 14,
+	14, 14, 14, 14, 14, 14, 14, 14, 14, 14,
+	14, 14, 14, 14, 14, 14, 14, 15, 16, 16,
+	16, 16, 18, 17, 17, 19, 19, 19, 19, 20,
+	21, 21, 21, 22, 22, 22, 23, 23, 24, 24,
+	24, 25, 25, 25, 26, 26,
 }
 
 var yyR2 = [...]int8{
-	0, 1, 2, 3, 0, 2, 2, 1, 3, 1,
-	3, 5, 4, 6, 8, 9, 11, 7, 3, 4,
-	4, 2, 3, 2, 0, 5, 1, 2, 1, 1,
-	3, 1, 3, 1, 3, 1, 4, 3, 1, 3,
-	1, 3, 1, 1, 1, 1, 1, 1, 1, 1,
-	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 2, 2, 2, 1,
-	1, 1, 1, 3, 3, 2, 4, 2, 3, 1,
-	1, 2, 5, 4, 1, 1, 3, 2, 3, 1,
-	3, 2, 3, 5, 1, 1, 1,
+	0, 1, 2, 1, 2, 3, 0, 2, 2, 2,
+	1, 3, 1, 3, 5, 4, 6, 8, 9, 11,
+	7, 3, 4, 4, 2, 3, 2, 5, 5, 6,
+	8, 0, 5, 5, 1, 2, 1, 1, 3, 1,
+	3, 1, 3, 1, 4, 3, 1, 3, 1, 3,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 2, 2, 2, 1, 1, 1,
+	1, 3, 3, 2, 4, 2, 3, 1, 1, 2,
+	5, 4, 5, 1, 1, 3, 2, 3, 1, 3,
+	2, 3, 5, 1, 1, 1,
 }
 
 var yyChk = [...]int16{
-	-1000, -1, -2, -6, -4, 47, 19, 5, -9, -15,
-	6, 24, 20, 13, 11, 12, 15, 32, 25, -10,
-	-17, -16, 37, 33, 47, -12, -13, 16, 10, 22,
-	34, 31, -19, -15, -14, -22, 41, 17, 54, 12,
-	-10, 35, 36, 48, 49, 52, 51, -18, 50, 37,
-	-22, -14, -3, -1, -13, -3, -13, 33, -11, -7,
-	-8, 33, 12, -11, 33, 33, 33, -13, -16, 49,
-	18, 4, 38, 39, 29, 28, 26, 27, 30, 40,
-	41, 42, 43, 44, 46, -13, -13, -13, -20, 37,
-	56, -23, -24, 33, 52, -13, -12, -10, -15, -13,
-	33, 33, 55, -12, 9, 6, 23, 21, 48, 14,
-	49, -20, 50, 51, 33, 48, 32, 55, 55, -13,
-	-13, -13, -13, -13, -13, -13, -13, -13, -13, -13,
-	-13, -13, -13, -13, -13, -21, 55, 31, -11, 56,
-	-25, 49, 47, 48, -13, 53, -18, 55, -3, -13,
-	-3, -13, -12, 33, 33, 33, -20, -12, 55, -3,
-	49, -24, -13, 53, 9, -5, 49, 6, -3, 9,
-	31, 48, 9, 7, 8, -13, -3, 9, -13, -3,
-	-13, 6, 49, 9, 9, 21, -3, -13, -3, 9,
-	6, -3, 9,
+	-32768, -1, -2, -3, 2, -7, -5, 52, 2, 19,
+	5, -10, -16, 6, 24, 20, 13, 11, 12, 15,
+	32, 25, -11, -18, -17, 37, 33, 52, -13, -14,
+	16, 10, 22, 34, 31, -20, -16, -15, -23, 42,
+	17, 43, 12, -11, 35, 36, 53, 54, 40, 56,
+	-19, 55, 37, -23, -15, -4, -2, -14, 2, -4,
+	-14, 2, 33, -12, 2, -8, -9, 33, 12, -12,
+	33, 33, 33, -14, -17, 54, 18, 4, 44, 45,
+	29, 28, 26, 27, 30, 46, 42, 47, 48, 49,
+	51, -14, -14, -14, -21, 37, 38, -24, -25, 33,
+	40, -14, -13, -11, -16, -14, 33, 33, 39, -13,
+	9, 6, 6, 23, 21, 21, 53, 14, 54, 6,
+	-21, 55, 56, 33, 53, 32, 39, 39, -14, -14,
+	-14, -14, -14, -14, -14, -14, -14, -14, -14, -14,
+	-14, -14, -14, -14, -22, 39, 2, 31, -12, 38,
+	-26, 54, 52, 53, -14, 41, -19, 39, -4, -4,
+	-14, -4, -4, -14, -13, 33, -4, 33, 33, -21,
+	-13, 39, -4, 39, 54, -25, -14, 41, 9, 9,
+	-6, -6, 54, 6, 9, -4, 9, -4, 31, 53,
+	9, 7, 8, 9, 7, -14, -4, 9, 9, -14,
+	-4, -14, 2, -4, 6, 54, 9, 9, 21, 21,
+	9, -4, -14, -4, -4, 9, 6, -4, 9,
 }
 
 var yyDef = [...]int8{
-	4, -2, 1, 2, 5, 6, 26, 28, 0, 9,
-	4, 0, 4, 0, 0, 0, 0, 0, 0, -2,
-	71, 72, 0, 35, 3, 27, 40, 42, 43, 44,
-	45, 46, 47, 48, 49, 50, 0, 0, 0, 0,
-	70, 69, 0, 0, 0, 0, 0, 75, 0, 0,
-	79, 80, 0, 7, 0, 0, 0, 38, 0, 0,
-	29, 31, 0, 21, 38, 0, 23, 0, 72, 0,
+	6, -2, 1, -2, 2, 4, 7, 8, 9, 34,
+	36, 0, 12, 6, 0, 6, 0, 0, 0, 0,
+	0, 0, -2, 79, 80, 0, 43, 5, 35, 48,
+	50, 51, 52, 53, 54, 55, 56, 57, 58, 0,
+	0, 0, 0, 78, 77, 0, 0, 0, 0, 0,
+	83, 0, 0, 87, 88, 0, 10, 0, 0, 0,
+	0, 0, 46, 0, 0, 0, 37, 39, 0, 24,
+	46, 0, 26, 0, 80, 0, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 66, 67, 68, 81, 0,
-	87, 0, 89, 35, 0, 94, 8, -2, 0, 0,
-	37, 0, 77, 0, 10, 4, 0, 4, 0, 0,
-	0, 18, 0, 0, 0, 0, 22, 73, 74, 41,
-	51, 52, 53, 54, 55, 56, 57, 58, 59, 60,
-	61, 62, 63, 64, 65, 0, 4, 84, 85, 88,
-	91, 95, 96, 0, 0, 36, 76, 78, 0, 12,
-	24, 0, 0, 39, 30, 32, 19, 20, 4, 0,
-	0, 90, 92, 0, 11, 0, 0, 4, 0, 83,
-	86, 0, 13, 4, 0, 0, 0, 82, 93, 0,
-	0, 4, 0, 17, 14, 4, 0, 0, 25, 15,
-	4, 0, 16,
+	0, 74, 75, 76, 89, 0, 96, 0, 98, 43,
+	0, 103, 11, -2, 0, 0, 45, 0, 85, 0,
+	13, 6, 6, 0, 6, 6, 0, 0, 0, 6,
+	21, 0, 0, 0, 0, 25, 81, 82, 49, 59,
+	60, 61, 62, 63, 64, 65, 66, 67, 68, 69,
+	70, 71, 72, 73, 0, 6, 0, 93, 94, 97,
+	100, 104, 105, 0, 0, 44, 84, 86, 0, 0,
+	15, 31, 31, 0, 0, 47, 0, 38, 40, 22,
+	23, 6, 0, 6, 0, 99, 101, 0, 14, 27,
+	0, 0, 0, 6, 28, 0, 91, 0, 95, 0,
+	16, 6, 0, 29, 6, 0, 0, 90, 92, 102,
+	0, 0, 0, 0, 6, 0, 20, 17, 6, 6,
+	30, 0, 0, 32, 33, 18, 6, 0, 19,
 }
 
 var yyTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 54, 3, 44, 3, 3,
-	37, 55, 42, 40, 49, 41, 51, 43, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 50, 47,
-	39, 48, 38, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 43, 3, 49, 3, 3,
+	37, 39, 47, 46, 54, 42, 56, 48, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 55, 52,
+	45, 53, 44, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 52, 3, 53, 46, 3, 3, 3, 3, 3,
+	3, 40, 3, 41, 51, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 36, 3, 56,
+	3, 3, 3, 36, 3, 38,
 }
 
 var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
-	32, 33, 34, 35, 45,
+	32, 33, 34, 35, 50,
 }
 
 var yyTok3 = [...]int8{
@@ -394,7 +432,7 @@ func yyNewParser() yyParser {
 	return &yyParserImpl{}
 }
 
-const yyFlag = -1000
+const yyFlag = -32768
 
 func yyTokname(c int) string {
 	if c >= 1 && c-1 < len(yyToknames) {
@@ -694,689 +732,821 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:73
+//line parser.go.y:74
+		{
+			yyVAL.stmts = yyDollar[1].stmts
+		}
+	case 2:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line parser.go.y:79
+		{
+			yyVAL.stmts = yyDollar[1].stmts
+		}
+	case 3:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line parser.go.y:84
 		{
 			yyVAL.stmts = yyDollar[1].stmts
 			if l, ok := yylex.(*Lexer); ok {
 				l.Stmts = yyVAL.stmts
 			}
 		}
-	case 2:
+	case 4:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:79
+//line parser.go.y:90
 		{
 			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
 			if l, ok := yylex.(*Lexer); ok {
 				l.Stmts = yyVAL.stmts
 			}
 		}
-	case 3:
+	case 5:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:85
+//line parser.go.y:96
 		{
 			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
 			if l, ok := yylex.(*Lexer); ok {
 				l.Stmts = yyVAL.stmts
 			}
 		}
-	case 4:
+	case 6:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line parser.go.y:93
+//line parser.go.y:104
 		{
 			yyVAL.stmts = []ast.Stmt{}
 		}
-	case 5:
+	case 7:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:96
+//line parser.go.y:107
 		{
-			yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
+			yyVAL.stmts = yyDollar[1].stmts
+			// stat is nil if it has an error and the parser recovers from the error
+			if yyDollar[2].stmt != nil {
+				yyVAL.stmts = append(yyDollar[1].stmts, yyDollar[2].stmt)
+			}
 		}
-	case 6:
+	case 8:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:99
+//line parser.go.y:114
 		{
 			yyVAL.stmts = yyDollar[1].stmts
 		}
-	case 7:
+	case 9:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line parser.go.y:118
+		{
+			yyVAL.stmts = yyDollar[1].stmts
+		}
+	case 10:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:104
+//line parser.go.y:123
 		{
 			yyVAL.stmts = yyDollar[1].stmts
 		}
-	case 8:
+	case 11:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:109
+//line parser.go.y:128
 		{
 			yyVAL.stmt = &ast.AssignStmt{Lhs: yyDollar[1].exprlist, Rhs: yyDollar[3].exprlist}
 			yyVAL.stmt.SetLine(yyDollar[1].exprlist[0].Line())
+			setSpan(yyVAL.stmt, yyDollar[1].exprlist[0].Pos(), yyDollar[3].exprlist[len(yyDollar[3].exprlist)-1].End())
 		}
-	case 9:
+	case 12:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:114
+//line parser.go.y:134
 		{
 			if _, ok := yyDollar[1].expr.(*ast.FuncCallExpr); !ok {
-				yylex.(*Lexer).Error("parse error")
+				yylex.(*Lexer).Error("expected '=' or a function call")
 			} else {
 				yyVAL.stmt = &ast.FuncCallStmt{Expr: yyDollar[1].expr}
 				yyVAL.stmt.SetLine(yyDollar[1].expr.Line())
+				setSpan(yyVAL.stmt, yyDollar[1].expr.Pos(), yyDollar[1].expr.End())
 			}
 		}
-	case 10:
+	case 13:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:122
+//line parser.go.y:143
 		{
 			yyVAL.stmt = &ast.DoBlockStmt{Stmts: yyDollar[2].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[3].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[3].token.End)
 		}
-	case 11:
+	case 14:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:127
+//line parser.go.y:149
 		{
 			yyVAL.stmt = &ast.WhileStmt{Condition: yyDollar[2].expr, Stmts: yyDollar[4].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[5].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[5].token.End)
 		}
-	case 12:
+	case 15:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:132
+//line parser.go.y:155
 		{
 			yyVAL.stmt = &ast.RepeatStmt{Condition: yyDollar[4].expr, Stmts: yyDollar[2].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[4].expr.Line())
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[4].expr.End())
 		}
-	case 13:
+	case 16:
 		yyDollar = yyS[yypt-6 : yypt+1]
-//line parser.go.y:137
+//line parser.go.y:161
 		{
 			yyVAL.stmt = &ast.IfStmt{Condition: yyDollar[2].expr, Then: yyDollar[4].stmts}
 			cur := yyVAL.stmt
 			for _, elseif := range yyDollar[5].stmts {
 				cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
 				cur = elseif
+				elseif.SetEnd(yyDollar[6].token.End)
 			}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[6].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[6].token.End)
 		}
-	case 14:
+	case 17:
 		yyDollar = yyS[yypt-8 : yypt+1]
-//line parser.go.y:147
+//line parser.go.y:173
 		{
 			yyVAL.stmt = &ast.IfStmt{Condition: yyDollar[2].expr, Then: yyDollar[4].stmts}
 			cur := yyVAL.stmt
 			for _, elseif := range yyDollar[5].stmts {
 				cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
 				cur = elseif
+				elseif.SetEnd(yyDollar[8].token.End)
 			}
 			cur.(*ast.IfStmt).Else = yyDollar[7].stmts
+			cur.(*ast.IfStmt).ElseLine = yyDollar[6].token.Pos.Line
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[8].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[8].token.End)
 		}
-	case 15:
+	case 18:
 		yyDollar = yyS[yypt-9 : yypt+1]
-//line parser.go.y:158
+//line parser.go.y:187
 		{
 			yyVAL.stmt = &ast.NumberForStmt{Name: yyDollar[2].token.Str, Init: yyDollar[4].expr, Limit: yyDollar[6].expr, Stmts: yyDollar[8].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[9].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[9].token.End)
 		}
-	case 16:
+	case 19:
 		yyDollar = yyS[yypt-11 : yypt+1]
-//line parser.go.y:163
+//line parser.go.y:193
 		{
 			yyVAL.stmt = &ast.NumberForStmt{Name: yyDollar[2].token.Str, Init: yyDollar[4].expr, Limit: yyDollar[6].expr, Step: yyDollar[8].expr, Stmts: yyDollar[10].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[11].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[11].token.End)
 		}
-	case 17:
+	case 20:
 		yyDollar = yyS[yypt-7 : yypt+1]
-//line parser.go.y:168
+//line parser.go.y:199
 		{
 			yyVAL.stmt = &ast.GenericForStmt{Names: yyDollar[2].namelist, Exprs: yyDollar[4].exprlist, Stmts: yyDollar[6].stmts}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[7].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[7].token.End)
 		}
-	case 18:
+	case 21:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:173
+//line parser.go.y:205
 		{
 			yyVAL.stmt = &ast.FuncDefStmt{Name: yyDollar[2].funcname, Func: yyDollar[3].funcexpr}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[3].funcexpr.LastLine())
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[3].funcexpr.End())
 		}
-	case 19:
+	case 22:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:178
+//line parser.go.y:211
 		{
 			yyVAL.stmt = &ast.LocalAssignStmt{Names: []string{yyDollar[3].token.Str}, Exprs: []ast.Expr{yyDollar[4].funcexpr}}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.stmt.SetLastLine(yyDollar[4].funcexpr.LastLine())
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[4].funcexpr.End())
 		}
-	case 20:
+	case 23:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:183
+//line parser.go.y:217
 		{
 			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyDollar[2].namelist, Exprs: yyDollar[4].exprlist}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[4].exprlist[len(yyDollar[4].exprlist)-1].End())
 		}
-	case 21:
+	case 24:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:187
+//line parser.go.y:222
 		{
 			yyVAL.stmt = &ast.LocalAssignStmt{Names: yyDollar[2].namelist, Exprs: []ast.Expr{}}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[2].token.End)
 		}
-	case 22:
+	case 25:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:191
+//line parser.go.y:227
 		{
 			yyVAL.stmt = &ast.LabelStmt{Name: yyDollar[2].token.Str}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[3].token.End)
 		}
-	case 23:
+	case 26:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:195
+//line parser.go.y:232
 		{
 			yyVAL.stmt = &ast.GotoStmt{Label: yyDollar[2].token.Str}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[2].token.End)
 		}
-	case 24:
+	case 27:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line parser.go.y:238
+		{
+			yyVAL.stmt = nil
+		}
+	case 28:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line parser.go.y:241
+		{
+			yyVAL.stmt = nil
+		}
+	case 29:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line parser.go.y:244
+		{
+			yyVAL.stmt = nil
+		}
+	case 30:
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line parser.go.y:247
+		{
+			yyVAL.stmt = nil
+		}
+	case 31:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line parser.go.y:201
+//line parser.go.y:252
 		{
 			yyVAL.stmts = []ast.Stmt{}
 		}
-	case 25:
+	case 32:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:204
+//line parser.go.y:255
 		{
 			yyVAL.stmts = append(yyDollar[1].stmts, &ast.IfStmt{Condition: yyDollar[3].expr, Then: yyDollar[5].stmts})
 			yyVAL.stmts[len(yyVAL.stmts)-1].SetLine(yyDollar[2].token.Pos.Line)
+			yyVAL.stmts[len(yyVAL.stmts)-1].SetPos(yyDollar[2].token.Pos)
 		}
-	case 26:
+	case 33:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line parser.go.y:260
+		{
+			yyVAL.stmts = yyDollar[1].stmts
+		}
+	case 34:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:210
+//line parser.go.y:265
 		{
 			yyVAL.stmt = &ast.ReturnStmt{Exprs: nil}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 27:
+	case 35:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:214
+//line parser.go.y:270
 		{
 			yyVAL.stmt = &ast.ReturnStmt{Exprs: yyDollar[2].exprlist}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[2].exprlist[len(yyDollar[2].exprlist)-1].End())
 		}
-	case 28:
+	case 36:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:218
+//line parser.go.y:275
 		{
 			yyVAL.stmt = &ast.BreakStmt{}
 			yyVAL.stmt.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.stmt, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 29:
+	case 37:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:224
+//line parser.go.y:282
 		{
 			yyVAL.funcname = yyDollar[1].funcname
 		}
-	case 30:
+	case 38:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:227
+//line parser.go.y:285
 		{
 			yyVAL.funcname = &ast.FuncName{Func: nil, Receiver: yyDollar[1].funcname.Func, Method: yyDollar[3].token.Str}
 		}
-	case 31:
+	case 39:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:232
+//line parser.go.y:290
 		{
 			yyVAL.funcname = &ast.FuncName{Func: &ast.IdentExpr{Value: yyDollar[1].token.Str}}
 			yyVAL.funcname.Func.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.funcname.Func, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 32:
+	case 40:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:236
+//line parser.go.y:295
 		{
 			key := &ast.StringExpr{Value: yyDollar[3].token.Str}
 			key.SetLine(yyDollar[3].token.Pos.Line)
+			setSpan(key, yyDollar[3].token.Pos, yyDollar[3].token.End)
 			fn := &ast.AttrGetExpr{Object: yyDollar[1].funcname.Func, Key: key}
 			fn.SetLine(yyDollar[3].token.Pos.Line)
+			setSpan(fn, yyDollar[1].funcname.Func.Pos(), yyDollar[3].token.End)
 			yyVAL.funcname = &ast.FuncName{Func: fn}
 		}
-	case 33:
+	case 41:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:245
+//line parser.go.y:306
 		{
 			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
 		}
-	case 34:
+	case 42:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:248
+//line parser.go.y:309
 		{
 			yyVAL.exprlist = append(yyDollar[1].exprlist, yyDollar[3].expr)
 		}
-	case 35:
+	case 43:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:253
+//line parser.go.y:314
 		{
 			yyVAL.expr = &ast.IdentExpr{Value: yyDollar[1].token.Str}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 36:
+	case 44:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:257
+//line parser.go.y:319
 		{
 			yyVAL.expr = &ast.AttrGetExpr{Object: yyDollar[1].expr, Key: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[4].token.End)
 		}
-	case 37:
+	case 45:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:261
+//line parser.go.y:324
 		{
 			key := &ast.StringExpr{Value: yyDollar[3].token.Str}
 			key.SetLine(yyDollar[3].token.Pos.Line)
+			setSpan(key, yyDollar[3].token.Pos, yyDollar[3].token.End)
 			yyVAL.expr = &ast.AttrGetExpr{Object: yyDollar[1].expr, Key: key}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].token.End)
 		}
-	case 38:
+	case 46:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:269
+//line parser.go.y:334
 		{
 			yyVAL.namelist = []string{yyDollar[1].token.Str}
 		}
-	case 39:
+	case 47:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:272
+//line parser.go.y:337
 		{
 			yyVAL.namelist = append(yyDollar[1].namelist, yyDollar[3].token.Str)
+			// the last name is kept to know where the list ends
+			yyVAL.token = yyDollar[3].token
 		}
-	case 40:
+	case 48:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:277
+//line parser.go.y:344
 		{
 			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
 		}
-	case 41:
+	case 49:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:280
+//line parser.go.y:347
 		{
 			yyVAL.exprlist = append(yyDollar[1].exprlist, yyDollar[3].expr)
 		}
-	case 42:
+	case 50:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:285
+//line parser.go.y:352
 		{
 			yyVAL.expr = &ast.NilExpr{}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 43:
+	case 51:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:289
+//line parser.go.y:357
 		{
 			yyVAL.expr = &ast.FalseExpr{}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 44:
+	case 52:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:293
+//line parser.go.y:362
 		{
 			yyVAL.expr = &ast.TrueExpr{}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 45:
+	case 53:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:297
+//line parser.go.y:367
 		{
 			yyVAL.expr = &ast.NumberExpr{Value: yyDollar[1].token.Str}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 46:
+	case 54:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:301
+//line parser.go.y:372
 		{
 			yyVAL.expr = &ast.Comma3Expr{}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 47:
+	case 55:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:305
+//line parser.go.y:377
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 48:
+	case 56:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:308
+//line parser.go.y:380
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 49:
+	case 57:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:311
+//line parser.go.y:383
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 50:
+	case 58:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:314
+//line parser.go.y:386
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 51:
+	case 59:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:317
+//line parser.go.y:389
 		{
 			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyDollar[1].expr, Operator: "or", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 52:
+	case 60:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:321
+//line parser.go.y:394
 		{
 			yyVAL.expr = &ast.LogicalOpExpr{Lhs: yyDollar[1].expr, Operator: "and", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 53:
+	case 61:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:325
+//line parser.go.y:399
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: ">", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 54:
+	case 62:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:329
+//line parser.go.y:404
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "<", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 55:
+	case 63:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:333
+//line parser.go.y:409
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: ">=", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 56:
+	case 64:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:337
+//line parser.go.y:414
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "<=", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 57:
+	case 65:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:341
+//line parser.go.y:419
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "==", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 58:
+	case 66:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:345
+//line parser.go.y:424
 		{
 			yyVAL.expr = &ast.RelationalOpExpr{Lhs: yyDollar[1].expr, Operator: "~=", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 59:
+	case 67:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:349
+//line parser.go.y:429
 		{
 			yyVAL.expr = &ast.StringConcatOpExpr{Lhs: yyDollar[1].expr, Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 60:
+	case 68:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:353
+//line parser.go.y:434
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "+", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 61:
+	case 69:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:357
+//line parser.go.y:439
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "-", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 62:
+	case 70:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:361
+//line parser.go.y:444
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "*", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 63:
+	case 71:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:365
+//line parser.go.y:449
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "/", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 64:
+	case 72:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:369
+//line parser.go.y:454
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "%", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 65:
+	case 73:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:373
+//line parser.go.y:459
 		{
 			yyVAL.expr = &ast.ArithmeticOpExpr{Lhs: yyDollar[1].expr, Operator: "^", Rhs: yyDollar[3].expr}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[3].expr.End())
 		}
-	case 66:
+	case 74:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:377
+//line parser.go.y:464
 		{
 			yyVAL.expr = &ast.UnaryMinusOpExpr{Expr: yyDollar[2].expr}
 			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[2].expr.End())
 		}
-	case 67:
+	case 75:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:381
+//line parser.go.y:469
 		{
 			yyVAL.expr = &ast.UnaryNotOpExpr{Expr: yyDollar[2].expr}
 			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[2].expr.End())
 		}
-	case 68:
+	case 76:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:385
+//line parser.go.y:474
 		{
 			yyVAL.expr = &ast.UnaryLenOpExpr{Expr: yyDollar[2].expr}
 			yyVAL.expr.SetLine(yyDollar[2].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[2].expr.End())
 		}
-	case 69:
+	case 77:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:391
+//line parser.go.y:481
 		{
 			yyVAL.expr = &ast.StringExpr{Value: yyDollar[1].token.Str}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 70:
+	case 78:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:397
+//line parser.go.y:488
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 71:
+	case 79:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:400
+//line parser.go.y:491
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 72:
+	case 80:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:403
+//line parser.go.y:494
 		{
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 73:
+	case 81:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:406
+//line parser.go.y:497
 		{
 			if ex, ok := yyDollar[2].expr.(*ast.Comma3Expr); ok {
 				ex.AdjustRet = true
 			}
 			yyVAL.expr = yyDollar[2].expr
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[3].token.End)
 		}
-	case 74:
+	case 82:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:415
+//line parser.go.y:507
 		{
 			yyDollar[2].expr.(*ast.FuncCallExpr).AdjustRet = true
 			yyVAL.expr = yyDollar[2].expr
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[3].token.End)
 		}
-	case 75:
+	case 83:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:421
+//line parser.go.y:514
 		{
 			yyVAL.expr = &ast.FuncCallExpr{Func: yyDollar[1].expr, Args: yyDollar[2].exprlist}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[2].token.End)
 		}
-	case 76:
+	case 84:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:425
+//line parser.go.y:519
 		{
 			yyVAL.expr = &ast.FuncCallExpr{Method: yyDollar[3].token.Str, Receiver: yyDollar[1].expr, Args: yyDollar[4].exprlist}
 			yyVAL.expr.SetLine(yyDollar[1].expr.Line())
+			setSpan(yyVAL.expr, yyDollar[1].expr.Pos(), yyDollar[4].token.End)
 		}
-	case 77:
+	case 85:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:431
+//line parser.go.y:526
 		{
 			if yylex.(*Lexer).PNewLine {
 				yylex.(*Lexer).TokenError(yyDollar[1].token, "ambiguous syntax (function call x new statement)")
 			}
 			yyVAL.exprlist = []ast.Expr{}
+			// the closing paren is kept to know where the arguments end
+			yyVAL.token = yyDollar[2].token
 		}
-	case 78:
+	case 86:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:437
+//line parser.go.y:534
 		{
 			if yylex.(*Lexer).PNewLine {
 				yylex.(*Lexer).TokenError(yyDollar[1].token, "ambiguous syntax (function call x new statement)")
 			}
 			yyVAL.exprlist = yyDollar[2].exprlist
+			yyVAL.token = yyDollar[3].token
 		}
-	case 79:
+	case 87:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:443
+//line parser.go.y:541
 		{
 			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
+			yyVAL.token = ast.Token{End: yyDollar[1].expr.End()}
 		}
-	case 80:
+	case 88:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:446
+//line parser.go.y:545
 		{
 			yyVAL.exprlist = []ast.Expr{yyDollar[1].expr}
+			yyVAL.token = ast.Token{End: yyDollar[1].expr.End()}
 		}
-	case 81:
+	case 89:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:451
+//line parser.go.y:551
 		{
 			yyVAL.expr = &ast.FunctionExpr{ParList: yyDollar[2].funcexpr.ParList, Stmts: yyDollar[2].funcexpr.Stmts}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.expr.SetLastLine(yyDollar[2].funcexpr.LastLine())
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[2].funcexpr.End())
 		}
-	case 82:
+	case 90:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:458
+//line parser.go.y:559
 		{
 			yyVAL.funcexpr = &ast.FunctionExpr{ParList: yyDollar[2].parlist, Stmts: yyDollar[4].stmts}
 			yyVAL.funcexpr.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.funcexpr.SetLastLine(yyDollar[5].token.Pos.Line)
+			setSpan(yyVAL.funcexpr, yyDollar[1].token.Pos, yyDollar[5].token.End)
 		}
-	case 83:
+	case 91:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.go.y:463
+//line parser.go.y:565
 		{
 			yyVAL.funcexpr = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: false, Names: []string{}}, Stmts: yyDollar[3].stmts}
 			yyVAL.funcexpr.SetLine(yyDollar[1].token.Pos.Line)
 			yyVAL.funcexpr.SetLastLine(yyDollar[4].token.Pos.Line)
+			setSpan(yyVAL.funcexpr, yyDollar[1].token.Pos, yyDollar[4].token.End)
 		}
-	case 84:
+	case 92:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line parser.go.y:572
+		{
+			yyVAL.funcexpr = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: true, Names: []string{}}, Stmts: yyDollar[4].stmts}
+			yyVAL.funcexpr.SetLine(yyDollar[1].token.Pos.Line)
+			yyVAL.funcexpr.SetLastLine(yyDollar[5].token.Pos.Line)
+			setSpan(yyVAL.funcexpr, yyDollar[1].token.Pos, yyDollar[5].token.End)
+		}
+	case 93:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:470
+//line parser.go.y:580
 		{
 			yyVAL.parlist = &ast.ParList{HasVargs: true, Names: []string{}}
 		}
-	case 85:
+	case 94:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:473
+//line parser.go.y:583
 		{
 			yyVAL.parlist = &ast.ParList{HasVargs: false, Names: []string{}}
 			yyVAL.parlist.Names = append(yyVAL.parlist.Names, yyDollar[1].namelist...)
 		}
-	case 86:
+	case 95:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:477
+//line parser.go.y:587
 		{
 			yyVAL.parlist = &ast.ParList{HasVargs: true, Names: []string{}}
 			yyVAL.parlist.Names = append(yyVAL.parlist.Names, yyDollar[1].namelist...)
 		}
-	case 87:
+	case 96:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:484
+//line parser.go.y:594
 		{
 			yyVAL.expr = &ast.TableExpr{Fields: []*ast.Field{}}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[2].token.End)
 		}
-	case 88:
+	case 97:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:488
+//line parser.go.y:599
 		{
 			yyVAL.expr = &ast.TableExpr{Fields: yyDollar[2].fieldlist}
 			yyVAL.expr.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.expr, yyDollar[1].token.Pos, yyDollar[3].token.End)
 		}
-	case 89:
+	case 98:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:495
+//line parser.go.y:607
 		{
 			yyVAL.fieldlist = []*ast.Field{yyDollar[1].field}
 		}
-	case 90:
+	case 99:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:498
+//line parser.go.y:610
 		{
 			yyVAL.fieldlist = append(yyDollar[1].fieldlist, yyDollar[3].field)
 		}
-	case 91:
+	case 100:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.go.y:501
+//line parser.go.y:613
 		{
 			yyVAL.fieldlist = yyDollar[1].fieldlist
 		}
-	case 92:
+	case 101:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.go.y:506
+//line parser.go.y:618
 		{
 			yyVAL.field = &ast.Field{Key: &ast.StringExpr{Value: yyDollar[1].token.Str}, Value: yyDollar[3].expr}
 			yyVAL.field.Key.SetLine(yyDollar[1].token.Pos.Line)
+			setSpan(yyVAL.field.Key, yyDollar[1].token.Pos, yyDollar[1].token.End)
 		}
-	case 93:
+	case 102:
 		yyDollar = yyS[yypt-5 : yypt+1]
-//line parser.go.y:510
+//line parser.go.y:623
 		{
 			yyVAL.field = &ast.Field{Key: yyDollar[2].expr, Value: yyDollar[5].expr}
 		}
-	case 94:
+	case 103:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:513
+//line parser.go.y:626
 		{
 			yyVAL.field = &ast.Field{Value: yyDollar[1].expr}
 		}
-	case 95:
+	case 104:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:518
+//line parser.go.y:631
 		{
 			yyVAL.fieldsep = ","
 		}
-	case 96:
+	case 105:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.go.y:521
+//line parser.go.y:634
 		{
 			yyVAL.fieldsep = ";"
 		}
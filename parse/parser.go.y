@@ -5,6 +5,7 @@ import (
   "github.com/yuin/gopher-lua/ast"
 )
 %}
+%type<stmts> program
 %type<stmts> chunk
 %type<stmts> chunk1
 %type<stmts> block
@@ -55,7 +56,7 @@ import (
 %token<token> TAnd TBreak TDo TElse TElseIf TEnd TFalse TFor TFunction TIf TIn TLocal TNil TNot TOr TReturn TRepeat TThen TTrue TUntil TWhile TGoto
 
 /* Literals */
-%token<token> TEqeq TNeq TLte TGte T2Comma T3Comma T2Colon TIdent TNumber TString '{' '('
+%token<token> TEqeq TNeq TLte TGte T2Comma T3Comma T2Colon TIdent TNumber TString '{' '(' '}' ')' '[' ']' '-' '#'
 
 /* Operators */
 %left TOr
@@ -69,6 +70,16 @@ import (
 
 %%
 
+program:
+        chunk {
+            $$ = $1
+        } |
+        /* skips the rest of the source if the parser can not recover from errors in the chunk.
+           this conflicts with `chunk1 error`, that is preferred */
+        program error {
+            $$ = $1
+        }
+
 chunk: 
         chunk1 {
             $$ = $1
@@ -94,10 +105,18 @@ chunk1:
             $$ = []ast.Stmt{}
         } |
         chunk1 stat {
-            $$ = append($1, $2)
+            $$ = $1
+            // stat is nil if it has an error and the parser recovers from the error
+            if $2 != nil {
+                $$ = append($1, $2)
+            }
         } | 
         chunk1 ';' {
             $$ = $1
+        } |
+        /* skips tokens until a statement can start when recovering from errors */
+        chunk1 error {
+            $$ = $1
         }
 
 block: 
@@ -109,30 +128,35 @@ stat:
         varlist '=' exprlist {
             $$ = &ast.AssignStmt{Lhs: $1, Rhs: $3}
             $$.SetLine($1[0].Line())
+            setSpan($$, $1[0].Pos(), $3[len($3)-1].End())
         } |
         /* 'stat = functioncal' causes a reduce/reduce conflict */
         prefixexp {
             if _, ok := $1.(*ast.FuncCallExpr); !ok {
-               yylex.(*Lexer).Error("parse error")
+               yylex.(*Lexer).Error("expected '=' or a function call")
             } else {
               $$ = &ast.FuncCallStmt{Expr: $1}
               $$.SetLine($1.Line())
+              setSpan($$, $1.Pos(), $1.End())
             }
         } |
         TDo block TEnd {
             $$ = &ast.DoBlockStmt{Stmts: $2}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($3.Pos.Line)
+            setSpan($$, $1.Pos, $3.End)
         } |
         TWhile expr TDo block TEnd {
             $$ = &ast.WhileStmt{Condition: $2, Stmts: $4}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($5.Pos.Line)
+            setSpan($$, $1.Pos, $5.End)
         } |
         TRepeat block TUntil expr {
             $$ = &ast.RepeatStmt{Condition: $4, Stmts: $2}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($4.Line())
+            setSpan($$, $1.Pos, $4.End())
         } |
         TIf expr TThen block elseifs TEnd {
             $$ = &ast.IfStmt{Condition: $2, Then: $4}
@@ -140,9 +164,11 @@ stat:
             for _, elseif := range $5 {
                 cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
                 cur = elseif
+                elseif.SetEnd($6.End)
             }
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($6.Pos.Line)
+            setSpan($$, $1.Pos, $6.End)
         } |
         TIf expr TThen block elseifs TElse block TEnd {
             $$ = &ast.IfStmt{Condition: $2, Then: $4}
@@ -150,51 +176,76 @@ stat:
             for _, elseif := range $5 {
                 cur.(*ast.IfStmt).Else = []ast.Stmt{elseif}
                 cur = elseif
+                elseif.SetEnd($8.End)
             }
             cur.(*ast.IfStmt).Else = $7
+            cur.(*ast.IfStmt).ElseLine = $6.Pos.Line
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($8.Pos.Line)
+            setSpan($$, $1.Pos, $8.End)
         } |
         TFor TIdent '=' expr ',' expr TDo block TEnd {
             $$ = &ast.NumberForStmt{Name: $2.Str, Init: $4, Limit: $6, Stmts: $8}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($9.Pos.Line)
+            setSpan($$, $1.Pos, $9.End)
         } |
         TFor TIdent '=' expr ',' expr ',' expr TDo block TEnd {
             $$ = &ast.NumberForStmt{Name: $2.Str, Init: $4, Limit: $6, Step:$8, Stmts: $10}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($11.Pos.Line)
+            setSpan($$, $1.Pos, $11.End)
         } |
         TFor namelist TIn exprlist TDo block TEnd {
             $$ = &ast.GenericForStmt{Names:$2, Exprs:$4, Stmts: $6}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($7.Pos.Line)
+            setSpan($$, $1.Pos, $7.End)
         } |
         TFunction funcname funcbody {
             $$ = &ast.FuncDefStmt{Name: $2, Func: $3}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($3.LastLine())
+            setSpan($$, $1.Pos, $3.End())
         } |
         TLocal TFunction TIdent funcbody {
             $$ = &ast.LocalAssignStmt{Names:[]string{$3.Str}, Exprs: []ast.Expr{$4}}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($4.LastLine())
+            setSpan($$, $1.Pos, $4.End())
         } | 
         TLocal namelist '=' exprlist {
             $$ = &ast.LocalAssignStmt{Names: $2, Exprs:$4}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $4[len($4)-1].End())
         } |
         TLocal namelist {
             $$ = &ast.LocalAssignStmt{Names: $2, Exprs:[]ast.Expr{}}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $<token>2.End)
         } |
         T2Colon TIdent T2Colon {
             $$ = &ast.LabelStmt{Name: $2.Str}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $3.End)
         } |
         TGoto TIdent {
             $$ = &ast.GotoStmt{Label: $2.Str}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $2.End)
+        } |
+        /* skips the statements that have errors in their headers when recovering from errors */
+        TWhile error TDo block TEnd {
+            $$ = nil
+        } |
+        TFor error TDo block TEnd {
+            $$ = nil
+        } |
+        TIf error TThen block elseifs TEnd {
+            $$ = nil
+        } |
+        TIf error TThen block elseifs TElse block TEnd {
+            $$ = nil
         }
 
 elseifs: 
@@ -204,20 +255,27 @@ elseifs:
         elseifs TElseIf expr TThen block {
             $$ = append($1, &ast.IfStmt{Condition: $3, Then: $5})
             $$[len($$)-1].SetLine($2.Pos.Line)
+            $$[len($$)-1].SetPos($2.Pos)
+        } |
+        elseifs TElseIf error TThen block {
+            $$ = $1
         }
 
 laststat:
         TReturn {
             $$ = &ast.ReturnStmt{Exprs:nil}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } |
         TReturn exprlist {
             $$ = &ast.ReturnStmt{Exprs:$2}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $2[len($2)-1].End())
         } |
         TBreak  {
             $$ = &ast.BreakStmt{}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         }
 
 funcname: 
@@ -232,12 +290,15 @@ funcname1:
         TIdent {
             $$ = &ast.FuncName{Func: &ast.IdentExpr{Value:$1.Str}}
             $$.Func.SetLine($1.Pos.Line)
+            setSpan($$.Func, $1.Pos, $1.End)
         } | 
         funcname1 '.' TIdent {
             key:= &ast.StringExpr{Value:$3.Str}
             key.SetLine($3.Pos.Line)
+            setSpan(key, $3.Pos, $3.End)
             fn := &ast.AttrGetExpr{Object: $1.Func, Key: key}
             fn.SetLine($3.Pos.Line)
+            setSpan(fn, $1.Func.Pos(), $3.End)
             $$ = &ast.FuncName{Func: fn}
         }
 
@@ -253,16 +314,20 @@ var:
         TIdent {
             $$ = &ast.IdentExpr{Value:$1.Str}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } |
         prefixexp '[' expr ']' {
             $$ = &ast.AttrGetExpr{Object: $1, Key: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $4.End)
         } | 
         prefixexp '.' TIdent {
             key := &ast.StringExpr{Value:$3.Str}
             key.SetLine($3.Pos.Line)
+            setSpan(key, $3.Pos, $3.End)
             $$ = &ast.AttrGetExpr{Object: $1, Key: key}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End)
         }
 
 namelist:
@@ -271,6 +336,8 @@ namelist:
         } | 
         namelist ','  TIdent {
             $$ = append($1, $3.Str)
+            // the last name is kept to know where the list ends
+            $<token>$ = $3
         }
 
 exprlist:
@@ -285,22 +352,27 @@ expr:
         TNil {
             $$ = &ast.NilExpr{}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } | 
         TFalse {
             $$ = &ast.FalseExpr{}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } | 
         TTrue {
             $$ = &ast.TrueExpr{}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } | 
         TNumber {
             $$ = &ast.NumberExpr{Value: $1.Str}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } | 
         T3Comma {
             $$ = &ast.Comma3Expr{}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } |
         function {
             $$ = $1
@@ -317,80 +389,99 @@ expr:
         expr TOr expr {
             $$ = &ast.LogicalOpExpr{Lhs: $1, Operator: "or", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr TAnd expr {
             $$ = &ast.LogicalOpExpr{Lhs: $1, Operator: "and", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '>' expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: ">", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '<' expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "<", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr TGte expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: ">=", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr TLte expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "<=", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr TEqeq expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "==", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr TNeq expr {
             $$ = &ast.RelationalOpExpr{Lhs: $1, Operator: "~=", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr T2Comma expr {
             $$ = &ast.StringConcatOpExpr{Lhs: $1, Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '+' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "+", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '-' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "-", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '*' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "*", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '/' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "/", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '%' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "%", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         expr '^' expr {
             $$ = &ast.ArithmeticOpExpr{Lhs: $1, Operator: "^", Rhs: $3}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $3.End())
         } |
         '-' expr %prec UNARY {
             $$ = &ast.UnaryMinusOpExpr{Expr: $2}
             $$.SetLine($2.Line())
+            setSpan($$, $1.Pos, $2.End())
         } |
         TNot expr %prec UNARY {
             $$ = &ast.UnaryNotOpExpr{Expr: $2}
             $$.SetLine($2.Line())
+            setSpan($$, $1.Pos, $2.End())
         } |
         '#' expr %prec UNARY {
             $$ = &ast.UnaryLenOpExpr{Expr: $2}
             $$.SetLine($2.Line())
+            setSpan($$, $1.Pos, $2.End())
         }
 
 string: 
         TString {
             $$ = &ast.StringExpr{Value: $1.Str}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $1.End)
         } 
 
 prefixexp:
@@ -409,22 +500,26 @@ prefixexp:
             }
             $$ = $2
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $3.End)
         }
 
 afunctioncall:
         '(' functioncall ')' {
             $2.(*ast.FuncCallExpr).AdjustRet = true
             $$ = $2
+            setSpan($$, $1.Pos, $3.End)
         }
 
 functioncall:
         prefixexp args {
             $$ = &ast.FuncCallExpr{Func: $1, Args: $2}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $<token>2.End)
         } |
         prefixexp ':' TIdent args {
             $$ = &ast.FuncCallExpr{Method: $3.Str, Receiver: $1, Args: $4}
             $$.SetLine($1.Line())
+            setSpan($$, $1.Pos(), $<token>4.End)
         }
 
 args:
@@ -433,18 +528,23 @@ args:
                yylex.(*Lexer).TokenError($1, "ambiguous syntax (function call x new statement)")
             }
             $$ = []ast.Expr{}
+            // the closing paren is kept to know where the arguments end
+            $<token>$ = $2
         } |
         '(' exprlist ')' {
             if yylex.(*Lexer).PNewLine {
                yylex.(*Lexer).TokenError($1, "ambiguous syntax (function call x new statement)")
             }
             $$ = $2
+            $<token>$ = $3
         } |
         tableconstructor {
             $$ = []ast.Expr{$1}
+            $<token>$ = ast.Token{End: $1.End()}
         } | 
         string {
             $$ = []ast.Expr{$1}
+            $<token>$ = ast.Token{End: $1.End()}
         }
 
 function:
@@ -452,6 +552,7 @@ function:
             $$ = &ast.FunctionExpr{ParList:$2.ParList, Stmts: $2.Stmts}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($2.LastLine())
+            setSpan($$, $1.Pos, $2.End())
         }
 
 funcbody:
@@ -459,11 +560,20 @@ funcbody:
             $$ = &ast.FunctionExpr{ParList: $2, Stmts: $4}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($5.Pos.Line)
+            setSpan($$, $1.Pos, $5.End)
         } | 
         '(' ')' block TEnd {
             $$ = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: false, Names: []string{}}, Stmts: $3}
             $$.SetLine($1.Pos.Line)
             $$.SetLastLine($4.Pos.Line)
+            setSpan($$, $1.Pos, $4.End)
+        } |
+        /* the parameters are dropped when recovering from errors in them */
+        '(' error ')' block TEnd {
+            $$ = &ast.FunctionExpr{ParList: &ast.ParList{HasVargs: true, Names: []string{}}, Stmts: $4}
+            $$.SetLine($1.Pos.Line)
+            $$.SetLastLine($5.Pos.Line)
+            setSpan($$, $1.Pos, $5.End)
         }
 
 parlist:
@@ -484,10 +594,12 @@ tableconstructor:
         '{' '}' {
             $$ = &ast.TableExpr{Fields: []*ast.Field{}}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $2.End)
         } |
         '{' fieldlist '}' {
             $$ = &ast.TableExpr{Fields: $2}
             $$.SetLine($1.Pos.Line)
+            setSpan($$, $1.Pos, $3.End)
         }
 
 
@@ -506,6 +618,7 @@ field:
         TIdent '=' expr {
             $$ = &ast.Field{Key: &ast.StringExpr{Value:$1.Str}, Value: $3}
             $$.Key.SetLine($1.Pos.Line)
+            setSpan($$.Key, $1.Pos, $1.End)
         } | 
         '[' expr ']' '=' expr {
             $$ = &ast.Field{Key: $2, Value: $5}
@@ -524,6 +637,12 @@ fieldsep:
 
 %%
 
+// setSpan sets the positions where node starts and ends.
+func setSpan(node ast.PositionHolder, pos, end ast.Position) {
+	node.SetPos(pos)
+	node.SetEnd(end)
+}
+
 func TokenName(c int) string {
 	if c >= TAnd && c-TAnd < len(yyToknames) {
 		if yyToknames[c-TAnd] != "" {
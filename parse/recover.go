@@ -0,0 +1,297 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+func init() {
+	// lets yyErrorMessage list the expected tokens
+	yyErrorVerbose = true
+}
+
+// openBlock is a block or a bracket that is not closed yet.
+type openBlock struct {
+	token ast.Token
+	// await is the type of the token like `do` of `while` that must come before the body
+	// of the block, or 0 if the token is already read
+	await int
+	// list is true if the header of `for` has a ',' or `in`, that a numeric `for` without
+	// the limit lacks
+	list bool
+	// failed is true if an error is reported in the header of the block
+	failed bool
+}
+
+// closer returns the type of the token that closes the block.
+func (b *openBlock) closer() int {
+	switch b.token.Type {
+	case TRepeat:
+		return TUntil
+	case '(':
+		return ')'
+	case '{':
+		return '}'
+	case '[':
+		return ']'
+	}
+	return TEnd
+}
+
+// closes reports whether tok closes the block or continues it like `else`.
+func (b *openBlock) closes(tok ast.Token) bool {
+	switch tok.Type {
+	case TElse, TElseIf:
+		return b.token.Type == TIf
+	}
+	return b.await == 0 && tok.Type == b.closer()
+}
+
+// trackBlock updates the open blocks with the token that the parser has read.
+func (lx *Lexer) trackBlock(tok ast.Token) {
+	n := len(lx.blocks)
+	switch tok.Type {
+	case TRepeat, '{', '[':
+		lx.blocks = append(lx.blocks, openBlock{token: tok})
+	case TFunction:
+		lx.blocks = append(lx.blocks, openBlock{token: tok, await: '('})
+	case '(':
+		if n > 0 && lx.blocks[n-1].await == '(' {
+			lx.blocks[n-1].await = 0
+		}
+		lx.blocks = append(lx.blocks, openBlock{token: tok})
+	case ',', TIn:
+		if n > 0 && lx.blocks[n-1].token.Type == TFor && lx.blocks[n-1].await != 0 {
+			lx.blocks[n-1].list = true
+		}
+	case TIf:
+		lx.blocks = append(lx.blocks, openBlock{token: tok, await: TThen})
+	case TWhile, TFor:
+		lx.blocks = append(lx.blocks, openBlock{token: tok, await: TDo})
+	case TElseIf:
+		if n > 0 && lx.blocks[n-1].token.Type == TIf {
+			lx.blocks[n-1].await = TThen
+		}
+	case TDo, TThen:
+		if n > 0 && lx.blocks[n-1].await == tok.Type {
+			lx.blocks[n-1].await = 0
+		} else if tok.Type == TDo {
+			lx.blocks = append(lx.blocks, openBlock{token: tok})
+		}
+	case TEnd, TUntil, ')', '}', ']':
+		if n > 0 && lx.blocks[n-1].closes(tok) {
+			lx.blocks = lx.blocks[:n-1]
+		}
+	}
+}
+
+// unmatched reports whether tok is a token like `end` that closes no open blocks, or
+// `else` that does not continue the innermost open block.
+func (lx *Lexer) unmatched(tok ast.Token) bool {
+	switch tok.Type {
+	case TElse, TElseIf:
+		n := len(lx.blocks)
+		return n == 0 || lx.blocks[n-1].token.Type != TIf
+	case TEnd, TUntil, ')', '}', ']':
+	default:
+		return false
+	}
+	for i := range lx.blocks {
+		if lx.blocks[i].closer() == tok.Type {
+			return false
+		}
+	}
+	return true
+}
+
+// unmatchedMessage returns the message for the error that tok does not close the innermost
+// open block.
+func (lx *Lexer) unmatchedMessage(tok ast.Token) string {
+	if n := len(lx.blocks); n > 0 && lx.blocks[n-1].await == 0 {
+		return unclosedMessage(&lx.blocks[n-1])
+	}
+	return fmt.Sprintf("unmatched '%s'", tok.Str)
+}
+
+// closeBlocks returns the tokens that close all the open blocks.
+func (lx *Lexer) closeBlocks() []ast.Token {
+	pos := lx.scanner.Pos
+	token := func(typ int) ast.Token {
+		return ast.Token{Type: typ, Name: TokenName(typ), Pos: pos, End: pos}
+	}
+	tokens := []ast.Token{}
+	for i := len(lx.blocks) - 1; i >= 0; i-- {
+		b := &lx.blocks[i]
+		if b.await != 0 {
+			tokens = append(tokens, token(b.await))
+		}
+		if b.await == '(' {
+			tokens = append(tokens, token(')'))
+		}
+		tokens = append(tokens, token(b.closer()))
+		if b.token.Type == TRepeat {
+			tokens = append(tokens, token(TNil))
+		}
+	}
+	return tokens
+}
+
+func tokenText(typ int) string {
+	switch typ {
+	case TEnd:
+		return "end"
+	case TUntil:
+		return "until"
+	case TDo:
+		return "do"
+	case TThen:
+		return "then"
+	}
+	return string(rune(typ))
+}
+
+// unclosedMessage returns the message for the error that the block b is not closed.
+func unclosedMessage(b *openBlock) string {
+	return fmt.Sprintf("expected '%s' to close '%s' at line %d", tokenText(b.closer()), b.token.Str, b.token.Pos.Line)
+}
+
+var tokenDescriptions = map[string]string{
+	"$end":    "<eof>",
+	"TEqeq":   "'=='",
+	"TNeq":    "'~='",
+	"TLte":    "'<='",
+	"TGte":    "'>='",
+	"T2Comma": "'..'",
+	"T3Comma": "'...'",
+	"T2Colon": "'::'",
+	"TIdent":  "name",
+	"TNumber": "number",
+	"TString": "string",
+}
+
+// describeToken returns the name of the token in Lua for the name of the token in the grammar.
+func describeToken(name string) string {
+	if desc, ok := tokenDescriptions[name]; ok {
+		return desc
+	}
+	if strings.HasPrefix(name, "T") {
+		// reserved words like TElseIf
+		return "'" + strings.ToLower(name[1:]) + "'"
+	}
+	return name
+}
+
+// precedesExpr reports whether an expression must come after the token of the type typ.
+func precedesExpr(typ int) bool {
+	switch typ {
+	case '=', ',', '[', '+', '-', '*', '/', '%', '^', '#', '<', '>', TEqeq, TNeq, TLte, TGte, T2Comma,
+		TAnd, TOr, TNot, TIf, TElseIf, TWhile, TUntil, TIn:
+		return true
+	}
+	return false
+}
+
+// syntaxErrorMessage rewrites the message like `syntax error: unexpected TEnd, expecting TThen`
+// generated by the parser.
+func (lx *Lexer) syntaxErrorMessage(message string) string {
+	if lx.unmatched(lx.Token) {
+		return lx.unmatchedMessage(lx.Token)
+	}
+	var b *openBlock
+	if n := len(lx.blocks); n > 0 {
+		b = &lx.blocks[n-1]
+		switch lx.Token.Type {
+		case EOF, TEnd, TUntil, TElse, TElseIf, ')', '}', ']':
+			if b.await == 0 && !b.closes(lx.Token) {
+				return unclosedMessage(b)
+			}
+		}
+	}
+	i := strings.Index(message, ", expecting ")
+	if i < 0 {
+		// too many tokens can continue the condition of `if` or the header of loops to be
+		// listed by the parser. if the token that must come after them is read, something
+		// before it is missing
+		if b != nil && b.await != 0 && lx.Token.Type != b.await {
+			return fmt.Sprintf("expected '%s'", tokenText(b.await))
+		}
+		if b != nil && b.token.Type == TFor && b.await != 0 && !b.list {
+			return "expected ','"
+		}
+		if precedesExpr(lx.PrevTokenType) {
+			return "expected expression"
+		}
+		if lx.Token.Type == EOF {
+			return "syntax error"
+		}
+		return "unexpected symbol"
+	}
+	expected := strings.Split(message[i+len(", expecting "):], " or ")
+	for j, name := range expected {
+		expected[j] = describeToken(name)
+	}
+	return "expected " + strings.Join(expected, " or ")
+}
+
+// headerFailed reports whether an error is reported in the header of an open block, that
+// the parser is skipping the tokens after.
+func (lx *Lexer) headerFailed() bool {
+	for i := range lx.blocks {
+		if lx.blocks[i].failed {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverAtEOF makes the parser read the tokens that close the open blocks instead of EOF,
+// that it could not recover from, after it has reported the error at EOF. It also marks the
+// innermost block if the error is in its header.
+func (lx *Lexer) recoverAtEOF() {
+	if n := len(lx.blocks); n > 0 && lx.blocks[n-1].await != 0 {
+		lx.blocks[n-1].failed = true
+	}
+	if lx.recovering && lx.Token.Type == EOF && !lx.inserted && len(lx.blocks) > 0 {
+		lx.inserted = true
+		lx.closers = lx.closeBlocks()
+		lx.parser.char = -1
+	}
+}
+
+// fail panics with err, or records err if the lexer recovers from errors.
+func (lx *Lexer) fail(err *Error) {
+	if !lx.recovering {
+		panic(err)
+	}
+	// errors at the tokens inserted by the lexer are caused by the reported unclosed blocks,
+	// and errors just after an error are likely caused by the error
+	if !lx.inserted && (len(lx.errors) == 0 || lx.sinceError >= 3) {
+		lx.errors = append(lx.errors, err)
+		lx.sinceError = 0
+	}
+}
+
+// ParseAll is like ParseWithComments, but does not stop at the first syntax error. The
+// statements that have errors are skipped, and the blocks that are not closed at the end of
+// the source are closed, so ParseAll returns the statements that it could parse along with
+// all the syntax errors.
+func ParseAll(reader io.Reader, name string) (chunk []ast.Stmt, comments []*ast.Comment, errs []*Error) {
+	lexer := newLexer(reader, name)
+	lexer.recovering = true
+	lexer.parser = yyNewParser().(*yyParserImpl)
+	defer func() {
+		// errors other than syntax errors, like the errors of reader, stop parsing
+		if e := recover(); e != nil {
+			chunk = nil
+			comments = lexer.Comments
+			errs = append(lexer.errors, lexer.scanner.Error(lexer.Token.Str, fmt.Sprint(e)))
+		}
+	}()
+	if lexer.parser.Parse(lexer) == 0 {
+		chunk = lexer.Stmts
+	}
+	return chunk, lexer.Comments, lexer.errors
+}
@@ -0,0 +1,75 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseAll(t *testing.T) {
+	cases := []struct {
+		src    string
+		nstmts int
+		errors []string
+	}{
+		{"local x = 1\nprint(x)", 2, nil},
+		{"end\nprint(1)", 1, []string{"1:1: unmatched 'end'"}},
+		{"local function f()\n  return 1\nend\nend\nprint(2)", 2, []string{"4:1: unmatched 'end'"}},
+		{"do\n  local x =\nend\nend\nprint(1)", 2, []string{"3:1: expected expression", "4:1: unmatched 'end'"}},
+		{"if x then\n  print(1)\nelse\n  function g() else end\nend\nprint(3)", 2, []string{"4:16: expected 'end' to close 'function' at line 4"}},
+		{"local t = {1, 2}}\nprint(t)", 2, []string{"1:17: unmatched '}'"}},
+		{"until x\nprint(1)", 1, []string{"1:1: unmatched 'until'"}},
+		{"local = 1\nprint(1)\nlocal y = = 2\nprint(2)", 2, []string{"1:7: expected 'function' or name", "3:11: expected expression"}},
+		{"while x do\n  print(1)\n", 1, []string{"EOF: expected 'end' to close 'while' at line 1"}},
+		{"for i=1 do end\nprint(1)", 1, []string{"1:9: expected ','"}},
+		{"while do end\nprint(1)", 1, []string{"1:7: expected expression"}},
+		{"print(1)\nrepeat until", 1, []string{"EOF: expected expression"}},
+		{"x = 1 + + 2 y = 3 z =", 1, []string{"1:9: expected expression", "EOF: expected expression"}},
+		{"print(1)\nif x\nprint(2)", 1, []string{"3:1: expected 'then'"}},
+		{"print(1)\nif x", 1, []string{"EOF: expected 'then'"}},
+		{"print(1)\nlocal function", 1, []string{"EOF: expected name"}},
+		{"print(1)\nlocal f = function", 1, []string{"EOF: expected '('"}},
+	}
+	for _, c := range cases {
+		chunk, _, errs := ParseAll(strings.NewReader(c.src), "test")
+		if len(chunk) != c.nstmts {
+			t.Errorf("%q: %d statements expected, but got %d", c.src, c.nstmts, len(chunk))
+		}
+		actual := []string{}
+		for _, err := range errs {
+			if err.Pos.Line == EOF {
+				actual = append(actual, "EOF: "+err.Message)
+			} else {
+				actual = append(actual, fmt.Sprintf("%d:%d: %s", err.Pos.Line, err.Pos.Column, err.Message))
+			}
+		}
+		if strings.Join(actual, "\n") != strings.Join(c.errors, "\n") {
+			t.Errorf("%q: errors %q expected, but got %q", c.src, c.errors, actual)
+		}
+		if len(c.errors) > 0 {
+			// Parse stops at the first error
+			if _, err := Parse(strings.NewReader(c.src), "test"); err == nil || !strings.Contains(err.Error(), errs[0].Message) {
+				t.Errorf("%q: %q expected, but got %v", c.src, errs[0].Message, err)
+			}
+		}
+	}
+}
+
+func TestParseAllReaderError(t *testing.T) {
+	newReader := func() io.Reader {
+		return io.MultiReader(strings.NewReader("local x = 1\n"), iotest.ErrReader(errors.New("read failed")))
+	}
+	if _, err := Parse(newReader(), "test"); err == nil || err.Error() != "read failed" {
+		t.Errorf("the error of the reader expected, but got %v", err)
+	}
+	chunk, _, errs := ParseAll(newReader(), "test")
+	if chunk != nil {
+		t.Errorf("no statements expected, but got %v", chunk)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "read failed") {
+		t.Errorf("the error of the reader expected, but got %v", errs)
+	}
+}
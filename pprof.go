@@ -0,0 +1,289 @@
+package lua
+
+import (
+	"compress/gzip"
+	"io"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* pprof profiles {{{ */
+
+// profileBuilder builds a profile in the profile.proto format of
+// github.com/google/pprof, whose stacks consist of Lua functions and lines.
+type profileBuilder struct {
+	// sampleTypes are the pairs of the type and the unit of the sample values
+	sampleTypes [][2]string
+	periodType  [2]string
+	period      int64
+	start       time.Time
+
+	strings     []string
+	stringIndex map[string]int64
+	functions   []profileFunction
+	// functionIDs maps *FunctionProto, or the entry pointer of a Go function, to the
+	// function id
+	functionIDs map[interface{}]uint64
+	locations   []profileLocation
+	locationIDs map[profileLocation]uint64
+	samples     []*profileSample
+	sampleIndex map[string]*profileSample
+}
+
+type profileFunction struct {
+	name, systemName, filename int64
+	startLine                  int64
+}
+
+type profileLocation struct {
+	function uint64
+	line     int
+}
+
+type profileSample struct {
+	locations []uint64
+	// values are rounded when written, so that scaled samples do not accumulate rounding
+	// errors
+	values []float64
+}
+
+func newProfileBuilder(periodType [2]string, period int64, sampleTypes ...[2]string) *profileBuilder {
+	return &profileBuilder{
+		sampleTypes: sampleTypes,
+		periodType:  periodType,
+		period:      period,
+		start:       time.Now(),
+		strings:     []string{""},
+		stringIndex: map[string]int64{"": 0},
+		functionIDs: map[interface{}]uint64{},
+		locationIDs: map[profileLocation]uint64{},
+		sampleIndex: map[string]*profileSample{},
+	}
+}
+
+func (b *profileBuilder) stringID(s string) int64 {
+	if id, ok := b.stringIndex[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIndex[s] = id
+	return id
+}
+
+// frameLocation returns the id of the location of the frame. The line of the frame is
+// the line of the instruction at pc.
+func (b *profileBuilder) frameLocation(L *LState, cf *callFrame, pc int) uint64 {
+	var key interface{}
+	line := 0
+	if cf.Fn.IsG {
+		key = reflect.ValueOf(cf.Fn.GFunction).Pointer()
+	} else {
+		proto := cf.Fn.Proto
+		key = proto
+		if pc >= len(proto.DbgSourcePositions) {
+			pc = len(proto.DbgSourcePositions) - 1
+		}
+		if pc >= 0 {
+			line = proto.DbgSourcePositions[pc]
+		}
+	}
+	fid, ok := b.functionIDs[key]
+	if !ok {
+		fn := profileFunction{name: b.stringID(profileFuncName(L, cf))}
+		if cf.Fn.IsG {
+			if f := runtime.FuncForPC(key.(uintptr)); f != nil {
+				fn.systemName = b.stringID(f.Name())
+			}
+		} else {
+			fn.systemName = fn.name
+			fn.filename = b.stringID(cf.Fn.Proto.SourceName)
+			fn.startLine = int64(cf.Fn.Proto.LineDefined)
+		}
+		b.functions = append(b.functions, fn)
+		fid = uint64(len(b.functions))
+		b.functionIDs[key] = fid
+	}
+	loc := profileLocation{function: fid, line: line}
+	id, ok := b.locationIDs[loc]
+	if !ok {
+		b.locations = append(b.locations, loc)
+		id = uint64(len(b.locations))
+		b.locationIDs[loc] = id
+	}
+	return id
+}
+
+// profileFuncName returns the name of the function of the frame. Functions are named by
+// the expressions that called them like in stack tracebacks, and the anonymous functions by
+// the places where they are defined like `anonymous@script.lua:10`, because pprof strips
+// names in angle brackets.
+func profileFuncName(L *LState, cf *callFrame) string {
+	if cf.Fn.IsG {
+		if cf.Parent == nil {
+			return "(anonymous)"
+		}
+		return L.rawFrameFuncName(cf)
+	}
+	proto := cf.Fn.Proto
+	if proto.LineDefined == 0 {
+		return "main chunk"
+	}
+	if cf.Parent != nil {
+		if name := L.rawFrameFuncName(cf); !strings.HasPrefix(name, "<") {
+			return name
+		}
+	}
+	return "anonymous@" + proto.SourceName + ":" + strconv.Itoa(proto.LineDefined)
+}
+
+// stack returns the locations of the frames of L from the innermost one, followed by the
+// frames of the threads that resumed L. The frames are at the instructions before their pcs,
+// that is the instructions being executed.
+func (b *profileBuilder) stack(L *LState) []uint64 {
+	locations := []uint64{}
+	for ; L != nil; L = L.Parent {
+		for cf := L.currentFrame; cf != nil; cf = cf.Parent {
+			locations = append(locations, b.frameLocation(L, cf, cf.Pc-1))
+		}
+	}
+	return locations
+}
+
+// addSample adds values to the sample of the stack.
+func (b *profileBuilder) addSample(locations []uint64, values ...float64) {
+	var key strings.Builder
+	for _, id := range locations {
+		key.WriteString(strconv.FormatUint(id, 36))
+		key.WriteByte(',')
+	}
+	s, ok := b.sampleIndex[key.String()]
+	if !ok {
+		s = &profileSample{locations: locations, values: make([]float64, len(values))}
+		b.samples = append(b.samples, s)
+		b.sampleIndex[key.String()] = s
+	}
+	for i, v := range values {
+		s.values[i] += v
+	}
+}
+
+// write writes the gzipped profile to w.
+func (b *profileBuilder) write(w io.Writer) error {
+	var pb protobuf
+	periodType := b.valueType(b.periodType)
+	for _, st := range b.sampleTypes {
+		pb.message(1, b.valueType(st))
+	}
+	for _, s := range b.samples {
+		s := s
+		pb.message(2, func(pb *protobuf) {
+			pb.packedUint64(1, s.locations)
+			values := make([]uint64, len(s.values))
+			for i, v := range s.values {
+				values[i] = uint64(math.Round(v))
+			}
+			pb.packedUint64(2, values)
+		})
+	}
+	for i, loc := range b.locations {
+		id, loc := uint64(i+1), loc
+		pb.message(4, func(pb *protobuf) {
+			pb.uint64(1, id)
+			pb.message(4, func(pb *protobuf) {
+				pb.uint64(1, loc.function)
+				pb.uint64(2, uint64(loc.line))
+			})
+		})
+	}
+	for i, fn := range b.functions {
+		id, fn := uint64(i+1), fn
+		pb.message(5, func(pb *protobuf) {
+			pb.uint64(1, id)
+			pb.uint64(2, uint64(fn.name))
+			pb.uint64(3, uint64(fn.systemName))
+			pb.uint64(4, uint64(fn.filename))
+			pb.uint64(5, uint64(fn.startLine))
+		})
+	}
+	for _, s := range b.strings {
+		pb.string(6, s)
+	}
+	pb.uint64(9, uint64(b.start.UnixNano()))
+	pb.uint64(10, uint64(time.Since(b.start).Nanoseconds()))
+	pb.message(11, periodType)
+	pb.uint64(12, uint64(b.period))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pb.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (b *profileBuilder) valueType(vt [2]string) func(*protobuf) {
+	typ, unit := b.stringID(vt[0]), b.stringID(vt[1])
+	return func(pb *protobuf) {
+		pb.uint64(1, uint64(typ))
+		pb.uint64(2, uint64(unit))
+	}
+}
+
+// protobuf encodes protocol buffers messages.
+type protobuf struct {
+	data []byte
+}
+
+func (pb *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		pb.data = append(pb.data, byte(x)|0x80)
+		x >>= 7
+	}
+	pb.data = append(pb.data, byte(x))
+}
+
+func (pb *protobuf) key(tag int, wireType uint64) {
+	pb.varint(uint64(tag)<<3 | wireType)
+}
+
+// uint64 encodes a varint field. Zero values are omitted.
+func (pb *protobuf) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	pb.key(tag, 0)
+	pb.varint(x)
+}
+
+func (pb *protobuf) packedUint64(tag int, xs []uint64) {
+	var field protobuf
+	for _, x := range xs {
+		field.varint(x)
+	}
+	pb.bytes(tag, field.data)
+}
+
+// string encodes a string field. Empty strings are not omitted, for the string table.
+func (pb *protobuf) string(tag int, s string) {
+	pb.key(tag, 2)
+	pb.varint(uint64(len(s)))
+	pb.data = append(pb.data, s...)
+}
+
+func (pb *protobuf) bytes(tag int, b []byte) {
+	pb.key(tag, 2)
+	pb.varint(uint64(len(b)))
+	pb.data = append(pb.data, b...)
+}
+
+func (pb *protobuf) message(tag int, encode func(*protobuf)) {
+	var msg protobuf
+	encode(&msg)
+	pb.bytes(tag, msg.data)
+}
+
+/* }}} */
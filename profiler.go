@@ -0,0 +1,117 @@
+package lua
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Profiler {{{ */
+
+// ProfileRate is the number of the samples per second that the profiler started by
+// LState.StartProfile takes.
+const ProfileRate = 100
+
+// profiler samples the Lua call stacks of an LState and the threads created by it.
+//
+// A timer goroutine counts ticks, and the LState takes a sample of its own stack when it
+// finds a tick at the next instruction or at the return of a Go function, so that the stacks
+// are never read while they are changing. A sample is weighted by the ticks counted since
+// the previous one, which attributes the time blocked in a Go function to that function.
+type profiler struct {
+	// ticks is the number of the ticks that are not recorded yet
+	ticks  int32
+	period time.Duration
+	w      io.Writer
+	done   chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	builder *profileBuilder
+}
+
+func newProfiler(w io.Writer, period time.Duration) *profiler {
+	p := &profiler{
+		period: period,
+		w:      w,
+		done:   make(chan struct{}),
+		builder: newProfileBuilder([2]string{"time", "nanoseconds"}, int64(period),
+			[2]string{"samples", "count"}, [2]string{"time", "nanoseconds"}),
+	}
+	go p.tick()
+	return p
+}
+
+func (p *profiler) tick() {
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			atomic.AddInt32(&p.ticks, 1)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// pending reports whether a sample should be taken.
+func (p *profiler) pending() bool {
+	return atomic.LoadInt32(&p.ticks) != 0
+}
+
+// reset discards the ticks counted while L was not running.
+func (p *profiler) reset() {
+	atomic.StoreInt32(&p.ticks, 0)
+}
+
+// sample records the current stack of L.
+func (p *profiler) sample(L *LState) {
+	n := float64(atomic.SwapInt32(&p.ticks, 0))
+	if n == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.builder.addSample(p.builder.stack(L), n, n*float64(p.period))
+}
+
+func (p *profiler) stop() error {
+	close(p.done)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	return p.builder.write(p.w)
+}
+
+// StartProfile enables the profiling of the Lua functions executed by this LState and the
+// threads created by it afterwards. The Lua call stacks are sampled ProfileRate times per
+// second, and StopProfile writes them to w as a pprof profile, which can be viewed by
+// `go tool pprof`. The samples measure the elapsed time, so the time spent in blocking Go
+// functions is counted as well.
+func (ls *LState) StartProfile(w io.Writer) error {
+	if ls.profiler != nil {
+		return errors.New("profiling is already enabled")
+	}
+	ls.profiler = newProfiler(w, time.Second/ProfileRate)
+	ls.updateMainLoop()
+	return nil
+}
+
+// StopProfile stops the profiling started by StartProfile and writes the profile.
+func (ls *LState) StopProfile() error {
+	p := ls.profiler
+	if p == nil {
+		return nil
+	}
+	ls.profiler = nil
+	ls.updateMainLoop()
+	return p.stop()
+}
+
+/* }}} */
@@ -0,0 +1,41 @@
+package lua
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("wait", L.NewFunction(func(L *LState) int {
+		// waits for a tick of the profiler, so that the stack is sampled
+		for !L.profiler.pending() {
+		}
+		return 0
+	}))
+	var buf bytes.Buffer
+	errorIfNotNil(t, L.StartProfile(&buf))
+	errorIfNil(t, L.StartProfile(&buf))
+	errorIfScriptFail(t, L, `
+local function busy()
+  wait()
+end
+local co = coroutine.wrap(function()
+  busy()
+end)
+co()
+`)
+	errorIfNotNil(t, L.StopProfile())
+	errorIfNotNil(t, L.StopProfile())
+
+	zr, err := gzip.NewReader(&buf)
+	errorIfNotNil(t, err)
+	data, err := ioutil.ReadAll(zr)
+	errorIfNotNil(t, err)
+	for _, s := range []string{"samples", "nanoseconds", "wait", "busy", "anonymous@<string>:5", "main chunk", "<string>"} {
+		errorIfFalse(t, bytes.Contains(data, []byte(s)), "profile should contain %q", s)
+	}
+}
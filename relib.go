@@ -0,0 +1,287 @@
+package lua
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const lRegexpClass = "regexp*"
+
+// OpenRe opens the `re` library, a wrapper around Go's regexp(RE2) package.
+// This library is not opened by OpenLibs; use PreloadModule(ReLibName, OpenRe)
+// to make it available via require.
+func OpenRe(L *LState) int {
+	mod := L.SetFuncs(L.NewTable(), reFuncs)
+	mt := L.NewTypeMetatable(lRegexpClass)
+	mt.RawSetString("__index", mt)
+	L.SetFuncs(mt, regexpMethods)
+	mt.RawSetString("gmatch", L.NewClosure(regexpGmatch, L.NewFunction(regexpGmatchIter)))
+	L.Push(mod)
+	return 1
+}
+
+var reFuncs = map[string]LGFunction{
+	"compile": reCompile,
+	"quote":   reQuote,
+}
+
+var regexpMethods = map[string]LGFunction{
+	"__tostring": regexpToString,
+	"match":      regexpMatch,
+	"find":       regexpFind,
+	"gsub":       regexpGsub,
+	"split":      regexpSplit,
+}
+
+func checkRegexp(L *LState, n int) *regexp.Regexp {
+	ud := L.CheckUserData(n)
+	if re, ok := ud.Value.(*regexp.Regexp); ok {
+		return re
+	}
+	L.ArgError(n, "regexp expected")
+	return nil
+}
+
+func reCompile(L *LState) int {
+	re, err := regexp.Compile(L.CheckString(1))
+	if err != nil {
+		L.RaiseError(err.Error())
+	}
+	ud := L.NewUserData()
+	ud.Value = re
+	L.SetMetatable(ud, L.GetTypeMetatable(lRegexpClass))
+	L.Push(ud)
+	return 1
+}
+
+func reQuote(L *LState) int {
+	L.Push(LString(regexp.QuoteMeta(L.CheckString(1))))
+	return 1
+}
+
+func regexpToString(L *LState) int {
+	L.Push(LString(fmt.Sprintf("regexp: %s", checkRegexp(L, 1).String())))
+	return 1
+}
+
+// regexpHasNames returns true if the given regexp has at least one named group.
+func regexpHasNames(re *regexp.Regexp) bool {
+	for _, name := range re.SubexpNames() {
+		if len(name) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// regexpCapture returns the idx-th capture of a match. Groups that did not
+// participate in the match are returned as nil.
+func regexpCapture(str string, loc []int, idx int) LValue {
+	if loc[2*idx] < 0 {
+		return LNil
+	}
+	return LString(str[loc[2*idx]:loc[2*idx+1]])
+}
+
+func regexpCaptureTable(L *LState, re *regexp.Regexp, str string, loc []int) *LTable {
+	names := re.SubexpNames()
+	tb := L.CreateTable(len(names)-1, len(names)-1)
+	for i := 1; i < len(names); i++ {
+		v := regexpCapture(str, loc, i)
+		tb.RawSetInt(i, v)
+		if len(names[i]) > 0 {
+			tb.RawSetString(names[i], v)
+		}
+	}
+	return tb
+}
+
+// regexpPushCaptures pushes the captures of a match the way string.match does:
+// the whole match if the regexp has no groups, otherwise one value per group.
+// If the regexp has named groups, a single table that holds both numbered and
+// named captures is pushed instead.
+func regexpPushCaptures(L *LState, re *regexp.Regexp, str string, loc []int, whole bool) int {
+	if regexpHasNames(re) {
+		L.Push(regexpCaptureTable(L, re, str, loc))
+		return 1
+	}
+	if len(loc) == 2 {
+		if !whole {
+			return 0
+		}
+		L.Push(LString(str[loc[0]:loc[1]]))
+		return 1
+	}
+	for i := 1; i < len(loc)/2; i++ {
+		L.Push(regexpCapture(str, loc, i))
+	}
+	return len(loc)/2 - 1
+}
+
+// regexpFindAt matches the regexp against the string starting at the Lua index
+// given at n. It returns nil if there is no match.
+func regexpFindAt(L *LState, re *regexp.Regexp, str string, n int) []int {
+	init := luaIndex2StringIndex(str, L.OptInt(n, 1), true)
+	if init > len(str) {
+		return nil
+	}
+	loc := re.FindStringSubmatchIndex(str[init:])
+	for i := range loc {
+		if loc[i] >= 0 {
+			loc[i] += init
+		}
+	}
+	return loc
+}
+
+func regexpMatch(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	loc := regexpFindAt(L, re, str, 3)
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	return regexpPushCaptures(L, re, str, loc, true)
+}
+
+func regexpFind(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	loc := regexpFindAt(L, re, str, 3)
+	if loc == nil {
+		L.Push(LNil)
+		return 1
+	}
+	L.Push(LNumber(loc[0] + 1))
+	L.Push(LNumber(loc[1]))
+	return regexpPushCaptures(L, re, str, loc, false) + 2
+}
+
+type regexpMatchData struct {
+	re      *regexp.Regexp
+	str     string
+	pos     int
+	matches [][]int
+}
+
+func regexpGmatchIter(L *LState) int {
+	md := L.CheckUserData(1).Value.(*regexpMatchData)
+	idx := md.pos
+	md.pos += 1
+	if idx == len(md.matches) {
+		return 0
+	}
+	return regexpPushCaptures(L, md.re, md.str, md.matches[idx], true)
+}
+
+func regexpGmatch(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	L.Push(L.Get(UpvalueIndex(1)))
+	ud := L.NewUserData()
+	ud.Value = &regexpMatchData{re, str, 0, re.FindAllStringSubmatchIndex(str, -1)}
+	L.Push(ud)
+	return 2
+}
+
+func regexpGsub(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	L.CheckTypes(3, LTString, LTTable, LTFunction, LTNil, LTNumber)
+	repl := L.CheckAny(3)
+	limit := L.OptInt(4, -1)
+
+	matches := re.FindAllStringSubmatchIndex(str, limit)
+	if len(matches) == 0 {
+		L.Push(LString(str))
+		L.Push(LNumber(0))
+		return 2
+	}
+	switch lv := repl.(type) {
+	case LString:
+		L.Push(LString(regexpGsubStr(L, str, string(lv), matches)))
+	case *LTable:
+		L.Push(LString(regexpGsubTable(L, str, lv, matches)))
+	case *LFunction:
+		L.Push(LString(regexpGsubFunc(L, re, str, lv, matches)))
+	case LNumber:
+		L.Push(LString(regexpGsubStr(L, str, lv.String(), matches)))
+	case *LNilType:
+		L.Push(LString(str))
+	}
+	L.Push(LNumber(len(matches)))
+	return 2
+}
+
+func regexpGsubStr(L *LState, str string, repl string, matches [][]int) string {
+	infoList := make([]replaceInfo, 0, len(matches))
+	for _, loc := range matches {
+		sc := newFlagScanner('%', "", "", repl)
+		for c, eos := sc.Next(); !eos; c, eos = sc.Next() {
+			if !sc.ChangeFlag {
+				if sc.HasFlag {
+					if c >= '0' && c <= '9' {
+						idx := int(c) - 48
+						if idx == 1 && len(loc) == 2 {
+							idx = 0
+						}
+						if 2*idx >= len(loc) {
+							L.RaiseError("invalid capture index")
+						}
+						sc.AppendString(LVAsString(regexpCapture(str, loc, idx)))
+					} else {
+						sc.AppendChar('%')
+						sc.AppendChar(c)
+					}
+					sc.HasFlag = false
+				} else {
+					sc.AppendChar(c)
+				}
+			}
+		}
+		infoList = append(infoList, replaceInfo{[]int{loc[0], loc[1]}, sc.String()})
+	}
+	return strGsubDoReplace(str, infoList)
+}
+
+func regexpGsubTable(L *LState, str string, repl *LTable, matches [][]int) string {
+	infoList := make([]replaceInfo, 0, len(matches))
+	for _, loc := range matches {
+		idx := 0
+		if len(loc) > 2 { // has captures
+			idx = 1
+		}
+		value := L.GetTable(repl, regexpCapture(str, loc, idx))
+		if !LVIsFalse(value) {
+			infoList = append(infoList, replaceInfo{[]int{loc[0], loc[1]}, LVAsString(value)})
+		}
+	}
+	return strGsubDoReplace(str, infoList)
+}
+
+func regexpGsubFunc(L *LState, re *regexp.Regexp, str string, repl *LFunction, matches [][]int) string {
+	infoList := make([]replaceInfo, 0, len(matches))
+	for _, loc := range matches {
+		L.Push(repl)
+		nargs := regexpPushCaptures(L, re, str, loc, true)
+		L.Call(nargs, 1)
+		ret := L.reg.Pop()
+		if !LVIsFalse(ret) {
+			infoList = append(infoList, replaceInfo{[]int{loc[0], loc[1]}, LVAsString(ret)})
+		}
+	}
+	return strGsubDoReplace(str, infoList)
+}
+
+func regexpSplit(L *LState) int {
+	re := checkRegexp(L, 1)
+	str := L.CheckString(2)
+	parts := re.Split(str, L.OptInt(3, -1))
+	tb := L.CreateTable(len(parts), 0)
+	for i, part := range parts {
+		tb.RawSetInt(i+1, LString(part))
+	}
+	L.Push(tb)
+	return 1
+}
@@ -0,0 +1,68 @@
+package lua
+
+import (
+	"testing"
+)
+
+func newReTestState() *LState {
+	L := NewState()
+	L.PreloadModule(ReLibName, OpenRe)
+	return L
+}
+
+func TestReMatchAndFind(t *testing.T) {
+	L := newReTestState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local re = require("re")
+    local r = re.compile("(\\w+)=(\\d+)")
+    local k, v = r:match("  foo=12 bar=3")
+    assert(k == "foo" and v == "12")
+    assert(r:match("nothing here") == nil)
+    local s, e, k2, v2 = r:find("  foo=12 bar=3", 9)
+    assert(s == 10 and e == 14 and k2 == "bar" and v2 == "3")
+    assert(re.compile("a+?"):match("aaa") == "a")
+    assert(re.compile("cat|dog"):find("hotdog") == 4)
+    assert(tostring(r) == "regexp: (\\w+)=(\\d+)")
+    `)
+	errorIfScriptNotFail(t, L, `require("re").compile("(")`, "missing closing")
+}
+
+func TestReNamedCaptures(t *testing.T) {
+	L := newReTestState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local re = require("re")
+    local r = re.compile("(?P<level>[A-Z]+): (?P<msg>.*)")
+    local m = r:match("ERROR: disk full")
+    assert(m.level == "ERROR" and m.msg == "disk full")
+    assert(m[1] == "ERROR" and m[2] == "disk full")
+    local levels = {}
+    for c in r:gmatch("INFO: a\nWARN: b") do
+      levels[#levels+1] = c.level
+    end
+    assert(#levels == 2 and levels[1] == "INFO" and levels[2] == "WARN")
+    `)
+}
+
+func TestReGsubAndSplit(t *testing.T) {
+	L := newReTestState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local re = require("re")
+    local r = re.compile("(\\w+)@(\\w+)")
+    local s, n = r:gsub("a@b c@d", "%2@%1")
+    assert(s == "b@a d@c" and n == 2)
+    s, n = r:gsub("a@b c@d", "x", 1)
+    assert(s == "x c@d" and n == 1)
+    s = r:gsub("a@b c@d", {a = "A"})
+    assert(s == "A c@d")
+    s = r:gsub("a@b c@d", function(u, h) return h:upper() end)
+    assert(s == "B D")
+    s = re.compile("\\d+"):gsub("a1b22", "<%0>")
+    assert(s == "a<1>b<22>")
+    local parts = re.compile("\\s*,\\s*"):split("a , b,c")
+    assert(#parts == 3 and parts[1] == "a" and parts[3] == "c")
+    assert(re.quote("a.b") == "a\\.b")
+    `)
+}
@@ -0,0 +1,341 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Scheduler runs coroutines cooperatively on one LState. Coroutines are spawned by
+// Spawn or sched.spawn and suspended by sched.sleep, sched.wait, sched.join and Await.
+// Operations that complete on other goroutines are passed back to the goroutine
+// calling Run, so the LState is never used concurrently.
+//
+// NewScheduler makes the `sched` module available via require. Its functions are:
+//
+//	sched.spawn(fn, ...)  starts fn(...) as a new coroutine and returns the coroutine
+//	sched.sleep(secs)     suspends the running coroutine for secs seconds
+//	sched.wait(ch)        suspends the running coroutine until a value is received from
+//	                      the channel ch, returns ok, value like channel:receive
+//	sched.join(co)        suspends the running coroutine until co finishes, returns true
+//	                      and the values returned by co, or false and the error
+//
+// A coroutine that yields by coroutine.yield is resumed after the other runnable
+// coroutines.
+type Scheduler struct {
+	L *LState
+
+	tasks   map[*LState]*schedTask
+	ready   []*schedTask
+	failed  []*schedTask
+	pending int
+
+	mu     sync.Mutex
+	done   []func()
+	notify chan struct{}
+	// stop is closed when Run returns, so that the operations waiting on other goroutines
+	// give up
+	stop chan struct{}
+}
+
+// errSchedulerStopped is raised in the coroutines whose operations have been abandoned
+// because Run has returned.
+var errSchedulerStopped = errors.New("scheduler has stopped")
+
+type schedTask struct {
+	co      *LState
+	fn      *LFunction
+	args    []LValue
+	waiting bool
+	wakeErr error
+
+	finished bool
+	joined   bool
+	results  []LValue
+	err      error
+	joiners  []*schedTask
+}
+
+// NewScheduler returns a new Scheduler that runs coroutines on L.
+func NewScheduler(L *LState) *Scheduler {
+	s := &Scheduler{
+		L:      L,
+		tasks:  make(map[*LState]*schedTask),
+		notify: make(chan struct{}, 1),
+	}
+	L.preloadBoundModule(SchedLibName, s.Loader)
+	return s
+}
+
+// Loader opens the `sched` module bound to the scheduler.
+func (s *Scheduler) Loader(L *LState) int {
+	mod := L.SetFuncs(L.NewTable(), map[string]LGFunction{
+		"spawn": s.schedSpawn,
+		"sleep": s.schedSleep,
+		"wait":  s.schedWait,
+		"join":  s.schedJoin,
+	})
+	L.Push(mod)
+	return 1
+}
+
+// Spawn starts fn(args...) as a new coroutine. The coroutine runs when Run is called.
+func (s *Scheduler) Spawn(fn *LFunction, args ...LValue) *LState {
+	co, _ := s.L.NewThread()
+	t := &schedTask{co: co, fn: fn, args: args}
+	s.tasks[co] = t
+	s.ready = append(s.ready, t)
+	return co
+}
+
+// Run runs the spawned coroutines until all of them have finished. If a coroutine has
+// failed and no coroutine has joined it, Run returns the error of the coroutine.
+// Run also returns when the context of the LState is canceled.
+func (s *Scheduler) Run() error {
+	var ctxDone <-chan struct{}
+	if ctx := s.L.Context(); ctx != nil {
+		ctxDone = ctx.Done()
+	}
+	s.stop = make(chan struct{})
+	defer close(s.stop)
+	for {
+		for len(s.ready) > 0 {
+			t := s.ready[0]
+			s.ready[0] = nil
+			s.ready = s.ready[1:]
+			s.resume(t)
+		}
+		if s.pending == 0 {
+			break
+		}
+		select {
+		case <-s.notify:
+			s.mu.Lock()
+			done := s.done
+			s.done = nil
+			s.mu.Unlock()
+			for _, f := range done {
+				s.pending--
+				f()
+			}
+		case <-ctxDone:
+			return s.L.Context().Err()
+		}
+	}
+	// nothing can resume the coroutines left unfinished, they are joining each other
+	deadlocked := 0
+	for co, t := range s.tasks {
+		if !t.finished {
+			deadlocked++
+		}
+		delete(s.tasks, co)
+	}
+	for _, t := range s.failed {
+		if !t.joined {
+			s.failed = nil
+			return t.err
+		}
+	}
+	s.failed = nil
+	if deadlocked > 0 {
+		return fmt.Errorf("scheduler deadlock: %d coroutines are joining each other and can never be resumed", deadlocked)
+	}
+	return nil
+}
+
+// Await runs fn on a new goroutine and suspends the running coroutine until fn returns.
+// It must be called by a Go function running in a coroutine spawned by the scheduler,
+// as the return value of the function:
+//
+//	return s.Await(L, func() (lua.LValue, error) { ... })
+//
+// The value returned by fn is returned to Lua, and the error is raised by RaiseGoError.
+// fn must not use the LState.
+func (s *Scheduler) Await(L *LState, fn func() (LValue, error)) int {
+	t := s.checkTask(L, "Await")
+	s.start(func(complete func(func())) {
+		go func() {
+			lv, err := fn()
+			complete(func() {
+				if err != nil {
+					s.wake(t, err)
+				} else if lv == nil {
+					s.wake(t, nil, LNil)
+				} else {
+					s.wake(t, nil, lv)
+				}
+			})
+		}()
+	})
+	return s.suspend(L, t)
+}
+
+func (s *Scheduler) resume(t *schedTask) {
+	args := t.args
+	t.args = nil
+	st, err, values := s.L.Resume(t.co, t.fn, args...)
+	switch st {
+	case ResumeYield:
+		if !t.waiting {
+			// yielded by coroutine.yield
+			s.ready = append(s.ready, t)
+		}
+	case ResumeOK:
+		s.finish(t, values, nil)
+	case ResumeError:
+		s.finish(t, nil, err)
+	}
+}
+
+func (s *Scheduler) finish(t *schedTask, results []LValue, err error) {
+	t.finished = true
+	t.results = results
+	t.err = err
+	for _, joiner := range t.joiners {
+		t.joined = true
+		s.wake(joiner, nil, t.joinResults()...)
+	}
+	t.joiners = nil
+	if err != nil && !t.joined {
+		s.failed = append(s.failed, t)
+	}
+}
+
+func (t *schedTask) joinResults() []LValue {
+	if t.err != nil {
+		if aerr, ok := t.err.(*ApiError); ok {
+			return []LValue{LFalse, aerr.Object}
+		}
+		return []LValue{LFalse, LString(t.err.Error())}
+	}
+	return append([]LValue{LTrue}, t.results...)
+}
+
+// start starts an operation that completes on another goroutine. The operation must call
+// complete exactly once with a function that is called by Run.
+func (s *Scheduler) start(op func(complete func(func()))) {
+	s.pending++
+	op(func(f func()) {
+		s.mu.Lock()
+		s.done = append(s.done, f)
+		s.mu.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	})
+}
+
+func (s *Scheduler) wake(t *schedTask, err error, values ...LValue) {
+	t.waiting = false
+	t.wakeErr = err
+	t.args = values
+	s.ready = append(s.ready, t)
+}
+
+func (s *Scheduler) suspend(L *LState, t *schedTask) int {
+	t.waiting = true
+	return L.YieldK(t, schedContinue)
+}
+
+func schedContinue(L *LState, err error, ctx interface{}) int {
+	t := ctx.(*schedTask)
+	if err := t.wakeErr; err != nil {
+		t.wakeErr = nil
+		L.RaiseGoError(err)
+	}
+	return L.GetTop()
+}
+
+func (s *Scheduler) checkTask(L *LState, name string) *schedTask {
+	t, ok := s.tasks[L]
+	if !ok {
+		L.RaiseError("%s must be called in a coroutine spawned by the scheduler", name)
+	}
+	if !L.IsYieldable() {
+		L.RaiseError("attempt to yield across a Go-call boundary")
+	}
+	return t
+}
+
+func (s *Scheduler) schedSpawn(L *LState) int {
+	fn := L.CheckFunction(1)
+	args := make([]LValue, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+	L.Push(s.Spawn(fn, args...))
+	return 1
+}
+
+func (s *Scheduler) schedSleep(L *LState) int {
+	d := time.Duration(float64(L.CheckNumber(1)) * float64(time.Second))
+	t := s.checkTask(L, "sched.sleep")
+	s.start(func(complete func(func())) {
+		time.AfterFunc(d, func() {
+			complete(func() { s.wake(t, nil) })
+		})
+	})
+	return s.suspend(L, t)
+}
+
+func (s *Scheduler) schedWait(L *LState) int {
+	ch := L.CheckChannel(1)
+	t := s.checkTask(L, "sched.wait")
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	stop := s.stop
+	s.start(func(complete func(func())) {
+		go func() {
+			// once Run has returned, the values sent to the channel are left to the other
+			// readers
+			select {
+			case <-stop:
+				complete(func() { s.wake(t, errSchedulerStopped) })
+				return
+			default:
+			}
+			select {
+			case lv, ok := <-ch:
+				complete(func() {
+					if ok {
+						s.wake(t, nil, LTrue, unmarshalChannelValue(L, lv))
+					} else {
+						s.wake(t, nil, LFalse, LNil)
+					}
+				})
+			case <-ctx.Done():
+				complete(func() { s.wake(t, ctx.Err()) })
+			case <-stop:
+				complete(func() { s.wake(t, errSchedulerStopped) })
+			}
+		}()
+	})
+	return s.suspend(L, t)
+}
+
+func (s *Scheduler) schedJoin(L *LState) int {
+	co := L.CheckThread(1)
+	target, ok := s.tasks[co]
+	if !ok {
+		L.ArgError(1, "coroutine is not spawned by the scheduler")
+	}
+	if target.finished {
+		target.joined = true
+		values := target.joinResults()
+		for _, lv := range values {
+			L.Push(lv)
+		}
+		return len(values)
+	}
+	t := s.checkTask(L, "sched.join")
+	if t == target {
+		L.ArgError(1, "can not join the running coroutine")
+	}
+	target.joiners = append(target.joiners, t)
+	return s.suspend(L, t)
+}
@@ -0,0 +1,157 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRun(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	s := NewScheduler(L)
+	errorIfScriptFail(t, L, `
+    local sched = require("sched")
+    log = {}
+    function main(ch)
+      local slow = sched.spawn(function(name)
+        sched.sleep(0.02)
+        table.insert(log, name)
+        return name, 1
+      end, "slow")
+      sched.spawn(function()
+        sched.sleep(0.01)
+        table.insert(log, "fast")
+        ch:send("ping")
+      end)
+      local ok, v = sched.wait(ch)
+      assert(ok and v == "ping")
+      table.insert(log, "received")
+      local ok, name, n = sched.join(slow)
+      assert(ok and name == "slow" and n == 1)
+      local ok, name = sched.join(slow)
+      assert(ok and name == "slow")
+      local failing = sched.spawn(function() error("--failed--") end)
+      local ok, msg = sched.join(failing)
+      assert(not ok and string.find(msg, "--failed--"))
+      table.insert(log, "done")
+    end
+    `)
+	s.Spawn(L.GetGlobal("main").(*LFunction), LChannel(make(chan LValue, 1)))
+	errorIfNotNil(t, s.Run())
+	errorIfScriptFail(t, L, `
+    assert(table.concat(log, ",") == "fast,received,slow,done", table.concat(log, ","))
+    `)
+
+	errorIfScriptFail(t, L, `
+    function failing()
+      local sched = require("sched")
+      local co = sched.spawn(function() error("--unjoined--") end)
+      coroutine.yield()
+    end
+    `)
+	s.Spawn(L.GetGlobal("failing").(*LFunction))
+	err := s.Run()
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "--unjoined--"), err.Error())
+
+	errorIfScriptNotFail(t, L, `require("sched").sleep(1)`, "must be called in a coroutine spawned by the scheduler")
+}
+
+func TestSchedulerAwait(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	s := NewScheduler(L)
+	errFetch := errors.New("fetch failed")
+	L.SetGlobal("fetch", L.NewFunction(func(L *LState) int {
+		key := L.CheckString(1)
+		return s.Await(L, func() (LValue, error) {
+			time.Sleep(time.Millisecond)
+			if key == "" {
+				return nil, errFetch
+			}
+			return LString("value of " + key), nil
+		})
+	}))
+	errorIfScriptFail(t, L, `
+    function main()
+      assert(fetch("a") == "value of a")
+      local ok, err = pcall(fetch, "")
+      assert(not ok and err:is(fetch_error))
+      result = "ok"
+    end
+    `)
+	L.SetGlobal("fetch_error", L.newGoError(errFetch))
+	s.Spawn(L.GetGlobal("main").(*LFunction))
+	errorIfNotNil(t, s.Run())
+	errorIfNotEqual(t, LString("ok"), L.GetGlobal("result"))
+}
+
+func TestSchedulerContext(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+	s := NewScheduler(L)
+	errorIfScriptFail(t, L, `
+    function main(ch)
+      require("sched").wait(ch)
+    end
+    `)
+	s.Spawn(L.GetGlobal("main").(*LFunction), LChannel(make(chan LValue)))
+	errorIfNotEqual(t, context.DeadlineExceeded, s.Run())
+}
+
+func TestSchedulerWaitAfterRun(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+	s := NewScheduler(L)
+	errorIfScriptFail(t, L, `
+    function main(ch)
+      return require("sched").wait(ch)
+    end
+    `)
+	ch := make(chan LValue, 1)
+	co := s.Spawn(L.GetGlobal("main").(*LFunction), LChannel(ch))
+	errorIfNotEqual(t, context.DeadlineExceeded, s.Run())
+
+	// the waiting goroutine has given up, so the value is left in the channel
+	time.Sleep(10 * time.Millisecond)
+	ch <- LString("value")
+	time.Sleep(10 * time.Millisecond)
+	errorIfNotEqual(t, 1, len(ch))
+
+	// the abandoned wait fails when the scheduler runs again
+	L.SetContext(context.Background())
+	err := s.Run()
+	errorIfFalse(t, err != nil && (strings.Contains(err.Error(), context.DeadlineExceeded.Error()) ||
+		strings.Contains(err.Error(), errSchedulerStopped.Error())), "unexpected error: %v", err)
+	errorIfNotEqual(t, "dead", L.Status(co))
+}
+
+func TestSchedulerDeadlock(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	s := NewScheduler(L)
+	errorIfScriptFail(t, L, `
+    local sched = require("sched")
+    function main()
+      local a, b
+      a = sched.spawn(function() sched.sleep(0.01); sched.join(b) end)
+      b = sched.spawn(function() sched.join(a) end)
+    end
+    `)
+	s.Spawn(L.GetGlobal("main").(*LFunction))
+	err := s.Run()
+	errorIfFalse(t, err != nil && strings.Contains(err.Error(), "deadlock: 2 coroutines"), "unexpected error: %v", err)
+
+	// the deadlocked coroutines are dropped, so the scheduler can run again
+	s.Spawn(L.NewFunction(func(L *LState) int { return 0 }))
+	errorIfNotNil(t, s.Run())
+}
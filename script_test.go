@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/yuin/gopher-lua/ast"
 	"github.com/yuin/gopher-lua/parse"
 )
 
@@ -230,3 +231,43 @@ func TestMergingLoadNil(t *testing.T) {
 		t.Fatalf("expected 1 LOADNIL instruction, found %d", count)
 	}
 }
+
+func TestSyntaxErrorMessages(t *testing.T) {
+	cases := []struct{ src, message string }{
+		{"function f()\n  x = 1\n", "expected 'end' to close 'function' at line 1"},
+		{"if x then\n  y = 1\nuntil x", "expected 'end' to close 'if' at line 1"},
+		{"if x y = 1 end", "expected 'then'"},
+		{"print(1,\n  2", "expected ')' to close '(' at line 1"},
+		{"x = 1 end", "unmatched 'end'"},
+		{"x", "expected '=' or a function call"},
+	}
+	for _, c := range cases {
+		_, err := parse.Parse(strings.NewReader(c.src), "test")
+		errorIfNil(t, err)
+		errorIfNotEqual(t, c.message, err.(*parse.Error).Message)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	s := `
+local a = = 1
+local b = 2
+function f(x y)
+  return x
+end
+print(b
+while true do
+  local c = 
+`
+	chunk, _, errs := parse.ParseAll(strings.NewReader(s), "test")
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%d: %s", err.Pos.Line, err.Message))
+	}
+	errorIfNotEqual(t, "2: expected expression|4: expected ')'|8: expected ')' or ','|-1: expected 'end' to close 'while' at line 8",
+		strings.Join(messages, "|"))
+	// `local b = 2`, `function f` and `while` closed at EOF
+	errorIfNotEqual(t, 3, len(chunk))
+	_, ok := chunk[1].(*ast.FuncDefStmt)
+	errorIfFalse(t, ok, "the function with the error in its parameters should be kept: %v", parse.Dump(chunk))
+}
@@ -5,6 +5,7 @@ package lua
 ////////////////////////////////////////////////////////
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -30,12 +31,15 @@ type ApiError struct {
 	Type       ApiErrorType
 	Object     LValue
 	StackTrace string
-	// Underlying error. This attribute is set only if the Type is ApiErrorFile or ApiErrorSyntax
+	// Underlying error. This attribute is set if the Type is ApiErrorFile or ApiErrorSyntax,
+	// or if a Go error has been raised as a Lua error(see LState.RaiseError).
 	Cause error
+	// Structured representation of the StackTrace.
+	Frames []StackFrame
 }
 
 func newApiError(code ApiErrorType, object LValue) *ApiError {
-	return &ApiError{code, object, "", nil}
+	return &ApiError{code, object, "", nil, nil}
 }
 
 func newApiErrorS(code ApiErrorType, message string) *ApiError {
@@ -43,14 +47,24 @@ func newApiErrorS(code ApiErrorType, message string) *ApiError {
 }
 
 func newApiErrorE(code ApiErrorType, err error) *ApiError {
-	return &ApiError{code, LString(err.Error()), "", err}
+	return &ApiError{code, LString(err.Error()), "", err, nil}
 }
 
 func (e *ApiError) Error() string {
+	message := e.Object.String()
+	if err, ok := goErrorValue(e.Object); ok {
+		message = err.Error()
+	}
 	if len(e.StackTrace) > 0 {
-		return fmt.Sprintf("%s\n%s", e.Object.String(), e.StackTrace)
+		return fmt.Sprintf("%s\n%s", message, e.StackTrace)
 	}
-	return e.Object.String()
+	return message
+}
+
+// Unwrap returns the underlying error, so errors.Is and errors.As can see Go errors
+// that have been raised as Lua errors.
+func (e *ApiError) Unwrap() error {
+	return e.Cause
 }
 
 type ApiErrorType int
@@ -65,6 +79,36 @@ const (
 
 /* }}} */
 
+/* StackFrame {{{ */
+
+type StackFrameKind int
+
+const (
+	StackFrameLua StackFrameKind = iota
+	StackFrameGo
+)
+
+// StackFrame is an entry of a Lua stack traceback.
+type StackFrame struct {
+	Kind StackFrameKind
+	// Chunk name of the function. This is "[G]" for Go functions.
+	Source string
+	// Line number that is executed in this frame. This is -1 for Go functions.
+	Line int
+	// Column that is executed in this frame. This is 0 if it is unknown.
+	Column       int
+	FunctionName string
+	// Local variables that are alive in this frame. This is set only if the Options.IncludeStackLocals is true.
+	Locals []StackLocal
+}
+
+type StackLocal struct {
+	Name  string
+	Value LValue
+}
+
+/* }}} */
+
 /* ResumeState {{{ */
 
 type ResumeState int
@@ -106,9 +150,20 @@ type Options struct {
 	SkipOpenLibs bool
 	// Tells whether a Go stacktrace should be included in a Lua stacktrace when panics occur.
 	IncludeGoStackTrace bool
+	// Tells whether values of local variables should be captured in ApiError.Frames.
+	IncludeStackLocals bool
+	// Tells whether positions in error messages and stack tracebacks include columns, like `file:line:col:`.
+	IncludeErrorColumns bool
+	// Maximum number of workers of the task library that run concurrently. This defaults to `lua.MaxTaskWorkers`.
+	MaxTaskWorkers int
 	// If `MinimizeStackMemory` is set, the call stack will be automatically grown or shrank up to a limit of
 	// `CallStackSize` in order to minimize memory usage. This does incur a slight performance penalty.
 	MinimizeStackMemory bool
+	// Tells whether LState.Stats counts the VM instructions executed. Counting them slows down the execution.
+	CountInstructions bool
+	// Tells whether LState.Load (and so DoFile, DoString and require) loads the chunks precompiled by
+	// DumpFunctionProto. Precompiled chunks are not verified, so enable this only for trusted chunks.
+	LoadBinaryChunks bool
 }
 
 /* }}} */
@@ -121,6 +176,7 @@ type Debug struct {
 	What            string
 	Source          string
 	CurrentLine     int
+	CurrentColumn   int
 	NUpvalues       int
 	LineDefined     int
 	LastLineDefined int
@@ -141,6 +197,36 @@ type callFrame struct {
 	NArgs      int
 	NRet       int
 	TailCall   int
+	Cont       *callContinuation
+}
+
+// callContinuation holds a continuation of a Go function that has called CallK or
+// PCallK, or yielded by YieldK.
+type callContinuation struct {
+	Fn  LKFunction
+	Ctx interface{}
+	// true if the continuation has been registered by PCallK
+	Protected bool
+	ErrFunc   *LFunction
+	// registry index of the function called by PCallK
+	Base int
+	// error caught by PCallK after the coroutine has been resumed
+	Err error
+}
+
+// continuation returns the continuation of this frame, or nil if the frame has no pending continuation.
+func (cf *callFrame) continuation() *callContinuation {
+	if cf.Cont != nil && cf.Cont.Fn != nil {
+		return cf.Cont
+	}
+	return nil
+}
+
+func (cf *callFrame) setContinuation(cont callContinuation) {
+	if cf.Cont == nil {
+		cf.Cont = &callContinuation{}
+	}
+	*cf.Cont = cont
 }
 
 type callFrameStack interface {
@@ -365,6 +451,7 @@ func (cs *autoGrowingCallFrameStack) Pop() *callFrame {
 
 type registryHandler interface {
 	registryOverflow()
+	registryResized()
 }
 type registry struct {
 	array   []LValue
@@ -398,6 +485,7 @@ func (rg *registry) resize(requiredSize int) { // +inline-start
 } // +inline-end
 
 func (rg *registry) forceResize(newSize int) {
+	rg.handler.registryResized()
 	newSlice := make([]LValue, newSize)
 	copy(newSlice, rg.array[:rg.top]) // should we copy the area beyond top? there shouldn't be any valid values there so it shouldn't be necessary.
 	rg.array = newSlice
@@ -652,12 +740,15 @@ func newGlobal() *Global {
 
 func panicWithTraceback(L *LState) {
 	err := newApiError(ApiErrorRun, L.Get(-1))
+	err.Cause, L.errCause = L.errCause, nil
 	err.StackTrace = L.stackTrace(0)
+	err.Frames = L.stackFrames(0)
 	panic(err)
 }
 
 func panicWithoutTraceback(L *LState) {
 	err := newApiError(ApiErrorRun, L.Get(-1))
+	err.Cause, L.errCause = L.errCause, nil
 	panic(err)
 }
 
@@ -678,6 +769,7 @@ func newLState(options Options) *LState {
 		hasErrorFunc: false,
 		mainLoop:     mainLoop,
 		ctx:          nil,
+		stats:        &stateStats{},
 	}
 	if options.MinimizeStackMemory {
 		ls.stack = newAutoGrowingCallFrameStack(options.CallStackSize)
@@ -686,6 +778,7 @@ func newLState(options Options) *LState {
 	}
 	ls.reg = newRegistry(ls, options.RegistrySize, options.RegistryGrowStep, options.RegistryMaxSize, al)
 	ls.Env = ls.G.Global
+	ls.updateMainLoop()
 	return ls
 }
 
@@ -733,13 +826,25 @@ func (ls *LState) closeAllUpvalues() { // +inline-start
 } // +inline-end
 
 func (ls *LState) raiseError(level int, format string, args ...interface{}) {
+	if len(args) == 0 {
+		ls.raiseMessage(level, format, nil)
+		return
+	}
+	// fmt.Errorf keeps errors wrapped by the %w verb reachable from the ApiError.
+	err := fmt.Errorf(format, args...)
+	var cause error
+	switch err.(type) {
+	case interface{ Unwrap() error }, interface{ Unwrap() []error }:
+		cause = err
+	}
+	ls.raiseMessage(level, err.Error(), cause)
+}
+
+func (ls *LState) raiseMessage(level int, message string, cause error) {
+	atomic.AddUint64(&ls.stats.errors, 1)
 	if !ls.hasErrorFunc {
 		ls.closeAllUpvalues()
 	}
-	message := format
-	if len(args) > 0 {
-		message = fmt.Sprintf(format, args...)
-	}
 	if level > 0 {
 		message = fmt.Sprintf("%v %v", ls.where(level-1, true), message)
 	}
@@ -748,6 +853,7 @@ func (ls *LState) raiseError(level int, format string, args ...interface{}) {
 		ls.reg.forceResize(ls.reg.Top() + 1)
 	}
 	ls.reg.Push(LString(message))
+	ls.errCause = cause
 	ls.Panic(ls)
 }
 
@@ -772,6 +878,95 @@ func (ls *LState) findLocal(frame *callFrame, no int) string {
 	return ""
 }
 
+// varInfo returns a description of the variable that the register reg(relative
+// to the local base) of the running Lua function comes from, like " (global 'foo')".
+// It returns an empty string if no such information is available.
+func (ls *LState) varInfo(reg int) string {
+	cf := ls.currentFrame
+	if cf == nil || cf.Fn.IsG || cf.Pc == 0 {
+		return ""
+	}
+	kind, name := cf.Fn.Proto.objName(cf.Pc-1, reg)
+	if len(kind) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s '%s')", kind, name)
+}
+
+// currentInst returns the instruction that the running Lua function is executing.
+func (ls *LState) currentInst() (uint32, bool) {
+	cf := ls.currentFrame
+	if cf == nil || cf.Fn.IsG || cf.Pc == 0 {
+		return opInvalidInstruction, false
+	}
+	return cf.Fn.Proto.Code[cf.Pc-1], true
+}
+
+// regVarInfo returns varInfo(reg) if the register reg holds the given value.
+func (ls *LState) regVarInfo(reg int, lv LValue) string {
+	if ls.reg.Get(ls.currentFrame.LocalBase+reg) != lv {
+		return ""
+	}
+	return ls.varInfo(reg)
+}
+
+func (ls *LState) indexVarInfo(obj LValue) string {
+	inst, ok := ls.currentInst()
+	if !ok {
+		return ""
+	}
+	switch opGetOpCode(inst) {
+	case OP_GETTABLE, OP_GETTABLEKS, OP_SELF:
+		return ls.regVarInfo(opGetArgB(inst), obj)
+	case OP_SETTABLE, OP_SETTABLEKS:
+		return ls.regVarInfo(opGetArgA(inst), obj)
+	}
+	return ""
+}
+
+func (ls *LState) callVarInfo(fn LValue) string {
+	inst, ok := ls.currentInst()
+	if !ok {
+		return ""
+	}
+	switch opGetOpCode(inst) {
+	case OP_CALL, OP_TAILCALL, OP_TFORLOOP:
+		return ls.regVarInfo(opGetArgA(inst), fn)
+	}
+	return ""
+}
+
+func (ls *LState) arithVarInfo(opcode int, lhs, rhs LValue) string {
+	inst, ok := ls.currentInst()
+	if !ok || opGetOpCode(inst) != opcode {
+		return ""
+	}
+	rk, lv := opGetArgB(inst), lhs
+	if _, isnum := lhs.(LNumber); isnum {
+		rk, lv = opGetArgC(inst), rhs
+	}
+	if opIsK(rk) {
+		return ""
+	}
+	return ls.regVarInfo(rk, lv)
+}
+
+func (ls *LState) concatVarInfo(reg int, lhs, rhs LValue) string {
+	inst, ok := ls.currentInst()
+	if !ok || opGetOpCode(inst) != OP_CONCAT {
+		return ""
+	}
+	reg -= ls.currentFrame.LocalBase
+	lv := lhs
+	if LVCanConvToString(lhs) {
+		reg, lv = reg+1, rhs
+	}
+	if reg < opGetArgB(inst) || reg > opGetArgC(inst) {
+		return ""
+	}
+	return ls.regVarInfo(reg, lv)
+}
+
 func (ls *LState) where(level int, skipg bool) string {
 	dbg, ok := ls.GetStack(level)
 	if !ok {
@@ -788,6 +983,9 @@ func (ls *LState) where(level int, skipg bool) string {
 	line := ""
 	if proto != nil {
 		line = fmt.Sprintf("%v:", proto.DbgSourcePositions[cf.Pc-1])
+		if col := proto.sourceColumn(cf.Pc - 1); ls.Options.IncludeErrorColumns && col > 0 {
+			line = fmt.Sprintf("%v%v:", line, col)
+		}
 	}
 	return fmt.Sprintf("%v:%v", sourcename, line)
 }
@@ -821,6 +1019,53 @@ func (ls *LState) stackTrace(level int) string {
 	return fmt.Sprintf("%s\n%s", header, strings.Join(buf, "\n"))
 }
 
+func (ls *LState) stackFrames(level int) []StackFrame {
+	frames := []StackFrame{}
+	if ls.currentFrame != nil {
+		for i := level; ; i++ {
+			dbg, ok := ls.GetStack(i)
+			if !ok {
+				break
+			}
+			cf := dbg.frame
+			frame := StackFrame{
+				Kind:         StackFrameGo,
+				Source:       "[G]",
+				Line:         -1,
+				FunctionName: ls.rawFrameFuncName(cf),
+			}
+			if !cf.Fn.IsG {
+				frame.Kind = StackFrameLua
+				frame.Source = cf.Fn.Proto.SourceName
+				if cf.Pc > 0 {
+					frame.Line = cf.Fn.Proto.DbgSourcePositions[cf.Pc-1]
+					frame.Column = cf.Fn.Proto.sourceColumn(cf.Pc - 1)
+				}
+				if ls.Options.IncludeStackLocals {
+					frame.Locals = ls.stackLocals(dbg)
+				}
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+func (ls *LState) stackLocals(dbg *Debug) []StackLocal {
+	locals := []StackLocal{}
+	for no := 1; ; no++ {
+		name, value := ls.GetLocal(dbg, no)
+		if len(name) == 0 {
+			break
+		}
+		if name[0] == '(' { // internal variables like (for index)
+			continue
+		}
+		locals = append(locals, StackLocal{name, value})
+	}
+	return locals
+}
+
 func (ls *LState) formattedFrameFuncName(fr *callFrame) string {
 	name, ischunk := ls.frameFuncName(fr)
 	if ischunk {
@@ -1135,13 +1380,16 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 		ls.reg.Insert(fn, cf.LocalBase)
 	}
 	if cf.Fn == nil {
-		ls.RaiseError("attempt to call a non-function object")
+		ls.RaiseError("attempt to call a non-function object%s", ls.callVarInfo(fn))
 	}
 	if ls.stack.IsFull() {
 		ls.RaiseError("stack overflow")
 	}
 	ls.stack.Push(cf)
 	newcf := ls.stack.Last()
+	if ls.tracing != nil {
+		ls.tracing.call(ls, newcf)
+	}
 	// this section is inlined by go-inline
 	// source function is 'func (ls *LState) initCallFrame(cf *callFrame) ' in '_state.go'
 	{
@@ -1241,6 +1489,7 @@ func (ls *LState) pushCallFrame(cf callFrame, fn LValue, meta bool) { // +inline
 		}
 	}
 	ls.currentFrame = newcf
+	ls.stats.call(ls)
 } // +inline-end
 
 func (ls *LState) callR(nargs, nret, rbase int) {
@@ -1286,7 +1535,7 @@ func (ls *LState) getField(obj LValue, key LValue) LValue {
 		metaindex := ls.metaOp1(curobj, "__index")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key.String())
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key.String(), ls.indexVarInfo(curobj))
 			}
 			return LNil
 		}
@@ -1317,7 +1566,7 @@ func (ls *LState) getFieldString(obj LValue, key string) LValue {
 		metaindex := ls.metaOp1(curobj, "__index")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key)
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key, ls.indexVarInfo(curobj))
 			}
 			return LNil
 		}
@@ -1348,7 +1597,7 @@ func (ls *LState) setField(obj LValue, key LValue, value LValue) {
 		metaindex := ls.metaOp1(curobj, "__newindex")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key.String())
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key.String(), ls.indexVarInfo(curobj))
 			}
 			ls.RawSet(tb, key, value)
 			return
@@ -1380,7 +1629,7 @@ func (ls *LState) setFieldString(obj LValue, key string, value LValue) {
 		metaindex := ls.metaOp1(curobj, "__newindex")
 		if metaindex == LNil {
 			if !istable {
-				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'", curobj.Type().String(), key)
+				ls.RaiseError("attempt to index a non-table object(%v) with key '%s'%s", curobj.Type().String(), key, ls.indexVarInfo(curobj))
 			}
 			tb.RawSetString(key, value)
 			return
@@ -1602,11 +1851,17 @@ func (ls *LState) Remove(index int) {
 /* object allocation {{{ */
 
 func (ls *LState) NewTable() *LTable {
-	return newLTable(defaultArrayCap, defaultHashCap)
+	atomic.AddUint64(&ls.stats.tables, 1)
+	tb := newLTable(defaultArrayCap, defaultHashCap)
+	ls.recordTable(tb, defaultArrayCap, defaultHashCap)
+	return tb
 }
 
 func (ls *LState) CreateTable(acap, hcap int) *LTable {
-	return newLTable(acap, hcap)
+	atomic.AddUint64(&ls.stats.tables, 1)
+	tb := newLTable(acap, hcap)
+	ls.recordTable(tb, acap, hcap)
+	return tb
 }
 
 // NewThread returns a new LState that shares with the original state all global objects.
@@ -1617,10 +1872,17 @@ func (ls *LState) NewThread() (*LState, context.CancelFunc) {
 	thread.Env = ls.Env
 	var f context.CancelFunc = nil
 	if ls.ctx != nil {
-		thread.mainLoop = mainLoopWithContext
 		thread.ctx, f = context.WithCancel(ls.ctx)
 		thread.ctxCancelFn = f
 	}
+	thread.coverage = ls.coverage
+	thread.profiler = ls.profiler
+	thread.allocProfile = ls.allocProfile
+	thread.stats = ls.stats
+	if ls.tracing != nil {
+		thread.tracing = ls.tracing.thread()
+	}
+	thread.updateMainLoop()
 	return thread, f
 }
 
@@ -1725,20 +1987,42 @@ func (ls *LState) registryOverflow() {
 	ls.RaiseError("registry overflow")
 }
 
+func (ls *LState) registryResized() {
+	atomic.AddUint64(&ls.stats.registryResizes, 1)
+}
+
 // This function is equivalent to luaL_error( http://www.lua.org/manual/5.1/manual.html#luaL_error ).
 func (ls *LState) RaiseError(format string, args ...interface{}) {
 	ls.raiseError(1, format, args...)
 }
 
+// RaiseGoError raises err as a Lua error. Unlike RaiseError, the error value is a
+// userdata that holds err, so Lua code can inspect it(err:is("not_exist")) and
+// PCall returns an *ApiError that unwraps to err.
+func (ls *LState) RaiseGoError(err error) {
+	ls.Error(ls.newGoError(err), 1)
+}
+
 // This function is equivalent to lua_error( http://www.lua.org/manual/5.1/manual.html#lua_error ).
 func (ls *LState) Error(lv LValue, level int) {
+	var cause error
+	if err, ok := goErrorValue(lv); ok {
+		cause = err
+	} else if ls.lastError != nil && sameLValue(ls.lastError.Object, lv) {
+		// re-raising an error caught by pcall, keep the underlying Go error.
+		cause = ls.lastError.Cause
+	}
+	// the error caught last is consumed by the error raised now
+	ls.lastError = nil
 	if str, ok := lv.(LString); ok {
-		ls.raiseError(level, string(str))
+		ls.raiseMessage(level, string(str), cause)
 	} else {
+		atomic.AddUint64(&ls.stats.errors, 1)
 		if !ls.hasErrorFunc {
 			ls.closeAllUpvalues()
 		}
 		ls.Push(lv)
+		ls.errCause = cause
 		ls.Panic(ls)
 	}
 }
@@ -1778,6 +2062,7 @@ func (ls *LState) GetInfo(what string, dbg *Debug, fn LValue) (LValue, error) {
 			if !f.IsG && dbg.frame != nil {
 				if dbg.frame.Pc > 0 {
 					dbg.CurrentLine = f.Proto.DbgSourcePositions[dbg.frame.Pc-1]
+					dbg.CurrentColumn = f.Proto.sourceColumn(dbg.frame.Pc - 1)
 				}
 			} else {
 				dbg.CurrentLine = -1
@@ -2010,8 +2295,22 @@ func (ls *LState) Register(name string, fn LGFunction) {
 
 /* load and function call operations {{{ */
 
+// Load compiles the chunk read from reader. If the chunk starts with FunctionProtoSignature,
+// it is loaded as a FunctionProto written by DumpFunctionProto when Options.LoadBinaryChunks
+// is set, and it is an error otherwise.
 func (ls *LState) Load(reader io.Reader, name string) (*LFunction, error) {
-	chunk, err := parse.Parse(reader, name)
+	br := bufio.NewReader(reader)
+	if sig, _ := br.Peek(len(FunctionProtoSignature)); string(sig) == FunctionProtoSignature {
+		if !ls.Options.LoadBinaryChunks {
+			return nil, newApiErrorE(ApiErrorSyntax, fmt.Errorf("%s: attempt to load a precompiled chunk", name))
+		}
+		proto, err := LoadFunctionProto(br)
+		if err != nil {
+			return nil, newApiErrorE(ApiErrorSyntax, fmt.Errorf("%s: %v", name, err))
+		}
+		return newLFunctionL(proto, ls.currentEnv(), 0), nil
+	}
+	chunk, err := parse.Parse(br, name)
 	if err != nil {
 		return nil, newApiErrorE(ApiErrorSyntax, err)
 	}
@@ -2026,10 +2325,31 @@ func (ls *LState) Call(nargs, nret int) {
 	ls.callR(nargs, nret, -1)
 }
 
+// CallK is like Call, but the called function is allowed to yield. If the coroutine yields
+// inside the called function, CallK does not return. When the coroutine is resumed and the
+// called function returns, k is called with ctx instead, and the return value of k is used as
+// the return value of the Go function that has called CallK. So the Go function typically
+// looks like:
+//
+//	L.CallK(nargs, nret, ctx, k)
+//	return k(L, nil, ctx)
+func (ls *LState) CallK(nargs, nret int, ctx interface{}, k LKFunction) {
+	cf := ls.currentFrame
+	if k == nil || cf == nil || !cf.Fn.IsG {
+		ls.Call(nargs, nret)
+		return
+	}
+	cf.setContinuation(callContinuation{Fn: k, Ctx: ctx})
+	ls.callR(nargs, nret, -1)
+	cf.Cont.Fn = nil
+	cf.Cont.Ctx = nil
+}
+
 func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 	err = nil
 	sp := ls.stack.Sp()
 	base := ls.reg.Top() - nargs - 1
+	nny := ls.nny
 	oldpanic := ls.Panic
 	ls.Panic = panicWithoutTraceback
 	if errfunc != nil {
@@ -2039,13 +2359,23 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 		ls.Panic = oldpanic
 		ls.hasErrorFunc = false
 		rcv := recover()
+		if _, ok := rcv.(*threadYield); ok {
+			// the coroutine has been suspended in PCallK, this frame will be continued by
+			// the continuation.
+			panic(rcv)
+		}
 		if rcv != nil {
+			ls.nny = nny
 			if _, ok := rcv.(*ApiError); !ok {
 				err = newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
+				if cause, ok := rcv.(error); ok {
+					err.(*ApiError).Cause = cause
+				}
 				if ls.Options.IncludeGoStackTrace {
 					buf := make([]byte, 4096)
 					runtime.Stack(buf, false)
 					err.(*ApiError).StackTrace = strings.Trim(string(buf), "\000") + "\n" + ls.stackTrace(0)
+					err.(*ApiError).Frames = ls.stackFrames(0)
 				}
 			} else {
 				err = rcv.(*ApiError)
@@ -2068,17 +2398,22 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 						} else {
 							err = rcv.(*ApiError)
 							err.(*ApiError).StackTrace = ls.stackTrace(0)
+							err.(*ApiError).Frames = ls.stackFrames(0)
 						}
 						ls.stack.SetSp(sp)
 						ls.currentFrame = ls.stack.Last()
 						ls.reg.SetTop(base)
 					}
 				}()
+				cause := err.(*ApiError).Cause
 				ls.Call(1, 1)
 				err = newApiError(ApiErrorError, ls.Get(-1))
+				err.(*ApiError).Cause = cause
 			} else if len(err.(*ApiError).StackTrace) == 0 {
 				err.(*ApiError).StackTrace = ls.stackTrace(0)
+				err.(*ApiError).Frames = ls.stackFrames(0)
 			}
+			ls.lastError = err.(*ApiError)
 			ls.stack.SetSp(sp)
 			ls.currentFrame = ls.stack.Last()
 			ls.reg.SetTop(base)
@@ -2086,6 +2421,11 @@ func (ls *LState) PCall(nargs, nret int, errfunc *LFunction) (err error) {
 		ls.stack.SetSp(sp)
 		if sp == 0 {
 			ls.currentFrame = nil
+			// the error is returned to the host, nothing can re-raise it anymore
+			ls.lastError = nil
+		}
+		if ls.tracing != nil {
+			ls.tracing.unwind(sp, MultRet, err)
 		}
 	}()
 
@@ -2148,13 +2488,24 @@ func (ls *LState) Status(th *LState) string {
 		status = "dead"
 	} else if ls.G.CurrentThread == th {
 		status = "running"
-	} else if ls.Parent == th {
-		status = "normal"
+	} else {
+		for parent := ls.Parent; parent != nil; parent = parent.Parent {
+			if parent == th {
+				status = "normal"
+				break
+			}
+		}
 	}
 	return status
 }
 
 func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState, error, []LValue) {
+	if ls.G.CurrentThread == th {
+		return ResumeError, newApiErrorS(ApiErrorRun, "can not resume a running thread"), nil
+	}
+	if th.Dead {
+		return ResumeError, newApiErrorS(ApiErrorRun, "can not resume a dead thread"), nil
+	}
 	isstarted := th.isStarted()
 	if !isstarted {
 		base := 0
@@ -2170,13 +2521,6 @@ func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState
 			TailCall:   0,
 		})
 	}
-
-	if ls.G.CurrentThread == th {
-		return ResumeError, newApiErrorS(ApiErrorRun, "can not resume a running thread"), nil
-	}
-	if th.Dead {
-		return ResumeError, newApiErrorS(ApiErrorRun, "can not resume a dead thread"), nil
-	}
 	th.Parent = ls
 	ls.G.CurrentThread = th
 	if !isstarted {
@@ -2187,6 +2531,9 @@ func (ls *LState) Resume(th *LState, fn *LFunction, args ...LValue) (ResumeState
 			th.Push(arg)
 		}
 		cf.NArgs = len(args)
+		if th.tracing != nil {
+			th.tracing.call(th, cf)
+		}
 		th.initCallFrame(cf)
 		th.Panic = panicWithoutTraceback
 	} else {
@@ -2222,6 +2569,146 @@ func (ls *LState) Yield(values ...LValue) int {
 	return -1
 }
 
+// PCallK is like PCall, but the called function is allowed to yield. See CallK for details.
+// If the coroutine has yielded inside the called function and an error occurs after the
+// coroutine is resumed, k is called with the error.
+func (ls *LState) PCallK(nargs, nret int, errfunc *LFunction, ctx interface{}, k LKFunction) error {
+	cf := ls.currentFrame
+	if k == nil || cf == nil || !cf.Fn.IsG {
+		return ls.PCall(nargs, nret, errfunc)
+	}
+	cf.setContinuation(callContinuation{Fn: k, Ctx: ctx, Protected: true, ErrFunc: errfunc, Base: ls.reg.Top() - nargs - 1})
+	err := ls.PCall(nargs, nret, errfunc)
+	*cf.Cont = callContinuation{}
+	return err
+}
+
+// YieldK is like Yield, but when the coroutine is resumed, k is called with ctx. The values
+// passed to the resume are the arguments of k, and the return value of k is used as the
+// return value of the Go function that has called YieldK.
+func (ls *LState) YieldK(ctx interface{}, k LKFunction, values ...LValue) int {
+	if cf := ls.currentFrame; cf != nil && cf.Fn.IsG {
+		cf.setContinuation(callContinuation{Fn: k, Ctx: ctx})
+	}
+	return ls.Yield(values...)
+}
+
+// checkYield raises an error if the running coroutine can not yield. It returns true if the
+// yield has to unwind Go function calls, that will be continued by their continuations.
+func (ls *LState) checkYield() bool {
+	reason, unwind := ls.yieldBoundary()
+	if len(reason) > 0 {
+		ls.RaiseError(reason)
+	}
+	return unwind
+}
+
+// yieldBoundary returns the reason why the running coroutine can not yield, or an empty
+// string if it can. unwind is true if the yield has to unwind Go function calls.
+func (ls *LState) yieldBoundary() (reason string, unwind bool) {
+	if ls.Parent == nil {
+		return "can not yield from outside of a coroutine", false
+	}
+	if ls.nny > 0 {
+		return "attempt to yield across a Go-call boundary", false
+	}
+	for cf := ls.currentFrame; cf != nil && cf.Parent != nil; cf = cf.Parent {
+		parent := cf.Parent
+		if parent.Fn.IsG {
+			if parent.continuation() == nil {
+				return "attempt to yield across a Go-call boundary", false
+			}
+			unwind = true
+		} else if op := opGetOpCode(parent.Fn.Proto.Code[parent.Pc-1]); op != OP_CALL && op != OP_TAILCALL {
+			// called by a metamethod or an iterator
+			if !opIsFinishable(op) {
+				return "attempt to yield across a Go-call boundary", false
+			}
+			unwind = true
+		}
+	}
+	return "", unwind
+}
+
+// IsYieldable returns true if the running Go function can yield, that is, ls is a coroutine
+// and there are no Go function calls without continuations between the function and the
+// coroutine body.
+func (ls *LState) IsYieldable() bool {
+	reason, _ := ls.yieldBoundary()
+	return len(reason) == 0
+}
+
+// CloseThread closes the coroutine th: open upvalues of th are closed, the call frames and
+// the registry of th are released and th becomes dead. th must be suspended or dead.
+// If th has been stopped by an error, CloseThread returns the error.
+func (ls *LState) CloseThread(th *LState) error {
+	if status := ls.Status(th); status == "running" || status == "normal" {
+		return newApiErrorS(ApiErrorRun, fmt.Sprintf("can not close a %s coroutine", status))
+	}
+	if th == ls.G.MainThread {
+		return newApiErrorS(ApiErrorRun, "can not close the main thread")
+	}
+	th.closeUpvalues(0)
+	th.stack.FreeAll()
+	th.stack = newFixedCallFrameStack(0)
+	th.currentFrame = nil
+	th.reg.SetTop(0)
+	th.Parent = nil
+	th.kill()
+	if err := th.exitError; err != nil {
+		th.exitError = nil
+		return err
+	}
+	return nil
+}
+
+// suspendedPCallFrame returns the innermost frame that has been suspended in PCallK.
+func (ls *LState) suspendedPCallFrame() *callFrame {
+	for cf := ls.currentFrame; cf != nil; cf = cf.Parent {
+		if cont := cf.continuation(); cont != nil && cont.Protected {
+			return cf
+		}
+	}
+	return nil
+}
+
+// recoverSuspendedPCall recovers the error rcv in the frame suspended in PCallK like PCall
+// does. The error is passed to the continuation of the frame.
+func (ls *LState) recoverSuspendedPCall(cf *callFrame, rcv interface{}) {
+	cont := cf.Cont
+	err, ok := rcv.(*ApiError)
+	if !ok {
+		err = newApiErrorS(ApiErrorPanic, fmt.Sprint(rcv))
+		if cause, ok := rcv.(error); ok {
+			err.Cause = cause
+		}
+	}
+	if len(err.StackTrace) == 0 {
+		err.StackTrace = ls.stackTrace(0)
+		err.Frames = ls.stackFrames(0)
+	}
+	if cont.ErrFunc != nil {
+		cause := err.Cause
+		ls.Push(cont.ErrFunc)
+		ls.Push(err.Object)
+		if ferr := ls.PCall(1, 1, nil); ferr != nil {
+			err = ferr.(*ApiError)
+		} else {
+			err = newApiError(ApiErrorError, ls.Get(-1))
+			err.Cause = cause
+		}
+	}
+	ls.nny = 0
+	ls.stack.SetSp(cf.Idx + 1)
+	if ls.tracing != nil {
+		ls.tracing.unwind(cf.Idx+1, MultRet, err)
+	}
+	ls.currentFrame = cf
+	ls.reg.SetTop(cont.Base)
+	cont.Err = err
+	ls.lastError = err
+}
+
 func (ls *LState) XMoveTo(other *LState, n int) {
 	if ls == other {
 		return
@@ -2259,8 +2746,8 @@ func (ls *LState) SetMx(mx int) {
 
 // SetContext set a context ctx to this LState. The provided ctx must be non-nil.
 func (ls *LState) SetContext(ctx context.Context) {
-	ls.mainLoop = mainLoopWithContext
 	ls.ctx = ctx
+	ls.updateMainLoop()
 }
 
 // Context returns the LState's context. To change the context, use WithContext.
@@ -2271,11 +2758,37 @@ func (ls *LState) Context() context.Context {
 // RemoveContext removes the context associated with this LState and returns this context.
 func (ls *LState) RemoveContext() context.Context {
 	oldctx := ls.ctx
-	ls.mainLoop = mainLoop
 	ls.ctx = nil
+	ls.updateMainLoop()
 	return oldctx
 }
 
+// SetCoverage makes this LState record the lines it executes to c. Threads created by this
+// LState afterwards record to c as well. A nil c stops recording.
+func (ls *LState) SetCoverage(c *Coverage) {
+	ls.coverage = c
+	ls.coverProto, ls.coverCounts = nil, nil
+	ls.updateMainLoop()
+}
+
+// Coverage returns the Coverage set by SetCoverage, or nil.
+func (ls *LState) Coverage() *Coverage {
+	return ls.coverage
+}
+
+// updateMainLoop selects the main loop that supports the features enabled for this LState,
+// so that the LStates without them are not slowed down.
+func (ls *LState) updateMainLoop() {
+	switch {
+	case ls.coverage != nil || ls.profiler != nil || ls.allocProfile != nil || ls.Options.CountInstructions:
+		ls.mainLoop = mainLoopWithHooks
+	case ls.ctx != nil:
+		ls.mainLoop = mainLoopWithContext
+	default:
+		ls.mainLoop = mainLoop
+	}
+}
+
 // Converts the Lua value at the given acceptable index to the chan LValue.
 func (ls *LState) ToChannel(n int) chan LValue {
 	if lv, ok := ls.Get(n).(LChannel); ok {
@@ -2,6 +2,8 @@ package lua
 
 import (
 	"context"
+	"errors"
+	"io"
 	"strings"
 	"testing"
 	"time"
@@ -474,6 +476,176 @@ func TestContextWithCroutine(t *testing.T) {
 
 }
 
+func TestYieldAcrossBoundaries(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetContext(context.Background())
+	errorIfScriptFail(t, L, `
+    local co = coroutine.wrap(function(a)
+      local ok, x = pcall(function(v) return coroutine.yield(v + 1) * 2 end, a)
+      assert(ok and x == 20)
+      local ok, err = xpcall(function() coroutine.yield("again"); error("boom", 0) end,
+        function(e) return "handled:" .. e end)
+      assert(not ok and err == "handled:boom")
+      return "done"
+    end)
+    assert(co(1) == 2)
+    assert(co(10) == "again")
+    assert(co() == "done")
+
+    local obj = setmetatable({}, {
+      __index = function(t, k) return coroutine.yield(k) end,
+      __add = function(a, b) return coroutine.yield("add") end,
+      __lt = function(a, b) return coroutine.yield("lt") end,
+    })
+    co = coroutine.wrap(function()
+      return obj.foo, obj + 1, obj < obj
+    end)
+    assert(co() == "foo")
+    assert(co("FOO") == "add")
+    assert(co(3) == "lt")
+    local v, s, c = co(true)
+    assert(v == "FOO" and s == 3 and c == true)
+
+    co = coroutine.wrap(function()
+      local sum = 0
+      for i in function(_, i) i = (i or 0) + 1; if i <= 3 then return coroutine.yield(i) end end do
+        sum = sum + i
+      end
+      return sum
+    end)
+    assert(co() == 1 and co(1) == 2 and co(2) == 3 and co(3) == 6)
+
+    co = coroutine.wrap(function()
+      local t = {5, 2, 8, 1, 9, 3}
+      table.sort(t, function(a, b) coroutine.yield() return a < b end)
+      return table.concat(t, ",")
+    end)
+    local r
+    repeat r = co() until r
+    assert(r == "1,2,3,5,8,9")
+
+    co = coroutine.create(function()
+      local x, y = "x", "y"
+      local a, b = coroutine.yield()
+      return a, b
+    end)
+    coroutine.resume(co)
+    local _, a, b = coroutine.resume(co, 1)
+    assert(a == 1 and b == nil)
+    `)
+	errorIfScriptNotFail(t, L, `
+    local t = setmetatable({}, {__concat = function() coroutine.yield() end})
+    coroutine.wrap(function() return t .. "x" end)()
+    `, "attempt to yield across a Go-call boundary")
+}
+
+func TestCallKAndYieldK(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	var finish LKFunction
+	finish = func(L *LState, err error, ctx interface{}) int {
+		L.Push(LString(ctx.(string) + ":" + L.CheckString(-1)))
+		return 1
+	}
+	L.SetGlobal("callk", L.NewFunction(func(L *LState) int {
+		L.CallK(L.GetTop()-1, 1, "callk", finish)
+		return finish(L, nil, "callk")
+	}))
+	L.SetGlobal("pcallk", L.NewFunction(func(L *LState) int {
+		err := L.PCallK(L.GetTop()-1, 0, nil, nil, func(L *LState, err error, ctx interface{}) int {
+			L.Push(LBool(errors.Is(err, io.EOF)))
+			return 1
+		})
+		L.Push(LBool(errors.Is(err, io.EOF)))
+		return 1
+	}))
+	L.SetGlobal("raiseeof", L.NewFunction(func(L *LState) int {
+		L.RaiseGoError(io.EOF)
+		return 0
+	}))
+	L.SetGlobal("await", L.NewFunction(func(L *LState) int {
+		return L.YieldK(L.CheckString(1), func(L *LState, err error, ctx interface{}) int {
+			L.Push(LString(ctx.(string) + "=" + L.CheckString(1)))
+			return 1
+		}, L.Get(1))
+	}))
+	errorIfScriptFail(t, L, `
+    assert(callk(function() return "direct" end) == "callk:direct")
+    local co = coroutine.wrap(function()
+      local a = callk(function(x) return coroutine.yield(x) end, "y1")
+      local b = pcallk(function() coroutine.yield("y2"); raiseeof() end)
+      local c = await("key")
+      return a, b, c
+    end)
+    assert(co() == "y1")
+    assert(co("resumed") == "y2")
+    assert(co() == "key")
+    local a, b, c = co("value")
+    assert(a == "callk:resumed")
+    assert(b == true)
+    assert(c == "key=value")
+    `)
+
+	co, _ := L.NewThread()
+	fn := L.NewFunction(func(L *LState) int {
+		L.Push(L.GetGlobal("coroutine").(*LTable).RawGetString("yield"))
+		L.Push(LNumber(1))
+		L.Call(1, 0)
+		return 0
+	})
+	_, err, _ := L.Resume(co, fn)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "attempt to yield across a Go-call boundary"), err.Error())
+}
+
+func TestCloseThread(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfFalse(t, !L.IsYieldable(), "main thread must not be yieldable")
+	errorIfScriptFail(t, L, `
+      function coro()
+        local n = 0
+        counter = function() n = n + 1; return n end
+        coroutine.yield(1)
+        error("--unreachable--")
+      end
+      function coro_error()
+        error("--failed--")
+      end
+    `)
+	co, _ := L.NewThread()
+	st, err, _ := L.Resume(co, L.GetGlobal("coro").(*LFunction))
+	errorIfNotEqual(t, ResumeYield, st)
+	errorIfNotNil(t, err)
+	errorIfNotNil(t, L.CloseThread(co))
+	errorIfNotEqual(t, "dead", L.Status(co))
+	errorIfScriptFail(t, L, `assert(counter() == 1 and counter() == 2)`)
+	st, err, _ = L.Resume(co, L.GetGlobal("coro").(*LFunction))
+	errorIfNotEqual(t, ResumeError, st)
+	errorIfFalse(t, strings.Contains(err.Error(), "can not resume a dead thread"), err.Error())
+
+	co, _ = L.NewThread()
+	st, _, _ = L.Resume(co, L.GetGlobal("coro_error").(*LFunction))
+	errorIfNotEqual(t, ResumeError, st)
+	err = L.CloseThread(co)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "--failed--"), err.Error())
+	errorIfNotNil(t, L.CloseThread(co))
+
+	err = L.CloseThread(L)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "can not close a running coroutine"), err.Error())
+
+	yieldable := false
+	co, _ = L.NewThread()
+	L.Resume(co, L.NewFunction(func(L *LState) int {
+		yieldable = L.IsYieldable()
+		return 0
+	}))
+	errorIfFalse(t, yieldable, "coroutine must be yieldable")
+}
+
 func TestPCallAfterFail(t *testing.T) {
 	L := NewState()
 	defer L.Close()
@@ -582,6 +754,136 @@ func TestUninitializedVarAccess(t *testing.T) {
 	`)
 }
 
+func TestVariableNamesInErrors(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptNotFail(t, L, `return foo.bar`, `key 'bar' \(global 'foo'\)`)
+	errorIfScriptNotFail(t, L, `local x; return x.y`, `key 'y' \(local 'x'\)`)
+	errorIfScriptNotFail(t, L, `do local x = 1 end local t; local z = t.y`, `key 'y' \(local 't'\)`)
+	errorIfScriptNotFail(t, L, `local t = {}; t.a.b = 1`, `key 'b' \(field 'a'\)`)
+	errorIfScriptNotFail(t, L, `undefinedfn()`, `non-function object \(global 'undefinedfn'\)`)
+	errorIfScriptNotFail(t, L, `local t = {}; t:m()`, `non-function object \(method 'm'\)`)
+	errorIfScriptNotFail(t, L, `local up; return (function() return up + 1 end)()`, `add operation between nil and number \(upvalue 'up'\)`)
+	errorIfScriptNotFail(t, L, `local s = "a"; return s .. nilglobal`, `concat operation between string and nil \(global 'nilglobal'\)`)
+	errorIfScriptNotFail(t, L, `return 1 + {}`, `(?m)add operation between number and table$`)
+	errorIfScriptNotFail(t, L, `local t = {}; return t["a%d"] + 1`, `add operation between nil and number \(field 'a%d'\)`)
+}
+
+func TestApiErrorFrames(t *testing.T) {
+	L := NewState(Options{IncludeStackLocals: true})
+	defer L.Close()
+	err := L.DoString(`
+local function inner(a)
+  local b = a * 2
+  error("oops")
+end
+local function outer()
+  inner(21)
+end
+outer()
+`)
+	aerr, ok := err.(*ApiError)
+	errorIfFalse(t, ok, "expected *ApiError")
+	errorIfFalse(t, len(aerr.Frames) >= 4, "expected at least 4 frames, got %d", len(aerr.Frames))
+	errorIfNotEqual(t, StackFrameGo, aerr.Frames[0].Kind)
+	errorIfNotEqual(t, "error", aerr.Frames[0].FunctionName)
+	errorIfNotEqual(t, -1, aerr.Frames[0].Line)
+
+	fr := aerr.Frames[1]
+	errorIfNotEqual(t, StackFrameLua, fr.Kind)
+	errorIfNotEqual(t, "<string>", fr.Source)
+	errorIfNotEqual(t, 4, fr.Line)
+	errorIfNotEqual(t, "inner", fr.FunctionName)
+	errorIfNotEqual(t, 2, len(fr.Locals))
+	errorIfNotEqual(t, "a", fr.Locals[0].Name)
+	errorIfNotEqual(t, LNumber(21), fr.Locals[0].Value)
+	errorIfNotEqual(t, "b", fr.Locals[1].Name)
+	errorIfNotEqual(t, LNumber(42), fr.Locals[1].Value)
+
+	errorIfNotEqual(t, "outer", aerr.Frames[2].FunctionName)
+	errorIfNotEqual(t, 7, aerr.Frames[2].Line)
+	errorIfNotEqual(t, "main chunk", aerr.Frames[3].FunctionName)
+
+	L2 := NewState()
+	defer L2.Close()
+	err = L2.DoString(`local x = 1; error("oops")`)
+	errorIfNotEqual(t, 0, len(err.(*ApiError).Frames[1].Locals))
+}
+
+func TestErrorColumns(t *testing.T) {
+	src := "local t\nlocal x = 1 +  t.y"
+	L := NewState()
+	defer L.Close()
+	err := L.DoString(src)
+	errorIfFalse(t, strings.Contains(err.Error(), "<string>:2: attempt to index"), err.Error())
+	errorIfNotEqual(t, 16, err.(*ApiError).Frames[0].Column)
+
+	L2 := NewState(Options{IncludeErrorColumns: true})
+	defer L2.Close()
+	err = L2.DoString(src)
+	errorIfFalse(t, strings.Contains(err.Error(), "<string>:2:16: attempt to index"), err.Error())
+
+	errorIfScriptFail(t, L, `
+  local info = debug.getinfo(1, "l")
+  assert(info.currentline == 2 and info.currentcolumn == 16)
+	`)
+}
+
+func TestApiErrorCause(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("readfile", L.NewFunction(func(L *LState) int {
+		L.RaiseError("readfile: %w", io.ErrUnexpectedEOF)
+		return 0
+	}))
+	L.SetGlobal("gocall", L.NewFunction(func(L *LState) int {
+		L.Push(L.CheckFunction(1))
+		if err := L.PCall(0, 0, nil); err != nil {
+			L.Error(err.(*ApiError).Object, 0)
+		}
+		return 0
+	}))
+
+	err := L.DoString(`readfile()`)
+	errorIfFalse(t, errors.Is(err, io.ErrUnexpectedEOF), "errors.Is should find io.ErrUnexpectedEOF: %v", err)
+
+	err = L.DoString(`
+local ok, e = pcall(readfile)
+assert(not ok)
+gocall(function() error(e, 0) end)
+`)
+	errorIfFalse(t, errors.Is(err, io.ErrUnexpectedEOF), "errors.Is should find io.ErrUnexpectedEOF through pcall: %v", err)
+
+	// errors caught in previous runs do not give their causes to errors with the same messages
+	errorIfNotNil(t, L.DoString(`msg = select(2, pcall(readfile))`))
+	err = L.DoString(`error(msg, 0)`)
+	errorIfNotNil(t, errors.Unwrap(err))
+	errorIfNotNil(t, L.lastError)
+	err = L.DoString(`
+local ok, e = pcall(readfile)
+pcall(error, e, 0)
+error(e, 0)
+`)
+	errorIfFalse(t, errors.Is(err, io.ErrUnexpectedEOF), "errors.Is should find io.ErrUnexpectedEOF after a re-raise: %v", err)
+
+	// a new error with the message of the caught one is not the caught one
+	err = L.DoString(`
+local ok, e = pcall(readfile)
+error(e:sub(1, 1) .. e:sub(2), 0)
+`)
+	errorIfFalse(t, strings.HasPrefix(err.Error(), "<string>:2: readfile: unexpected EOF"), "unexpected error: %v", err)
+	errorIfNotNil(t, errors.Unwrap(err))
+
+	err = L.DoString(`error("plain")`)
+	errorIfNotNil(t, errors.Unwrap(err))
+
+	L.Push(L.NewFunction(func(L *LState) int {
+		panic(io.EOF)
+	}))
+	err = L.PCall(0, 0, nil)
+	errorIfFalse(t, errors.Is(err, io.EOF), "errors.Is should find a panicked error: %v", err)
+}
+
 func BenchmarkCallFrameStackPushPopAutoGrow(t *testing.B) {
 	stack := newAutoGrowingCallFrameStack(256)
 
@@ -711,6 +1013,8 @@ func (registryTestHandler) registryOverflow() {
 	panic("registry overflow")
 }
 
+func (registryTestHandler) registryResized() {}
+
 // test pushing and popping from the registry
 func BenchmarkRegistryPushPopAutoGrow(t *testing.B) {
 	al := newAllocator(32)
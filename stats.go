@@ -0,0 +1,103 @@
+package lua
+
+import (
+	"sync/atomic"
+)
+
+/* Stats {{{ */
+
+// Stats are the counters of the activities of an LState and the threads created by it.
+type Stats struct {
+	// Instructions is the number of the VM instructions executed. It is counted only if
+	// Options.CountInstructions is set.
+	Instructions uint64
+	// LuaCalls and GoCalls are the numbers of the calls of Lua functions and Go functions,
+	// including tail calls and calls from Go.
+	LuaCalls uint64
+	GoCalls  uint64
+	// Resumes and Yields are the numbers of the resumes and the yields of coroutines.
+	Resumes uint64
+	Yields  uint64
+	// TablesCreated is the number of the tables created by table constructors,
+	// LState.NewTable and LState.CreateTable.
+	TablesCreated uint64
+	// Errors is the number of the errors raised, including the ones caught by pcall.
+	Errors uint64
+	// RegistryHighWater is the largest number of the registry slots used by a thread, and
+	// RegistryResizes is the number of the times the registries grew.
+	RegistryHighWater int
+	RegistryResizes   uint64
+	// CallStackHighWater is the deepest call stack of a thread.
+	CallStackHighWater int
+}
+
+// Add returns the sums of the counters of s and other, and the larger ones of their high
+// water marks. It aggregates the stats of many LStates, like the ones in a pool.
+func (s Stats) Add(other Stats) Stats {
+	s.Instructions += other.Instructions
+	s.LuaCalls += other.LuaCalls
+	s.GoCalls += other.GoCalls
+	s.Resumes += other.Resumes
+	s.Yields += other.Yields
+	s.TablesCreated += other.TablesCreated
+	s.Errors += other.Errors
+	s.RegistryHighWater = intMax(s.RegistryHighWater, other.RegistryHighWater)
+	s.RegistryResizes += other.RegistryResizes
+	s.CallStackHighWater = intMax(s.CallStackHighWater, other.CallStackHighWater)
+	return s
+}
+
+// stateStats holds the counters of an LState, which are shared with the threads created by
+// it. The counters are updated atomically, so that they can be read by other goroutines.
+type stateStats struct {
+	instructions       uint64
+	luaCalls           uint64
+	goCalls            uint64
+	resumes            uint64
+	yields             uint64
+	tables             uint64
+	errors             uint64
+	registryHighWater  int64
+	registryResizes    uint64
+	callStackHighWater int64
+}
+
+// call counts the call of the function of the current frame of L.
+func (s *stateStats) call(L *LState) {
+	if L.currentFrame.Fn.IsG {
+		atomic.AddUint64(&s.goCalls, 1)
+	} else {
+		atomic.AddUint64(&s.luaCalls, 1)
+	}
+	storeMax(&s.registryHighWater, L.reg.Top())
+	storeMax(&s.callStackHighWater, L.stack.Sp())
+}
+
+func storeMax(addr *int64, value int) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if int64(value) <= old || atomic.CompareAndSwapInt64(addr, old, int64(value)) {
+			return
+		}
+	}
+}
+
+// Stats returns the counters of this LState and the threads created by it. It can be called
+// by any goroutine.
+func (ls *LState) Stats() Stats {
+	s := ls.stats
+	return Stats{
+		Instructions:       atomic.LoadUint64(&s.instructions),
+		LuaCalls:           atomic.LoadUint64(&s.luaCalls),
+		GoCalls:            atomic.LoadUint64(&s.goCalls),
+		Resumes:            atomic.LoadUint64(&s.resumes),
+		Yields:             atomic.LoadUint64(&s.yields),
+		TablesCreated:      atomic.LoadUint64(&s.tables),
+		Errors:             atomic.LoadUint64(&s.errors),
+		RegistryHighWater:  int(atomic.LoadInt64(&s.registryHighWater)),
+		RegistryResizes:    atomic.LoadUint64(&s.registryResizes),
+		CallStackHighWater: int(atomic.LoadInt64(&s.callStackHighWater)),
+	}
+}
+
+/* }}} */
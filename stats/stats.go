@@ -0,0 +1,93 @@
+// Package stats publishes the counters returned by LState.Stats through expvar or in the
+// Prometheus text format.
+package stats
+
+import (
+	"bufio"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Func returns the stats to publish by their names, like the names of tenants. It is called
+// each time the stats are read, so it should be safe to call from many goroutines.
+type Func func() map[string]lua.Stats
+
+// Publish publishes the stats returned by f as an expvar variable of the given name.
+func Publish(name string, f Func) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return f()
+	}))
+}
+
+type metric struct {
+	name  string
+	typ   string
+	help  string
+	value func(lua.Stats) uint64
+}
+
+var metrics = []metric{
+	{"instructions_total", "counter", "Number of the VM instructions executed.",
+		func(s lua.Stats) uint64 { return s.Instructions }},
+	{"lua_calls_total", "counter", "Number of the calls of Lua functions.",
+		func(s lua.Stats) uint64 { return s.LuaCalls }},
+	{"go_calls_total", "counter", "Number of the calls of Go functions.",
+		func(s lua.Stats) uint64 { return s.GoCalls }},
+	{"coroutine_resumes_total", "counter", "Number of the resumes of coroutines.",
+		func(s lua.Stats) uint64 { return s.Resumes }},
+	{"coroutine_yields_total", "counter", "Number of the yields of coroutines.",
+		func(s lua.Stats) uint64 { return s.Yields }},
+	{"tables_created_total", "counter", "Number of the tables created.",
+		func(s lua.Stats) uint64 { return s.TablesCreated }},
+	{"errors_total", "counter", "Number of the errors raised.",
+		func(s lua.Stats) uint64 { return s.Errors }},
+	{"registry_resizes_total", "counter", "Number of the times the registries grew.",
+		func(s lua.Stats) uint64 { return s.RegistryResizes }},
+	{"registry_high_water", "gauge", "Largest number of the registry slots used.",
+		func(s lua.Stats) uint64 { return uint64(s.RegistryHighWater) }},
+	{"call_stack_high_water", "gauge", "Deepest call stack.",
+		func(s lua.Stats) uint64 { return uint64(s.CallStackHighWater) }},
+}
+
+// WritePrometheus writes the stats in the Prometheus text format. The metrics are named
+// gopherlua_*, and the names of the stats are written as the values of the given label.
+func WritePrometheus(w io.Writer, label string, stats map[string]lua.Stats) error {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name := "gopherlua_" + m.name
+		bw.WriteString("# HELP " + name + " " + m.help + "\n")
+		bw.WriteString("# TYPE " + name + " " + m.typ + "\n")
+		for _, n := range names {
+			bw.WriteString(name + "{" + label + "=\"" + escapeLabel(n) + "\"} ")
+			bw.WriteString(strconv.FormatUint(m.value(stats[n]), 10) + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// Handler returns an http.Handler that serves the stats returned by f in the Prometheus text
+// format.
+func Handler(label string, f Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, label, f())
+	})
+}
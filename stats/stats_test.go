@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yuin/gopher-lua"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	stats := map[string]lua.Stats{
+		"b":             {LuaCalls: 2, CallStackHighWater: 7},
+		"a":             {LuaCalls: 1, Errors: 3},
+		"quote\"\\\nnl": {LuaCalls: 5},
+	}
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, "tenant", stats); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expected := range []string{
+		"# HELP gopherlua_lua_calls_total Number of the calls of Lua functions.\n" +
+			"# TYPE gopherlua_lua_calls_total counter\n" +
+			"gopherlua_lua_calls_total{tenant=\"a\"} 1\n" +
+			"gopherlua_lua_calls_total{tenant=\"b\"} 2\n" +
+			"gopherlua_lua_calls_total{tenant=\"quote\\\"\\\\\\nnl\"} 5\n",
+		"gopherlua_errors_total{tenant=\"a\"} 3\n",
+		"# TYPE gopherlua_call_stack_high_water gauge\n",
+		"gopherlua_call_stack_high_water{tenant=\"b\"} 7\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("%q expected in:\n%s", expected, out)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != len(metrics)*(2+len(stats)) {
+		t.Errorf("%d lines expected, but got %d", len(metrics)*(2+len(stats)), len(lines))
+	}
+
+	// the output is stable
+	for i := 0; i < 10; i++ {
+		var again bytes.Buffer
+		WritePrometheus(&again, "tenant", stats)
+		if again.String() != out {
+			t.Fatalf("the output changed:\n%s", again.String())
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	handler := Handler("state", func() map[string]lua.Stats {
+		return map[string]lua.Stats{"main": {GoCalls: 4}}
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(string(body), "gopherlua_go_calls_total{state=\"main\"} 4\n") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
@@ -0,0 +1,79 @@
+package lua
+
+import (
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	L.SetGlobal("gofunc", L.NewFunction(func(L *LState) int { return 0 }))
+	errorIfScriptFail(t, L, `
+local function depth(n)
+  if n == 0 then return 0 end
+  return 1 + depth(n - 1)
+end
+depth(50)
+for i = 1, 10 do
+  gofunc()
+end
+local co = coroutine.create(function()
+  for i = 1, 3 do coroutine.yield(i) end
+end)
+while coroutine.resume(co) do
+  if coroutine.status(co) == "dead" then break end
+end
+local t = {}
+for i = 1, 5 do t[i] = {} end
+pcall(error, "error")
+pcall(error, {})
+`)
+	stats := L.Stats()
+	errorIfFalse(t, stats.LuaCalls >= 51, "LuaCalls should be at least 51, but got %v", stats.LuaCalls)
+	errorIfFalse(t, stats.GoCalls >= 10, "GoCalls should be at least 10, but got %v", stats.GoCalls)
+	errorIfNotEqual(t, uint64(4), stats.Resumes)
+	errorIfNotEqual(t, uint64(3), stats.Yields)
+	errorIfFalse(t, stats.TablesCreated >= 7, "TablesCreated should be at least 7, but got %v", stats.TablesCreated)
+	errorIfNotEqual(t, uint64(2), stats.Errors)
+	errorIfFalse(t, stats.CallStackHighWater > 50, "CallStackHighWater should be greater than 50, but got %v", stats.CallStackHighWater)
+	errorIfFalse(t, stats.RegistryHighWater > 0, "RegistryHighWater should be greater than 0, but got %v", stats.RegistryHighWater)
+	errorIfNotEqual(t, uint64(0), stats.Instructions)
+}
+
+func TestStatsInstructions(t *testing.T) {
+	L := NewState(Options{CountInstructions: true})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+local n = 0
+for i = 1, 100 do n = n + i end
+`)
+	instructions := L.Stats().Instructions
+	errorIfFalse(t, instructions > 200, "Instructions should be greater than 200, but got %v", instructions)
+}
+
+func TestStatsThread(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	before := L.Stats()
+	co, _ := L.NewThread()
+	errorIfNotNil(t, co.DoString(`local t = {}`))
+	after := L.Stats()
+	errorIfNotEqual(t, uint64(1), after.TablesCreated-before.TablesCreated)
+	errorIfNotEqual(t, uint64(1), after.LuaCalls-before.LuaCalls)
+}
+
+func TestStatsRegistryResizes(t *testing.T) {
+	L := NewState(Options{RegistrySize: 256, RegistryMaxSize: 1024 * 64, RegistryGrowStep: 32})
+	defer L.Close()
+	for i := 0; i < 1000; i++ {
+		L.Push(LNumber(i))
+	}
+	stats := L.Stats()
+	errorIfFalse(t, stats.RegistryResizes > 0, "RegistryResizes should be greater than 0, but got %v", stats.RegistryResizes)
+}
+
+func TestStatsAdd(t *testing.T) {
+	a := Stats{LuaCalls: 1, Errors: 2, RegistryHighWater: 10, CallStackHighWater: 3}
+	b := Stats{LuaCalls: 4, Errors: 1, RegistryHighWater: 5, CallStackHighWater: 7}
+	errorIfNotEqual(t, Stats{LuaCalls: 5, Errors: 3, RegistryHighWater: 10, CallStackHighWater: 7}, a.Add(b))
+}
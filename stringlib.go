@@ -141,7 +141,9 @@ func strFormat(L *LState) int {
 		args[i-2] = L.Get(i)
 	}
 	npat := strings.Count(str, "%") - strings.Count(str, "%%")
-	L.Push(LString(fmt.Sprintf(str, args[:intMin(npat, len(args))]...)))
+	result := fmt.Sprintf(str, args[:intMin(npat, len(args))]...)
+	L.recordAlloc(stringBytes(len(result)))
+	L.Push(LString(result))
 	return 1
 }
 
@@ -161,18 +163,21 @@ func strGsub(L *LState) int {
 		L.Push(LNumber(0))
 		return 2
 	}
+	result := str
 	switch lv := repl.(type) {
 	case LString:
-		L.Push(LString(strGsubStr(L, str, string(lv), mds)))
+		result = strGsubStr(L, str, string(lv), mds)
 	case *LTable:
-		L.Push(LString(strGsubTable(L, str, lv, mds)))
+		result = strGsubTable(L, str, lv, mds)
 	case *LFunction:
-		L.Push(LString(strGsubFunc(L, str, lv, mds)))
+		result = strGsubFunc(L, str, lv, mds)
 	case LNumber:
-		L.Push(LString(strGsubStr(L, str, lv.String(), mds)))
-	case *LNilType:
-		L.Push(LString(str))
+		result = strGsubStr(L, str, lv.String(), mds)
 	}
+	if _, ok := repl.(*LNilType); !ok {
+		L.recordAlloc(stringBytes(len(result)))
+	}
+	L.Push(LString(result))
 	L.Push(LNumber(len(mds)))
 	return 2
 }
@@ -399,7 +404,9 @@ func strRep(L *LState) int {
 	if n < 0 {
 		L.Push(emptyLString)
 	} else {
-		L.Push(LString(strings.Repeat(str, n)))
+		result := strings.Repeat(str, n)
+		L.recordAlloc(stringBytes(len(result)))
+		L.Push(LString(result))
 	}
 	return 1
 }
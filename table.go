@@ -73,6 +73,7 @@ func newLTable(acap int, hcap int) *LTable {
 }
 
 func (tb *LTable) createNewArray(cap int) []LValue {
+	tb.grown(cap * valueBytes)
 	ret := make([]LValue, cap)
 	for i := 0; i < cap; i++ {
 		ret[i] = LNil
@@ -135,7 +136,11 @@ func (tb *LTable) Insert(i int, value LValue) {
 		return
 	}
 	i -= 1
+	acap := cap(tb.array)
 	tb.array = append(tb.array, LNil)
+	if cap(tb.array) != acap {
+		tb.grown(cap(tb.array) * valueBytes)
+	}
 	copy(tb.array[i+1:], tb.array[i:])
 	tb.array[i] = value
 }
@@ -296,6 +301,7 @@ func (tb *LTable) RawSetString(key string, value LValue) {
 		tb.strdict[key] = value
 		lkey := LString(key)
 		if _, ok := tb.k2i[lkey]; !ok {
+			tb.grown(2 * valueBytes)
 			tb.k2i[lkey] = len(tb.keys)
 			tb.keys = append(tb.keys, lkey)
 		}
@@ -338,6 +344,7 @@ func (tb *LTable) RawSetH(key LValue, value LValue) {
 	} else {
 		tb.dict[key] = value
 		if _, ok := tb.k2i[key]; !ok {
+			tb.grown(2 * valueBytes)
 			tb.k2i[key] = len(tb.keys)
 			tb.keys = append(tb.keys, key)
 		}
@@ -513,6 +520,7 @@ func (t *LTable) resize(nasize, nhsize int) {
 
 	// 创建新的哈希部分
 	newHash := make(map[LValue]LValue, nhsize)
+	t.grown(nhsize * 2 * valueBytes)
 
 	// 重新构造k2i, keys
 	t.k2i = map[LValue]int{}
@@ -317,6 +317,36 @@ func TestTableSort(t *testing.T) {
 	}
 }
 
+func TestTableSortComparator(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+	local t = {3, 1, 2, 5}
+	table.sort(t, function(a, b) return a > b end)
+	assert(table.concat(t, " ") == "5 3 2 1")
+
+	-- the comparator gets the holes, and the table is kept as it is if it fails
+	t = {3, 1, 2, 5}
+	t[2] = nil
+	assert(not pcall(table.sort, t, function(a, b) return a < b end))
+	assert(t[1] == 3 and t[2] == nil and t[3] == 2 and t[4] == 5)
+
+	table.sort(t, function(a, b)
+	  if a == nil or b == nil then return b == nil and a ~= nil end
+	  return a < b
+	end)
+	assert(t[1] == 2 and t[2] == 3 and t[3] == 5 and t[4] == nil)
+
+	-- the sorted elements are written back even if the comparator resizes the table
+	t = {3, 1, 2, 5}
+	table.sort(t, function(a, b)
+	  for i = 1, 16 do table.insert(t, 0) end
+	  return a < b
+	end)
+	assert(t[1] == 1 and t[2] == 2 and t[3] == 3 and t[4] == 5 and t[5] == 0)
+	`)
+}
+
 func TestTableUnpack(t *testing.T) {
 	L := NewState(Options{})
 	err := L.DoString(`
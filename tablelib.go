@@ -23,11 +23,95 @@ var tableFuncs = map[string]LGFunction{
 
 func tableSort(L *LState) int {
 	tbl := L.CheckTable(1)
-	sorter := NewLValueArraySorter(L, nil, tbl.array)
-	if L.GetTop() != 1 {
-		sorter.Fn = L.CheckFunction(2)
+	if L.GetTop() == 1 {
+		sort.Sort(NewLValueArraySorter(L, nil, tbl.array))
+		return 0
+	}
+	// sorts t[1..#t], holes included, and writes the elements back when the sort completes,
+	// so that the table is left as it is if the comparator raises an error.
+	n := intMin(tbl.Len(), len(tbl.array))
+	values := make([]LValue, n)
+	for i, v := range tbl.array[:n] {
+		if v == nil {
+			v = LNil
+		}
+		values[i] = v
+	}
+	st := &tableSortState{
+		tbl:   tbl,
+		fn:    L.CheckFunction(2),
+		src:   values,
+		dst:   make([]LValue, n),
+		width: 1,
+	}
+	st.startRun(0)
+	return tableSortMerge(L, nil, st)
+}
+
+// tableSortState is a state of the bottom-up merge sort used by table.sort with a
+// comparator. The sort can be continued by tableSortMerge, so the comparator can yield.
+type tableSortState struct {
+	tbl       *LTable
+	fn        *LFunction
+	src, dst  []LValue
+	width     int
+	mid, hi   int
+	i, j, k   int
+	comparing bool
+}
+
+func (st *tableSortState) startRun(lo int) {
+	n := len(st.src)
+	st.mid = intMin(lo+st.width, n)
+	st.hi = intMin(lo+2*st.width, n)
+	st.i, st.j, st.k = lo, st.mid, lo
+}
+
+func (st *tableSortState) take(right bool) {
+	if right {
+		st.dst[st.k] = st.src[st.j]
+		st.j++
+	} else {
+		st.dst[st.k] = st.src[st.i]
+		st.i++
+	}
+	st.k++
+}
+
+func tableSortMerge(L *LState, err error, ctx interface{}) int {
+	st := ctx.(*tableSortState)
+	if st.comparing {
+		// resumed after the comparator has yielded
+		st.comparing = false
+		st.take(LVAsBool(L.reg.Pop()))
+	}
+	n := len(st.src)
+	for st.width < n {
+		for st.k < st.hi {
+			if st.i < st.mid && st.j < st.hi {
+				L.Push(st.fn)
+				L.Push(st.src[st.j])
+				L.Push(st.src[st.i])
+				st.comparing = true
+				L.CallK(2, 1, st, tableSortMerge)
+				st.comparing = false
+				st.take(LVAsBool(L.reg.Pop()))
+			} else {
+				st.take(st.j < st.hi)
+			}
+		}
+		if st.hi < n {
+			st.startRun(st.hi)
+			continue
+		}
+		st.src, st.dst = st.dst, st.src
+		st.width *= 2
+		st.startRun(0)
+	}
+	// the comparator may have resized the table, so the elements are not copied into its array
+	for i, v := range st.src {
+		st.tbl.RawSetInt(i+1, v)
 	}
-	sort.Sort(sorter)
 	return 0
 }
 
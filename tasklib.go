@@ -0,0 +1,267 @@
+package lua
+
+import (
+	"context"
+)
+
+const lTaskClass = "task*"
+
+// OpenTask opens the `task` library that runs Lua functions concurrently in child LStates.
+// This library is not opened by OpenLibs; use PreloadModule(TaskLibName, OpenTask)
+// to make it available via require.
+//
+// task.spawn(fn, ...) runs fn(...) in a new LState on its own goroutine. The child LState
+// is created with the Options of the spawning LState and has the same modules in
+// package.preload. fn, the arguments and the results are copied between the LStates
+// like values sent over channels. If Options.MaxTaskWorkers(or lua.MaxTaskWorkers)
+// workers are running, task.spawn blocks until one of them finishes. Tasks spawned by
+// tasks count against the same limit, but task.spawn does not block in a task: the new
+// task waits on its own goroutine for a worker to become free. A task that is joining
+// another one frees its worker while it waits, so that the tasks it has spawned can run;
+// a task that is waiting on a channel does not. The Go loaders in package.preload are registered to the child LState again, but
+// the loaders bound to the spawning LState, like the one of a Scheduler, are not.
+func OpenTask(L *LState) int {
+	if L.G.tasks == nil {
+		limit := L.Options.MaxTaskWorkers
+		if limit < 1 {
+			limit = MaxTaskWorkers
+		}
+		L.G.tasks = make(chan struct{}, limit)
+	}
+	mod := L.SetFuncs(L.NewTable(), taskFuncs)
+	taskMetatable(L)
+	L.Push(mod)
+	return 1
+}
+
+// taskMetatable returns the metatable of tasks, which is created if the library has been
+// copied to L without being opened.
+func taskMetatable(L *LState) LValue {
+	if mt := L.GetTypeMetatable(lTaskClass); mt != LNil {
+		return mt
+	}
+	mt := L.NewTypeMetatable(lTaskClass)
+	mt.RawSetString("__index", mt)
+	L.SetFuncs(mt, taskMethods)
+	return mt
+}
+
+var taskFuncs = map[string]LGFunction{
+	"spawn": taskSpawn,
+}
+
+var taskMethods = map[string]LGFunction{
+	"join":    taskJoin,
+	"cancel":  taskCancel,
+	"channel": taskChannel,
+}
+
+type taskHandle struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// result is a table that holds true and the return values of the function, or
+	// false and the error object.
+	result LValue
+	ch     LChannel
+}
+
+func checkTask(L *LState, n int) *taskHandle {
+	ud := L.CheckUserData(n)
+	if t, ok := ud.Value.(*taskHandle); ok {
+		return t
+	}
+	L.ArgError(n, "task expected")
+	return nil
+}
+
+func taskSpawn(L *LState) int {
+	L.CheckFunction(1)
+	msg, err := marshalChannelValue(L, packValues(L, 1, L.GetTop()))
+	if err != nil {
+		L.ArgError(1, "can not spawn a task: "+err.Error())
+	}
+
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// a task spawned by a task waits for a worker in runTask, as blocking here would keep
+	// the worker of the parent
+	acquired := !L.G.taskWorker
+	if acquired {
+		select {
+		case L.G.tasks <- struct{}{}:
+		case <-ctx.Done():
+			raiseContextError(L)
+		}
+	}
+
+	child := NewState(L.Options)
+	child.G.tasks = L.G.tasks
+	child.G.taskWorker = true
+	childCtx, cancel := context.WithCancel(ctx)
+	child.SetContext(childCtx)
+	if err := copyPreloadedModules(L, child); err != nil {
+		if acquired {
+			<-L.G.tasks
+		}
+		cancel()
+		child.Close()
+		L.RaiseError("can not spawn a task: %v", err)
+	}
+
+	t := &taskHandle{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		ch:     LChannel(make(chan LValue, 1)),
+	}
+	in := make(chan LValue, 1)
+	in <- msg
+	go runTask(child, t, in, acquired)
+
+	ud := L.NewUserData()
+	ud.Value = t
+	L.SetMetatable(ud, taskMetatable(L))
+	L.Push(ud)
+	return 1
+}
+
+func runTask(L *LState, t *taskHandle, in chan LValue, acquired bool) {
+	defer func() {
+		t.cancel()
+		L.Close()
+		close(t.done)
+	}()
+	if !acquired {
+		select {
+		case L.G.tasks <- struct{}{}:
+		case <-L.ctx.Done():
+			L.Push(LFalse)
+			L.Push(LString(L.ctx.Err().Error()))
+			t.finish(marshalTaskResult(L, packValues(L, 1, 2)))
+			return
+		}
+	}
+	defer func() {
+		<-L.G.tasks
+	}()
+	args := unmarshalChannelValue(L, <-in).(*LTable)
+	nargs := unpackValues(L, args)
+	var result *LTable
+	if err := L.PCall(nargs-1, MultRet, nil); err != nil {
+		var errobj LValue = LString(err.Error())
+		if aerr, ok := err.(*ApiError); ok {
+			errobj = aerr.Object
+		}
+		L.SetTop(0)
+		L.Push(LFalse)
+		L.Push(errobj)
+		result = packValues(L, 1, 2)
+	} else {
+		L.Insert(LTrue, 1)
+		result = packValues(L, 1, L.GetTop())
+	}
+	t.finish(marshalTaskResult(L, result))
+}
+
+// finish makes the result available to join and the channel of t. Both get the same
+// values, which are bound to the LState that receives them first.
+func (t *taskHandle) finish(result LValue) {
+	t.result = result
+	t.ch <- result
+}
+
+func marshalTaskResult(L *LState, result *LTable) LValue {
+	v, err := marshalChannelValue(L, result)
+	if err != nil {
+		L.SetTop(0)
+		L.Push(LFalse)
+		L.Push(LString("can not return values from a task: " + err.Error()))
+		v = packValues(L, 1, 2)
+	}
+	return v
+}
+
+// packValues returns a table that holds the values from index i to j and their
+// number in the field n.
+func packValues(L *LState, i, j int) *LTable {
+	tb := L.CreateTable(j-i+1, 1)
+	for k := i; k <= j; k++ {
+		tb.RawSetInt(k-i+1, L.Get(k))
+	}
+	tb.RawSetString("n", LNumber(j-i+1))
+	return tb
+}
+
+// unpackValues pushes the values in the table packed by packValues.
+func unpackValues(L *LState, tb *LTable) int {
+	n := int(LVAsNumber(tb.RawGetString("n")))
+	for i := 1; i <= n; i++ {
+		L.Push(tb.RawGetInt(i))
+	}
+	return n
+}
+
+// copyPreloadedModules copies the package.preload table of from to to. The Go loaders are
+// registered to to again instead of being copied, and the loaders bound to from are skipped.
+func copyPreloadedModules(from, to *LState) error {
+	src, ok := from.GetField(from.GetField(from.Get(EnvironIndex), "package"), "preload").(*LTable)
+	if !ok {
+		return nil
+	}
+	dst, ok := to.GetField(to.GetField(to.Get(EnvironIndex), "package"), "preload").(*LTable)
+	if !ok {
+		return nil
+	}
+	var err error
+	src.ForEach(func(name, loader LValue) {
+		if err != nil {
+			return
+		}
+		if fn, ok := loader.(*LFunction); ok && fn.IsG {
+			if from.G.boundLoaders[fn] {
+				return
+			}
+			if len(fn.Upvalues) == 0 {
+				dst.RawSet(name, to.NewFunction(fn.GFunction))
+				return
+			}
+		}
+		var lv LValue
+		if lv, err = Transfer(from, to, loader); err == nil {
+			dst.RawSet(name, lv)
+		}
+	})
+	return err
+}
+
+func taskJoin(L *LState) int {
+	t := checkTask(L, 1)
+	if L.G.taskWorker {
+		// frees the worker while waiting, the task joined may be waiting for it
+		<-L.G.tasks
+		defer func() {
+			L.G.tasks <- struct{}{}
+		}()
+	}
+	if ctx := L.Context(); ctx != nil {
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+			raiseContextError(L)
+		}
+	} else {
+		<-t.done
+	}
+	return unpackValues(L, unmarshalChannelValue(L, t.result).(*LTable))
+}
+
+func taskCancel(L *LState) int {
+	checkTask(L, 1).cancel()
+	return 0
+}
+
+func taskChannel(L *LState) int {
+	L.Push(checkTask(L, 1).ch)
+	return 1
+}
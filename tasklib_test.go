@@ -0,0 +1,171 @@
+package lua
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTaskTestState(opts ...Options) *LState {
+	L := NewState(opts...)
+	L.PreloadModule(TaskLibName, OpenTask)
+	return L
+}
+
+func TestTaskSpawnAndJoin(t *testing.T) {
+	L := newTaskTestState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local base = 10
+    local t = task.spawn(function(a, b)
+      return a + b + base, nil, {sum = a + b}
+    end, 1, 2)
+    local ok, v, none, tbl = t:join()
+    assert(ok and v == 13 and none == nil and tbl.sum == 3)
+    assert(select("#", t:join()) == 4)
+
+    local failed = task.spawn(function() error({code = 42}) end)
+    local ok, err = failed:join()
+    assert(not ok and err.code == 42)
+
+    local nested = task.spawn(function()
+      local task = require("task")
+      return task.spawn(function() return "nested" end):join()
+    end)
+    local ok, ok2, v = nested:join()
+    assert(ok and ok2 and v == "nested")
+
+    local t = task.spawn(function() return "via channel" end)
+    local idx, result = channel.select({"|<-", t:channel()})
+    assert(result[1] == true and result[2] == "via channel" and result.n == 2)
+    `)
+	errorIfScriptNotFail(t, L, `require("task").spawn(function() end, coroutine.create(print))`, "can not transfer a thread")
+}
+
+func TestTaskCancel(t *testing.T) {
+	L := newTaskTestState()
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local t = task.spawn(function() while true do end end)
+    t:cancel()
+    local ok, err = t:join()
+    assert(not ok and string.find(err, "context canceled"), err)
+    `)
+}
+
+func TestTaskWorkerLimit(t *testing.T) {
+	L := newTaskTestState(Options{MaxTaskWorkers: 1})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local ch = channel.make()
+    local t1 = task.spawn(function(ch)
+      ch:send("started")
+      return "first"
+    end, ch)
+    assert(ch:receive())
+    -- blocks until the first worker finishes
+    local t2 = task.spawn(function() return "second" end)
+    assert(select(2, t1:join()) == "first")
+    assert(select(2, t2:join()) == "second")
+    `)
+}
+
+func TestTaskNestedWorkerLimit(t *testing.T) {
+	L := newTaskTestState(Options{MaxTaskWorkers: 1})
+	defer L.Close()
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local t = task.spawn(function()
+      local task = require("task")
+      return task.spawn(function()
+        return task.spawn(function() return "nested" end):join()
+      end):join()
+    end)
+    local ok, ok2, ok3, v = t:join()
+    assert(ok and ok2 and ok3 and v == "nested")
+    -- the worker has been released
+    assert(select(2, task.spawn(function() return "next" end):join()) == "next")
+    `)
+}
+
+func TestTaskPreloadedModules(t *testing.T) {
+	L := newTaskTestState()
+	defer L.Close()
+	NewScheduler(L)
+	L.PreloadModule("state", func(L *LState) int {
+		L.Push(L.NewFunction(func(co *LState) int {
+			co.Push(LBool(co.G == L.G))
+			return 1
+		}))
+		return 1
+	})
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local ok, sched, same = task.spawn(function()
+      return package.preload.sched ~= nil, require("state")()
+    end):join()
+    assert(ok and sched == false and same == true)
+    assert(package.preload.sched ~= nil and require("state")())
+    `)
+}
+
+func TestTaskNestedWorkerConcurrency(t *testing.T) {
+	const limit = 2
+	L := newTaskTestState(Options{MaxTaskWorkers: limit})
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	L.SetContext(ctx)
+	var running, maxRunning int32
+	L.PreloadModule("probe", func(L *LState) int {
+		L.Push(L.SetFuncs(L.NewTable(), map[string]LGFunction{
+			"work": func(L *LState) int {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return 0
+			},
+		}))
+		return 1
+	})
+	errorIfScriptFail(t, L, `
+    local task = require("task")
+    local function worker(depth)
+      local task, probe = require("task"), require("probe")
+      probe.work()
+      if depth == 0 then return 1 end
+      local children = {}
+      for i = 1, 3 do
+        children[i] = task.spawn(worker, depth - 1)
+      end
+      local n = 1
+      for _, child in ipairs(children) do
+        local ok, m = child:join()
+        assert(ok, m)
+        n = n + m
+      end
+      probe.work()
+      return n
+    end
+    local tasks = {}
+    for i = 1, 3 do
+      tasks[i] = task.spawn(worker, 2)
+    end
+    for _, t in ipairs(tasks) do
+      local ok, n = t:join()
+      assert(ok and n == 13, n)
+    end
+    `)
+	errorIfFalse(t, maxRunning <= limit, "%d tasks ran at once, the limit is %d", maxRunning, limit)
+	errorIfFalse(t, maxRunning == limit, "the tasks did not run concurrently")
+}
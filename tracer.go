@@ -0,0 +1,301 @@
+package lua
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* Tracer {{{ */
+
+// Tracer is notified of the calls of Lua functions and Go functions by an LState. Set a Tracer
+// to LStates by LState.SetTracer.
+//
+// The calls are notified in the order that they are made and return, so an OnReturn is for
+// the innermost call that has not returned yet. A function that raises an error returns with
+// the error when the error is caught, and a function that makes a tail call returns before
+// the called function is called.
+type Tracer interface {
+	// OnCall is called before a function is called. fnName is the name of the function, and
+	// source and line are the location in the innermost Lua function calling it, which are
+	// empty and 0 if there is none. args are the arguments of the call, which are valid only
+	// during OnCall.
+	OnCall(fnName, source string, line int, args []LValue)
+	// OnReturn is called after a function returns. nret is the number of the values returned,
+	// or MultRet if it is unknown, and err is the error that the function has raised.
+	OnReturn(duration time.Duration, nret int, err error)
+}
+
+// ThreadTracer is a Tracer that traces the threads created by an LState, like coroutines,
+// separately from the LState.
+type ThreadTracer interface {
+	Tracer
+	// NewThread returns the Tracer of a new thread.
+	NewThread() Tracer
+}
+
+// tracing is the calls traced in an LState.
+type tracing struct {
+	tracer Tracer
+	calls  []tracedCall
+}
+
+type tracedCall struct {
+	// depth is the number of the frames of the LState when the function is called
+	depth int
+	start time.Time
+}
+
+func newTracing(t Tracer) *tracing {
+	if t == nil {
+		return nil
+	}
+	return &tracing{tracer: t}
+}
+
+// call notifies the call of the function of cf, which has been pushed onto the stack of L but
+// not initialized yet.
+func (t *tracing) call(L *LState, cf *callFrame) {
+	depth := L.stack.Sp()
+	// the frames left without returning, like the callers of tail calls, end here
+	t.unwind(depth-1, MultRet, nil)
+	source, line := traceCallSite(cf.Parent)
+	t.tracer.OnCall(traceFuncName(L, cf), source, line, L.reg.array[cf.LocalBase:cf.LocalBase+cf.NArgs])
+	t.calls = append(t.calls, tracedCall{depth: depth, start: time.Now()})
+}
+
+// ret notifies the returns of the functions whose frames have been popped from the stack of L.
+func (t *tracing) ret(L *LState, nret int) {
+	t.unwind(L.stack.Sp(), nret, nil)
+}
+
+// unwind notifies the returns of the calls made at the depths deeper than depth.
+func (t *tracing) unwind(depth, nret int, err error) {
+	for n := len(t.calls); n > 0 && t.calls[n-1].depth > depth; n-- {
+		call := t.calls[n-1]
+		t.calls = t.calls[:n-1]
+		t.tracer.OnReturn(time.Since(call.start), nret, err)
+	}
+}
+
+// thread returns the tracing of a thread created by the LState.
+func (t *tracing) thread() *tracing {
+	if tt, ok := t.tracer.(ThreadTracer); ok {
+		return newTracing(tt.NewThread())
+	}
+	return newTracing(t.tracer)
+}
+
+func traceFuncName(L *LState, cf *callFrame) string {
+	name := profileFuncName(L, cf)
+	if cf.Fn.IsG && name == "(anonymous)" {
+		if f := runtime.FuncForPC(reflect.ValueOf(cf.Fn.GFunction).Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return name
+}
+
+// traceCallSite returns the location of the innermost Lua frame from cf.
+func traceCallSite(cf *callFrame) (string, int) {
+	for ; cf != nil; cf = cf.Parent {
+		if cf.Fn.IsG {
+			continue
+		}
+		proto := cf.Fn.Proto
+		line := 0
+		if pc := cf.Pc - 1; pc >= 0 && pc < len(proto.DbgSourcePositions) {
+			line = proto.DbgSourcePositions[pc]
+		}
+		return proto.SourceName, line
+	}
+	return "", 0
+}
+
+// SetTracer makes this LState notify t of the function calls. Threads created by this LState
+// afterwards notify t as well, or the Tracer returned by t.NewThread if t is a ThreadTracer.
+// A nil t stops tracing.
+func (ls *LState) SetTracer(t Tracer) {
+	ls.tracing = newTracing(t)
+}
+
+// Tracer returns the Tracer set by SetTracer, or nil.
+func (ls *LState) Tracer() Tracer {
+	if ls.tracing == nil {
+		return nil
+	}
+	return ls.tracing.tracer
+}
+
+/* }}} */
+
+/* ChromeTracer {{{ */
+
+// ChromeTracer is a ThreadTracer that writes the calls as Chrome trace events, which can be
+// viewed by chrome://tracing or Perfetto. Each call is a complete event with the location and
+// the arguments of the call, and each coroutine is a thread of the trace.
+//
+// A ChromeTracer traces an LState; to trace the LStates running in other goroutines into the
+// same trace, set them the Tracers returned by NewThread. Close writes the end of the trace.
+type ChromeTracer struct {
+	trace *chromeTrace
+	tid   int
+	calls []chromeCall
+}
+
+type chromeTrace struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	start   time.Time
+	threads int
+	events  int
+	closed  bool
+	err     error
+}
+
+type chromeCall struct {
+	name   string
+	source string
+	line   int
+	args   []string
+	ts     time.Duration
+}
+
+type chromeEvent struct {
+	Name string                 `json:"name"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// maxTraceArgLength is the length of the string arguments written by ChromeTracer
+const maxTraceArgLength = 64
+
+// NewChromeTracer returns a new ChromeTracer that writes the trace to w.
+func NewChromeTracer(w io.Writer) *ChromeTracer {
+	trace := &chromeTrace{w: bufio.NewWriter(w), start: time.Now()}
+	trace.write("{\"traceEvents\":[\n")
+	return trace.newThread()
+}
+
+func (t *chromeTrace) newThread() *ChromeTracer {
+	t.mu.Lock()
+	t.threads++
+	tid := t.threads
+	t.mu.Unlock()
+	name := "main"
+	if tid > 1 {
+		name = "coroutine " + strconv.Itoa(tid-1)
+	}
+	t.event(&chromeEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: tid,
+		Args: map[string]interface{}{"name": name}})
+	return &ChromeTracer{trace: t, tid: tid}
+}
+
+func (t *chromeTrace) write(s string) {
+	if t.err == nil {
+		_, t.err = t.w.WriteString(s)
+	}
+}
+
+func (t *chromeTrace) event(ev *chromeEvent) {
+	data, err := json.Marshal(ev)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return
+	}
+	if t.events > 0 {
+		t.write(",\n")
+	}
+	t.events++
+	t.write(string(data))
+}
+
+// NewThread returns a ChromeTracer that writes to the same trace as a new thread.
+func (t *ChromeTracer) NewThread() Tracer {
+	return t.trace.newThread()
+}
+
+// OnCall implements Tracer.
+func (t *ChromeTracer) OnCall(fnName, source string, line int, args []LValue) {
+	call := chromeCall{name: fnName, source: source, line: line, ts: time.Since(t.trace.start)}
+	for _, arg := range args {
+		call.args = append(call.args, traceArgString(arg))
+	}
+	t.calls = append(t.calls, call)
+}
+
+// OnReturn implements Tracer.
+func (t *ChromeTracer) OnReturn(duration time.Duration, nret int, err error) {
+	n := len(t.calls)
+	if n == 0 {
+		return
+	}
+	call := t.calls[n-1]
+	t.calls = t.calls[:n-1]
+	args := map[string]interface{}{}
+	if call.source != "" {
+		args["source"] = call.source + ":" + strconv.Itoa(call.line)
+	}
+	if call.args != nil {
+		args["args"] = call.args
+	}
+	if nret != MultRet {
+		args["nret"] = nret
+	}
+	if err != nil {
+		args["error"] = err.Error()
+	}
+	t.trace.event(&chromeEvent{
+		Name: call.name,
+		Ph:   "X",
+		Ts:   float64(call.ts) / float64(time.Microsecond),
+		Dur:  float64(duration) / float64(time.Microsecond),
+		Pid:  1,
+		Tid:  t.tid,
+		Args: args,
+	})
+}
+
+// Close writes the end of the trace. The calls that return afterwards are not written.
+func (t *ChromeTracer) Close() error {
+	trace := t.trace
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	if trace.closed {
+		return trace.err
+	}
+	trace.closed = true
+	trace.write("\n],\"displayTimeUnit\":\"ms\"}\n")
+	if trace.err == nil {
+		trace.err = trace.w.Flush()
+	}
+	return trace.err
+}
+
+func traceArgString(lv LValue) string {
+	if s, ok := lv.(LString); ok {
+		if len(s) > maxTraceArgLength {
+			return strconv.Quote(string(s[:maxTraceArgLength])) + "..."
+		}
+		return strconv.Quote(string(s))
+	}
+	return lv.String()
+}
+
+/* }}} */
@@ -0,0 +1,174 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTracer records the calls as lines like "call name source:line args" and
+// "return name nret err", indented by the depths of the calls.
+type testTracer struct {
+	lines []string
+	names []string
+}
+
+func newTestTracer() *testTracer {
+	return &testTracer{}
+}
+
+func (t *testTracer) OnCall(fnName, source string, line int, args []LValue) {
+	strs := make([]string, 0, len(args))
+	for _, arg := range args {
+		strs = append(strs, arg.String())
+	}
+	t.lines = append(t.lines, fmt.Sprintf("%vcall %v %v:%v [%v]", strings.Repeat("  ", len(t.names)), fnName, source, line, strings.Join(strs, " ")))
+	t.names = append(t.names, fnName)
+}
+
+func (t *testTracer) OnReturn(duration time.Duration, nret int, err error) {
+	name := t.names[len(t.names)-1]
+	t.names = t.names[:len(t.names)-1]
+	t.lines = append(t.lines, fmt.Sprintf("%vreturn %v %v %v", strings.Repeat("  ", len(t.names)), name, nret, err != nil))
+}
+
+func (t *testTracer) String() string {
+	return strings.Join(t.lines, "\n")
+}
+
+func TestTracer(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	tracer := newTestTracer()
+	L.SetTracer(tracer)
+	errorIfNotEqual(t, Tracer(tracer), L.Tracer())
+	L.SetGlobal("double", L.NewFunction(func(L *LState) int {
+		L.Push(LNumber(L.CheckInt(1) * 2))
+		return 1
+	}))
+	errorIfScriptFail(t, L, `
+local function f(a, b)
+  return double(a) + b
+end
+local function g()
+  return f(1, 2)
+end
+g()
+pcall(function()
+  double("x")
+end)
+`)
+	L.SetTracer(nil)
+	errorIfNotNil(t, L.Tracer())
+	expected := `call main chunk :0 []
+  call g <string>:8 []
+  return g -1 false
+  call anonymous@<string>:2 <string>:8 [1 2]
+    call double <string>:3 [1]
+    return double 1 false
+  return anonymous@<string>:2 1 false
+  call pcall <string>:9 [function: %p]
+    call anonymous@<string>:9 <string>:9 []
+      call double <string>:10 [x]
+      return double -1 true
+    return anonymous@<string>:9 -1 true
+  return pcall 2 false
+return main chunk 0 false`
+	lines := strings.Split(tracer.String(), "\n")
+	for i, line := range strings.Split(expected, "\n") {
+		if i >= len(lines) {
+			t.Fatalf("missing lines:\n%v", tracer)
+		}
+		if strings.Contains(line, "%p") {
+			line = line[:strings.Index(line, "%p")]
+		}
+		errorIfFalse(t, strings.HasPrefix(lines[i], line), "%q expected, but got %q", line, lines[i])
+	}
+	errorIfNotEqual(t, len(strings.Split(expected, "\n")), len(lines))
+}
+
+type testThreadTracer struct {
+	*testTracer
+	threads []*testTracer
+}
+
+func (t *testThreadTracer) NewThread() Tracer {
+	tracer := newTestTracer()
+	t.threads = append(t.threads, tracer)
+	return tracer
+}
+
+func TestTracerCoroutine(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	tracer := &testThreadTracer{testTracer: newTestTracer()}
+	L.SetTracer(tracer)
+	errorIfScriptFail(t, L, `
+local co = coroutine.create(function(a)
+  coroutine.yield(a)
+  error("error")
+end)
+coroutine.resume(co, 1)
+coroutine.resume(co)
+`)
+	errorIfNotEqual(t, 1, len(tracer.threads))
+	errorIfNotEqual(t, `call anonymous@<string>:2 :0 [1]
+  call yield <string>:3 [1]
+  return yield 1 false
+  call error <string>:4 [error]
+  return error -1 true
+return anonymous@<string>:2 -1 true`, tracer.threads[0].String())
+	errorIfFalse(t, strings.Contains(tracer.String(), "call resume <string>:6 [thread: "), "%v", tracer)
+	errorIfNotEqual(t, 0, len(tracer.names))
+}
+
+func TestChromeTracer(t *testing.T) {
+	L := NewState()
+	defer L.Close()
+	var buf bytes.Buffer
+	tracer := NewChromeTracer(&buf)
+	L.SetTracer(tracer)
+	errorIfScriptFail(t, L, `
+local function f(s)
+  return s:upper()
+end
+f("abc")
+local co = coroutine.wrap(function() coroutine.yield() end)
+co()
+`)
+	errorIfNotNil(t, tracer.Close())
+	errorIfNotNil(t, tracer.Close())
+
+	var trace struct {
+		TraceEvents []struct {
+			Name string                 `json:"name"`
+			Ph   string                 `json:"ph"`
+			Tid  int                    `json:"tid"`
+			Args map[string]interface{} `json:"args"`
+		} `json:"traceEvents"`
+	}
+	errorIfNotNil(t, json.Unmarshal(buf.Bytes(), &trace))
+	events := map[string]int{}
+	for _, ev := range trace.TraceEvents {
+		switch ev.Ph {
+		case "M":
+			events[ev.Args["name"].(string)] = ev.Tid
+		case "X":
+			events[ev.Name] = ev.Tid
+			if ev.Name == "f" {
+				errorIfNotEqual(t, "<string>:5", ev.Args["source"])
+				errorIfNotEqual(t, `["abc"]`, fmt.Sprint(ev.Args["args"]))
+				errorIfNotEqual(t, float64(1), ev.Args["nret"])
+			}
+		}
+	}
+	errorIfNotEqual(t, 1, events["main"])
+	errorIfNotEqual(t, 2, events["coroutine 1"])
+	for _, name := range []string{"main chunk", "f", "upper", "wrap", "co"} {
+		errorIfNotEqual(t, 1, events[name])
+	}
+	errorIfNotEqual(t, 2, events["yield"])
+}
@@ -0,0 +1,217 @@
+package lua
+
+import (
+	"fmt"
+)
+
+// TransferHook copies the value of a userdata for Transfer. It must return a value that
+// does not share mutable state with the original value.
+type TransferHook func(value interface{}) (interface{}, error)
+
+// SetTransferHook allows userdata whose metatable is the type metatable typ to be copied
+// by Transfer and sent over channels. hook copies the value of the userdata, and the copy
+// gets the type metatable typ of the receiving LState.
+func (ls *LState) SetTransferHook(typ string, hook TransferHook) {
+	if ls.G.transferHooks == nil {
+		ls.G.transferHooks = make(map[string]TransferHook)
+	}
+	ls.G.transferHooks[typ] = hook
+}
+
+// Transfer copies v of the LState from to the LState to, so the copy can be used by
+// another goroutine that runs to. Tables are copied deeply with their metatables,
+// preserving cycles and shared references. Lua functions are rebuilt from their
+// FunctionProto with copied upvalues; functions whose environment is the global table
+// of from get the global table of to. Userdata can be copied only if its type has
+// a TransferHook. Threads can not be copied.
+func Transfer(from, to *LState, v LValue) (LValue, error) {
+	tr := newTransferer(from, to)
+	return tr.copy(v)
+}
+
+type transferer struct {
+	from     *LState
+	to       *LState
+	seen     map[LValue]LValue
+	upvalues map[*Upvalue]*Upvalue
+}
+
+func newTransferer(from, to *LState) *transferer {
+	return &transferer{
+		from:     from,
+		to:       to,
+		seen:     make(map[LValue]LValue),
+		upvalues: make(map[*Upvalue]*Upvalue),
+	}
+}
+
+// detachedUserData is a userdata value copied for a channel that has not been received
+// by an LState yet.
+type detachedUserData struct {
+	typ   string
+	value interface{}
+}
+
+func (tr *transferer) copy(v LValue) (LValue, error) {
+	switch lv := v.(type) {
+	case *LTable:
+		return tr.copyTable(lv)
+	case *LFunction:
+		return tr.copyFunction(lv)
+	case *LUserData:
+		return tr.copyUserData(lv)
+	case *LState:
+		return nil, fmt.Errorf("can not transfer a thread")
+	default:
+		// nil, booleans, numbers, strings and channels are immutable or goroutine safe
+		return v, nil
+	}
+}
+
+func (tr *transferer) copyTable(tb *LTable) (LValue, error) {
+	if cp, ok := tr.seen[tb]; ok {
+		return cp, nil
+	}
+	newtb := newLTable(len(tb.array), 0)
+	tr.seen[tb] = newtb
+	var err error
+	tb.ForEach(func(key, value LValue) {
+		if err != nil {
+			return
+		}
+		var k, v LValue
+		if k, err = tr.copy(key); err != nil {
+			return
+		}
+		if v, err = tr.copy(value); err != nil {
+			return
+		}
+		newtb.RawSet(k, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tb.Metatable != LNil {
+		mt, err := tr.copy(tb.Metatable)
+		if err != nil {
+			return nil, err
+		}
+		newtb.Metatable = mt
+	}
+	return newtb, nil
+}
+
+func (tr *transferer) copyFunction(fn *LFunction) (LValue, error) {
+	if cp, ok := tr.seen[fn]; ok {
+		return cp, nil
+	}
+	var newfn *LFunction
+	if fn.IsG {
+		newfn = newLFunctionG(fn.GFunction, nil, len(fn.Upvalues))
+	} else {
+		newfn = newLFunctionL(fn.Proto, nil, len(fn.Upvalues))
+	}
+	tr.seen[fn] = newfn
+	if fn.Env != tr.from.G.Global {
+		env, err := tr.copy(fn.Env)
+		if err != nil {
+			return nil, err
+		}
+		newfn.Env = env.(*LTable)
+	} else if tr.to != nil {
+		newfn.Env = tr.to.G.Global
+	}
+	for i, uv := range fn.Upvalues {
+		if uv == nil {
+			continue
+		}
+		if cp, ok := tr.upvalues[uv]; ok {
+			newfn.Upvalues[i] = cp
+			continue
+		}
+		newuv := &Upvalue{closed: true}
+		tr.upvalues[uv] = newuv
+		value, err := tr.copy(uv.Value())
+		if err != nil {
+			return nil, err
+		}
+		newuv.value = value
+		newfn.Upvalues[i] = newuv
+	}
+	return newfn, nil
+}
+
+func (tr *transferer) copyUserData(ud *LUserData) (LValue, error) {
+	if cp, ok := tr.seen[ud]; ok {
+		return cp, nil
+	}
+	for typ, hook := range tr.from.G.transferHooks {
+		if ud.Metatable == LNil || tr.from.GetTypeMetatable(typ) != ud.Metatable {
+			continue
+		}
+		value, err := hook(ud.Value)
+		if err != nil {
+			return nil, err
+		}
+		newud := &LUserData{Metatable: LNil}
+		if tr.to != nil {
+			newud.Value = value
+			newud.Env = tr.to.G.Global
+			newud.Metatable = tr.to.GetTypeMetatable(typ)
+		} else {
+			newud.Value = &detachedUserData{typ, value}
+		}
+		tr.seen[ud] = newud
+		return newud, nil
+	}
+	return nil, fmt.Errorf("can not transfer a userdata")
+}
+
+// marshalChannelValue copies v of L to be sent over a channel. Functions and userdata
+// in the copy are bound to the receiving LState by unmarshalChannelValue.
+func marshalChannelValue(L *LState, v LValue) (LValue, error) {
+	return newTransferer(L, nil).copy(v)
+}
+
+// unmarshalChannelValue binds functions and userdata in v received from a channel to L.
+func unmarshalChannelValue(L *LState, v LValue) LValue {
+	switch v.(type) {
+	case *LTable, *LFunction, *LUserData:
+		bindTransferred(L, v, make(map[LValue]struct{}))
+	}
+	return v
+}
+
+func bindTransferred(L *LState, v LValue, seen map[LValue]struct{}) {
+	if _, ok := seen[v]; ok {
+		return
+	}
+	switch lv := v.(type) {
+	case *LTable:
+		seen[v] = struct{}{}
+		lv.ForEach(func(key, value LValue) {
+			bindTransferred(L, key, seen)
+			bindTransferred(L, value, seen)
+		})
+		bindTransferred(L, lv.Metatable, seen)
+	case *LFunction:
+		seen[v] = struct{}{}
+		if lv.Env == nil {
+			lv.Env = L.G.Global
+		} else {
+			bindTransferred(L, lv.Env, seen)
+		}
+		for _, uv := range lv.Upvalues {
+			if uv != nil && uv.IsClosed() {
+				bindTransferred(L, uv.Value(), seen)
+			}
+		}
+	case *LUserData:
+		seen[v] = struct{}{}
+		if d, ok := lv.Value.(*detachedUserData); ok {
+			lv.Value = d.value
+			lv.Env = L.G.Global
+			lv.Metatable = L.GetTypeMetatable(d.typ)
+		}
+	}
+}
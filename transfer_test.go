@@ -0,0 +1,102 @@
+package lua
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	from := NewState()
+	defer from.Close()
+	to := NewState()
+	defer to.Close()
+	errorIfScriptFail(t, from, `
+    local shared = {1, 2, 3}
+    value = {a = shared, b = shared, name = "value"}
+    value.self = value
+    local n = 10
+    value.inc = function() n = n + 1; return n end
+    value.get = function() return n end
+    value.globals = function() return who end
+    setmetatable(value, {__index = function(t, k) return "missing " .. k end})
+    who = "from"
+    `)
+	v, err := Transfer(from, to, from.GetGlobal("value"))
+	errorIfNotNil(t, err)
+	to.SetGlobal("value", v)
+	to.SetGlobal("who", LString("to"))
+	errorIfScriptFail(t, to, `
+    assert(value.a == value.b and value.a[3] == 3)
+    assert(value.self == value)
+    assert(value.inc() == 11 and value.get() == 11)
+    assert(value.globals() == "to")
+    assert(value.foo == "missing foo")
+    value.a[1] = 100
+    `)
+	errorIfScriptFail(t, from, `
+    assert(value.a[1] == 1)
+    assert(value.get() == 10)
+    `)
+
+	co, _ := from.NewThread()
+	_, err = Transfer(from, to, co)
+	errorIfNil(t, err)
+	errorIfFalse(t, strings.Contains(err.Error(), "can not transfer a thread"), err.Error())
+}
+
+type transferPoint struct{ x, y int }
+
+func TestTransferHook(t *testing.T) {
+	newState := func() *LState {
+		L := NewState()
+		mt := L.NewTypeMetatable("point")
+		mt.RawSetString("__index", L.NewFunction(func(L *LState) int {
+			p := L.CheckUserData(1).Value.(*transferPoint)
+			L.Push(LNumber(map[string]int{"x": p.x, "y": p.y}[L.CheckString(2)]))
+			return 1
+		}))
+		L.SetTransferHook("point", func(value interface{}) (interface{}, error) {
+			p := *value.(*transferPoint)
+			return &p, nil
+		})
+		return L
+	}
+	from := newState()
+	defer from.Close()
+	to := newState()
+	defer to.Close()
+	ud := from.NewUserData()
+	ud.Value = &transferPoint{1, 2}
+	from.SetMetatable(ud, from.GetTypeMetatable("point"))
+	v, err := Transfer(from, to, ud)
+	errorIfNotNil(t, err)
+	copied := v.(*LUserData)
+	errorIfNotEqual(t, to.GetTypeMetatable("point"), copied.Metatable)
+	errorIfFalse(t, copied.Value != ud.Value, "userdata value must be copied")
+
+	plain := from.NewUserData()
+	_, err = Transfer(from, to, plain)
+	errorIfNil(t, err)
+
+	var wg sync.WaitGroup
+	ch := make(chan LValue, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		to.SetGlobal("ch", LChannel(ch))
+		errorIfScriptFail(t, to, `
+        local ok, msg = ch:receive()
+        assert(ok)
+        assert(msg.point.x == 1 and msg.point.y == 2)
+        assert(msg.greet("to") == "hello to")
+        `)
+	}()
+	from.SetGlobal("ch", LChannel(ch))
+	from.SetGlobal("pt", ud)
+	errorIfScriptFail(t, from, `
+    local prefix = "hello "
+    ch:send({point = pt, greet = function(name) return prefix .. name end})
+    `)
+	wg.Wait()
+}
@@ -178,17 +178,6 @@ func popenArgs(arg string) (string, []string) {
 	return cmd, args
 }
 
-func isGoroutineSafe(lv LValue) bool {
-	switch v := lv.(type) {
-	case *LFunction, *LUserData, *LState:
-		return false
-	case *LTable:
-		return v.Metatable == LNil
-	default:
-		return true
-	}
-}
-
 func readBufioSize(reader *bufio.Reader, size int64) ([]byte, error, bool) {
 	result := []byte{}
 	read := int64(0)
@@ -275,3 +264,16 @@ func unsafeFastStringToReadOnlyBytes(s string) (bs []byte) {
 	bh.Len = sh.Len
 	return
 }
+
+// sameLValue reports whether lhs and rhs are the same value. Unlike ==, strings are the same
+// only if they share their bytes, so a string built elsewhere with the same contents is not.
+func sameLValue(lhs, rhs LValue) bool {
+	ls, ok1 := lhs.(LString)
+	rs, ok2 := rhs.(LString)
+	if !ok1 || !ok2 {
+		return lhs == rhs
+	}
+	lh := (*reflect.StringHeader)(unsafe.Pointer(&ls))
+	rh := (*reflect.StringHeader)(unsafe.Pointer(&rs))
+	return lh.Data == rh.Data && lh.Len == rh.Len
+}
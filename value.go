@@ -150,6 +150,9 @@ type LTable struct {
 	k2i     map[LValue]int
 
 	pairsHashFlag bool
+	// allocG is the Global that the growth of the table is recorded to, if the table is
+	// created by an LState with an AllocProfile
+	allocG *Global
 }
 
 func (tb *LTable) String() string   { return fmt.Sprintf("table: %p", tb) }
@@ -164,6 +167,12 @@ type LFunction struct {
 }
 type LGFunction func(*LState) int
 
+// LKFunction is a continuation of a Go function. It is called instead of returning to
+// the Go function when a function called by CallK or PCallK, or the Go function itself
+// (see LState.YieldK), resumes after the coroutine has yielded. err is an error caught by
+// PCallK and ctx is the value passed to CallK, PCallK or YieldK.
+type LKFunction func(L *LState, err error, ctx interface{}) int
+
 func (fn *LFunction) String() string   { return fmt.Sprintf("function: %p", fn) }
 func (fn *LFunction) Type() LValueType { return LTFunction }
 
@@ -173,9 +182,15 @@ type Global struct {
 	Registry      *LTable
 	Global        *LTable
 
-	builtinMts map[int]LValue
-	tempFiles  []*os.File
-	gccount    int32
+	builtinMts    map[int]LValue
+	tempFiles     []*os.File
+	gccount       int32
+	transferHooks map[string]TransferHook
+	tasks         chan struct{}
+	// taskWorker is whether the LState runs a task spawned by the task library
+	taskWorker bool
+	// boundLoaders are the loaders in package.preload that are bound to the LState
+	boundLoaders map[*LFunction]bool
 }
 
 type LState struct {
@@ -196,7 +211,20 @@ type LState struct {
 	hasErrorFunc bool
 	mainLoop     func(*LState, *callFrame)
 	ctx          context.Context
+	coverage     *Coverage
+	// coverProto and coverCounts cache the hit counts of the function that was executed last
+	coverProto   *FunctionProto
+	coverCounts  []uint64
+	profiler     *profiler
+	allocProfile *AllocProfile
+	stats        *stateStats
+	tracing      *tracing
 	ctxCancelFn  context.CancelFunc
+	errCause     error
+	lastError    *ApiError
+	nny          int
+	yieldTop     int
+	exitError    *ApiError
 }
 
 func (ls *LState) String() string   { return fmt.Sprintf("thread: %p", ls) }
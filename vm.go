@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"sync/atomic"
 )
 
 func mainLoop(L *LState, baseframe *callFrame) {
@@ -64,6 +65,55 @@ func mainLoopWithContext(L *LState, baseframe *callFrame) {
 	}
 }
 
+// mainLoopWithHooks is the main loop for the LStates that record coverage, profiles or
+// allocations, or count instructions. It also checks the context if the LState has one.
+func mainLoopWithHooks(L *LState, baseframe *callFrame) {
+	var inst uint32
+	var cf *callFrame
+
+	if L.stack.IsEmpty() {
+		return
+	}
+	if L.profiler != nil && L.Parent == nil && L.stack.Sp() == 1 {
+		L.profiler.reset()
+	}
+
+	L.currentFrame = L.stack.Last()
+	if L.currentFrame.Fn.IsG {
+		callGFunction(L, false)
+		return
+	}
+
+	for {
+		cf = L.currentFrame
+		inst = cf.Fn.Proto.Code[cf.Pc]
+		if L.coverage != nil {
+			L.coverage.hit(L, cf.Fn.Proto, cf.Pc)
+		}
+		if L.Options.CountInstructions {
+			atomic.AddUint64(&L.stats.instructions, 1)
+		}
+		cf.Pc++
+		if L.profiler != nil && L.profiler.pending() {
+			L.profiler.sample(L)
+		}
+		if L.ctx != nil {
+			select {
+			case <-L.ctx.Done():
+				L.RaiseError(L.ctx.Err().Error())
+				return
+			default:
+			}
+		}
+		if jumpTable[int(inst>>26)](L, inst, baseframe) == 1 {
+			return
+		}
+		if L.allocProfile != nil {
+			L.allocProfile.instruction(L, cf, inst)
+		}
+	}
+}
+
 // regv is the first target register to copy the return values to.
 // It can be reg.top, indicating that the copied values are going into new registers, or it can be below reg.top
 // Indicating that the values should be within the existing registers.
@@ -173,7 +223,7 @@ func copyReturnValues(L *LState, regv, start, n, b int) { // +inline-start
 	}
 } // +inline-end
 
-func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
+func moveToParentThread(L *LState, nargs int, haserror bool) {
 	parent := L.Parent
 	if parent == nil {
 		L.RaiseError("can not yield from outside of a coroutine")
@@ -188,7 +238,14 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 		}
 	}
 	L.XMoveTo(parent, nargs)
+}
+
+func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
+	moveToParentThread(L, nargs, haserror)
 	L.stack.Pop()
+	if L.tracing != nil {
+		L.tracing.ret(L, nargs)
+	}
 	offset := L.currentFrame.LocalBase - L.currentFrame.ReturnBase
 	L.currentFrame = L.stack.Last()
 	L.reg.SetTop(L.reg.Top() - offset) // remove 'yield' function(including tailcalled functions)
@@ -197,15 +254,44 @@ func switchToParentThread(L *LState, nargs int, haserror bool, kill bool) {
 	}
 }
 
+// threadYield is a panic value that unwinds Go function calls when a coroutine yields
+// inside functions called by CallK or PCallK, or metamethods.
+type threadYield struct {
+	L *LState
+}
+
+// yieldThread suspends the coroutine and passes nargs values to the parent thread.
+func yieldThread(L *LState, nargs int) {
+	unwind := L.checkYield()
+	atomic.AddUint64(&L.stats.yields, 1)
+	cf := L.currentFrame
+	if cf.continuation() != nil {
+		// yielded by YieldK, the values passed to the resume become the arguments of the continuation.
+		moveToParentThread(L, nargs, false)
+		L.reg.SetTop(cf.LocalBase)
+	} else {
+		if cf.NRet != MultRet {
+			L.yieldTop = cf.ReturnBase + cf.NRet
+		}
+		switchToParentThread(L, nargs, false, false)
+	}
+	if unwind {
+		panic(&threadYield{L})
+	}
+}
+
 func callGFunction(L *LState, tailcall bool) bool {
 	frame := L.currentFrame
 	gfnret := frame.Fn.GFunction(L)
+	if L.profiler != nil && L.profiler.pending() {
+		L.profiler.sample(L)
+	}
 	if tailcall {
 		L.currentFrame = L.RemoveCallerFrame()
 	}
 
 	if gfnret < 0 {
-		switchToParentThread(L, L.GetTop(), false, false)
+		yieldThread(L, L.GetTop())
 		return true
 	}
 
@@ -214,7 +300,7 @@ func callGFunction(L *LState, tailcall bool) bool {
 		wantret = gfnret
 	}
 
-	if tailcall && L.Parent != nil && L.stack.Sp() == 1 {
+	if L.Parent != nil && L.stack.Sp() == 1 {
 		switchToParentThread(L, wantret, false, true)
 		return true
 	}
@@ -260,22 +346,157 @@ func callGFunction(L *LState, tailcall bool) bool {
 		}
 	}
 	L.stack.Pop()
+	if L.tracing != nil {
+		L.tracing.ret(L, gfnret)
+	}
 	L.currentFrame = L.stack.Last()
 	return false
 }
 
+// finishGFunction calls the continuation of the current Go function frame, and returns from
+// the frame like callGFunction.
+func finishGFunction(L *LState) bool {
+	frame := L.currentFrame
+	cont := frame.Cont
+	k, ctx, err := cont.Fn, cont.Ctx, cont.Err
+	*cont = callContinuation{}
+	gfnret := k(L, err, ctx)
+	if parent := frame.Parent; parent != nil && !parent.Fn.IsG && opGetOpCode(parent.Fn.Proto.Code[parent.Pc-1]) == OP_TAILCALL {
+		L.currentFrame = L.RemoveCallerFrame()
+	}
+
+	if gfnret < 0 {
+		yieldThread(L, L.GetTop())
+		return true
+	}
+
+	wantret := frame.NRet
+	if wantret == MultRet {
+		wantret = gfnret
+	}
+
+	if L.Parent != nil && L.stack.Sp() == 1 {
+		switchToParentThread(L, wantret, false, true)
+		return true
+	}
+
+	L.reg.CopyRange(frame.ReturnBase, L.reg.Top()-gfnret, -1, wantret)
+	L.stack.Pop()
+	if L.tracing != nil {
+		L.tracing.ret(L, gfnret)
+	}
+	L.currentFrame = L.stack.Last()
+	if cf := L.currentFrame; !cf.Fn.IsG && opGetOpCode(cf.Fn.Proto.Code[cf.Pc-1]) != OP_CALL {
+		finishOp(L)
+	}
+	return false
+}
+
+// opIsFinishable returns true if the instruction can be continued by finishOp after a
+// metamethod or an iterator function called by the instruction yields.
+func opIsFinishable(op int) bool {
+	switch op {
+	case OP_GETGLOBAL, OP_GETTABLE, OP_GETTABLEKS, OP_SELF,
+		OP_SETGLOBAL, OP_SETTABLE, OP_SETTABLEKS,
+		OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW, OP_UNM, OP_LEN,
+		OP_EQ, OP_LT, OP_LE, OP_TFORLOOP:
+		return true
+	}
+	return false
+}
+
+// finishOp completes the instruction of the current frame that has been interrupted by
+// a yield in a metamethod or an iterator function. The return value of the called function
+// is at the top of the registry.
+func finishOp(L *LState) {
+	reg := L.reg
+	cf := L.currentFrame
+	lbase := cf.LocalBase
+	inst := cf.Fn.Proto.Code[cf.Pc-1]
+	A := int(inst>>18) & 0xff //GETA
+	RA := lbase + A
+	switch int(inst >> 26) {
+	case OP_GETGLOBAL, OP_GETTABLE, OP_GETTABLEKS,
+		OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW, OP_UNM, OP_LEN:
+		reg.Set(RA, reg.Pop())
+	case OP_SELF:
+		B := int(inst & 0x1ff) //GETB
+		selfobj := reg.Get(lbase + B)
+		reg.Set(RA, reg.Pop())
+		reg.Set(RA+1, selfobj)
+	case OP_EQ, OP_LT, OP_LE:
+		v := 1
+		if LVAsBool(reg.Pop()) {
+			v = 0
+		}
+		if v == A {
+			cf.Pc++
+		}
+	case OP_TFORLOOP:
+		if value := reg.Get(RA + 3); value != LNil {
+			reg.Set(RA+2, value)
+			pc := cf.Fn.Proto.Code[cf.Pc]
+			cf.Pc += int(pc&0x3ffff) - opMaxArgSbx
+		}
+		cf.Pc++
+	}
+}
+
+// unroll runs the coroutine. If an error occurs after the coroutine has been resumed inside
+// PCallK, the error is returned to be recovered by recoverSuspendedPCall.
+func unroll(L *LState) (rcv interface{}) {
+	defer func() {
+		if rcv = recover(); rcv != nil {
+			if _, ok := rcv.(*threadYield); ok || L.suspendedPCallFrame() == nil {
+				panic(rcv)
+			}
+		}
+	}()
+	for L.Parent != nil && L.currentFrame != nil {
+		if L.currentFrame.continuation() != nil {
+			if finishGFunction(L) {
+				break
+			}
+		} else {
+			L.mainLoop(L, nil)
+		}
+	}
+	return nil
+}
+
 func threadRun(L *LState) {
 	if L.stack.IsEmpty() {
 		return
 	}
+	atomic.AddUint64(&L.stats.resumes, 1)
+	if L.yieldTop > 0 {
+		// adjust the number of values returned by the yield
+		for L.reg.Top() < L.yieldTop {
+			L.reg.Push(LNil)
+		}
+		L.reg.SetTop(L.yieldTop)
+		L.yieldTop = 0
+	}
+	if cf := L.currentFrame; !cf.Fn.IsG && cf.Pc > 0 && opGetOpCode(cf.Fn.Proto.Code[cf.Pc-1]) != OP_CALL {
+		// the coroutine has yielded in a metamethod
+		finishOp(L)
+	}
 
 	defer func() {
 		if rcv := recover(); rcv != nil {
+			if yield, ok := rcv.(*threadYield); ok && yield.L == L {
+				return
+			}
 			var lv LValue
 			if v, ok := rcv.(*ApiError); ok {
 				lv = v.Object
+				L.exitError = v
 			} else {
 				lv = LString(fmt.Sprint(rcv))
+				L.exitError = newApiError(ApiErrorRun, lv)
+			}
+			if L.tracing != nil {
+				L.tracing.unwind(0, MultRet, L.exitError)
 			}
 			if parent := L.Parent; parent != nil {
 				if L.wrapped {
@@ -291,7 +512,9 @@ func threadRun(L *LState) {
 			}
 		}
 	}()
-	L.mainLoop(L, nil)
+	for rcv := unroll(L); rcv != nil; rcv = unroll(L) {
+		L.recoverSuspendedPCall(L.suspendedPCallFrame(), rcv)
+	}
 }
 
 type instFunc func(*LState, uint32, *callFrame) int
@@ -682,6 +905,7 @@ func init() {
 			B := int(inst & 0x1ff)    //GETB
 			C := int(inst>>9) & 0x1ff //GETC
 			v := newLTable(B, C)
+			atomic.AddUint64(&L.stats.tables, 1)
 			// this section is inlined by go-inline
 			// source function is 'func (rg *registry) Set(regi int, vali LValue) ' in '_state.go'
 			{
@@ -840,10 +1064,10 @@ func init() {
 							}
 						}
 					} else {
-						L.RaiseError("__unm undefined")
+						L.RaiseError("__unm undefined%v", L.arithVarInfo(OP_UNM, unaryv, LNil))
 					}
 				} else {
-					L.RaiseError("__unm undefined")
+					L.RaiseError("__unm undefined%v", L.arithVarInfo(OP_UNM, unaryv, LNil))
 				}
 			}
 			return 0
@@ -1105,7 +1329,10 @@ func init() {
 					case 0:
 						ret = false
 					default:
+						// the result of __lt must be negated, finishOp can not continue this call.
+						L.nny++
 						ret = !objectRationalWithError(L, rhs, lhs, "__lt")
+						L.nny--
 					}
 				}
 			}
@@ -1198,13 +1425,16 @@ func init() {
 					ls.reg.Insert(fn, cf.LocalBase)
 				}
 				if cf.Fn == nil {
-					ls.RaiseError("attempt to call a non-function object")
+					ls.RaiseError("attempt to call a non-function object%s", ls.callVarInfo(fn))
 				}
 				if ls.stack.IsFull() {
 					ls.RaiseError("stack overflow")
 				}
 				ls.stack.Push(cf)
 				newcf := ls.stack.Last()
+				if ls.tracing != nil {
+					ls.tracing.call(ls, newcf)
+				}
 				// this section is inlined by go-inline
 				// source function is 'func (ls *LState) initCallFrame(cf *callFrame) ' in '_state.go'
 				{
@@ -1304,6 +1534,7 @@ func init() {
 					}
 				}
 				ls.currentFrame = newcf
+				ls.stats.call(ls)
 			}
 			if callable.IsG && callGFunction(L, false) {
 				return 1
@@ -1331,7 +1562,7 @@ func init() {
 				callable, meta = L.metaCall(lv)
 			}
 			if callable == nil {
-				L.RaiseError("attempt to call a non-function object")
+				L.RaiseError("attempt to call a non-function object%s", L.callVarInfo(lv))
 			}
 			// this section is inlined by go-inline
 			// source function is 'func (ls *LState) closeUpvalues(idx int) ' in '_state.go'
@@ -1372,6 +1603,9 @@ func init() {
 				if L.currentFrame == nil || L.currentFrame.Fn.IsG || luaframe == baseframe {
 					return 1
 				}
+				if parent := L.currentFrame; opGetOpCode(parent.Fn.Proto.Code[parent.Pc-1]) != OP_CALL {
+					finishOp(L)
+				}
 			} else {
 				base := cf.Base
 				cf.Fn = callable
@@ -1387,6 +1621,9 @@ func init() {
 					cf.NArgs++
 					L.reg.Insert(lv, cf.LocalBase)
 				}
+				if L.tracing != nil {
+					L.tracing.call(L, cf)
+				}
 				// this section is inlined by go-inline
 				// source function is 'func (ls *LState) initCallFrame(cf *callFrame) ' in '_state.go'
 				{
@@ -1485,6 +1722,7 @@ func init() {
 						}
 					}
 				}
+				L.stats.call(L)
 				// this section is inlined by go-inline
 				// source function is 'func (rg *registry) CopyRange(regv, start, limit, n int) ' in '_state.go'
 				{
@@ -1677,6 +1915,9 @@ func init() {
 				return 1
 			}
 			islast := baseframe == L.stack.Pop() || L.stack.IsEmpty()
+			if L.tracing != nil {
+				L.tracing.ret(L, nret)
+			}
 			// this section is inlined by go-inline
 			// source function is 'func copyReturnValues(L *LState, regv, start, n, b int) ' in '_vm.go'
 			{
@@ -1787,6 +2028,10 @@ func init() {
 			if islast || L.currentFrame == nil || L.currentFrame.Fn.IsG {
 				return 1
 			}
+			if parent := L.currentFrame; opGetOpCode(parent.Fn.Proto.Code[parent.Pc-1]) != OP_CALL {
+				// returned from a metamethod that has been suspended by a yield
+				finishOp(L)
+			}
 			return 0
 		},
 		func(L *LState, inst uint32, baseframe *callFrame) int { //OP_FORLOOP
@@ -2337,8 +2582,8 @@ func objectArith(L *LState, opcode int, lhs, rhs LValue) LValue {
 			return numberArith(L, opcode, LNumber(v1), LNumber(v2))
 		}
 	}
-	L.RaiseError(fmt.Sprintf("cannot perform %v operation between %v and %v",
-		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String()))
+	L.RaiseError("cannot perform %v operation between %v and %v%v",
+		strings.TrimLeft(event, "_"), lhs.Type().String(), rhs.Type().String(), L.arithVarInfo(opcode, lhs, rhs))
 
 	return LNil
 }
@@ -2359,7 +2604,7 @@ func stringConcat(L *LState, total, last int) LValue {
 				total--
 				i--
 			} else {
-				L.RaiseError("cannot perform concat operation between %v and %v", lhs.Type().String(), rhs.Type().String())
+				L.RaiseError("cannot perform concat operation between %v and %v%v", lhs.Type().String(), rhs.Type().String(), L.concatVarInfo(i, lhs, rhs))
 				return LNil
 			}
 		} else {